@@ -4,25 +4,34 @@ import (
 	"context"
 	"os"
 	"os/signal"
+	"regexp"
+	"slices"
 	"syscall"
+	"time"
 
 	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/config"
 	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/controller"
 	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/db"
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
 	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/repo"
 	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/rest"
 	"go.uber.org/zap"
 )
 
+const toolHealthcheckTimeout = 30 * time.Second
+const s3StartupCheckTimeout = 30 * time.Second
+
 type App struct {
-	logger *zap.SugaredLogger
-	config *config.Config
+	logger   *zap.SugaredLogger
+	config   *config.Config
+	logLevel zap.AtomicLevel
 }
 
-func NewApp(logger *zap.SugaredLogger, config *config.Config) *App {
+func NewApp(logger *zap.SugaredLogger, config *config.Config, logLevel zap.AtomicLevel) *App {
 	return &App{
-		logger: logger,
-		config: config,
+		logger:   logger,
+		config:   config,
+		logLevel: logLevel,
 	}
 }
 
@@ -33,6 +42,10 @@ func (a *App) Run() {
 	ctx, cancel := context.WithCancel(context.TODO())
 	_ = ctx
 
+	if err := db.ValidatePath(cfg.DBPath); err != nil {
+		l.Fatalf("invalid db path %s: %v", cfg.DBPath, err)
+	}
+
 	dbConnections, err := db.NewConnection(cfg.DBPath)
 	if err != nil {
 		l.Fatalf("could not connect to database %w", err)
@@ -44,25 +57,234 @@ func (a *App) Run() {
 	}()
 
 	dbRepo := repo.NewDBRepo(dbConnections)
+	if cfg.JobStatusCacheTTL > 0 {
+		dbRepo = repo.NewCachedDBRepo(dbRepo, cfg.JobStatusCacheTTL)
+	}
+	// jobs run inside the daemon, after a restart nothing is working on unfinished ones
+	if failed, err := dbRepo.FailUnfinishedJobs(ctx, "interrupted by restart"); err != nil {
+		l.Errorf("failed to fail jobs interrupted by restart err: %v", err)
+	} else if failed > 0 {
+		l.Warnf("Marked %d jobs interrupted by restart as Failed", failed)
+	}
 
-	storageRepo := repo.NewStorageRepo(cfg.StorageRoot, cfg.ExternalRoot, cfg.Namespace, cfg.AllowPrefix)
+	vaultNameMatcher, err := regexp.Compile(cfg.VaultNameRegexp)
+	if err != nil {
+		l.Fatalf("invalid vault name regexp %v", err)
+	}
+	storageRepo := repo.NewStorageRepo(cfg.StorageRoot, cfg.ExternalRoot, cfg.Namespace, cfg.AllowPrefix, cfg.PrefixFullBackups, vaultNameMatcher, l)
 
 	scheduler := controller.NewScheduler()
 
-	s3Client, err := controller.NewS3Client(ctx, cfg.S3URL, cfg.AccessKeyID, cfg.AccessKeySecret, cfg.BucketName, cfg.Region, cfg.S3SslVerify)
+	janitor := controller.NewJobsJanitor(storageRepo, dbRepo, cfg.JobsTTL, cfg.JobsPruneInterval, l)
+	if cfg.JobsTTL > 0 {
+		go janitor.Run(ctx)
+	}
+	if cfg.DBVacuumInterval > 0 {
+		go janitor.RunVacuum(ctx, cfg.DBVacuumInterval)
+	}
+
+	s3Timeouts := controller.S3Timeouts{
+		Dial:           cfg.S3DialTimeout,
+		TLSHandshake:   cfg.S3TLSHandshakeTimeout,
+		ResponseHeader: cfg.S3ResponseHeaderTimeout,
+		Operation:      cfg.S3OperationTimeout,
+		ObjectWait:     cfg.S3ObjectWaitTimeout,
+	}
+	s3StorageClasses := controller.S3StorageClasses{Full: cfg.S3StorageClass, Granular: cfg.S3GranularStorageClass}
+	s3ObjectLock := controller.S3ObjectLock{Mode: cfg.S3ObjectLockMode, Days: cfg.S3ObjectLockDays}
+	s3Client, err := controller.NewS3Client(ctx, cfg.S3URL, cfg.AccessKeyID, cfg.AccessKeySecret, cfg.BucketName, cfg.Region, cfg.S3SslVerify, cfg.S3ForcePathStyle == "true",
+		cfg.S3PartSize, cfg.S3MultipartThreshold, cfg.S3DeleteBatchSize, cfg.S3SkipUnchanged, cfg.S3SetContentHeaders, cfg.S3SkipExistsCheck, cfg.S3FollowSymlinks, s3StorageClasses, s3ObjectLock, s3Timeouts)
 	if err != nil {
 		l.Fatalf("could not connect to s3 client %v", err)
 	}
 
-	executor := controller.NewExecutor(cfg.EvictCmd, cfg.BackupCmd, cfg.RestoreCmd, cfg.DbListCmd, cfg.CustomVars, cfg.DatabasesKey, cfg.DbmapKey, l)
+	var secondaryS3Client controller.S3ClientRepository
+	if cfg.S3SecondaryBucketName != "" {
+		secondaryS3Client, err = controller.NewS3Client(ctx, cfg.S3SecondaryURL, cfg.S3SecondaryAccessKeyID, cfg.S3SecondaryAccessKeySecret, cfg.S3SecondaryBucketName,
+			cfg.S3SecondaryRegion, cfg.S3SslVerify, cfg.S3ForcePathStyle == "true", cfg.S3PartSize, cfg.S3MultipartThreshold, cfg.S3DeleteBatchSize, cfg.S3SkipUnchanged, cfg.S3SetContentHeaders, cfg.S3SkipExistsCheck, cfg.S3FollowSymlinks, s3StorageClasses, s3ObjectLock, s3Timeouts)
+		if err != nil {
+			l.Fatalf("could not connect to secondary s3 client %v", err)
+		}
+	}
+
+	// allowed buckets share the endpoint and credentials of the default one
+	bucketS3Clients := make(map[string]controller.S3ClientRepository, len(cfg.S3AllowedBuckets))
+	for _, bucket := range cfg.S3AllowedBuckets {
+		if bucket == "" {
+			continue
+		}
+		if bucket == cfg.BucketName {
+			bucketS3Clients[bucket] = s3Client
+			continue
+		}
+		bucketS3Clients[bucket], err = controller.NewS3Client(ctx, cfg.S3URL, cfg.AccessKeyID, cfg.AccessKeySecret, bucket, cfg.Region, cfg.S3SslVerify, cfg.S3ForcePathStyle == "true",
+			cfg.S3PartSize, cfg.S3MultipartThreshold, cfg.S3DeleteBatchSize, cfg.S3SkipUnchanged, cfg.S3SetContentHeaders, cfg.S3SkipExistsCheck, cfg.S3FollowSymlinks, s3StorageClasses, s3ObjectLock, s3Timeouts)
+		if err != nil {
+			l.Fatalf("could not connect to s3 client of bucket %s %v", bucket, err)
+		}
+	}
+
+	for _, c := range []struct {
+		cmd  *string
+		file string
+	}{
+		{cmd: &cfg.EvictCmd, file: cfg.EvictCmdFile},
+		{cmd: &cfg.BackupCmd, file: cfg.BackupCmdFile},
+		{cmd: &cfg.RestoreCmd, file: cfg.RestoreCmdFile},
+		{cmd: &cfg.DbListCmd, file: cfg.DbListCmdFile},
+		{cmd: &cfg.DiscoverDbsCmd, file: cfg.DiscoverDbsCmdFile},
+		{cmd: &cfg.TestRestoreCmd, file: cfg.TestRestoreCmdFile},
+	} {
+		if *c.cmd, err = controller.CommandTemplate(*c.cmd, c.file); err != nil {
+			l.Fatalf("invalid command template %v", err)
+		}
+	}
+
+	watchBackups := cfg.BackupMode == controller.BackupModeWatch
+	if watchBackups && (cfg.BackupWatchTimeout <= 0 || cfg.BackupWatchMarker == "") {
+		l.Fatalf("backup mode %s needs a completion marker and a positive timeout", cfg.BackupMode)
+	}
+	unconfigured := controller.UnconfiguredCommands(cfg.BackupCmd, cfg.RestoreCmd, cfg.DbListCmd)
+	if watchBackups {
+		// a watched backup runs no backup command
+		unconfigured = slices.DeleteFunc(unconfigured, func(name string) bool { return name == "backup" })
+	}
+	if len(unconfigured) > 0 {
+		if cfg.Strict {
+			l.Fatalf("commands %v are not configured, they are empty or left at the placeholder %q", unconfigured, controller.PlaceholderCmd)
+		}
+		l.Warnf("!!! commands %v are not configured, they are empty or left at the placeholder %q, backups will contain no data !!!",
+			unconfigured, controller.PlaceholderCmd)
+	}
+
+	if cfg.VerifyAfterRestore && cfg.DiscoverDbsCmd == "" {
+		l.Fatalf("--verify-after-restore lists the restore target with the discover dbs command, it is not configured")
+	}
+
+	var outputCriteria controller.BackupOutputCriteria
+	if cfg.BackupSuccessRegexp != "" {
+		if outputCriteria.Success, err = regexp.Compile(cfg.BackupSuccessRegexp); err != nil {
+			l.Fatalf("invalid backup success regexp %v", err)
+		}
+	}
+	if cfg.BackupFailureRegexp != "" {
+		if outputCriteria.Failure, err = regexp.Compile(cfg.BackupFailureRegexp); err != nil {
+			l.Fatalf("invalid backup failure regexp %v", err)
+		}
+	}
+
+	executor := controller.NewExecutor(cfg.EvictCmd, cfg.BackupCmd, cfg.RestoreCmd, cfg.DbListCmd, cfg.DiscoverDbsCmd, cfg.TestRestoreCmd, cfg.CustomVars, cfg.CustomVarsFormat,
+		cfg.SensitiveCustomVars, cfg.DatabasesKey, cfg.DbmapKey,
+		cfg.ExcludeDbsKey, cfg.StreamCommandLogs,
+		controller.CommandRetries{Backup: cfg.BackupCmdRetries, Restore: cfg.RestoreCmdRetries, Delay: cfg.CmdRetryDelay}, outputCriteria,
+		controller.BackupWatch{Enabled: watchBackups, Marker: cfg.BackupWatchMarker, Timeout: cfg.BackupWatchTimeout}, cfg.RestoreDBConcurrency, l)
+
+	weekStart, err := controller.ParseWeekday(cfg.EvictionWeekStart)
+	if err != nil {
+		l.Fatalf("invalid eviction week start %v", err)
+	}
+	evictionLocation, err := time.LoadLocation(cfg.EvictionTimezone)
+	if err != nil {
+		l.Fatalf("invalid eviction timezone %v", err)
+	}
+
+	configuredPolicy := entity.EvictionPolicyResponse{FullEvictionPolicy: cfg.EvictionPolicy, GranularEvictionPolicy: cfg.GranularEvictionPolicy}
+	evictionPolicy, err := controller.LoadEvictionPolicy(ctx, dbRepo, configuredPolicy)
+	if err != nil {
+		l.Errorf("failed to load eviction policy, using the configured one err: %v", err)
+	} else if evictionPolicy != configuredPolicy {
+		l.Infof("Using eviction policies full=%s granular=%s set at runtime instead of the configured ones",
+			evictionPolicy.FullEvictionPolicy, evictionPolicy.GranularEvictionPolicy)
+	}
+
+	backupDaemon := controller.NewBackupDaemon(storageRepo, dbRepo, scheduler, s3Client, executor, l, controller.BackupDaemonOptions{
+		S3Enable:                 cfg.S3Enabled,
+		EvictionPolicy:           evictionPolicy.FullEvictionPolicy,
+		GranularEvictionPolicy:   evictionPolicy.GranularEvictionPolicy,
+		ConfiguredEvictionPolicy: configuredPolicy,
+		LocalArchiveDir:          cfg.LocalArchiveDir,
+		EnableFullRestore:        cfg.EnableFullRestore,
+		SecondaryS3Client:        secondaryS3Client,
+		SecondaryS3Required:      cfg.S3SecondaryRequired,
+		RestoreURLAllowedHosts:   cfg.RestoreURLAllowedHosts,
+		RestoreURLMaxSize:        cfg.RestoreURLMaxSize,
+		EvictionAlignment:        controller.EvictionAlignment(weekStart, evictionLocation),
+		KeepRestoreTemp:          cfg.KeepRestoreTemp,
+		BucketS3Clients:          bucketS3Clients,
+		BackupCaps: controller.BackupCaps{Full: cfg.MaxBackupsFull, Granular: cfg.MaxBackupsGranular, Evict: cfg.OnCapFull == "evict",
+			StorageQuotas: cfg.StorageQuotas, MinFreeInodesPercent: cfg.MinFreeInodesPercent},
+		AllowCommandOverride:     cfg.AllowCommandOverride,
+		LoadLimits:               controller.BackupLoadLimits{MaxInFlight: cfg.MaxInFlightBackups, MaxIOPressure: cfg.MaxIOPressure, RetryAfter: cfg.OverloadRetryAfter},
+		VerifyAfterRestore:       cfg.VerifyAfterRestore,
+		RestoreIDScheme:          cfg.RestoreIDScheme,
+		GranularPerDBJobs:        cfg.GranularPerDBJobs,
+		DebounceDuplicateBackups: cfg.DebounceDuplicateBackups,
+		Namespace:                cfg.Namespace,
+		AllowedRestoreNamespaces: cfg.AllowedRestoreNamespaces,
+		EvictionGracePeriod:      cfg.EvictionGracePeriod,
+	})
 
-	backupDaemon := controller.NewBackupDaemon(storageRepo, dbRepo, scheduler, s3Client, executor, cfg.S3Enabled, l, cfg.EvictionPolicy, cfg.GranularEvictionPolicy)
+	if cfg.PresignDefaultExpiry <= 0 || cfg.PresignDefaultExpiry > rest.MaxPresignExpiry {
+		l.Fatalf("presign default expiry must be positive and at most %s, got %s", rest.MaxPresignExpiry, cfg.PresignDefaultExpiry)
+	}
+	if cfg.BackupAgeCheckInterval <= 0 {
+		l.Fatalf("backup age check interval must be positive, got %s", cfg.BackupAgeCheckInterval)
+	}
+	if len(cfg.StorageQuotas) > 0 {
+		if cfg.StorageQuotaRefreshInterval <= 0 {
+			l.Fatalf("storage quota refresh interval must be positive, got %s", cfg.StorageQuotaRefreshInterval)
+		}
+		go backupDaemon.RunStorageQuotaRefresh(ctx, cfg.StorageQuotaRefreshInterval)
+	}
+
+	// the watchdog also keeps the ages /health reports when stale backups aren't reported
+	watchdog := controller.NewBackupAgeWatchdog(backupDaemon, cfg.MaxBackupAge, cfg.BackupAgeCheckInterval, cfg.StaleBackupWebhook, l)
+	go watchdog.Run(ctx)
 
 	endpointHandler := rest.NewEndpointHandler(backupDaemon, l)
+	endpointHandler.SetBackupAges(watchdog.LastAges)
+	endpointHandler.SetLogLevel(a.logLevel)
+	endpointHandler.SetPresignExpiry(cfg.PresignDefaultExpiry, cfg.PresignMaxExpiry)
+	endpointHandler.SetTenants(cfg.TenantHeader, cfg.TenantAPIKeys)
+
+	if cfg.ReadyCheckInterval <= 0 {
+		l.Fatalf("ready check interval must be positive, got %s", cfg.ReadyCheckInterval)
+	}
+	if cfg.ToolHealthcheckCmd != "" {
+		endpointHandler.AddReadyCheck("tool health check", func(context.Context) error {
+			return controller.CheckTool(cfg.ToolHealthcheckCmd, toolHealthcheckTimeout)
+		})
+	}
+	endpointHandler.AddReadyCheck("database check", dbConnections.WriterDB.PingContext)
+	endpointHandler.AddReadyCheck("storage check", func(context.Context) error {
+		return storageRepo.CheckRoot()
+	})
+	if cfg.S3Enabled && !cfg.S3SkipStartupCheck {
+		s3Check := func(ctx context.Context) error {
+			checkCtx, checkCancel := context.WithTimeout(ctx, s3StartupCheckTimeout)
+			defer checkCancel()
+			return s3Client.CheckBucket(checkCtx)
+		}
+		if cfg.Strict {
+			if err := s3Check(ctx); err != nil {
+				l.Fatalf("s3 startup check failed %v", err)
+			}
+		}
+		endpointHandler.AddReadyCheck("s3 check", s3Check)
+	}
+	if err := endpointHandler.CheckReady(ctx); err == nil {
+		l.Infof("ready checks passed")
+	}
+	go endpointHandler.RunReadyChecks(ctx, cfg.ReadyCheckInterval)
 
 	router := rest.NewRouter()
 
-	server, err := rest.NewServer(cfg.Port, cfg.ShutdownTimeout, router, l, endpointHandler)
+	serverTimeouts := rest.ServerTimeouts{
+		Read:  cfg.HTTPReadTimeout,
+		Write: cfg.HTTPWriteTimeout,
+		Idle:  cfg.HTTPIdleTimeout,
+	}
+	server, err := rest.NewServer(cfg.Port, cfg.ShutdownTimeout, serverTimeouts, cfg.HTTPMaxHeaderBytes, router, l, endpointHandler)
 	if err != nil {
 		l.Fatalf("failed to create server err: %v", err)
 	}
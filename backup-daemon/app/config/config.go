@@ -5,12 +5,24 @@ import "time"
 type Config struct {
 	Port            int           `long:"port" description:"HTTP server port" default:"8080"`
 	ShutdownTimeout time.Duration `long:"shutdown-timeout" description:"Timeout for server shutdown" default:"2s"`
+	LogLevel        string        `long:"log-level" description:"Log level" default:"info" choice:"debug" choice:"info" choice:"warn" choice:"error" env:"LOG_LEVEL"` //nolint:all
+
+	// backup, restore, evict and copy routes answer when the work is done and ignore the write timeout
+	HTTPReadTimeout    time.Duration `long:"http-read-timeout" description:"Maximum time to read a whole request, 0 disables it" default:"30s" env:"HTTP_READ_TIMEOUT"`
+	HTTPWriteTimeout   time.Duration `long:"http-write-timeout" description:"Maximum time to write a response of a short route, 0 disables it" default:"60s" env:"HTTP_WRITE_TIMEOUT"`
+	HTTPIdleTimeout    time.Duration `long:"http-idle-timeout" description:"How long an idle keep-alive connection is kept open, 0 uses the read timeout" default:"120s" env:"HTTP_IDLE_TIMEOUT"`
+	HTTPMaxHeaderBytes int           `long:"http-max-header-bytes" description:"Maximum size of request headers in bytes" default:"1048576" env:"HTTP_MAX_HEADER_BYTES"`
 
 	StorageRoot  string `long:"storage-root" description:"Local storage root path" default:"/backup-storage" env:"STORAGE"`
 	ExternalRoot string `long:"external-root" description:"External storage path" default:"/external" env:"STORAGE_EXTERNAL"`
 	Namespace    string `long:"namespace" description:"Namespace for storage" default:"default"`
 	AllowPrefix  bool   `long:"allow-prefix" description:"Allow prefix matching in storage" env:"ALLOW_PREFIX"`
 
+	PrefixFullBackups bool `long:"prefix-full-backups" description:"Name full backups with the prefix and namespace like granular ones, needs --allow-prefix" env:"PREFIX_FULL_BACKUPS"`
+
+	// matched against the name after its last underscore, creation times are still parsed from a 20060102T150405 suffix
+	VaultNameRegexp string `long:"vault-name-regexp" description:"Regexp recognizing vault directories, e.g. of backups migrated from other tools" default:"(?i)\\d{8}T\\d{4,6}" env:"VAULT_NAME_REGEXP"`
+
 	S3URL           string `long:"s3-url" description:"S3 endpoint URL" env:"S3_URL"`
 	AccessKeyID     string `long:"s3-access-key-id" description:"S3 access key ID" env:"S3_KEY_ID"`
 	AccessKeySecret string `long:"s3-access-key-secret" description:"S3 access key secret" env:"S3_KEY_SECRET"`
@@ -19,16 +31,155 @@ type Config struct {
 	S3Enabled       bool   `long:"s3-enabled" description:"Enable S3 storage" env:"S3_ENABLED"`
 	S3SslVerify     bool   `long:"s3-ssl-verify" description:"Verify S3 certificates" env:"S3_SSL_VERIFY"`
 
+	S3AllowedBuckets []string `long:"s3-allowed-buckets" description:"Buckets on the S3 endpoint a backup request may choose instead of the default one" env:"S3_ALLOWED_BUCKETS" env-delim:","`
+
+	// string because go-flags booleans cannot default to true
+	S3ForcePathStyle string `long:"s3-force-path-style" description:"Use path-style S3 addressing, false switches to virtual-hosted style for real AWS S3" default:"true" choice:"true" choice:"false" env:"S3_FORCE_PATH_STYLE"` //nolint:all
+
+	S3PartSize           int64 `long:"s3-part-size" description:"Part size in bytes for S3 multipart uploads" default:"67108864" env:"S3_PART_SIZE"`
+	S3MultipartThreshold int64 `long:"s3-multipart-threshold" description:"Files smaller than this many bytes are uploaded with a single PutObject" default:"8388608" env:"S3_MULTIPART_THRESHOLD"`
+	S3DeleteBatchSize    int   `long:"s3-delete-batch-size" description:"Maximum keys per S3 DeleteObjects request (up to 1000)" default:"1000" env:"S3_DELETE_BATCH_SIZE"`
+	S3SkipUnchanged      bool  `long:"s3-skip-unchanged" description:"Skip uploading files whose S3 copy has the same size and SHA-256, costs a hash and a HeadObject per file" env:"S3_SKIP_UNCHANGED"`
+	S3SetContentHeaders  bool  `long:"s3-set-content-headers" description:"Store Content-Encoding and Content-Type by file extension, clients then decompress .gz objects transparently" env:"S3_SET_CONTENT_HEADERS"`
+	S3SkipExistsCheck    bool  `long:"s3-skip-exists-check" description:"Do not wait for uploaded objects to exist, for strongly consistent stores" env:"S3_SKIP_EXISTS_CHECK"`
+	S3SkipStartupCheck   bool  `long:"s3-skip-startup-check" description:"Do not check that the S3 bucket is reachable, a failed check makes the daemon not ready, at startup it fails it under --strict" env:"S3_SKIP_STARTUP_CHECK"`
+	S3FollowSymlinks     bool  `long:"s3-follow-symlinks" description:"Upload the content of symlinked directories in a vault, their targets may be outside the vault" env:"S3_FOLLOW_SYMLINKS"`
+
+	S3StorageClass         string `long:"s3-storage-class" description:"Storage class of uploaded objects, e.g. STANDARD_IA or GLACIER" default:"STANDARD" env:"S3_STORAGE_CLASS"`
+	S3GranularStorageClass string `long:"s3-granular-storage-class" description:"Storage class of uploaded granular backups, defaults to --s3-storage-class" env:"S3_GRANULAR_STORAGE_CLASS"`
+
+	// expiry of presigned URLs handed out for backup files, in seconds in the expiration query
+	PresignDefaultExpiry time.Duration `long:"presign-default-expiry" description:"Expiry of a presigned URL requested without expiration" default:"1h" env:"PRESIGN_DEFAULT_EXPIRY"`
+	PresignMaxExpiry     time.Duration `long:"presign-max-expiry" description:"Longest expiry a client may request for a presigned URL, 0 leaves the S3 maximum of 168h" default:"168h" env:"PRESIGN_MAX_EXPIRY"`
+
+	// for buckets with Object Lock enabled, retained objects can not be deleted before the retention ends
+	S3ObjectLockMode string `long:"s3-object-lock-mode" description:"Object Lock mode of uploaded objects, GOVERNANCE or COMPLIANCE, empty disables retention" env:"S3_OBJECT_LOCK_MODE"`
+	S3ObjectLockDays int    `long:"s3-object-lock-days" description:"Days uploaded objects are retained by Object Lock" env:"S3_OBJECT_LOCK_DAYS"`
+
+	S3DialTimeout           time.Duration `long:"s3-dial-timeout" description:"Timeout for establishing a connection to S3" default:"10s" env:"S3_DIAL_TIMEOUT"`
+	S3TLSHandshakeTimeout   time.Duration `long:"s3-tls-handshake-timeout" description:"Timeout for the TLS handshake with S3" default:"10s" env:"S3_TLS_HANDSHAKE_TIMEOUT"`
+	S3ResponseHeaderTimeout time.Duration `long:"s3-response-header-timeout" description:"Timeout for waiting on S3 response headers" default:"60s" env:"S3_RESPONSE_HEADER_TIMEOUT"`
+	S3OperationTimeout      time.Duration `long:"s3-operation-timeout" description:"Overall timeout of a single S3 operation, 0 disables it" default:"30m" env:"S3_OPERATION_TIMEOUT"`
+	S3ObjectWaitTimeout     time.Duration `long:"s3-object-wait-timeout" description:"How long to wait for an uploaded object to exist" default:"1m" env:"S3_OBJECT_WAIT_TIMEOUT"`
+
+	// replication target, enabled when the bucket is set
+	S3SecondaryURL             string `long:"s3-secondary-url" description:"Secondary S3 endpoint URL every backup is replicated to" env:"S3_SECONDARY_URL"`
+	S3SecondaryAccessKeyID     string `long:"s3-secondary-access-key-id" description:"Secondary S3 access key ID" env:"S3_SECONDARY_KEY_ID"`
+	S3SecondaryAccessKeySecret string `long:"s3-secondary-access-key-secret" description:"Secondary S3 access key secret" env:"S3_SECONDARY_KEY_SECRET"`
+	S3SecondaryBucketName      string `long:"s3-secondary-bucket" description:"Secondary S3 bucket name, empty disables replication" env:"S3_SECONDARY_BUCKET"`
+	S3SecondaryRegion          string `long:"s3-secondary-region" description:"Secondary S3 region" default:"us-east-1" env:"S3_SECONDARY_REGION"`
+	S3SecondaryRequired        bool   `long:"s3-secondary-required" description:"Fail the backup when replication to the secondary S3 fails" env:"S3_SECONDARY_REQUIRED"`
+
 	EvictCmd   string `long:"evict-cmd"   description:"Command to evict data"     default:"ls -la {{.data_folder}}" env:"EVICT_CMD"`
 	BackupCmd  string `long:"backup-cmd"  description:"Command to backup data"    default:"ls -la {{.data_folder}}" env:"BACKUP_COMMAND"`
 	RestoreCmd string `long:"restore-cmd" description:"Command to restore data"   default:"ls -la {{.data_folder}}" env:"RESTORE_COMMAND"`
 	DbListCmd  string `long:"dblist-cmd"  description:"Command to list databases" default:"ls -la {{.data_folder}}" env:"LIST_COMMAND"`
 
-	CustomVars   []string `long:"custom-vars" description:"Custom variables for executor" default:"skip_users_recovery" default:"clean" default:"storageName" default:"blob_path"` //nolint:all
-	DatabasesKey string   `long:"databases-key" description:"Key for databases list" default:"--dbs" env:"DATABASES_KEY"`
-	DbmapKey     string   `long:"dbmap-key" description:"Key for database map" default:"--dbmap" env:"DBMAP_KEY"`
-	DBPath       string   `long:"db-path" description:"SQLite DB file path" default:"/backup-storage/database.db" env:"DB_PATH"`
+	DiscoverDbsCmd string `long:"discover-dbs-cmd" description:"Command listing databases of the live source, one per line, used by discoverDatabases backups" env:"DISCOVER_DBS_COMMAND"`
+	TestRestoreCmd string `long:"test-restore-cmd" description:"Command restoring a copy of a vault into a test instance, used by test restores" env:"TEST_RESTORE_COMMAND"`
+
+	// jobs and backups created for a tenant are visible only to it, requests without one see everything
+	TenantHeader  string            `long:"tenant-header" description:"Request header naming the tenant of a request, ignored when --tenant-api-keys are set" env:"TENANT_HEADER"`
+	TenantAPIKeys map[string]string `long:"tenant-api-keys" description:"key:tenant pairs, a request must then send a known key in X-API-Key and is scoped to its tenant" env:"TENANT_API_KEYS" env-delim:","`
+
+	// a template file is read at startup and used while the matching command is empty or left at the default
+	EvictCmdFile       string `long:"evict-cmd-file" description:"File with the evict command template" env:"EVICT_COMMAND_FILE"`
+	BackupCmdFile      string `long:"backup-cmd-file" description:"File with the backup command template" env:"BACKUP_COMMAND_FILE"`
+	RestoreCmdFile     string `long:"restore-cmd-file" description:"File with the restore command template" env:"RESTORE_COMMAND_FILE"`
+	DbListCmdFile      string `long:"dblist-cmd-file" description:"File with the dblist command template" env:"LIST_COMMAND_FILE"`
+	DiscoverDbsCmdFile string `long:"discover-dbs-cmd-file" description:"File with the discover dbs command template" env:"DISCOVER_DBS_COMMAND_FILE"`
+	TestRestoreCmdFile string `long:"test-restore-cmd-file" description:"File with the test restore command template" env:"TEST_RESTORE_COMMAND_FILE"`
+
+	// requests may then run arbitrary commands, each override is logged
+	AllowCommandOverride bool `long:"allow-command-override" description:"Let a backup request replace the backup command with its commandOverride" env:"ALLOW_COMMAND_OVERRIDE"`
+
+	ToolHealthcheckCmd string        `long:"tool-healthcheck-cmd" description:"Command /ready runs to check the backup tool, e.g. 'pg_dump --version'" env:"TOOL_HEALTHCHECK_CMD"`
+	ReadyCheckInterval time.Duration `long:"ready-check-interval" description:"How often /ready rechecks the backup tool, the database, the storage and the S3 bucket" default:"30s" env:"READY_CHECK_INTERVAL"`
+	Strict             bool          `long:"strict" description:"Refuse to start while backup, restore or dblist commands are not configured" env:"STRICT"`
+
+	CustomVars    []string `long:"custom-vars" description:"Custom variables for executor" default:"skip_users_recovery" default:"clean" default:"storageName" default:"blob_path"` //nolint:all
+	DatabasesKey  string   `long:"databases-key" description:"Key for databases list" default:"--dbs" env:"DATABASES_KEY"`
+	DbmapKey      string   `long:"dbmap-key" description:"Key for database map" default:"--dbmap" env:"DBMAP_KEY"`
+	ExcludeDbsKey string   `long:"exclude-dbs-key" description:"Key for the list of databases excluded from a backup" default:"--exclude-dbs" env:"EXCLUDE_DBS_KEY"`
+	DBPath        string   `long:"db-path" description:"SQLite DB file path" default:"/backup-storage/database.db" env:"DB_PATH"`
+
+	// the env format lets shell based backup tools source the custom vars of a vault
+	CustomVarsFormat string `long:"custom-vars-format" description:"Format of the .custom_vars file of a vault, json or env with KEY='value' lines" default:"json" choice:"json" choice:"env" env:"CUSTOM_VARS_FORMAT"` //nolint:all
+
+	// sensitive values are still passed to the commands, only logs and manifests show ***
+	SensitiveCustomVars []string `long:"sensitive-custom-vars" description:"Redact in logs the values of custom vars whose name contains any of these, case insensitive" default:"pass" default:"secret" default:"token" default:"key" env:"SENSITIVE_CUSTOM_VARS" env-delim:","` //nolint:all
+
+	// the exit code alone misses tools that only print warnings on partial failure
+	BackupSuccessRegexp string `long:"backup-success-regexp" description:"Fail a backup whose console has no line matching this regexp, whatever its exit code" env:"BACKUP_SUCCESS_REGEXP"`
+	BackupFailureRegexp string `long:"backup-failure-regexp" description:"Fail a backup whose console has a line matching this regexp, e.g. 'WARNING: .* skipped', whatever its exit code" env:"BACKUP_FAILURE_REGEXP"`
+
+	StreamCommandLogs bool `long:"stream-command-logs" description:"Also log backup and restore command output line by line at debug level, besides the console file" env:"STREAM_COMMAND_LOGS"`
+
+	EnableFullRestore bool `long:"enable-full-restore" description:"Allow restoring a full backup without a dbs list via REST API" env:"ENABLE_FULL_RESTORE"`
+
+	AllowedRestoreNamespaces []string `long:"allowed-restore-namespaces" description:"Namespaces a restore may target, the request's targetNamespace or --namespace, empty allows any" env:"ALLOWED_RESTORE_NAMESPACES" env-delim:","`
+
+	RestoreURLAllowedHosts []string `long:"restore-url-allowed-hosts" description:"Hosts /restore/from-url may download archives from, empty disables it" env:"RESTORE_URL_ALLOWED_HOSTS" env-delim:","`
+	RestoreURLMaxSize      int64    `long:"restore-url-max-size" description:"Maximum size in bytes of an archive downloaded by /restore/from-url" default:"10737418240" env:"RESTORE_URL_MAX_SIZE"`
+
+	VerifyAfterRestore bool `long:"verify-after-restore" description:"After a restore list the live target with the discover dbs command and fail the restore when restored databases are missing" env:"VERIFY_AFTER_RESTORE"`
+
+	RestoreIDScheme string `long:"restore-id-scheme" description:"Task ids of restores, timestamp ids sort chronologically like backup ids" default:"uuid" choice:"uuid" choice:"timestamp" env:"RESTORE_ID_SCHEME"` //nolint:all
+
+	// commands exiting non-zero are re-run, a killed command is not
+	BackupCmdRetries  int           `long:"backup-cmd-retries" description:"How many times a backup command exiting non-zero is re-run, the console of every failed attempt is kept as .console.<attempt>" env:"BACKUP_CMD_RETRIES"`
+	RestoreCmdRetries int           `long:"restore-cmd-retries" description:"How many times a restore command exiting non-zero is re-run, the log of every failed attempt is kept as <log>.<attempt>" env:"RESTORE_CMD_RETRIES"`
+	CmdRetryDelay     time.Duration `long:"cmd-retry-delay" description:"Delay before a failed backup or restore command is re-run" default:"10s" env:"CMD_RETRY_DELAY"`
+
+	// in watch mode a sidecar writes the vault the daemon opened, the backup command is not run
+	BackupMode         string        `long:"backup-mode" description:"Run the backup command, or wait for a tool outside the daemon to write the vault and create the completion marker" default:"command" choice:"command" choice:"watch" env:"BACKUP_MODE"` //nolint:all
+	BackupWatchMarker  string        `long:"backup-watch-marker" description:"File the backup tool creates in the vault folder once a watched backup is complete" default:".backup_complete" env:"BACKUP_WATCH_MARKER"`
+	BackupWatchTimeout time.Duration `long:"backup-watch-timeout" description:"How long a watched backup may take before it fails" default:"1h" env:"BACKUP_WATCH_TIMEOUT"`
+
+	// the restore command must accept a single database in the dbs key for values above 1
+	RestoreDBConcurrency int `long:"restore-db-concurrency" description:"How many databases of a restore with dbs are restored at a time, above 1 the restore command runs once per database with its own log" default:"1" env:"RESTORE_DB_CONCURRENCY"`
+
+	KeepRestoreTemp bool `long:"keep-restore-temp" description:"Keep backups downloaded or extracted to the temp dir for a restore, for debugging" env:"KEEP_RESTORE_TEMP"`
+
+	LocalArchiveDir string `long:"local-archive-dir" description:"Directory where every successful backup is also stored as <backupID>.tar.gz" env:"LOCAL_ARCHIVE_DIR"`
+
+	JobsTTL           time.Duration `long:"jobs-ttl" description:"Remove finished jobs older than this whose vault no longer exists (0 disables)" default:"0" env:"JOBS_TTL"`
+	JobsPruneInterval time.Duration `long:"jobs-prune-interval" description:"How often finished jobs are pruned" default:"1h" env:"JOBS_PRUNE_INTERVAL"`
+	JobStatusCacheTTL time.Duration `long:"job-status-cache-ttl" description:"How long job status reads are cached between updates (0 disables)" default:"1s" env:"JOB_STATUS_CACHE_TTL"`
+	DBVacuumInterval  time.Duration `long:"db-vacuum-interval" description:"How often the jobs database is vacuumed and its WAL truncated (0 disables)" default:"24h" env:"DB_VACUUM_INTERVAL"`
+
+	// types whose latest successful backup is older than MaxBackupAge are reported, to the webhook when set
+	MaxBackupAge           time.Duration `long:"max-backup-age" description:"Report a backup type whose latest successful backup is older than this, types never backed up are skipped (0 disables)" default:"0" env:"MAX_BACKUP_AGE"`
+	BackupAgeCheckInterval time.Duration `long:"backup-age-check-interval" description:"How often the age of the latest successful backups is checked, /health reports the ages of the last check" default:"5m" env:"BACKUP_AGE_CHECK_INTERVAL"`
+	StaleBackupWebhook     string        `long:"stale-backup-webhook" description:"URL a stale_backup event is posted to as JSON once a backup type gets older than the max backup age" env:"STALE_BACKUP_WEBHOOK"`
 
 	EvictionPolicy         string `long:"eviction" description:"Eviction policy (e.g. 0/1h,4h/1d)" env:"EVICTION_POLICY"`
 	GranularEvictionPolicy string `long:"granular_eviction" description:"Granular eviction policy (e.g. 0/1h,4h/1d)" env:"GRANULAR_EVICTION_POLICY"`
+
+	// vaults a job still uses are never evicted or removed, the grace period also covers other writers
+	EvictionGracePeriod time.Duration `long:"eviction-grace-period" description:"Keep backups younger than this from eviction and removal, 0 disables the grace period" default:"0" env:"EVICTION_GRACE_PERIOD"`
+
+	GranularPerDBJobs bool `long:"granular-per-db-jobs" description:"Back up every database of a granular backup into its own vault and job under a parent job, so the others succeed when one fails" env:"GRANULAR_PER_DB_JOBS"`
+
+	DebounceDuplicateBackups bool `long:"debounce-duplicate-backups" description:"Answer a backup request identical to a running backup, same type, databases and storage, with the id of the running one instead of starting another" env:"DEBOUNCE_DUPLICATE_BACKUPS"`
+
+	// caps are checked when a backup starts, unlike the eviction policy applied periodically
+	MaxBackupsFull     int              `long:"max-backups-full" description:"Maximum number of full backups kept, 0 disables the cap" env:"MAX_BACKUPS_FULL"`
+	MaxBackupsGranular int              `long:"max-backups-granular" description:"Maximum number of granular backups kept, 0 disables the cap" env:"MAX_BACKUPS_GRANULAR"`
+	OnCapFull          string           `long:"on-cap-full" description:"Evict the oldest evictable backup or reject a backup over the cap" default:"reject" choice:"evict" choice:"reject" env:"ON_CAP_FULL"` //nolint:all
+	StorageQuotas      map[string]int64 `long:"storage-quotas" description:"storageName:bytes pairs limiting the size of the backups of a storage, a backup over the quota is handled like one over a cap" env:"STORAGE_QUOTAS" env-delim:","`
+	// /health reports the usage of the last measurement instead of walking the vaults on every probe
+	StorageQuotaRefreshInterval time.Duration `long:"storage-quota-refresh-interval" description:"How often the usage of the storages with a quota is measured for /health" default:"5m" env:"STORAGE_QUOTA_REFRESH_INTERVAL"`
+
+	// unlike caps the inode check only rejects, many small granular backups can exhaust inodes before space
+	MinFreeInodesPercent float64 `long:"min-free-inodes-percent" description:"Reject backups while less than this percent of the storage inodes are free, 0 disables the check" env:"MIN_FREE_INODES_PERCENT"`
+
+	// backups over a load limit are rejected with 503 and Retry-After
+	MaxInFlightBackups int           `long:"max-inflight-backups" description:"Maximum number of backups running at once, 0 disables the limit" env:"MAX_INFLIGHT_BACKUPS"`
+	MaxIOPressure      float64       `long:"max-io-pressure" description:"Reject backups while tasks stall on I/O more than this percent of time (avg10 of /proc/pressure/io), 0 disables the check" env:"MAX_IO_PRESSURE"`
+	OverloadRetryAfter time.Duration `long:"overload-retry-after" description:"Retry-After sent with a backup rejected under load" default:"30s" env:"OVERLOAD_RETRY_AFTER"`
+
+	// interval rules bucket backups from midnight of EvictionWeekStart in EvictionTimezone
+	EvictionWeekStart string `long:"eviction-week-start" description:"Day weekly eviction buckets start on" default:"monday" choice:"monday" choice:"tuesday" choice:"wednesday" choice:"thursday" choice:"friday" choice:"saturday" choice:"sunday" env:"EVICTION_WEEK_START"` //nolint:all
+	EvictionTimezone  string `long:"eviction-timezone" description:"IANA timezone whose midnight eviction buckets start at" default:"UTC" env:"EVICTION_TIMEZONE"`
 }
@@ -6,17 +6,26 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
 	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/repo"
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/util"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
@@ -27,16 +36,118 @@ const COMMONBACKUP = "backup"
 const INCREMENTALBACKUP = "incremental backup"
 const COMMONRESTORE = "restore"
 const INCREMENTALRESTORE = "incremental restore"
+const TESTRESTORE = "test restore"
+const STARTTS = "start_ts"
+const CLEAN = "clean"
+const COPY = "copy"
+const DISCOVERDATABASES = "discoverDatabases"
+
+// PERDBBACKUP is the parent job of a granular backup split into a backup per database
+const PERDBBACKUP = "per database backup"
+
+// restore task id schemes, timestamp ids sort chronologically like vault names
+const RestoreIDUUID = "uuid"
+const RestoreIDTimestamp = "timestamp"
+const restoreIDFormat = repo.VaultNameFormat + ".000000"
+
+var ErrNoSuccessfulBackup = errors.New("no successful backup found")
+var ErrBackupNotFound = errors.New("backup not found")
+
+// ErrVaultNotFound is returned when the vault of a backup to delete or restore is missing, it is an ErrBackupNotFound.
+var ErrVaultNotFound = fmt.Errorf("%w in storage", ErrBackupNotFound)
+var ErrS3Disabled = errors.New("s3 storage is disabled")
+var ErrBackupNotSuccessful = errors.New("backup is not successful")
+var ErrBackupIncomplete = errors.New("backup is incomplete")
+var ErrFullRestoreDisabled = errors.New("full restore via REST API is disabled")
+var ErrNoDatabasesDiscovered = errors.New("no databases discovered")
+var ErrURLNotAllowed = errors.New("url is not allowed")
+var ErrJobNotFound = errors.New("job not found")
+var ErrJobFinished = errors.New("job is already finished")
+var ErrInvalidRetention = errors.New("invalid backup retention")
+var ErrInvalidMultiRestore = errors.New("invalid multi restore request")
+var ErrBucketNotAllowed = errors.New("s3 bucket is not allowed")
+var ErrBackupCapReached = errors.New("backup cap reached")
+var ErrLowInodes = errors.New("storage is low on free inodes")
+var ErrCommandOverrideNotAllowed = errors.New("backup command override is not allowed")
+var ErrOverloaded = errors.New("backup daemon is overloaded")
+var ErrRenameCollision = errors.New("restored database name collides with an existing one")
+var ErrRestoreNotVerified = errors.New("restored databases are missing on the target")
+var ErrInvalidBackupID = errors.New("invalid backup id")
+var ErrBackupIDExists = errors.New("backup id already exists")
+var ErrBackupNotRunning = errors.New("backup is not running")
+var ErrBackupNotQueued = errors.New("backup has nothing queued to cancel")
+var ErrBackupNotRetryable = errors.New("backup cannot be retried")
+var ErrExcludedDBRequested = errors.New("excluded database is also requested")
+var ErrVaultNamespaceUnknown = errors.New("backup name does not embed a namespace")
+var ErrRestoreNamespaceNotAllowed = errors.New("restore target namespace is not allowed")
+var ErrInvalidBaseBackup = errors.New("invalid incremental base backup")
+var ErrVaultBusy = errors.New("backup vault is in use")
+var ErrInvalidEvictionPolicy = errors.New("invalid eviction policy")
+
+// evictionPolicySetting is the setting the eviction policies set at runtime are stored under
+const evictionPolicySetting = "eviction_policy"
+
+// consolePollInterval is how often a streamed .console is checked for new output
+var consolePollInterval = 500 * time.Millisecond
 
 //go:generate mockgen -source=backup-daemon.go -destination=../rest/mock.go -package=rest
 type BackupDaemonUseCase interface {
 	EnqueueBackup(ctx context.Context, request entity.BackupRequest) (entity.BackupResponse, error)
 	RestoreBackup(ctx context.Context, request entity.RestoreRequest) (entity.RestoreResponse, error)
-	EnqueueEviction(ctx context.Context, request entity.EvictRequest) error
+	RestoreLatestBackup(ctx context.Context, request entity.RestoreLatestRequest) (entity.RestoreResponse, error)
+	RestoreMulti(ctx context.Context, request entity.MultiRestoreRequest) (entity.MultiRestoreResponse, error)
+	EnqueueEviction(ctx context.Context, request entity.EvictRequest) (entity.EvictResponse, error)
+	SetEvictionPolicy(ctx context.Context, request entity.EvictionPolicyRequest) (entity.EvictionPolicyResponse, error)
+	GetEvictionPolicy(ctx context.Context) entity.EvictionPolicyResponse
+	ResetEvictionPolicy(ctx context.Context) (entity.EvictionPolicyResponse, error)
 	RemoveBackup(ctx context.Context, request entity.EvictByVaultRequest) error
 	RemoveBackupV2(ctx context.Context, request entity.EvictByVaultV2Request) error
+	CopyBackup(ctx context.Context, request entity.CopyBackupRequest) (entity.CopyBackupResponse, error)
+	PromoteBackup(ctx context.Context, backupID string) error
+	GetGoldenBackup(ctx context.Context) (entity.GoldenBackupResponse, error)
+	Reconcile(ctx context.Context) (entity.ReconcileResponse, error)
+	ListS3Orphans(ctx context.Context) (entity.S3OrphansResponse, error)
+	CleanS3Orphans(ctx context.Context, dryRun bool) (entity.S3OrphansResponse, error)
+	RestoreFromURL(ctx context.Context, request entity.RestoreFromURLRequest) (entity.RestoreResponse, error)
+	VacuumDB(ctx context.Context) error
+	FailJob(ctx context.Context, taskID string) error
+	CancelBackup(ctx context.Context, backupID string) error
+	RetryBackup(ctx context.Context, backupID string) (entity.BackupResponse, error)
 	GetJobStatus(ctx context.Context, request entity.JobStatusRequest) (entity.JobStatusResponse, error)
+	ListJobs(ctx context.Context, filter entity.JobsFilter) ([]entity.JobStatusResponse, int, error)
 	CreateS3PresignedURL(ctx context.Context, request entity.S3PresignedURLRequest) (entity.S3PresignedURLResponse, error)
+	ListBackupFiles(ctx context.Context, backupID string) (entity.BackupFilesResponse, error)
+	GetBackupFile(ctx context.Context, request entity.BackupFileRequest) (entity.BackupFileResponse, error)
+	GetBackupManifest(ctx context.Context, backupID string) (entity.BackupManifest, error)
+	CreateBackupUploadURL(ctx context.Context, request entity.BackupUploadURLRequest) (entity.BackupUploadURLResponse, error)
+	RegisterUploadedBackup(ctx context.Context, backupID string) (entity.BackupResponse, error)
+	StreamBackupConsole(ctx context.Context, backupID string) (<-chan string, error)
+	GetStorageUsage(ctx context.Context) (entity.StorageUsageResponse, error)
+	StorageQuotas(ctx context.Context) (map[string]entity.StorageQuota, error)
+	RunStorageQuotaRefresh(ctx context.Context, interval time.Duration)
+	StorageInfo() (entity.StorageInfo, error)
+	BackupLoad() entity.BackupLoad
+	BackupAges() (entity.BackupAges, error)
+}
+
+// BackupCaps limit how many full and granular backups may exist, zero disables a cap. A backup
+// over its cap evicts the oldest evictable backups when Evict is set and is rejected otherwise.
+// StorageQuotas limit the size in bytes of the backups of each storageName the same way. Backups are
+// rejected while less than MinFreeInodesPercent of the storage inodes are free, zero disables the check.
+type BackupCaps struct {
+	Full                 int
+	Granular             int
+	Evict                bool
+	StorageQuotas        map[string]int64
+	MinFreeInodesPercent float64
+}
+
+// BackupLoadLimits make EnqueueBackup reject backups under load, zero disables a limit.
+// MaxIOPressure is the percent of time tasks stalled on I/O over the last ten seconds.
+type BackupLoadLimits struct {
+	MaxInFlight   int
+	MaxIOPressure float64
+	RetryAfter    time.Duration
 }
 
 type BackupDaemon struct {
@@ -49,33 +160,344 @@ type BackupDaemon struct {
 	logger                 *zap.SugaredLogger
 	evictionPolicy         string
 	granularEvictionPolicy string
+	localArchiveDir        string
+	enableFullRestore      bool
+	// secondaryS3Client receives a copy of every backup uploaded to s3, nil disables replication
+	secondaryS3Client   S3ClientRepository
+	secondaryS3Required bool
+	// restoreURLAllowedHosts lists hosts RestoreFromURL may download from, empty disables it
+	restoreURLAllowedHosts []string
+	restoreURLMaxSize      int64
+	httpClient             *http.Client
+	// evictionAlignment is the unix time in milliseconds the interval buckets of eviction rules start from
+	evictionAlignment int64
+	// keepRestoreTemp leaves restore copies in the temp dir for debugging
+	keepRestoreTemp bool
+	// bucketS3Clients are clients of the buckets a backup request may choose instead of the default one
+	bucketS3Clients map[string]S3ClientRepository
+	backupCaps      BackupCaps
+	// allowCommandOverride lets a backup request replace the configured backup command
+	allowCommandOverride bool
+
+	loadLimits BackupLoadLimits
+	inFlight   atomic.Int32
+	ioPressure func() (float64, error)
+
+	// verifyAfterRestore lists the live target after a restore and fails it when restored databases are missing
+	verifyAfterRestore bool
+	// restoreIDScheme is RestoreIDUUID or RestoreIDTimestamp
+	restoreIDScheme string
+	// granularPerDBJobs backs up every database of a granular backup into its own vault and job
+	granularPerDBJobs bool
+	// debounceDuplicateBackups answers a request identical to a running backup with its id
+	debounceDuplicateBackups bool
+	runningMu                sync.Mutex
+	running                  map[string]*runningBackup
+	// namespace is restored into unless a request names another, it must be in allowedRestoreNamespaces
+	// unless that is empty
+	namespace                string
+	allowedRestoreNamespaces []string
+
+	// queuedCancels cancel the databases not started yet of the per database backups running here, by parent job
+	queuedMu      sync.Mutex
+	queuedCancels map[string]*atomic.Bool
+
+	// vaultLocks hold the vaults backups write and removals delete here, by vault name
+	vaultMu    sync.Mutex
+	vaultLocks map[string]bool
+	// evictionGracePeriod keeps vaults younger than it from eviction and removal
+	evictionGracePeriod time.Duration
+
+	// capMu serializes the cap and quota checks with opening the vault they made room for, openVaults
+	// are the vaults of the backups running here by name
+	capMu      sync.Mutex
+	openVaults map[string]openVault
+
+	// usageMu guards quotaUsage, the bytes used by every storage with a quota when last measured
+	usageMu    sync.Mutex
+	quotaUsage map[string]int64
+
+	// policyMu guards evictionPolicy and granularEvictionPolicy, they can be replaced at runtime
+	policyMu sync.RWMutex
+	// configuredPolicy is the eviction policy of the configuration, restored by ResetEvictionPolicy
+	configuredPolicy entity.EvictionPolicyResponse
+}
+
+// openVault is the vault of a backup running here, it counts against the caps until the backup ends.
+type openVault struct {
+	granular    bool
+	storageName string
+}
+
+// runningBackup is a backup duplicate requests are answered with, ready is closed once its id is known.
+type runningBackup struct {
+	id    string
+	ready chan struct{}
 }
 
-func NewBackupDaemon(storageRepo repo.StorageRepository, dbRepo repo.DBRepository,
-	scheduler SchedulerRepository, s3Client S3ClientRepository, executor CommandExecutor,
-	s3Enable bool, logger *zap.SugaredLogger, evictionPolicy string, granularEvictionPolicy string) BackupDaemonUseCase {
+// BackupDaemonOptions configure NewBackupDaemon, their zero values leave the optional features off.
+type BackupDaemonOptions struct {
+	S3Enable               bool
+	EvictionPolicy         string
+	GranularEvictionPolicy string
+	// ConfiguredEvictionPolicy is what ResetEvictionPolicy returns to, EvictionPolicy may be one set at runtime
+	ConfiguredEvictionPolicy entity.EvictionPolicyResponse
+	LocalArchiveDir          string
+	EnableFullRestore        bool
+	SecondaryS3Client        S3ClientRepository
+	SecondaryS3Required      bool
+	RestoreURLAllowedHosts   []string
+	RestoreURLMaxSize        int64
+	// EvictionAlignment is the unix time in milliseconds the interval buckets of eviction rules start from
+	EvictionAlignment        int64
+	KeepRestoreTemp          bool
+	BucketS3Clients          map[string]S3ClientRepository
+	BackupCaps               BackupCaps
+	AllowCommandOverride     bool
+	LoadLimits               BackupLoadLimits
+	VerifyAfterRestore       bool
+	RestoreIDScheme          string
+	GranularPerDBJobs        bool
+	DebounceDuplicateBackups bool
+	Namespace                string
+	AllowedRestoreNamespaces []string
+	EvictionGracePeriod      time.Duration
+}
+
+func NewBackupDaemon(storageRepo repo.StorageRepository, dbRepo repo.DBRepository, scheduler SchedulerRepository,
+	s3Client S3ClientRepository, executor CommandExecutor, logger *zap.SugaredLogger, options BackupDaemonOptions) BackupDaemonUseCase {
 	return &BackupDaemon{
 		storageRepo:            storageRepo,
 		dbRepo:                 dbRepo,
 		scheduler:              scheduler,
 		s3Client:               s3Client,
 		executor:               executor,
-		s3Enable:               s3Enable,
+		s3Enable:               options.S3Enable,
 		logger:                 logger,
-		evictionPolicy:         evictionPolicy,
-		granularEvictionPolicy: granularEvictionPolicy,
+		evictionPolicy:         options.EvictionPolicy,
+		granularEvictionPolicy: options.GranularEvictionPolicy,
+		configuredPolicy:       options.ConfiguredEvictionPolicy,
+		localArchiveDir:        options.LocalArchiveDir,
+		enableFullRestore:      options.EnableFullRestore,
+		secondaryS3Client:      options.SecondaryS3Client,
+		secondaryS3Required:    options.SecondaryS3Required,
+		restoreURLAllowedHosts: options.RestoreURLAllowedHosts,
+		restoreURLMaxSize:      options.RestoreURLMaxSize,
+		evictionAlignment:      options.EvictionAlignment,
+		httpClient:             http.DefaultClient,
+		keepRestoreTemp:        options.KeepRestoreTemp,
+		bucketS3Clients:        options.BucketS3Clients,
+		backupCaps:             options.BackupCaps,
+		allowCommandOverride:   options.AllowCommandOverride,
+		loadLimits:             options.LoadLimits,
+		ioPressure: func() (float64, error) {
+			return util.IOPressure(util.IOPressurePath)
+		},
+		verifyAfterRestore:       options.VerifyAfterRestore,
+		restoreIDScheme:          options.RestoreIDScheme,
+		granularPerDBJobs:        options.GranularPerDBJobs,
+		debounceDuplicateBackups: options.DebounceDuplicateBackups,
+		running:                  map[string]*runningBackup{},
+		namespace:                options.Namespace,
+		allowedRestoreNamespaces: options.AllowedRestoreNamespaces,
+		evictionGracePeriod:      options.EvictionGracePeriod,
 	}
 }
 
 // TODO: worker pool, add task
 func (b *BackupDaemon) EnqueueBackup(ctx context.Context, request entity.BackupRequest) (entity.BackupResponse, error) {
+	keepUntil, err := retainUntil(request, time.Now())
+	if err != nil {
+		return entity.BackupResponse{}, err
+	}
+	if request.CommandOverride != "" && !b.allowCommandOverride {
+		return entity.BackupResponse{}, ErrCommandOverrideNotAllowed
+	}
+	if excluded := excludedRequestedDBs(request); len(excluded) > 0 {
+		return entity.BackupResponse{}, fmt.Errorf("%w: %v", ErrExcludedDBRequested, excluded)
+	}
+	if request.BaseBackupID != "" {
+		startTS, err := b.baseBackupStartTS(ctx, request)
+		if err != nil {
+			return entity.BackupResponse{}, err
+		}
+		request.CustomVars = maps.Clone(request.CustomVars)
+		if request.CustomVars == nil {
+			request.CustomVars = map[string]string{}
+		}
+		request.CustomVars[STARTTS] = startTS
+	}
+	var started func(backupID string)
+	if b.debounceDuplicateBackups {
+		runningID, start, done := b.joinRunningBackup(ctx, request)
+		if runningID != "" {
+			b.logger.Infof("Backup request is identical to running backup %s, not starting another", runningID)
+			return entity.BackupResponse{BackupID: runningID}, nil
+		}
+		defer done()
+		started = start
+	}
+	if request.BackupID != "" {
+		if err := b.checkBackupID(ctx, request); err != nil {
+			return entity.BackupResponse{}, err
+		}
+	}
+	if b.granularPerDBJobs && len(request.ExternalBackupPath) == 0 && (request.Mode == DISCOVERDATABASES || len(request.DBs) > 1) {
+		return b.enqueuePerDBBackups(ctx, request, keepUntil, started)
+	}
+	return b.backup(ctx, request, keepUntil, "", started)
+}
+
+// baseBackupStartTS checks the base backup an incremental backup is requested to chain from and
+// returns its start_ts, in the form the newest vault would be auto-selected in.
+func (b *BackupDaemon) baseBackupStartTS(ctx context.Context, request entity.BackupRequest) (string, error) {
+	if request.ProcType != INCREMENTAL {
+		return "", fmt.Errorf("%w: baseBackupId is only allowed for incremental backups", ErrInvalidBaseBackup)
+	}
+	vaultNames, err := b.storageRepo.ListVaultNames(false, repo.ALL, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to list all backup err: %w", err)
+	}
+	if !contains(vaultNames, request.BaseBackupID) {
+		return "", fmt.Errorf("%w: base vault %s", ErrBackupNotFound, request.BaseBackupID)
+	}
+	if err := b.checkTenantVault(ctx, request.BaseBackupID); err != nil {
+		return "", err
+	}
+	vault := b.storageRepo.GetVault(request.BaseBackupID, false, "", "", false)
+	if reflect.DeepEqual(vault, entity.Vault{}) {
+		return "", fmt.Errorf("%w: base vault %s", ErrBackupNotFound, request.BaseBackupID)
+	}
+	if !b.storageRepo.IsSuccessful(vault) {
+		return "", fmt.Errorf("%w: base vault %s", ErrBackupNotSuccessful, request.BaseBackupID)
+	}
+	if len(request.ExternalBackupPath) > 0 {
+		return request.BaseBackupID, nil
+	}
+	return strconv.Itoa(int(vault.TimeStamp)), nil
+}
+
+// joinRunningBackup returns the id of a running backup identical to request, waiting until a just
+// started one has its job. Otherwise it registers request as running: started publishes its id once
+// its job exists and done unregisters it.
+func (b *BackupDaemon) joinRunningBackup(ctx context.Context, request entity.BackupRequest) (string, func(string), func()) {
+	key := duplicateBackupKey(ctx, request)
+	for {
+		b.runningMu.Lock()
+		running, ok := b.running[key]
+		if !ok {
+			running = &runningBackup{ready: make(chan struct{})}
+			b.running[key] = running
+			b.runningMu.Unlock()
+			var once sync.Once
+			started := func(backupID string) {
+				once.Do(func() {
+					running.id = backupID
+					close(running.ready)
+				})
+			}
+			done := func() {
+				b.runningMu.Lock()
+				delete(b.running, key)
+				b.runningMu.Unlock()
+				// wakes up duplicates of a backup that failed before it had a job
+				started("")
+			}
+			return "", started, done
+		}
+		b.runningMu.Unlock()
+		<-running.ready
+		if running.id != "" {
+			return running.id, nil, nil
+		}
+	}
+}
+
+// duplicateBackupKey identifies backups of the same type and databases, or discovery patterns, chained from
+// the same base into the same storage requested by the same tenant, a tenant must not get the id of a backup
+// it can't see.
+func duplicateBackupKey(ctx context.Context, request entity.BackupRequest) string {
+	dbs := make([]string, 0, len(request.DBs))
+	for _, db := range request.DBs {
+		entry, _ := json.Marshal(db)
+		dbs = append(dbs, string(entry))
+	}
+	sort.Strings(dbs)
+	excluded := slices.Clone(request.ExcludeDBs)
+	sort.Strings(excluded)
+	include := slices.Clone(request.Include)
+	sort.Strings(include)
+	exclude := slices.Clone(request.Exclude)
+	sort.Strings(exclude)
+	key, _ := json.Marshal([]any{
+		request.ProcType, request.Mode, request.BackupID, request.BaseBackupID, dbs, excluded, include, exclude,
+		request.CustomVars["storageName"], strings.Trim(strings.TrimSpace(request.CustomVars["blob_path"]), "/"),
+		strings.TrimSpace(request.Bucket), request.ExternalBackupPath, repo.Tenant(ctx),
+	})
+	return string(key)
+}
+
+// checkVaultID accepts an id naming a single vault directory, gin doesn't clean the ids it takes from paths.
+func (b *BackupDaemon) checkVaultID(id string) error {
+	if id != filepath.Base(id) || id == "." || id == ".." || strings.Contains(id, `\`) || !b.storageRepo.IsVaultName(id) {
+		return fmt.Errorf("%w: %s doesn't match the vault name pattern", ErrInvalidBackupID, id)
+	}
+	return nil
+}
+
+// checkBackupID accepts a client supplied backup id matching the vault name pattern that no backup
+// or job uses yet.
+func (b *BackupDaemon) checkBackupID(ctx context.Context, request entity.BackupRequest) error {
+	id := request.BackupID
+	if len(request.ExternalBackupPath) > 0 {
+		return fmt.Errorf("%w: backupId can't be used with an external backup path", ErrInvalidBackupID)
+	}
+	if err := b.checkVaultID(id); err != nil {
+		return err
+	}
+	blobPath := strings.Trim(strings.TrimSpace(request.CustomVars["blob_path"]), "/")
+	if b.storageRepo.GetVault(id, false, "", blobPath, false).Folder != "" {
+		return fmt.Errorf("%w: %s", ErrBackupIDExists, id)
+	}
+	// ids are unique across tenants, a job of another tenant still takes it
+	_, err := b.dbRepo.SelectEverything(repo.WithTenant(ctx, ""), id)
+	if err == nil {
+		return fmt.Errorf("%w: %s", ErrBackupIDExists, id)
+	}
+	if !errors.Is(err, repo.ErrNotFound) {
+		return fmt.Errorf("failed to check backup id %s err: %w", id, err)
+	}
+	return nil
+}
+
+// backup runs one backup into a new vault, parentID is the per database backup it belongs to, if any.
+// jobStarted, if set, is called with the backup id once its job exists.
+func (b *BackupDaemon) backup(ctx context.Context, request entity.BackupRequest, retainUntil time.Time, parentID string,
+	jobStarted func(backupID string)) (entity.BackupResponse, error) {
+	release, err := b.acquireBackupSlot()
+	if err != nil {
+		return entity.BackupResponse{}, err
+	}
+	defer release()
+	bucket := strings.TrimSpace(request.Bucket)
+	s3Client, err := b.s3ClientFor(bucket)
+	if err != nil {
+		return entity.BackupResponse{}, err
+	}
+	if request.Mode == DISCOVERDATABASES {
+		dbs, err := b.discoverDatabases(request)
+		if err != nil {
+			return entity.BackupResponse{}, err
+		}
+		request.DBs = dbs
+	}
 	dirType := repo.FULL
 	if len(request.DBs) == 0 && len(request.ExternalBackupPath) == 0 {
 		dirType = repo.GRANULAR
 	}
 	var commonTS []string
-	var err error
-	if request.ProcType == INCREMENTAL {
+	// a requested base backup already set start_ts in EnqueueBackup
+	if request.ProcType == INCREMENTAL && request.BaseBackupID == "" {
 		if len(request.ExternalBackupPath) == 0 {
 			commonTS, err = b.storageRepo.ListVaultNames(true, repo.ALL, "")
 			if err != nil {
@@ -95,7 +517,7 @@ func (b *BackupDaemon) EnqueueBackup(ctx context.Context, request entity.BackupR
 	})
 
 	if len(commonTS) > 0 {
-		request.CustomVars["start_ts"] = commonTS[0]
+		request.CustomVars[STARTTS] = commonTS[0]
 	}
 	var isGranular bool
 	if len(request.DBs) > 0 {
@@ -106,15 +528,11 @@ func (b *BackupDaemon) EnqueueBackup(ctx context.Context, request entity.BackupR
 	if len(request.ExternalBackupPath) > 0 {
 		isExternal = true
 	}
-	blobPath := strings.TrimLeft(strings.TrimSpace(request.CustomVars["blob_path"]), "/")
-
-	var vault entity.Vault
-	if blobPath != "" {
-		vault = b.storageRepo.OpenVault("", request.AllowEviction, isGranular, request.Sharded, false, "", request.Prefix, blobPath)
-	} else {
-		vault = b.storageRepo.OpenVault(request.ExternalBackupPath, request.AllowEviction, isGranular, request.Sharded, isExternal, request.ExternalBackupPath, request.Prefix, "")
+	vault, unlock, err := b.openBackupVault(ctx, request, isGranular, isExternal)
+	if err != nil {
+		return entity.BackupResponse{}, err
 	}
-
+	defer unlock()
 	backupID := filepath.Base(vault.Folder)
 	dbNames := make([]string, 0, len(request.DBs))
 	for _, d := range request.DBs {
@@ -124,37 +542,74 @@ func (b *BackupDaemon) EnqueueBackup(ctx context.Context, request entity.BackupR
 	}
 	dbsJSON, _ := json.Marshal(dbNames)
 
-	job := entity.Job{TaskID: backupID, Type: action, Status: "Queued", Vault: backupID, Err: "", StorageName: request.CustomVars["storageName"], BlobPath: request.CustomVars["blob_path"], Databases: string(dbsJSON)}
+	job := entity.Job{TaskID: backupID, Type: action, Status: "Queued", Vault: backupID, Err: "", StorageName: request.CustomVars["storageName"], BlobPath: request.CustomVars["blob_path"], Databases: string(dbsJSON), Bucket: bucket,
+		ParentID: parentID, Request: storedRequest(request), OverwriteDatabases: true}
 
 	if err = b.dbRepo.UpdateJob(ctx, job); err != nil {
 		return entity.BackupResponse{}, fmt.Errorf("failed to update job err: %w", err)
 	}
+	job.OverwriteDatabases = false
+	if jobStarted != nil {
+		jobStarted(backupID)
+	}
 
-	if err := b.executor.PerformBackup(vault, request.DBs, request.CustomVars); err != nil {
+	if request.CommandOverride != "" {
+		b.logger.Warnw("Backup command is overridden by the request", "backup_id", backupID, "command", request.CommandOverride)
+	}
+	if err := b.executor.PerformBackup(vault, request.DBs, request.ExcludeDBs, request.CustomVars, request.CommandOverride); err != nil {
 		tail, _ := b.tailConsole(vault.Folder, 5)
+		if errors.Is(err, ErrBackupOutputFailed) {
+			// the matching line tells more than the last ones
+			tail = err.Error()
+		}
 		job.Status = "Failed"
+		if errors.Is(err, ErrBackupWatchCanceled) {
+			job.Status = "Canceled"
+			tail = err.Error()
+		}
 		job.Err = tail
 		_ = b.dbRepo.UpdateJob(ctx, job)
 		return entity.BackupResponse{}, err
 	}
+	if !retainUntil.IsZero() {
+		if err := b.storageRepo.LockUntil(vault, retainUntil); err != nil {
+			job.Status = "Failed"
+			job.Err = err.Error()
+			_ = b.dbRepo.UpdateJob(ctx, job)
+			return entity.BackupResponse{}, fmt.Errorf("failed to retain backup %s err: %w", backupID, err)
+		}
+		b.logger.Infof("Backup %s is retained until %s", backupID, retainUntil.Format(time.RFC3339))
+	}
 
 	// TODO
 	//b.scheduler.EnqueueExecution()
 	if b.s3Enable {
 		blobPath := strings.Trim(strings.TrimSpace(request.CustomVars["blob_path"]), "/")
 
-		if blobPath != "" {
-			backupID := filepath.Base(vault.Folder)
-			prefix := path.Join(blobPath, backupID)
-
-			err = b.s3Client.UploadFolderWithPrefix(ctx, vault.Folder, prefix)
-		} else {
-			err = b.s3Client.UploadFolder(ctx, vault.Folder)
-		}
-
-		if err != nil {
+		if err := uploadVault(ctx, s3Client, vault.Folder, blobPath); err != nil {
 			return entity.BackupResponse{}, fmt.Errorf("failed to upload folder to s3 err: %w", err)
 		}
+		if b.secondaryS3Client != nil {
+			if err := uploadVault(ctx, b.secondaryS3Client, vault.Folder, blobPath); err != nil {
+				if b.secondaryS3Required {
+					job.Status = "Failed"
+					job.Err = fmt.Sprintf("failed to replicate backup to secondary s3 err: %v", err)
+					_ = b.dbRepo.UpdateJob(ctx, job)
+					return entity.BackupResponse{}, fmt.Errorf("failed to replicate backup %s to secondary s3 err: %w", backupID, err)
+				}
+				b.logger.Errorf("failed to replicate backup %s to secondary s3 err: %v", backupID, err)
+			}
+		}
+	}
+	if b.localArchiveDir != "" {
+		archivePath := filepath.Join(b.localArchiveDir, backupID+".tar.gz")
+		if err := util.TarGz(vault.Folder, archivePath); err != nil {
+			job.Status = "Failed"
+			job.Err = err.Error()
+			_ = b.dbRepo.UpdateJob(ctx, job)
+			return entity.BackupResponse{}, fmt.Errorf("failed to archive backup %s err: %w", backupID, err)
+		}
+		job.ArchivePath = archivePath
 	}
 	job.Status = "Successful"
 	_ = b.dbRepo.UpdateJob(ctx, job)
@@ -164,33 +619,201 @@ func (b *BackupDaemon) EnqueueBackup(ctx context.Context, request entity.BackupR
 	}, nil
 }
 
+// openBackupVault makes room for a new backup within the caps, then opens and locks its vault. Both
+// happen under capMu and the vault counts against the caps until the returned func releases it, so
+// concurrent backups can't all pass a cap with room for one of them.
+func (b *BackupDaemon) openBackupVault(ctx context.Context, request entity.BackupRequest, isGranular bool,
+	isExternal bool) (entity.Vault, func(), error) {
+	b.capMu.Lock()
+	defer b.capMu.Unlock()
+	blobPath := strings.TrimLeft(strings.TrimSpace(request.CustomVars["blob_path"]), "/")
+	if !isExternal {
+		if err := b.checkFreeInodes(); err != nil {
+			return entity.Vault{}, nil, err
+		}
+		if err := b.enforceBackupCap(ctx, isGranular); err != nil {
+			return entity.Vault{}, nil, err
+		}
+		if err := b.enforceStorageQuota(ctx, request.CustomVars["storageName"]); err != nil {
+			return entity.Vault{}, nil, err
+		}
+	}
+
+	var vault entity.Vault
+	if blobPath != "" {
+		vault = b.storageRepo.OpenVault(request.BackupID, request.AllowEviction, isGranular, request.Sharded, false, "", request.Prefix, blobPath)
+	} else if request.BackupID != "" {
+		vault = b.storageRepo.OpenVault(request.BackupID, request.AllowEviction, isGranular, request.Sharded, false, "", request.Prefix, "")
+	} else {
+		vault = b.storageRepo.OpenVault(request.ExternalBackupPath, request.AllowEviction, isGranular, request.Sharded, isExternal, request.ExternalBackupPath, request.Prefix, "")
+	}
+
+	backupID := filepath.Base(vault.Folder)
+	unlock, ok := b.lockVault(backupID)
+	if !ok {
+		return entity.Vault{}, nil, fmt.Errorf("%w: %s", ErrVaultBusy, backupID)
+	}
+	if isExternal {
+		return vault, unlock, nil
+	}
+	if b.openVaults == nil {
+		b.openVaults = map[string]openVault{}
+	}
+	b.openVaults[backupID] = openVault{granular: isGranular, storageName: request.CustomVars["storageName"]}
+	return vault, func() {
+		b.capMu.Lock()
+		delete(b.openVaults, backupID)
+		b.capMu.Unlock()
+		unlock()
+	}, nil
+}
+
+// enqueuePerDBBackups backs up every requested database into its own vault and job, one after
+// another, under a parent job whose database statuses follow the children. The parent fails when
+// any child fails, the vaults of the others are kept. An error is returned only when all fail.
+func (b *BackupDaemon) enqueuePerDBBackups(ctx context.Context, request entity.BackupRequest, retainUntil time.Time,
+	jobStarted func(backupID string)) (entity.BackupResponse, error) {
+	if request.Mode == DISCOVERDATABASES {
+		dbs, err := b.discoverDatabases(request)
+		if err != nil {
+			return entity.BackupResponse{}, err
+		}
+		request.DBs = dbs
+		request.Mode = ""
+	}
+	var dbs []entity.DBEntry
+	var dbNames []string
+	for _, d := range request.DBs {
+		if d.SimpleName != "" {
+			dbs = append(dbs, d)
+			dbNames = append(dbNames, d.SimpleName)
+			continue
+		}
+		for _, name := range slices.Sorted(maps.Keys(d.Object)) {
+			dbs = append(dbs, entity.DBEntry{Object: map[string]entity.DBObject{name: d.Object[name]}})
+			dbNames = append(dbNames, name)
+		}
+	}
+	if len(dbs) < 2 {
+		request.DBs = dbs
+		return b.backup(ctx, request, retainUntil, "", jobStarted)
+	}
+
+	dbsJSON, _ := json.Marshal(dbNames)
+	parentID := request.BackupID
+	if parentID == "" {
+		parentID = uuid.New().String()
+	}
+	parent := entity.Job{
+		TaskID:      parentID,
+		Type:        PERDBBACKUP,
+		Status:      "Processing",
+		StorageName: request.CustomVars["storageName"],
+		BlobPath:    request.CustomVars["blob_path"],
+		Databases:   string(dbsJSON),
+		Bucket:      strings.TrimSpace(request.Bucket),
+		Request:     storedRequest(request),
+	}
+	statuses := make(map[string]string, len(dbNames))
+	parent.DatabaseStatuses = databaseStatuses(dbNames, "Queued", statuses)
+	parent.OverwriteDatabases = true
+	canceled, untrack := b.trackQueuedBackups(parent.TaskID)
+	defer untrack()
+	if err := b.dbRepo.UpdateJob(ctx, parent); err != nil {
+		return entity.BackupResponse{}, fmt.Errorf("failed to update job err: %w", err)
+	}
+	parent.OverwriteDatabases = false
+	if jobStarted != nil {
+		jobStarted(parent.TaskID)
+	}
+
+	response := entity.BackupResponse{BackupID: parent.TaskID}
+	var failed, skipped []string
+	var errs []error
+	var started time.Time
+	for i, db := range dbs {
+		if canceled.Load() {
+			statuses[dbNames[i]] = "Canceled"
+			skipped = append(skipped, dbNames[i])
+			continue
+		}
+		// vault names have a one second resolution, children must not share a vault
+		time.Sleep(time.Until(started.Truncate(time.Second).Add(time.Second)))
+		started = time.Now()
+
+		child := request
+		child.DBs = []entity.DBEntry{db}
+		child.BackupID = ""
+		child.CustomVars = maps.Clone(request.CustomVars)
+		childResponse, err := b.backup(ctx, child, retainUntil, parent.TaskID, nil)
+		if err != nil {
+			b.logger.Errorf("backup of database %s for %s failed: %v", dbNames[i], parent.TaskID, err)
+			statuses[dbNames[i]] = "Failed"
+			failed = append(failed, dbNames[i])
+			errs = append(errs, fmt.Errorf("database %s: %w", dbNames[i], err))
+		} else {
+			statuses[dbNames[i]] = "Successful"
+			response.Children = append(response.Children, childResponse.BackupID)
+		}
+		parent.DatabaseStatuses = databaseStatuses(dbNames, "Queued", statuses)
+		_ = b.dbRepo.UpdateJob(ctx, parent)
+	}
+
+	parent.Status = "Successful"
+	parent.DatabaseStatuses = databaseStatuses(dbNames, "Queued", statuses)
+	if len(failed) > 0 {
+		parent.Status = "Failed"
+		parent.Err = fmt.Sprintf("backups of databases %v failed", failed)
+	} else if len(skipped) > 0 {
+		parent.Status = "Canceled"
+		parent.Err = fmt.Sprintf("backups of databases %v were canceled", skipped)
+	}
+	if err := b.dbRepo.UpdateJob(ctx, parent); err != nil {
+		return entity.BackupResponse{}, fmt.Errorf("failed to update job err: %w", err)
+	}
+	if len(failed) == len(dbs) {
+		return entity.BackupResponse{}, errors.Join(errs...)
+	}
+	return response, nil
+}
+
 func (b *BackupDaemon) RestoreBackup(ctx context.Context, request entity.RestoreRequest) (entity.RestoreResponse, error) {
 	action := getRestoreAction(request.ProcType)
-	taskID := uuid.New().String()
+	if request.Test {
+		action = TESTRESTORE
+	}
+	if len(request.Vault) > 0 && len(request.ExternalBackupPath) == 0 {
+		if err := b.checkTenantVault(ctx, request.Vault); err != nil {
+			return entity.RestoreResponse{}, err
+		}
+	}
+	if err := b.checkRestoreNamespace(request.TargetNamespace); err != nil {
+		return entity.RestoreResponse{}, err
+	}
+	taskID := b.newRestoreID()
 	dbNames := make([]string, 0, len(request.DBs))
 	for _, d := range request.DBs {
 		if d.SimpleName != "" {
-			if newName, ok := request.ChangeDbNames[d.SimpleName]; ok {
-				dbNames = append(dbNames, newName)
-			} else {
-				dbNames = append(dbNames, d.SimpleName)
-			}
+			dbNames = append(dbNames, restoredName(d.SimpleName, request.ChangeDbNames))
 		}
 	}
 	dbsJSON, _ := json.Marshal(dbNames)
 
+	request.CustomVars = restoreCustomVars(request)
 	storageName := request.CustomVars["storageName"]
 	blobPath := strings.Trim(strings.TrimSpace(request.CustomVars["blob_path"]), "/")
 
 	err := b.dbRepo.UpdateJob(ctx, entity.Job{
-		TaskID:      taskID,
-		Type:        action,
-		Status:      "Queued",
-		Vault:       "",
-		Err:         "",
-		StorageName: storageName,
-		BlobPath:    blobPath,
-		Databases:   string(dbsJSON),
+		TaskID:             taskID,
+		Type:               action,
+		Status:             "Queued",
+		Vault:              "",
+		Err:                "",
+		StorageName:        storageName,
+		BlobPath:           blobPath,
+		Databases:          string(dbsJSON),
+		DatabaseStatuses:   databaseStatuses(dbNames, "Queued", nil),
+		OverwriteDatabases: true,
 	})
 	if err != nil {
 		return entity.RestoreResponse{}, fmt.Errorf("failed to update job err: %w", err)
@@ -205,9 +828,12 @@ func (b *BackupDaemon) RestoreBackup(ctx context.Context, request entity.Restore
 	if len(request.Vault) > 0 {
 		vault = b.storageRepo.GetVault(request.Vault, external, request.ExternalBackupPath, "", false)
 	} else {
-		vaultName, err := b.storageRepo.FindByTS(request.TimeStamp, repo.ALL, "")
+		vaultName, err := b.findRestoreVault(request)
 		if err != nil {
-			return entity.RestoreResponse{}, fmt.Errorf("failed to find backup by ts %s err: %w", request.TimeStamp, err)
+			return entity.RestoreResponse{}, err
+		}
+		if err := b.checkTenantVault(ctx, vaultName); err != nil {
+			return entity.RestoreResponse{}, err
 		}
 		vault = b.storageRepo.GetVault(vaultName, external, request.ExternalBackupPath, "", false)
 	}
@@ -215,20 +841,43 @@ func (b *BackupDaemon) RestoreBackup(ctx context.Context, request entity.Restore
 	b.logger.Infof("Starting process from: %s, %s", request.ExternalBackupPath, vault.Folder)
 
 	var vaultFolder string
+	// tempFolder holds a copy downloaded or extracted for this restore, removed once it ends
+	var tempFolder string
+	downloadProgress := b.restoreDownloadProgress(ctx, entity.Job{
+		TaskID:           taskID,
+		Type:             action,
+		Status:           "Processing",
+		Vault:            filepath.Base(request.Vault),
+		StorageName:      storageName,
+		BlobPath:         blobPath,
+		Databases:        string(dbsJSON),
+		DatabaseStatuses: databaseStatuses(dbNames, "Processing", nil),
+	})
+	defer func() {
+		b.removeRestoreTemp(tempFolder)
+	}()
 
 	if b.s3Enable && blobPath != "" {
 		s3Prefix := path.Join(blobPath, request.Vault)
 
 		vaultFolder = filepath.Join(os.TempDir(), "backup-daemon", "restore", request.Vault)
+		tempFolder = vaultFolder
 
 		_ = os.RemoveAll(vaultFolder)
 		if err := os.MkdirAll(vaultFolder, 0o755); err != nil {
 			return entity.RestoreResponse{}, fmt.Errorf("failed to create restore dir %s: %w", vaultFolder, err)
 		}
 
-		if err := b.s3Client.DownloadFolder(ctx, s3Prefix, vaultFolder); err != nil {
+		s3Client, err := b.s3ClientFor(b.vaultBucket(ctx, request.Vault))
+		if err != nil {
+			return entity.RestoreResponse{}, err
+		}
+		if err := s3Client.DownloadFolder(ctx, s3Prefix, vaultFolder, downloadProgress); err != nil {
 			return entity.RestoreResponse{}, fmt.Errorf("failed to download backup from s3 prefix=%s err: %w", s3Prefix, err)
 		}
+		if b.storageRepo.IsIncomplete(entity.Vault{Folder: vaultFolder}) {
+			return entity.RestoreResponse{}, fmt.Errorf("%w: vault %s was interrupted", ErrBackupIncomplete, s3Prefix)
+		}
 	} else {
 		var vault entity.Vault
 		external := len(request.ExternalBackupPath) > 0
@@ -236,21 +885,79 @@ func (b *BackupDaemon) RestoreBackup(ctx context.Context, request entity.Restore
 		if len(request.Vault) > 0 {
 			vault = b.storageRepo.GetVault(request.Vault, external, request.ExternalBackupPath, "", false)
 		} else {
-			vaultName, err := b.storageRepo.FindByTS(request.TimeStamp, repo.ALL, "")
+			vaultName, err := b.findRestoreVault(request)
 			if err != nil {
-				return entity.RestoreResponse{}, fmt.Errorf("failed to find backup by ts %s err: %w", request.TimeStamp, err)
+				return entity.RestoreResponse{}, err
 			}
 			vault = b.storageRepo.GetVault(vaultName, external, request.ExternalBackupPath, "", false)
 		}
 
+		if reflect.DeepEqual(vault, entity.Vault{}) {
+			return entity.RestoreResponse{}, fmt.Errorf("%w: vault %s", ErrVaultNotFound, request.Vault)
+		}
+		if vault.IsIncomplete {
+			return entity.RestoreResponse{}, fmt.Errorf("%w: vault %s was interrupted", ErrBackupIncomplete, filepath.Base(vault.Folder))
+		}
 		vaultFolder = vault.Folder
 
-		if b.s3Enable {
-			if err := b.s3Client.DownloadFolder(ctx, vaultFolder, ""); err != nil {
+		if archivePath := b.localArchive(vaultFolder); archivePath != "" {
+			vaultFolder = filepath.Join(os.TempDir(), "backup-daemon", "restore", filepath.Base(vaultFolder))
+			tempFolder = vaultFolder
+			_ = os.RemoveAll(vaultFolder)
+			if err := util.ExtractTarGz(archivePath, vaultFolder); err != nil {
+				return entity.RestoreResponse{}, fmt.Errorf("failed to extract backup archive %s err: %w", archivePath, err)
+			}
+		} else if b.s3Enable {
+			s3Client, err := b.s3ClientFor(b.vaultBucket(ctx, vaultFolder))
+			if err != nil {
+				return entity.RestoreResponse{}, err
+			}
+			// vault keys mirror the local path, download them back in place
+			if err := s3Client.DownloadFolder(ctx, vaultFolder, vaultFolder, downloadProgress); err != nil {
 				return entity.RestoreResponse{}, fmt.Errorf("failed to download backup err: %w", err)
 			}
 		}
 	}
+	if err := checkManifest(vaultFolder); err != nil {
+		_ = b.dbRepo.UpdateJob(ctx, entity.Job{
+			TaskID:           taskID,
+			Type:             action,
+			Status:           "Failed",
+			Vault:            filepath.Base(request.Vault),
+			Err:              err.Error(),
+			StorageName:      storageName,
+			BlobPath:         blobPath,
+			Databases:        string(dbsJSON),
+			DatabaseStatuses: databaseStatuses(dbNames, "Failed", nil),
+		})
+		return entity.RestoreResponse{}, err
+	}
+	renameAll := request.RenamePrefix != "" || request.RenameSuffix != "" || request.TargetNamespace != ""
+	if renameAll {
+		dbmap, err := b.renameDbMap(vaultFolder, request)
+		if err != nil {
+			_ = b.dbRepo.UpdateJob(ctx, entity.Job{
+				TaskID:           taskID,
+				Type:             action,
+				Status:           "Failed",
+				Vault:            filepath.Base(request.Vault),
+				Err:              err.Error(),
+				StorageName:      storageName,
+				BlobPath:         blobPath,
+				Databases:        string(dbsJSON),
+				DatabaseStatuses: databaseStatuses(dbNames, "Failed", nil),
+			})
+			return entity.RestoreResponse{}, err
+		}
+		request.ChangeDbNames = dbmap
+		dbNames = dbNames[:0]
+		for _, d := range request.DBs {
+			if d.SimpleName != "" {
+				dbNames = append(dbNames, restoredName(d.SimpleName, dbmap))
+			}
+		}
+		dbsJSON, _ = json.Marshal(dbNames)
+	}
 	if len(request.DBs) > 0 {
 		backedDBs, err := b.executor.GetBackupDBs(vaultFolder)
 		if err != nil {
@@ -275,15 +982,20 @@ func (b *BackupDaemon) RestoreBackup(ctx context.Context, request entity.Restore
 			}
 		}
 		if len(wrong) > 0 {
+			failed := make(map[string]string, len(wrong))
+			for _, db := range wrong {
+				failed[restoredName(db, request.ChangeDbNames)] = "Failed"
+			}
 			err = b.dbRepo.UpdateJob(ctx, entity.Job{
-				TaskID:      taskID,
-				Type:        action,
-				Status:      "Failed",
-				Vault:       filepath.Base(request.Vault),
-				Err:         fmt.Sprintf("Sorry, but databases %v do not exist in backup %s", wrong, vaultFolder),
-				StorageName: storageName,
-				BlobPath:    blobPath,
-				Databases:   string(dbsJSON),
+				TaskID:           taskID,
+				Type:             action,
+				Status:           "Failed",
+				Vault:            filepath.Base(request.Vault),
+				Err:              fmt.Sprintf("Sorry, but databases %v do not exist in backup %s", wrong, vaultFolder),
+				StorageName:      storageName,
+				BlobPath:         blobPath,
+				Databases:        string(dbsJSON),
+				DatabaseStatuses: databaseStatuses(dbNames, "Skipped", failed),
 			})
 			if err != nil {
 				return entity.RestoreResponse{}, fmt.Errorf("failed to update job err: %w", err)
@@ -294,14 +1006,15 @@ func (b *BackupDaemon) RestoreBackup(ctx context.Context, request entity.Restore
 			for old := range request.ChangeDbNames {
 				if !backed[old] {
 					err = b.dbRepo.UpdateJob(ctx, entity.Job{
-						TaskID:      taskID,
-						Type:        action,
-						Status:      "Failed",
-						Vault:       filepath.Base(request.Vault),
-						Err:         fmt.Sprintf("Sorry, but database name %s from dbmap does not exist in backup %s", old, vaultFolder),
-						StorageName: storageName,
-						BlobPath:    blobPath,
-						Databases:   string(dbsJSON),
+						TaskID:           taskID,
+						Type:             action,
+						Status:           "Failed",
+						Vault:            filepath.Base(request.Vault),
+						Err:              fmt.Sprintf("Sorry, but database name %s from dbmap does not exist in backup %s", old, vaultFolder),
+						StorageName:      storageName,
+						BlobPath:         blobPath,
+						Databases:        string(dbsJSON),
+						DatabaseStatuses: databaseStatuses(dbNames, "Skipped", map[string]string{request.ChangeDbNames[old]: "Failed"}),
 					})
 					if err != nil {
 						return entity.RestoreResponse{}, fmt.Errorf("failed to update job err: %w", err)
@@ -310,64 +1023,164 @@ func (b *BackupDaemon) RestoreBackup(ctx context.Context, request entity.Restore
 				}
 			}
 		}
-		// TODO python code
-		//else:
-		//if not configuration.config.enable_full_restore and not dbs:
-		//error_message = \
-		//"Sorry, but vault %s contains full backup of database, you can't restore it fully via REST API" \
-		//% self.trim_storage_from_vault(vault_folder)
-		//log.error(error_message)
-		//self.db.update_job(task_id, action, "Failed", self.trim_storage_from_vault(vault_folder),
-		//	error_message, login=True)
-		//return
+	} else if !request.Test && !b.enableFullRestore && !external && b.isFullBackup(ctx, vault, request.Vault) {
+		errorMessage := fmt.Sprintf("Sorry, but vault %s contains full backup of database, you can't restore it fully via REST API",
+			filepath.Base(vaultFolder))
+		b.logger.Error(errorMessage)
+		err = b.dbRepo.UpdateJob(ctx, entity.Job{
+			TaskID:      taskID,
+			Type:        action,
+			Status:      "Failed",
+			Vault:       filepath.Base(request.Vault),
+			Err:         errorMessage,
+			StorageName: storageName,
+			BlobPath:    blobPath,
+			Databases:   string(dbsJSON),
+		})
+		if err != nil {
+			return entity.RestoreResponse{}, fmt.Errorf("failed to update job err: %w", err)
+		}
+		return entity.RestoreResponse{}, fmt.Errorf("%w: vault %s", ErrFullRestoreDisabled, filepath.Base(vaultFolder))
+	}
+	// renameDbMap already checked the targets of a dbmap it built
+	if len(request.ChangeDbNames) > 0 && !renameAll {
+		if err := b.checkDbMapTargets(vaultFolder, request); err != nil {
+			_ = b.dbRepo.UpdateJob(ctx, entity.Job{
+				TaskID:           taskID,
+				Type:             action,
+				Status:           "Failed",
+				Vault:            filepath.Base(request.Vault),
+				Err:              err.Error(),
+				StorageName:      storageName,
+				BlobPath:         blobPath,
+				Databases:        string(dbsJSON),
+				DatabaseStatuses: databaseStatuses(dbNames, "Failed", nil),
+			})
+			return entity.RestoreResponse{}, err
+		}
 	}
 	err = b.dbRepo.UpdateJob(ctx, entity.Job{
-		TaskID:      taskID,
-		Type:        action,
-		Status:      "Processing",
-		Vault:       filepath.Base(request.Vault),
-		Err:         "",
-		StorageName: storageName,
-		BlobPath:    blobPath,
-		Databases:   string(dbsJSON),
+		TaskID:           taskID,
+		Type:             action,
+		Status:           "Processing",
+		Vault:            filepath.Base(request.Vault),
+		Err:              "",
+		StorageName:      storageName,
+		BlobPath:         blobPath,
+		Databases:        string(dbsJSON),
+		DatabaseStatuses: databaseStatuses(dbNames, "Processing", nil),
 	})
 	if err != nil {
 		return entity.RestoreResponse{}, fmt.Errorf("failed to update job err: %w", err)
 	}
 
-	err = b.executor.PerformRestore(vaultFolder, request.DBs, request.ChangeDbNames, request.CustomVars, external, taskID)
+	if request.Test {
+		// a test restore works on a scratch copy, so the vault is left as it was
+		scratchFolder := filepath.Join(os.TempDir(), "backup-daemon", "test-restore", taskID)
+		defer b.removeRestoreTemp(scratchFolder)
+		if err := util.CopyDir(vaultFolder, scratchFolder); err != nil {
+			_ = b.dbRepo.UpdateJob(ctx, entity.Job{
+				TaskID:           taskID,
+				Type:             action,
+				Status:           "Failed",
+				Vault:            filepath.Base(request.Vault),
+				Err:              err.Error(),
+				StorageName:      storageName,
+				BlobPath:         blobPath,
+				Databases:        string(dbsJSON),
+				DatabaseStatuses: databaseStatuses(dbNames, "Failed", nil),
+			})
+			return entity.RestoreResponse{}, fmt.Errorf("failed to copy vault %s for test restore err: %w", vaultFolder, err)
+		}
+		vaultFolder = scratchFolder
+		err = b.executor.PerformTestRestore(vaultFolder, request.DBs, request.ChangeDbNames, request.CustomVars, taskID)
+	} else {
+		err = b.executor.PerformRestore(vaultFolder, request.DBs, request.ChangeDbNames, request.CustomVars, external, taskID)
+	}
 	b.uploadRestoreLogsToS3(ctx, vaultFolder, request.CustomVars["blob_path"], request.Vault, taskID)
 
 	if err != nil {
+		// a restore run per database failed only on some of them, the others are restored
+		var dbErr *DBRestoreError
+		if errors.As(err, &dbErr) {
+			failed := make(map[string]string, len(dbErr.Failed))
+			for db := range dbErr.Failed {
+				failed[restoredName(db, request.ChangeDbNames)] = "Failed"
+			}
+			if updateErr := b.dbRepo.UpdateJob(ctx, entity.Job{
+				TaskID:           taskID,
+				Type:             action,
+				Status:           "Failed",
+				Vault:            filepath.Base(request.Vault),
+				Err:              err.Error(),
+				StorageName:      storageName,
+				BlobPath:         blobPath,
+				Databases:        string(dbsJSON),
+				DatabaseStatuses: databaseStatuses(dbNames, "Successful", failed),
+			}); updateErr != nil {
+				return entity.RestoreResponse{}, fmt.Errorf("failed to update job: %w", updateErr)
+			}
+			return entity.RestoreResponse{}, err
+		}
 		lineNumber := 5
 		tail, errTail := b.tailConsole(vaultFolder, lineNumber)
 		if errTail != nil {
 			return entity.RestoreResponse{}, fmt.Errorf("failed to tail err: %w", errTail)
 		}
 		if updateErr := b.dbRepo.UpdateJob(ctx, entity.Job{
-			TaskID:      taskID,
-			Type:        action,
-			Status:      "Failed",
-			Vault:       filepath.Base(request.Vault),
-			Err:         tail,
-			StorageName: storageName,
-			BlobPath:    blobPath,
-			Databases:   string(dbsJSON),
+			TaskID:           taskID,
+			Type:             action,
+			Status:           "Failed",
+			Vault:            filepath.Base(request.Vault),
+			Err:              tail,
+			StorageName:      storageName,
+			BlobPath:         blobPath,
+			Databases:        string(dbsJSON),
+			DatabaseStatuses: databaseStatuses(dbNames, "Failed", nil),
 		}); updateErr != nil {
 			return entity.RestoreResponse{}, fmt.Errorf("failed to update job: %w", updateErr)
 		}
 		return entity.RestoreResponse{}, err
 	}
 
+	if b.verifyAfterRestore && !request.Test {
+		missing, err := b.missingRestoredDBs(vaultFolder, request)
+		if err == nil && len(missing) > 0 {
+			err = fmt.Errorf("%w: %v", ErrRestoreNotVerified, missing)
+		}
+		if err != nil {
+			b.logger.Errorf("restore %s is not verified: %v", taskID, err)
+			failed := make(map[string]string, len(missing))
+			for _, db := range missing {
+				failed[db] = "Failed"
+			}
+			if updateErr := b.dbRepo.UpdateJob(ctx, entity.Job{
+				TaskID:           taskID,
+				Type:             action,
+				Status:           "Failed",
+				Vault:            filepath.Base(request.Vault),
+				Err:              err.Error(),
+				StorageName:      storageName,
+				BlobPath:         blobPath,
+				Databases:        string(dbsJSON),
+				DatabaseStatuses: databaseStatuses(dbNames, "Successful", failed),
+			}); updateErr != nil {
+				return entity.RestoreResponse{}, fmt.Errorf("failed to update job: %w", updateErr)
+			}
+			return entity.RestoreResponse{}, err
+		}
+	}
+
 	err = b.dbRepo.UpdateJob(ctx, entity.Job{
-		TaskID:      taskID,
-		Type:        action,
-		Status:      "Successful",
-		Vault:       filepath.Base(request.Vault),
-		Err:         "",
-		StorageName: storageName,
-		BlobPath:    blobPath,
-		Databases:   string(dbsJSON),
+		TaskID:           taskID,
+		Type:             action,
+		Status:           "Successful",
+		Vault:            filepath.Base(request.Vault),
+		Err:              "",
+		StorageName:      storageName,
+		BlobPath:         blobPath,
+		Databases:        string(dbsJSON),
+		DatabaseStatuses: databaseStatuses(dbNames, "Successful", nil),
 	})
 	if err != nil {
 		return entity.RestoreResponse{}, fmt.Errorf("failed to update job err: %w", err)
@@ -378,49 +1191,1092 @@ func (b *BackupDaemon) RestoreBackup(ctx context.Context, request entity.Restore
 	}, nil
 }
 
-func (b *BackupDaemon) EnqueueEviction(ctx context.Context, request entity.EvictRequest) error {
-	excludedFiles, err := b.storageRepo.GetNonEvictableVaults(repo.ALL)
-	if err != nil {
-		return fmt.Errorf("failed to list all non evictable vaults err: %w", err)
+// RestoreFromURL downloads a backup archive from an allowed https url, extracts it to the
+// restore temp dir and runs the restore command against it.
+func (b *BackupDaemon) RestoreFromURL(ctx context.Context, request entity.RestoreFromURLRequest) (entity.RestoreResponse, error) {
+	if err := b.checkArchiveURL(request.URL); err != nil {
+		return entity.RestoreResponse{}, err
 	}
-
-	fullVaults, err := b.storageRepo.List(repo.FULL, "")
-	if err != nil {
-		return fmt.Errorf("failed to list full vaults err: %w", err)
+	if err := b.checkRestoreNamespace(""); err != nil {
+		return entity.RestoreResponse{}, err
 	}
 
-	obsoleteFullVaults, err := b.evict(fullVaults, b.evictionPolicy, excludedFiles)
-	if err != nil {
-		return fmt.Errorf("failed to list evict full vaults err: %w", err)
+	taskID := b.newRestoreID()
+	dbNames := make([]string, 0, len(request.DBs))
+	for _, d := range request.DBs {
+		if d.SimpleName != "" {
+			dbNames = append(dbNames, restoredName(d.SimpleName, request.ChangeDbNames))
+		}
 	}
-
-	granularVaults, err := b.storageRepo.List(repo.GRANULAR, "")
-	if err != nil {
-		return fmt.Errorf("failed to list granular vaults err: %w", err)
+	dbsJSON, _ := json.Marshal(dbNames)
+	customVars := restoreCustomVars(entity.RestoreRequest{CustomVars: request.CustomVars, Clean: request.Clean})
+
+	job := entity.Job{
+		TaskID:           taskID,
+		Type:             COMMONRESTORE,
+		Status:           "Processing",
+		Databases:        string(dbsJSON),
+		DatabaseStatuses: databaseStatuses(dbNames, "Processing", nil),
 	}
-
-	obsoleteGranularVaults, err := b.evict(granularVaults, b.granularEvictionPolicy, excludedFiles)
-	if err != nil {
-		return fmt.Errorf("failed to list evict granular vaults err: %w", err)
+	if err := b.dbRepo.UpdateJob(ctx, job); err != nil {
+		return entity.RestoreResponse{}, fmt.Errorf("failed to update job err: %w", err)
+	}
+	fail := func(err error) (entity.RestoreResponse, error) {
+		job.Status = "Failed"
+		job.Err = err.Error()
+		job.DatabaseStatuses = databaseStatuses(dbNames, "Failed", nil)
+		_ = b.dbRepo.UpdateJob(ctx, job)
+		return entity.RestoreResponse{}, err
 	}
 
-	obsoleteVaults := append(obsoleteFullVaults, obsoleteGranularVaults...)
-	for _, obsoleteVault := range obsoleteVaults {
-		err = b.storageRepo.Evict(obsoleteVault.Folder)
-		if err != nil {
-			return fmt.Errorf("failed to evict backup %s from storage err: %w", obsoleteVault.Folder, err)
-		}
-		err = b.dbRepo.RemoveVault(ctx, b.storageRepo.GetName(obsoleteVault.Folder))
-		if err != nil {
-			return fmt.Errorf("failed to remove backup %s from database err: %w", obsoleteVault.Folder, err)
+	restoreDir := filepath.Join(os.TempDir(), "backup-daemon", "restore", taskID)
+	archivePath := restoreDir + ".tar.gz"
+	defer func() {
+		if !b.keepRestoreTemp {
+			_ = os.Remove(archivePath)
 		}
-		err = b.executor.ExecuteEvictCmd(obsoleteVault.Folder)
-		if err != nil {
-			return fmt.Errorf("failed to evict backup from executor err: %w", err)
+		b.removeRestoreTemp(restoreDir)
+	}()
+
+	client := *b.httpClient
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
 		}
+		return b.checkArchiveURL(req.URL.String())
+	}
+	b.logger.Infof("Downloading backup archive from %s for restore %s", request.URL, taskID)
+	if err := util.DownloadFile(ctx, &client, request.URL, archivePath, b.restoreURLMaxSize); err != nil {
+		return fail(fmt.Errorf("failed to download backup archive err: %w", err))
+	}
+	if err := util.ExtractTarGz(archivePath, restoreDir); err != nil {
+		return fail(fmt.Errorf("failed to extract backup archive err: %w", err))
 	}
-	return nil
-}
+
+	if err := b.executor.PerformRestore(restoreDir, request.DBs, request.ChangeDbNames, customVars, true, taskID); err != nil {
+		return fail(err)
+	}
+
+	job.Status = "Successful"
+	job.DatabaseStatuses = databaseStatuses(dbNames, "Successful", nil)
+	if err := b.dbRepo.UpdateJob(ctx, job); err != nil {
+		return entity.RestoreResponse{}, fmt.Errorf("failed to update job err: %w", err)
+	}
+	return entity.RestoreResponse{TaskID: taskID}, nil
+}
+
+// checkArchiveURL accepts only https urls whose host is in the restore url allowlist.
+func (b *BackupDaemon) checkArchiveURL(rawURL string) error {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrURLNotAllowed, err)
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %q, only https is supported", ErrURLNotAllowed, u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range b.restoreURLAllowedHosts {
+		if host != "" && host == strings.ToLower(strings.TrimSpace(allowed)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: host %q is not in the allowed hosts", ErrURLNotAllowed, host)
+}
+
+func (b *BackupDaemon) RestoreLatestBackup(ctx context.Context, request entity.RestoreLatestRequest) (entity.RestoreResponse, error) {
+	typeOfBackup := request.TypeOfBackup
+	if typeOfBackup == "" {
+		typeOfBackup = repo.ALL
+	}
+	vaults, err := b.storageRepo.List(typeOfBackup, "")
+	if err != nil && !errors.Is(err, repo.ErrNoVaults) {
+		return entity.RestoreResponse{}, fmt.Errorf("failed to list %s vaults err: %w", typeOfBackup, err)
+	}
+
+	if repo.Tenant(ctx) != "" {
+		vaults = slices.DeleteFunc(vaults, func(vault entity.Vault) bool {
+			return b.checkTenantVault(ctx, vault.Folder) != nil
+		})
+	}
+	latest, golden := b.goldenVault(vaults)
+	for i := len(vaults) - 1; i >= 0 && !golden; i-- {
+		if b.storageRepo.IsSuccessful(vaults[i]) {
+			latest = b.storageRepo.GetName(vaults[i].Folder)
+			break
+		}
+	}
+	if latest == "" {
+		return entity.RestoreResponse{}, fmt.Errorf("%w for type %s", ErrNoSuccessfulBackup, typeOfBackup)
+	}
+	if golden {
+		b.logger.Infof("Restoring golden %s backup %s", typeOfBackup, latest)
+	} else {
+		b.logger.Infof("Restoring latest successful %s backup %s", typeOfBackup, latest)
+	}
+
+	restore := request.Restore
+	restore.Vault = latest
+	restore.TimeStamp = ""
+	response, err := b.RestoreBackup(ctx, restore)
+	if err != nil {
+		return entity.RestoreResponse{}, err
+	}
+	response.Vault = latest
+	return response, nil
+}
+
+// RestoreMulti restores several vaults in order. Every vault is checked to exist before the
+// first restore starts, a failure stops the rest unless ContinueOnError is set.
+func (b *BackupDaemon) RestoreMulti(ctx context.Context, request entity.MultiRestoreRequest) (entity.MultiRestoreResponse, error) {
+	vaults, err := b.multiRestoreVaults(request)
+	if err != nil {
+		return entity.MultiRestoreResponse{}, err
+	}
+	b.logger.Infof("Restoring %d vaults: %v", len(vaults), vaults)
+
+	var response entity.MultiRestoreResponse
+	var errs []error
+	for i, vault := range vaults {
+		restored, err := b.RestoreBackup(ctx, entity.RestoreRequest{
+			Vault:         vault,
+			ChangeDbNames: request.ChangeDbNames,
+			CustomVars:    request.CustomVars,
+			Clean:         request.Clean,
+			ProcType:      request.ProcType,
+		})
+		if err != nil {
+			b.logger.Errorf("failed to restore vault %s: %v", vault, err)
+			response.Failed = append(response.Failed, entity.RestoreFailure{Vault: vault, Error: err.Error()})
+			errs = append(errs, fmt.Errorf("failed to restore vault %s err: %w", vault, err))
+			if !request.ContinueOnError {
+				response.Skipped = vaults[i+1:]
+				break
+			}
+			continue
+		}
+		restored.Vault = vault
+		response.Restored = append(response.Restored, restored)
+	}
+	return response, errors.Join(errs...)
+}
+
+// findRestoreVault resolves the vault of a restore request given by ts or a fromTs/toTs window.
+func (b *BackupDaemon) findRestoreVault(request entity.RestoreRequest) (string, error) {
+	if request.FromTs == "" && request.ToTs == "" {
+		vaultName, err := b.storageRepo.FindByTS(request.TimeStamp, repo.ALL, "")
+		if err != nil {
+			return "", fmt.Errorf("failed to find backup by ts %s err: %w", request.TimeStamp, err)
+		}
+		return vaultName, nil
+	}
+	vaultName, err := b.storageRepo.FindLatestInRange(request.FromTs, request.ToTs, repo.ALL, "")
+	if errors.Is(err, repo.ErrNoVaults) {
+		return "", fmt.Errorf("%w: no backup between %s and %s", ErrBackupNotFound, request.FromTs, request.ToTs)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to find backup between %s and %s err: %w", request.FromTs, request.ToTs, err)
+	}
+	return vaultName, nil
+}
+
+// multiRestoreVaults resolves the vaults of a multi restore request, oldest first for a time range.
+func (b *BackupDaemon) multiRestoreVaults(request entity.MultiRestoreRequest) ([]string, error) {
+	ranged := request.From != "" || request.To != ""
+	if len(request.Vaults) > 0 && ranged {
+		return nil, fmt.Errorf("%w: set either vaults or a from/to range", ErrInvalidMultiRestore)
+	}
+	if len(request.Vaults) > 0 {
+		var missing []string
+		vaults := make([]string, 0, len(request.Vaults))
+		for _, name := range request.Vaults {
+			name = strings.TrimSpace(name)
+			if b.storageRepo.GetVault(name, false, "", "", false).Folder == "" {
+				missing = append(missing, name)
+				continue
+			}
+			vaults = append(vaults, name)
+		}
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("%w: %v", ErrBackupNotFound, missing)
+		}
+		return vaults, nil
+	}
+	if !ranged {
+		return nil, fmt.Errorf("%w: no vaults or from/to range", ErrInvalidMultiRestore)
+	}
+
+	from, err := parseTimestampBound(request.From, 0)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseTimestampBound(request.To, math.MaxInt64)
+	if err != nil {
+		return nil, err
+	}
+	all, err := b.storageRepo.List(repo.ALL, "")
+	if err != nil && !errors.Is(err, repo.ErrNoVaults) {
+		return nil, fmt.Errorf("failed to list vaults err: %w", err)
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].TimeStamp < all[j].TimeStamp
+	})
+	var vaults []string
+	for _, vault := range all {
+		if vault.TimeStamp >= from && vault.TimeStamp <= to {
+			vaults = append(vaults, b.storageRepo.GetName(vault.Folder))
+		}
+	}
+	if len(vaults) == 0 {
+		return nil, fmt.Errorf("%w: no vaults between %s and %s", ErrBackupNotFound, request.From, request.To)
+	}
+	return vaults, nil
+}
+
+func parseTimestampBound(value string, unset int64) (int64, error) {
+	if value == "" {
+		return unset, nil
+	}
+	ts, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: timestamp %s is in incorrect format", ErrInvalidMultiRestore, value)
+	}
+	return ts, nil
+}
+
+// goldenVault returns the golden backup name when it is among vaults and restorable.
+func (b *BackupDaemon) goldenVault(vaults []entity.Vault) (string, bool) {
+	golden, err := b.storageRepo.GetGolden()
+	if err != nil {
+		if !errors.Is(err, repo.ErrNoGolden) {
+			b.logger.Warnf("failed to get golden backup, falling back to the latest one: %v", err)
+		}
+		return "", false
+	}
+	for _, vault := range vaults {
+		if vault.Folder == golden.Folder && b.storageRepo.IsSuccessful(vault) {
+			return b.storageRepo.GetName(vault.Folder), true
+		}
+	}
+	return "", false
+}
+
+func (b *BackupDaemon) PromoteBackup(ctx context.Context, backupID string) error {
+	vaultNames, err := b.storageRepo.ListVaultNames(false, repo.ALL, "")
+	if err != nil {
+		return fmt.Errorf("failed to list all backup err: %w", err)
+	}
+	if !contains(vaultNames, backupID) {
+		return fmt.Errorf("%w: vault %s", ErrBackupNotFound, backupID)
+	}
+	if err := b.checkTenantVault(ctx, backupID); err != nil {
+		return err
+	}
+	vault := b.storageRepo.GetVault(backupID, false, "", "", false)
+	if reflect.DeepEqual(vault, entity.Vault{}) {
+		return fmt.Errorf("%w: vault %s", ErrBackupNotFound, backupID)
+	}
+	if !b.storageRepo.IsSuccessful(vault) {
+		return fmt.Errorf("%w: vault %s", ErrBackupNotSuccessful, backupID)
+	}
+	if err := b.storageRepo.SetGolden(vault); err != nil {
+		return fmt.Errorf("failed to promote backup err: %w", err)
+	}
+	b.logger.Infof("Backup %s promoted to golden", backupID)
+	return nil
+}
+
+func (b *BackupDaemon) GetGoldenBackup(ctx context.Context) (entity.GoldenBackupResponse, error) {
+	golden, err := b.storageRepo.GetGolden()
+	if err != nil {
+		return entity.GoldenBackupResponse{}, fmt.Errorf("failed to get golden backup err: %w", err)
+	}
+	return entity.GoldenBackupResponse{
+		BackupID:   b.storageRepo.GetName(golden.Folder),
+		IsGranular: golden.IsGranular,
+	}, nil
+}
+
+// FailJob force fails a Queued or Processing job, e.g. one left behind by a killed daemon.
+func (b *BackupDaemon) FailJob(ctx context.Context, taskID string) error {
+	job, err := b.dbRepo.SelectEverything(ctx, taskID)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return fmt.Errorf("%w: %s", ErrJobNotFound, taskID)
+		}
+		return fmt.Errorf("failed to select job err: %w", err)
+	}
+	if job.Status != "Queued" && job.Status != "Processing" {
+		return fmt.Errorf("%w: %s is %s", ErrJobFinished, taskID, job.Status)
+	}
+	job.Status = "Failed"
+	job.Err = "failed manually via admin API"
+	if err := b.dbRepo.UpdateJob(ctx, job); err != nil {
+		return fmt.Errorf("failed to update job err: %w", err)
+	}
+	b.logger.Infof("Job %s was failed manually", taskID)
+	return nil
+}
+
+// CancelBackup cancels the databases of a per database backup that have not started yet, the backup ends
+// Canceled once the running one is done. The command of a running backup is not interrupted, a watched
+// backup waiting for its completion marker stops waiting and ends Canceled.
+func (b *BackupDaemon) CancelBackup(ctx context.Context, backupID string) error {
+	job, err := b.dbRepo.SelectEverything(ctx, backupID)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return fmt.Errorf("%w: %s", ErrJobNotFound, backupID)
+		}
+		return fmt.Errorf("failed to select job err: %w", err)
+	}
+	if job.Status != "Queued" && job.Status != "Processing" {
+		return fmt.Errorf("%w: %s is %s", ErrJobFinished, backupID, job.Status)
+	}
+	b.queuedMu.Lock()
+	canceled, ok := b.queuedCancels[backupID]
+	b.queuedMu.Unlock()
+	if !ok {
+		if b.executor.CancelWatch(backupID) {
+			b.logger.Infof("Watched backup %s is canceled", backupID)
+			return nil
+		}
+		return fmt.Errorf("%w: %s is running", ErrBackupNotQueued, backupID)
+	}
+	canceled.Store(true)
+	b.logger.Infof("Queued databases of backup %s are canceled", backupID)
+	return nil
+}
+
+// RetryBackup enqueues the request a failed or canceled backup was started with again, under a new id.
+func (b *BackupDaemon) RetryBackup(ctx context.Context, backupID string) (entity.BackupResponse, error) {
+	job, err := b.dbRepo.SelectEverything(ctx, backupID)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return entity.BackupResponse{}, fmt.Errorf("%w: %s", ErrJobNotFound, backupID)
+		}
+		return entity.BackupResponse{}, fmt.Errorf("failed to select job err: %w", err)
+	}
+	if job.Type != COMMONBACKUP && job.Type != INCREMENTALBACKUP && job.Type != PERDBBACKUP {
+		return entity.BackupResponse{}, fmt.Errorf("%w: %s is a %s job", ErrBackupNotRetryable, backupID, job.Type)
+	}
+	if job.Status != "Failed" && job.Status != "Canceled" {
+		return entity.BackupResponse{}, fmt.Errorf("%w: %s is %s", ErrBackupNotRetryable, backupID, job.Status)
+	}
+	if job.Request == "" {
+		return entity.BackupResponse{}, fmt.Errorf("%w: %s has no stored request", ErrBackupNotRetryable, backupID)
+	}
+	var request entity.BackupRequest
+	if err := json.Unmarshal([]byte(job.Request), &request); err != nil {
+		return entity.BackupResponse{}, fmt.Errorf("failed to read stored request of backup %s err: %w", backupID, err)
+	}
+	b.logger.Infof("Retrying backup %s", backupID)
+	return b.EnqueueBackup(ctx, request)
+}
+
+// storedRequest is the JSON of a backup request kept on its job for RetryBackup, without the start_ts
+// a retry selects again.
+func storedRequest(request entity.BackupRequest) string {
+	request.BackupID = ""
+	request.CustomVars = maps.Clone(request.CustomVars)
+	delete(request.CustomVars, STARTTS)
+	data, err := json.Marshal(request)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// externalJob reports whether the backup of job was written to an external backup path.
+func externalJob(job entity.Job) bool {
+	if job.Request == "" {
+		return false
+	}
+	var request struct {
+		ExternalBackupPath string `json:"externalBackupPath"`
+	}
+	if err := json.Unmarshal([]byte(job.Request), &request); err != nil {
+		return false
+	}
+	return request.ExternalBackupPath != ""
+}
+
+// trackQueuedBackups lets CancelBackup cancel the queued databases of a per database backup until untrack is called.
+func (b *BackupDaemon) trackQueuedBackups(parentID string) (*atomic.Bool, func()) {
+	canceled := &atomic.Bool{}
+	b.queuedMu.Lock()
+	defer b.queuedMu.Unlock()
+	if b.queuedCancels == nil {
+		b.queuedCancels = map[string]*atomic.Bool{}
+	}
+	b.queuedCancels[parentID] = canceled
+	return canceled, func() {
+		b.queuedMu.Lock()
+		delete(b.queuedCancels, parentID)
+		b.queuedMu.Unlock()
+	}
+}
+
+func (b *BackupDaemon) VacuumDB(ctx context.Context) error {
+	start := time.Now()
+	if err := b.dbRepo.Vacuum(ctx); err != nil {
+		return fmt.Errorf("failed to vacuum jobs database err: %w", err)
+	}
+	b.logger.Infof("Vacuumed jobs database in %s", time.Since(start))
+	return nil
+}
+
+// Reconcile aligns the jobs table with the vaults in storage: finished local jobs of vaults
+// that are gone are removed and vaults without a job get a placeholder backup job. The storage
+// is shared, so the jobs of every tenant are reconciled.
+func (b *BackupDaemon) Reconcile(ctx context.Context) (entity.ReconcileResponse, error) {
+	ctx = repo.WithTenant(ctx, "")
+	// jobs of vaults missing from a partial list would be removed
+	vaults, err := b.storageRepo.ListComplete(repo.ALL, "")
+	if err != nil {
+		return entity.ReconcileResponse{}, fmt.Errorf("failed to list all vaults err: %w", err)
+	}
+	jobs, err := b.dbRepo.ListJobs(ctx, entity.JobsFilter{})
+	if err != nil {
+		return entity.ReconcileResponse{}, fmt.Errorf("failed to list jobs err: %w", err)
+	}
+
+	stored := make(map[string]bool, len(vaults))
+	for _, vault := range vaults {
+		stored[b.storageRepo.GetName(vault.Folder)] = true
+	}
+	tracked := make(map[string]bool, len(jobs))
+	orphaned := make(map[string]bool)
+	for _, job := range jobs {
+		tracked[job.TaskID] = true
+		tracked[job.Vault] = true
+		// s3 and external backups are not in local storage and running jobs may not have written their vault yet,
+		// restores may read external vaults so only backup jobs tell a local vault is gone
+		if job.Vault == "" || job.BlobPath != "" || (job.Type != COMMONBACKUP && job.Type != INCREMENTALBACKUP) ||
+			externalJob(job) || (job.Status != "Successful" && job.Status != "Failed") {
+			continue
+		}
+		if !stored[job.Vault] {
+			orphaned[job.Vault] = true
+		}
+	}
+
+	response := entity.ReconcileResponse{Removed: []string{}, Added: []string{}}
+	for vault := range orphaned {
+		if err := b.dbRepo.RemoveVault(ctx, vault); err != nil && !errors.Is(err, repo.ErrNoVaults) {
+			return response, fmt.Errorf("failed to remove jobs of vault %s err: %w", vault, err)
+		}
+		b.logger.Infof("Removed jobs of vault %s missing from storage", vault)
+		response.Removed = append(response.Removed, vault)
+	}
+	for _, vault := range vaults {
+		name := b.storageRepo.GetName(vault.Folder)
+		if tracked[name] {
+			continue
+		}
+		action := COMMONBACKUP
+		if b.vaultParent(vault) != "" {
+			action = INCREMENTALBACKUP
+		}
+		status := "Failed"
+		if b.storageRepo.IsSuccessful(vault) {
+			status = "Successful"
+		}
+		if err := b.dbRepo.UpdateJob(ctx, entity.Job{TaskID: name, Type: action, Status: status, Vault: name}); err != nil {
+			return response, fmt.Errorf("failed to add job for vault %s err: %w", name, err)
+		}
+		b.logger.Infof("Added %s job for vault %s without a job", status, name)
+		response.Added = append(response.Added, name)
+	}
+	sort.Strings(response.Removed)
+	sort.Strings(response.Added)
+	return response, nil
+}
+
+// ListS3Orphans lists the S3 prefixes of vaults that have no job and no local vault,
+// e.g. left behind when deleting a backup failed half way.
+func (b *BackupDaemon) ListS3Orphans(ctx context.Context) (entity.S3OrphansResponse, error) {
+	if !b.s3Enable {
+		return entity.S3OrphansResponse{}, ErrS3Disabled
+	}
+	// the objects of vaults missing from a partial list would look orphaned
+	vaults, err := b.storageRepo.ListComplete(repo.ALL, "")
+	if err != nil && !errors.Is(err, repo.ErrNoVaults) {
+		return entity.S3OrphansResponse{}, fmt.Errorf("failed to list all vaults err: %w", err)
+	}
+	// objects of every tenant share the bucket, so jobs of all tenants are known
+	jobs, err := b.dbRepo.ListJobs(repo.WithTenant(ctx, ""), entity.JobsFilter{})
+	if err != nil {
+		return entity.S3OrphansResponse{}, fmt.Errorf("failed to list jobs err: %w", err)
+	}
+	keys, err := b.s3Client.ListFiles(ctx, "")
+	if err != nil {
+		return entity.S3OrphansResponse{}, fmt.Errorf("failed to list files from s3 err: %w", err)
+	}
+
+	known := make(map[string]bool, len(vaults)+2*len(jobs))
+	for _, vault := range vaults {
+		known[b.storageRepo.GetName(vault.Folder)] = true
+	}
+	for _, job := range jobs {
+		known[job.TaskID] = true
+		known[job.Vault] = true
+	}
+	objects := make(map[string]int)
+	for _, key := range keys {
+		// the vault is the first segment named like one, it follows the blob path or the storage root
+		segments := strings.Split(strings.Trim(key, "/"), "/")
+		for i, segment := range segments[:len(segments)-1] {
+			if b.storageRepo.IsVaultName(segment) {
+				if !known[segment] {
+					objects[strings.Join(segments[:i+1], "/")]++
+				}
+				break
+			}
+		}
+	}
+
+	response := entity.S3OrphansResponse{Orphans: make([]entity.S3Orphan, 0, len(objects))}
+	for prefix, count := range objects {
+		response.Orphans = append(response.Orphans, entity.S3Orphan{Prefix: prefix, Objects: count})
+	}
+	sort.Slice(response.Orphans, func(i, j int) bool {
+		return response.Orphans[i].Prefix < response.Orphans[j].Prefix
+	})
+	response.Count = len(response.Orphans)
+	return response, nil
+}
+
+// CleanS3Orphans deletes the prefixes ListS3Orphans finds. A failing prefix does not stop the others,
+// it keeps its error in the response. A dry run only lists them.
+func (b *BackupDaemon) CleanS3Orphans(ctx context.Context, dryRun bool) (entity.S3OrphansResponse, error) {
+	response, err := b.ListS3Orphans(ctx)
+	if err != nil || dryRun {
+		response.DryRun = dryRun
+		return response, err
+	}
+	var errs []error
+	for i, orphan := range response.Orphans {
+		if err := b.s3Client.DeletePrefix(ctx, orphan.Prefix); err != nil {
+			b.logger.Errorf("failed to delete orphaned s3 prefix %s: %v", orphan.Prefix, err)
+			response.Orphans[i].Error = err.Error()
+			errs = append(errs, fmt.Errorf("failed to delete s3 prefix=%s: %w", orphan.Prefix, err))
+			continue
+		}
+		b.logger.Infof("Deleted orphaned s3 prefix %s with %d objects", orphan.Prefix, orphan.Objects)
+	}
+	return response, errors.Join(errs...)
+}
+
+// LoadEvictionPolicy returns the eviction policies last set with SetEvictionPolicy, they outlive
+// a restart and take precedence over the configured ones until ResetEvictionPolicy drops them.
+// Without stored policies configured is returned.
+func LoadEvictionPolicy(ctx context.Context, dbRepo repo.DBRepository, configured entity.EvictionPolicyResponse) (entity.EvictionPolicyResponse, error) {
+	stored, err := dbRepo.GetSetting(ctx, evictionPolicySetting)
+	if errors.Is(err, repo.ErrNotFound) {
+		return configured, nil
+	}
+	if err != nil {
+		return configured, fmt.Errorf("failed to read stored eviction policy err: %w", err)
+	}
+	var policy entity.EvictionPolicyResponse
+	if err := json.Unmarshal([]byte(stored), &policy); err != nil {
+		return configured, fmt.Errorf("failed to parse stored eviction policy err: %w", err)
+	}
+	return policy, nil
+}
+
+// SetEvictionPolicy replaces the eviction policies the following evictions use and stores them,
+// a policy left empty in request is kept.
+func (b *BackupDaemon) SetEvictionPolicy(ctx context.Context, request entity.EvictionPolicyRequest) (entity.EvictionPolicyResponse, error) {
+	if request.FullEvictionPolicy == "" && request.GranularEvictionPolicy == "" {
+		return entity.EvictionPolicyResponse{}, fmt.Errorf("%w: no policy given", ErrInvalidEvictionPolicy)
+	}
+	for _, rules := range []string{request.FullEvictionPolicy, request.GranularEvictionPolicy} {
+		if rules == "" {
+			continue
+		}
+		if _, err := parseRules(rules); err != nil {
+			return entity.EvictionPolicyResponse{}, fmt.Errorf("%w %s: %v", ErrInvalidEvictionPolicy, rules, err)
+		}
+	}
+
+	b.policyMu.Lock()
+	defer b.policyMu.Unlock()
+	policy := entity.EvictionPolicyResponse{
+		FullEvictionPolicy:     b.evictionPolicy,
+		GranularEvictionPolicy: b.granularEvictionPolicy,
+	}
+	if request.FullEvictionPolicy != "" {
+		policy.FullEvictionPolicy = request.FullEvictionPolicy
+	}
+	if request.GranularEvictionPolicy != "" {
+		policy.GranularEvictionPolicy = request.GranularEvictionPolicy
+	}
+	stored, _ := json.Marshal(policy)
+	if err := b.dbRepo.SetSetting(ctx, evictionPolicySetting, string(stored)); err != nil {
+		return entity.EvictionPolicyResponse{}, fmt.Errorf("failed to store eviction policy err: %w", err)
+	}
+	b.evictionPolicy = policy.FullEvictionPolicy
+	b.granularEvictionPolicy = policy.GranularEvictionPolicy
+	b.logger.Infof("Eviction policies set to full=%s granular=%s", policy.FullEvictionPolicy, policy.GranularEvictionPolicy)
+	return policy, nil
+}
+
+// GetEvictionPolicy returns the eviction policies in effect.
+func (b *BackupDaemon) GetEvictionPolicy(_ context.Context) entity.EvictionPolicyResponse {
+	return b.evictionPolicies()
+}
+
+// ResetEvictionPolicy drops the eviction policies set at runtime, the configured ones apply again.
+func (b *BackupDaemon) ResetEvictionPolicy(ctx context.Context) (entity.EvictionPolicyResponse, error) {
+	b.policyMu.Lock()
+	defer b.policyMu.Unlock()
+	if err := b.dbRepo.DeleteSetting(ctx, evictionPolicySetting); err != nil {
+		return entity.EvictionPolicyResponse{}, fmt.Errorf("failed to delete stored eviction policy err: %w", err)
+	}
+	b.evictionPolicy = b.configuredPolicy.FullEvictionPolicy
+	b.granularEvictionPolicy = b.configuredPolicy.GranularEvictionPolicy
+	b.logger.Infof("Eviction policies reset to the configured full=%s granular=%s", b.evictionPolicy, b.granularEvictionPolicy)
+	return b.configuredPolicy, nil
+}
+
+func (b *BackupDaemon) evictionPolicies() entity.EvictionPolicyResponse {
+	b.policyMu.RLock()
+	defer b.policyMu.RUnlock()
+	return entity.EvictionPolicyResponse{
+		FullEvictionPolicy:     b.evictionPolicy,
+		GranularEvictionPolicy: b.granularEvictionPolicy,
+	}
+}
+
+// EnqueueEviction evicts every obsolete vault. A failing vault does not stop the others,
+// the response counts and lists evicted and failed vaults and the error joins all per-vault failures.
+// A dry run lists the obsolete vaults as evicted and removes nothing.
+func (b *BackupDaemon) EnqueueEviction(ctx context.Context, request entity.EvictRequest) (entity.EvictResponse, error) {
+	excludedFiles, err := b.nonEvictableVaults(ctx, repo.ALL)
+	if err != nil {
+		return entity.EvictResponse{}, fmt.Errorf("failed to list all non evictable vaults err: %w", err)
+	}
+
+	fullVaults, err := b.storageRepo.List(repo.FULL, "")
+	if err != nil {
+		return entity.EvictResponse{}, fmt.Errorf("failed to list full vaults err: %w", err)
+	}
+
+	evictionPolicy := b.evictionPolicies()
+	obsoleteFullVaults, err := b.evict(fullVaults, evictionPolicy.FullEvictionPolicy, excludedFiles)
+	if err != nil {
+		return entity.EvictResponse{}, fmt.Errorf("failed to list evict full vaults err: %w", err)
+	}
+
+	granularVaults, err := b.storageRepo.List(repo.GRANULAR, "")
+	if err != nil {
+		return entity.EvictResponse{}, fmt.Errorf("failed to list granular vaults err: %w", err)
+	}
+
+	obsoleteGranularVaults, err := b.evict(granularVaults, evictionPolicy.GranularEvictionPolicy, excludedFiles)
+	if err != nil {
+		return entity.EvictResponse{}, fmt.Errorf("failed to list evict granular vaults err: %w", err)
+	}
+
+	var response entity.EvictResponse
+	var errs []error
+	obsoleteVaults := append(obsoleteFullVaults, obsoleteGranularVaults...)
+	if len(obsoleteVaults) == 0 {
+		b.logger.Infof("no vaults matched eviction policies full=%s granular=%s", evictionPolicy.FullEvictionPolicy, evictionPolicy.GranularEvictionPolicy)
+		response.NothingToEvict = true
+		response.DryRun = request.DryRun
+		return response, nil
+	}
+	if request.DryRun {
+		for _, obsoleteVault := range obsoleteVaults {
+			response.Evicted = append(response.Evicted, b.storageRepo.GetName(obsoleteVault.Folder))
+		}
+		response.Count = len(response.Evicted)
+		response.DryRun = true
+		return response, nil
+	}
+	for _, obsoleteVault := range obsoleteVaults {
+		name := b.storageRepo.GetName(obsoleteVault.Folder)
+		if err := b.evictVault(ctx, obsoleteVault.Folder, name); err != nil {
+			b.logger.Errorf("failed to evict vault %s: %v", name, err)
+			response.Failed = append(response.Failed, entity.EvictFailure{Vault: name, Error: err.Error()})
+			errs = append(errs, err)
+			continue
+		}
+		response.Evicted = append(response.Evicted, name)
+	}
+	response.Count = len(response.Evicted)
+	return response, errors.Join(errs...)
+}
+
+func (b *BackupDaemon) evictVault(ctx context.Context, folder string, name string) error {
+	unlock, ok := b.lockVault(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrVaultBusy, name)
+	}
+	defer unlock()
+	if err := b.storageRepo.Evict(folder); err != nil {
+		return fmt.Errorf("failed to evict backup %s from storage err: %w", folder, err)
+	}
+	if err := b.dbRepo.RemoveVault(ctx, name); err != nil {
+		return fmt.Errorf("failed to remove backup %s from database err: %w", folder, err)
+	}
+	if err := b.executor.ExecuteEvictCmd(folder); err != nil {
+		return fmt.Errorf("failed to evict backup %s from executor err: %w", folder, err)
+	}
+	return nil
+}
+
+// lockVault holds a vault until the returned func is called, false when it is held already.
+func (b *BackupDaemon) lockVault(name string) (func(), bool) {
+	b.vaultMu.Lock()
+	defer b.vaultMu.Unlock()
+	if b.vaultLocks == nil {
+		b.vaultLocks = map[string]bool{}
+	}
+	if b.vaultLocks[name] {
+		return nil, false
+	}
+	b.vaultLocks[name] = true
+	return func() {
+		b.vaultMu.Lock()
+		defer b.vaultMu.Unlock()
+		delete(b.vaultLocks, name)
+	}, true
+}
+
+// lockIdleVault holds a vault for removal, it fails with ErrVaultBusy while a job still uses the
+// vault or it is younger than the grace period.
+func (b *BackupDaemon) lockIdleVault(ctx context.Context, name string, vault entity.Vault) (func(), error) {
+	if b.inGracePeriod(vault) {
+		return nil, fmt.Errorf("%w: %s is younger than %s", ErrVaultBusy, name, b.evictionGracePeriod)
+	}
+	busy, err := b.busyVaults(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if busy[name] {
+		return nil, fmt.Errorf("%w: %s", ErrVaultBusy, name)
+	}
+	unlock, ok := b.lockVault(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrVaultBusy, name)
+	}
+	return unlock, nil
+}
+
+// busyVaults returns the names of the vaults held here or used by a Queued or Processing job of any tenant.
+func (b *BackupDaemon) busyVaults(ctx context.Context) (map[string]bool, error) {
+	busy := map[string]bool{}
+	b.vaultMu.Lock()
+	for name := range b.vaultLocks {
+		busy[name] = true
+	}
+	b.vaultMu.Unlock()
+	for _, status := range []string{"Queued", "Processing"} {
+		jobs, err := b.dbRepo.ListJobs(repo.WithTenant(ctx, ""), entity.JobsFilter{Status: status})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s jobs err: %w", status, err)
+		}
+		for _, job := range jobs {
+			if job.Vault != "" {
+				busy[job.Vault] = true
+			}
+		}
+	}
+	return busy, nil
+}
+
+// nonEvictableVaults adds the busy vaults and the ones in the grace period to the vaults the
+// storage keeps from eviction.
+func (b *BackupDaemon) nonEvictableVaults(ctx context.Context, typeOfBackup string) (map[int64]bool, error) {
+	excluded, err := b.storageRepo.GetNonEvictableVaults(typeOfBackup)
+	if err != nil {
+		return nil, err
+	}
+	vaults, err := b.storageRepo.List(typeOfBackup, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s vaults err: %w", typeOfBackup, err)
+	}
+	busy, err := b.busyVaults(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, vault := range vaults {
+		if busy[b.storageRepo.GetName(vault.Folder)] || b.inGracePeriod(vault) {
+			excluded[vault.TimeStamp] = true
+		}
+	}
+	return excluded, nil
+}
+
+func (b *BackupDaemon) inGracePeriod(vault entity.Vault) bool {
+	return b.evictionGracePeriod > 0 && vault.TimeStamp > 0 && time.Since(time.UnixMilli(vault.TimeStamp)) < b.evictionGracePeriod
+}
+
+// acquireBackupSlot counts a new backup in flight unless the daemon is overloaded, the
+// returned func releases the slot. A host without I/O pressure information skips that check.
+func (b *BackupDaemon) acquireBackupSlot() (func(), error) {
+	inFlight := int(b.inFlight.Add(1))
+	release := func() {
+		b.inFlight.Add(-1)
+	}
+	if limit := b.loadLimits.MaxInFlight; limit > 0 && inFlight > limit {
+		release()
+		return nil, fmt.Errorf("%w: %d backups in flight, limit %d", ErrOverloaded, inFlight-1, limit)
+	}
+	if b.loadLimits.MaxIOPressure > 0 && b.ioPressure != nil {
+		pressure, err := b.ioPressure()
+		if err != nil {
+			b.logger.Debugf("I/O pressure is not available: %v", err)
+		} else if pressure > b.loadLimits.MaxIOPressure {
+			release()
+			return nil, fmt.Errorf("%w: I/O pressure %.2f%%, limit %.2f%%", ErrOverloaded, pressure, b.loadLimits.MaxIOPressure)
+		}
+	}
+	return release, nil
+}
+
+// BackupLoad returns the backups in flight and the load limits.
+func (b *BackupDaemon) BackupLoad() entity.BackupLoad {
+	return entity.BackupLoad{
+		InFlight:   int(b.inFlight.Load()),
+		Limit:      b.loadLimits.MaxInFlight,
+		RetryAfter: int(b.loadLimits.RetryAfter / time.Second),
+	}
+}
+
+// enforceBackupCap makes room for one more backup of the type when its cap is reached. Oldest
+// evictable backups go first, bases of a remaining incremental backup are kept.
+func (b *BackupDaemon) enforceBackupCap(ctx context.Context, isGranular bool) error {
+	typeOfBackup, limit := repo.FULL, b.backupCaps.Full
+	if isGranular {
+		typeOfBackup, limit = repo.GRANULAR, b.backupCaps.Granular
+	}
+	if limit <= 0 {
+		return nil
+	}
+	vaults, err := b.storageRepo.List(typeOfBackup, "")
+	if err != nil && !errors.Is(err, repo.ErrNoVaults) {
+		return fmt.Errorf("failed to list %s vaults err: %w", typeOfBackup, err)
+	}
+	inProgress, err := b.storageRepo.ListInProgress(typeOfBackup, "")
+	if err != nil && !errors.Is(err, repo.ErrNoVaults) {
+		return fmt.Errorf("failed to list %s vaults in progress err: %w", typeOfBackup, err)
+	}
+	// backups in progress count too, they are never evicted
+	names := map[string]bool{}
+	for _, vault := range append(append([]entity.Vault{}, vaults...), inProgress...) {
+		names[b.storageRepo.GetName(vault.Folder)] = true
+	}
+	for name, open := range b.openVaults {
+		if open.granular == isGranular {
+			names[name] = true
+		}
+	}
+	count := len(names)
+	excess := count - limit + 1
+	if excess <= 0 {
+		return nil
+	}
+	if !b.backupCaps.Evict {
+		return fmt.Errorf("%w: %d %s backups of %d allowed", ErrBackupCapReached, count, typeOfBackup, limit)
+	}
+	excluded, err := b.nonEvictableVaults(ctx, typeOfBackup)
+	if err != nil {
+		return fmt.Errorf("failed to list %s non evictable vaults err: %w", typeOfBackup, err)
+	}
+	sort.Slice(vaults, func(i, j int) bool {
+		return vaults[i].TimeStamp < vaults[j].TimeStamp
+	})
+	var eviction []entity.Vault
+	for _, vault := range vaults {
+		if len(eviction) == excess {
+			break
+		}
+		if _, open := b.openVaults[b.storageRepo.GetName(vault.Folder)]; open || excluded[vault.TimeStamp] {
+			continue
+		}
+		candidate := append(append([]entity.Vault{}, eviction...), vault)
+		if len(b.dropChainBases(vaults, candidate)) == len(candidate) {
+			eviction = candidate
+		}
+	}
+	if len(eviction) < excess {
+		return fmt.Errorf("%w: %d %s backups of %d allowed and not enough evictable ones", ErrBackupCapReached, count, typeOfBackup, limit)
+	}
+	for _, vault := range eviction {
+		name := b.storageRepo.GetName(vault.Folder)
+		if err := b.evictVault(ctx, vault.Folder, name); err != nil {
+			return fmt.Errorf("failed to evict backup %s over the %s cap err: %w", name, typeOfBackup, err)
+		}
+		b.logger.Infof("Backup %s is evicted, %s backups reached the cap of %d", name, typeOfBackup, limit)
+	}
+	return nil
+}
+
+// checkFreeInodes rejects a backup while the storage is low on free inodes, a filesystem not reporting
+// inodes passes.
+func (b *BackupDaemon) checkFreeInodes() error {
+	if b.backupCaps.MinFreeInodesPercent <= 0 {
+		return nil
+	}
+	stats, err := b.storageRepo.GetFSStats()
+	if err != nil {
+		return fmt.Errorf("failed to check free inodes err: %w", err)
+	}
+	if stats.TotalInodes <= 0 {
+		return nil
+	}
+	if free := float64(stats.FreeInodes) * 100 / float64(stats.TotalInodes); free < b.backupCaps.MinFreeInodesPercent {
+		return fmt.Errorf("%w: %.2f%% free, at least %.2f%% required", ErrLowInodes, free, b.backupCaps.MinFreeInodesPercent)
+	}
+	return nil
+}
+
+// enforceStorageQuota makes room for one more backup of the storage when it would exceed its quota,
+// assuming the new backup is as large as the latest one of the storage.
+func (b *BackupDaemon) enforceStorageQuota(ctx context.Context, storageName string) error {
+	quota, ok := b.backupCaps.StorageQuotas[storageName]
+	if !ok || quota <= 0 {
+		return nil
+	}
+	vaults, sizes, err := b.storageVaults(ctx, storageName)
+	if err != nil {
+		return err
+	}
+	var used, latest int64
+	listed := make(map[string]bool, len(vaults))
+	for i, vault := range vaults {
+		used += sizes[vault.Folder]
+		listed[b.storageRepo.GetName(vault.Folder)] = true
+		if i == len(vaults)-1 {
+			latest = sizes[vault.Folder]
+		}
+	}
+	b.setQuotaUsage(storageName, used)
+	// backups of the storage still running are assumed as large as the latest one too
+	usage := used
+	for name, open := range b.openVaults {
+		if open.storageName == storageName && !listed[name] {
+			usage += latest
+		}
+	}
+	excess := usage + latest - quota
+	if excess <= 0 {
+		return nil
+	}
+	if !b.backupCaps.Evict {
+		return fmt.Errorf("%w: storage %q uses %d of %d bytes", ErrBackupCapReached, storageName, usage, quota)
+	}
+	excluded, err := b.nonEvictableVaults(ctx, repo.ALL)
+	if err != nil {
+		return fmt.Errorf("failed to list non evictable vaults err: %w", err)
+	}
+	var eviction []entity.Vault
+	var freed int64
+	for _, vault := range vaults {
+		if freed >= excess {
+			break
+		}
+		if _, open := b.openVaults[b.storageRepo.GetName(vault.Folder)]; open || excluded[vault.TimeStamp] {
+			continue
+		}
+		candidate := append(append([]entity.Vault{}, eviction...), vault)
+		if len(b.dropChainBases(vaults, candidate)) == len(candidate) {
+			eviction = candidate
+			freed += sizes[vault.Folder]
+		}
+	}
+	if freed < excess {
+		return fmt.Errorf("%w: storage %q uses %d of %d bytes and not enough evictable backups", ErrBackupCapReached, storageName, usage, quota)
+	}
+	for _, vault := range eviction {
+		name := b.storageRepo.GetName(vault.Folder)
+		if err := b.evictVault(ctx, vault.Folder, name); err != nil {
+			return fmt.Errorf("failed to evict backup %s over the quota of storage %q err: %w", name, storageName, err)
+		}
+		used -= sizes[vault.Folder]
+		b.setQuotaUsage(storageName, used)
+		b.logger.Infof("Backup %s is evicted, storage %q reached its quota of %d bytes", name, storageName, quota)
+	}
+	return nil
+}
+
+func (b *BackupDaemon) setQuotaUsage(storageName string, usage int64) {
+	b.usageMu.Lock()
+	defer b.usageMu.Unlock()
+	if b.quotaUsage == nil {
+		b.quotaUsage = map[string]int64{}
+	}
+	b.quotaUsage[storageName] = usage
+}
+
+// RunStorageQuotaRefresh measures the usage of every storage with a quota for StorageQuotas now and every
+// interval until ctx is done.
+func (b *BackupDaemon) RunStorageQuotaRefresh(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := b.refreshStorageQuotas(ctx); err != nil {
+			b.logger.Warnf("failed to measure storage quota usage err: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *BackupDaemon) refreshStorageQuotas(ctx context.Context) error {
+	for storageName := range b.backupCaps.StorageQuotas {
+		_, sizes, err := b.storageVaults(ctx, storageName)
+		if err != nil {
+			return err
+		}
+		var usage int64
+		for _, size := range sizes {
+			usage += size
+		}
+		b.setQuotaUsage(storageName, usage)
+	}
+	return nil
+}
+
+// storageVaults returns the local vaults of the backups of every tenant to storageName, oldest
+// first, with their sizes by folder.
+func (b *BackupDaemon) storageVaults(ctx context.Context, storageName string) ([]entity.Vault, map[string]int64, error) {
+	jobs, err := b.dbRepo.ListJobs(repo.WithTenant(ctx, ""), entity.JobsFilter{StorageName: storageName, Types: []string{COMMONBACKUP, INCREMENTALBACKUP}})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list backups of storage %q err: %w", storageName, err)
+	}
+	names := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		names[job.Vault] = true
+	}
+	all, err := b.storageRepo.List(repo.ALL, "")
+	if err != nil && !errors.Is(err, repo.ErrNoVaults) {
+		return nil, nil, fmt.Errorf("failed to list all vaults err: %w", err)
+	}
+	var vaults []entity.Vault
+	sizes := map[string]int64{}
+	for _, vault := range all {
+		if !names[b.storageRepo.GetName(vault.Folder)] {
+			continue
+		}
+		size, err := b.storageRepo.GetVaultSize(vault)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get size of %s vault err: %w", vault.Folder, err)
+		}
+		vaults = append(vaults, vault)
+		sizes[vault.Folder] = size
+	}
+	sort.Slice(vaults, func(i, j int) bool {
+		return vaults[i].TimeStamp < vaults[j].TimeStamp
+	})
+	return vaults, sizes, nil
+}
 
 func (b *BackupDaemon) RemoveBackup(ctx context.Context, request entity.EvictByVaultRequest) error {
 	vaultNames, err := b.storageRepo.ListVaultNames(true, repo.ALL, "")
@@ -428,15 +2284,23 @@ func (b *BackupDaemon) RemoveBackup(ctx context.Context, request entity.EvictByV
 		return fmt.Errorf("failed to list all backup by timestamp err: %w", err)
 	}
 	if !contains(vaultNames, request.Vault) {
-		return fmt.Errorf("backup vault %s not found in storage", request.Vault)
+		return fmt.Errorf("%w: vault %s", ErrVaultNotFound, request.Vault)
+	}
+	if err := b.checkTenantVault(ctx, request.Vault); err != nil {
+		return err
 	}
 	vaultObject := b.storageRepo.GetVault(request.Vault, false, "", "", false)
 	if reflect.DeepEqual(vaultObject, entity.Vault{}) {
-		return fmt.Errorf("backup vault %s not found in storage", request.Vault)
+		return fmt.Errorf("%w: vault %s", ErrVaultNotFound, request.Vault)
 	}
 	if vaultObject.IsLocked {
 		return fmt.Errorf("backup vault %s is locked", request.Vault)
 	}
+	unlock, err := b.lockIdleVault(ctx, request.Vault, vaultObject)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	err = b.storageRepo.Evict(vaultObject.Folder)
 	if err != nil {
 		return fmt.Errorf("failed to evict backup err: %w", err)
@@ -452,6 +2316,65 @@ func (b *BackupDaemon) RemoveBackup(ctx context.Context, request entity.EvictByV
 	return nil
 }
 
+// CopyBackup uploads an existing local vault to S3 under the requested blob path.
+// The source vault is left untouched, the copy is tracked as its own job.
+func (b *BackupDaemon) CopyBackup(ctx context.Context, request entity.CopyBackupRequest) (entity.CopyBackupResponse, error) {
+	if !b.s3Enable {
+		return entity.CopyBackupResponse{}, ErrS3Disabled
+	}
+	vaultNames, err := b.storageRepo.ListVaultNames(false, repo.ALL, "")
+	if err != nil {
+		return entity.CopyBackupResponse{}, fmt.Errorf("failed to list all backup err: %w", err)
+	}
+	if !contains(vaultNames, request.BackupID) {
+		return entity.CopyBackupResponse{}, fmt.Errorf("%w: vault %s", ErrBackupNotFound, request.BackupID)
+	}
+	if err := b.checkTenantVault(ctx, request.BackupID); err != nil {
+		return entity.CopyBackupResponse{}, err
+	}
+	vault := b.storageRepo.GetVault(request.BackupID, false, "", "", false)
+	if reflect.DeepEqual(vault, entity.Vault{}) {
+		return entity.CopyBackupResponse{}, fmt.Errorf("%w: vault %s", ErrBackupNotFound, request.BackupID)
+	}
+	if vault.IsIncomplete {
+		return entity.CopyBackupResponse{}, fmt.Errorf("%w: vault %s was interrupted", ErrBackupIncomplete, request.BackupID)
+	}
+
+	blobPath := strings.Trim(strings.TrimSpace(request.BlobPath), "/")
+	job := entity.Job{
+		TaskID:      uuid.New().String(),
+		Type:        COPY,
+		Status:      "Processing",
+		Vault:       request.BackupID,
+		StorageName: request.StorageName,
+		BlobPath:    blobPath,
+	}
+	if err := b.dbRepo.UpdateJob(ctx, job); err != nil {
+		return entity.CopyBackupResponse{}, fmt.Errorf("failed to update job err: %w", err)
+	}
+
+	if blobPath != "" {
+		err = b.s3Client.UploadFolderWithPrefix(ctx, vault.Folder, path.Join(blobPath, request.BackupID))
+	} else {
+		err = b.s3Client.UploadFolder(ctx, vault.Folder)
+	}
+	if err != nil {
+		job.Status = "Failed"
+		job.Err = err.Error()
+		_ = b.dbRepo.UpdateJob(ctx, job)
+		return entity.CopyBackupResponse{}, fmt.Errorf("failed to copy backup %s to s3 err: %w", request.BackupID, err)
+	}
+
+	job.Status = "Successful"
+	if err := b.dbRepo.UpdateJob(ctx, job); err != nil {
+		return entity.CopyBackupResponse{}, fmt.Errorf("failed to update job err: %w", err)
+	}
+	return entity.CopyBackupResponse{
+		TaskID:   job.TaskID,
+		BackupID: request.BackupID,
+	}, nil
+}
+
 func (b *BackupDaemon) RemoveBackupV2(ctx context.Context, request entity.EvictByVaultV2Request) error {
 	backupID := strings.TrimSpace(request.Vault)
 	if backupID == "" {
@@ -459,6 +2382,9 @@ func (b *BackupDaemon) RemoveBackupV2(ctx context.Context, request entity.EvictB
 	}
 
 	job, err := b.dbRepo.SelectEverything(ctx, backupID)
+	if errors.Is(err, repo.ErrNotFound) {
+		return fmt.Errorf("%w: vault %s", ErrVaultNotFound, backupID)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to select job %s: %w", backupID, err)
 	}
@@ -475,14 +2401,28 @@ func (b *BackupDaemon) RemoveBackupV2(ctx context.Context, request entity.EvictB
 		blob = strings.Trim(normalizeBlobPath(job.BlobPath), "/")
 	}
 
+	vaultObj := b.storageRepo.GetVault(backupID, false, "", blob, false)
+	unlock, err := b.lockIdleVault(ctx, backupID, vaultObj)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	if b.s3Enable && blob != "" {
+		s3Client, err := b.s3ClientFor(job.Bucket)
+		if err != nil {
+			return err
+		}
 		prefix := path.Join(blob, backupID)
-		if err := b.s3Client.DeletePrefix(ctx, prefix); err != nil {
+		if err := s3Client.DeletePrefix(ctx, prefix); errors.Is(err, ErrObjectLocked) {
+			// the backup stays restorable until its retention ends, so it is kept in the database
+			b.logger.Warnf("backup %s is retained by s3 object lock: %v", backupID, err)
+			return fmt.Errorf("backup %s is retained in s3: %w", backupID, err)
+		} else if err != nil {
 			return fmt.Errorf("failed to delete from s3 prefix=%s: %w", prefix, err)
 		}
 	}
 
-	vaultObj := b.storageRepo.GetVault(backupID, false, "", blob, false)
 	if !reflect.DeepEqual(vaultObj, entity.Vault{}) {
 		if vaultObj.IsLocked {
 			return fmt.Errorf("backup vault %s is locked", backupID)
@@ -494,68 +2434,384 @@ func (b *BackupDaemon) RemoveBackupV2(ctx context.Context, request entity.EvictB
 	if err := b.dbRepo.RemoveVault(ctx, backupID); err != nil {
 		return fmt.Errorf("failed to remove backup %s from database: %w", backupID, err)
 	}
-
-	return nil
+
+	return nil
+}
+
+func (b *BackupDaemon) GetJobStatus(ctx context.Context, request entity.JobStatusRequest) (entity.JobStatusResponse, error) {
+	job, err := b.dbRepo.SelectEverything(ctx, request.TaskID)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return entity.JobStatusResponse{
+				StatusCode: http.StatusNotFound,
+			}, nil
+		}
+		return entity.JobStatusResponse{}, fmt.Errorf("failed to select job err: %w", err)
+	}
+	response := jobStatusResponse(job)
+	if job.Type == PERDBBACKUP {
+		children, err := b.dbRepo.ListJobs(ctx, entity.JobsFilter{ParentID: job.TaskID})
+		if err != nil {
+			return entity.JobStatusResponse{}, fmt.Errorf("failed to list child jobs err: %w", err)
+		}
+		for _, child := range children {
+			response.Children = append(response.Children, child.TaskID)
+		}
+		slices.Sort(response.Children)
+	}
+	return response, nil
+}
+
+// ListJobs returns a page of the jobs matching filter and the number of all matching jobs.
+func (b *BackupDaemon) ListJobs(ctx context.Context, filter entity.JobsFilter) ([]entity.JobStatusResponse, int, error) {
+	jobs, err := b.dbRepo.ListJobs(ctx, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list jobs err: %w", err)
+	}
+	total := len(jobs)
+	if filter.Limit > 0 {
+		total, err = b.dbRepo.CountJobs(ctx, filter)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to count jobs err: %w", err)
+		}
+	}
+	responses := make([]entity.JobStatusResponse, 0, len(jobs))
+	for _, job := range jobs {
+		responses = append(responses, jobStatusResponse(job))
+	}
+	return responses, total, nil
+}
+
+func jobStatusResponse(job entity.Job) entity.JobStatusResponse {
+	var dbs []string
+	if strings.TrimSpace(job.Databases) != "" {
+		_ = json.Unmarshal([]byte(job.Databases), &dbs)
+	}
+	var statuses map[string]string
+	if strings.TrimSpace(job.DatabaseStatuses) != "" {
+		_ = json.Unmarshal([]byte(job.DatabaseStatuses), &statuses)
+	}
+	response := entity.JobStatusResponse{
+		DatabaseStatuses: statuses,
+		TaskID:           job.TaskID,
+		Status:           job.Status,
+		Vault:            job.Vault,
+		Error:            job.Err,
+		Type:             job.Type,
+		StorageName:      job.StorageName,
+		BlobPath:         job.BlobPath,
+		Databases:        dbs,
+		ArchivePath:      job.ArchivePath,
+		Progress:         job.Progress,
+		StartedAt:        jobTime(job.CreatedAt),
+	}
+	if job.Status == "Successful" {
+		response.StatusCode = http.StatusOK
+	} else if job.Status == "Failed" || job.Status == "Canceled" {
+		response.StatusCode = http.StatusInternalServerError
+	} else {
+		response.StatusCode = http.StatusPartialContent
+	}
+	if job.Status == "Successful" || job.Status == "Failed" || job.Status == "Canceled" {
+		// a finished job is not updated anymore
+		response.FinishedAt = jobTime(job.UpdatedAt)
+	}
+	return response
+}
+
+// jobTime formats unix seconds of a job as RFC3339 in UTC, zero is unknown and formatted empty.
+func jobTime(unix int64) string {
+	if unix <= 0 {
+		return ""
+	}
+	return time.Unix(unix, 0).UTC().Format(time.RFC3339)
+}
+
+func (b *BackupDaemon) CreateS3PresignedURL(ctx context.Context, request entity.S3PresignedURLRequest) (entity.S3PresignedURLResponse, error) {
+	vault := b.storageRepo.GetVault(request.BackupID, false, "", "", false)
+	if reflect.DeepEqual(vault, entity.Vault{}) {
+		return entity.S3PresignedURLResponse{}, fmt.Errorf("%w: vault %s", ErrVaultNotFound, request.BackupID)
+	}
+	if err := b.checkTenantVault(ctx, request.BackupID); err != nil {
+		return entity.S3PresignedURLResponse{}, err
+	}
+	s3Client, err := b.s3ClientFor(b.vaultBucket(ctx, request.BackupID))
+	if err != nil {
+		return entity.S3PresignedURLResponse{}, err
+	}
+	extensions := []string{".zip", ".tar", ".gz"}
+	files, err := s3Client.ListFiles(ctx, vault.Folder)
+	if err != nil {
+		return entity.S3PresignedURLResponse{}, fmt.Errorf("failed to list files from s3 err: %w", err)
+	}
+	var urls []string
+	for _, file := range files {
+		for _, extension := range extensions {
+			if strings.HasSuffix(file, extension) {
+				url, err := s3Client.CreatePresignedUrl(ctx, file, request.Expiration)
+				if err != nil {
+					return entity.S3PresignedURLResponse{}, fmt.Errorf("failed to create presigned url err: %w", err)
+				}
+				urls = append(urls, url)
+				break
+			}
+		}
+	}
+	return entity.S3PresignedURLResponse{Urls: urls}, nil
+}
+
+// ListBackupFiles lists the files of a backup, from S3 when it is enabled.
+func (b *BackupDaemon) ListBackupFiles(ctx context.Context, backupID string) (entity.BackupFilesResponse, error) {
+	if err := b.checkVaultID(backupID); err != nil {
+		return entity.BackupFilesResponse{}, err
+	}
+	vault := b.storageRepo.GetVault(backupID, false, "", "", false)
+	if reflect.DeepEqual(vault, entity.Vault{}) {
+		return entity.BackupFilesResponse{}, fmt.Errorf("%w: vault %s", ErrBackupNotFound, backupID)
+	}
+	if err := b.checkTenantVault(ctx, backupID); err != nil {
+		return entity.BackupFilesResponse{}, err
+	}
+	if !b.s3Enable {
+		files, err := b.storageRepo.ListFiles(backupID)
+		if err != nil {
+			return entity.BackupFilesResponse{}, fmt.Errorf("failed to list backup files err: %w", err)
+		}
+		return entity.BackupFilesResponse{Files: files}, nil
+	}
+	s3Client, err := b.s3ClientFor(b.vaultBucket(ctx, backupID))
+	if err != nil {
+		return entity.BackupFilesResponse{}, err
+	}
+	prefix := strings.Trim(vault.Folder, "/") + "/"
+	keys, err := s3Client.ListFiles(ctx, prefix)
+	if err != nil {
+		return entity.BackupFilesResponse{}, fmt.Errorf("failed to list files from s3 err: %w", err)
+	}
+	files := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if file := strings.TrimPrefix(key, prefix); file != "" && !strings.HasSuffix(file, "/") {
+			files = append(files, file)
+		}
+	}
+	return entity.BackupFilesResponse{Files: files}, nil
+}
+
+// GetBackupFile opens a single file of a backup, when S3 is enabled it presigns the object instead.
+func (b *BackupDaemon) GetBackupFile(ctx context.Context, request entity.BackupFileRequest) (entity.BackupFileResponse, error) {
+	if err := b.checkVaultID(request.BackupID); err != nil {
+		return entity.BackupFileResponse{}, err
+	}
+	vault := b.storageRepo.GetVault(request.BackupID, false, "", "", false)
+	if reflect.DeepEqual(vault, entity.Vault{}) {
+		return entity.BackupFileResponse{}, fmt.Errorf("%w: vault %s", ErrBackupNotFound, request.BackupID)
+	}
+	if err := b.checkTenantVault(ctx, request.BackupID); err != nil {
+		return entity.BackupFileResponse{}, err
+	}
+	if !b.s3Enable {
+		file, err := b.storageRepo.ProtGetAsStream(request.BackupID, request.Path)
+		if err != nil {
+			return entity.BackupFileResponse{}, err
+		}
+		return entity.BackupFileResponse{File: file}, nil
+	}
+	file := strings.TrimPrefix(path.Clean("/"+request.Path), "/")
+	if file == "" {
+		return entity.BackupFileResponse{}, fmt.Errorf("%w: %s", repo.ErrInvalidPath, request.Path)
+	}
+	s3Client, err := b.s3ClientFor(b.vaultBucket(ctx, request.BackupID))
+	if err != nil {
+		return entity.BackupFileResponse{}, err
+	}
+	key := strings.Trim(vault.Folder, "/") + "/" + file
+	keys, err := s3Client.ListFiles(ctx, key)
+	if err != nil {
+		return entity.BackupFileResponse{}, fmt.Errorf("failed to list files from s3 err: %w", err)
+	}
+	if !slices.Contains(keys, key) {
+		return entity.BackupFileResponse{}, fmt.Errorf("backup file %s: %w", file, os.ErrNotExist)
+	}
+	presignedURL, err := s3Client.CreatePresignedUrl(ctx, key, request.Expiration)
+	if err != nil {
+		return entity.BackupFileResponse{}, fmt.Errorf("failed to create presigned url err: %w", err)
+	}
+	return entity.BackupFileResponse{URL: presignedURL}, nil
+}
+
+// GetBackupManifest reads the manifest a backup was written with. Sensitive custom vars are redacted
+// again, manifests written before they were redacted on write still hold their values.
+func (b *BackupDaemon) GetBackupManifest(ctx context.Context, backupID string) (entity.BackupManifest, error) {
+	if err := b.checkVaultID(backupID); err != nil {
+		return entity.BackupManifest{}, err
+	}
+	vault := b.storageRepo.GetVault(backupID, false, "", "", false)
+	if reflect.DeepEqual(vault, entity.Vault{}) {
+		return entity.BackupManifest{}, fmt.Errorf("%w: vault %s", ErrBackupNotFound, backupID)
+	}
+	if err := b.checkTenantVault(ctx, backupID); err != nil {
+		return entity.BackupManifest{}, err
+	}
+	manifest, err := readManifest(vault.Folder)
+	if err != nil {
+		return entity.BackupManifest{}, err
+	}
+	manifest.CustomVars = b.executor.RedactCustomVars(manifest.CustomVars)
+	return manifest, nil
+}
+
+// CreateBackupUploadURL presigns an upload of a file into the S3 prefix of a backup that doesn't
+// exist yet, the client registers the backup once all of its files are uploaded.
+func (b *BackupDaemon) CreateBackupUploadURL(ctx context.Context, request entity.BackupUploadURLRequest) (entity.BackupUploadURLResponse, error) {
+	if !b.s3Enable {
+		return entity.BackupUploadURLResponse{}, ErrS3Disabled
+	}
+	if err := b.checkBackupID(ctx, entity.BackupRequest{BackupID: request.BackupID}); err != nil {
+		return entity.BackupUploadURLResponse{}, err
+	}
+	file := strings.TrimPrefix(path.Clean("/"+request.Key), "/")
+	if file == "" {
+		return entity.BackupUploadURLResponse{}, fmt.Errorf("%w: %s", repo.ErrInvalidPath, request.Key)
+	}
+	vault := b.storageRepo.GetVault(request.BackupID, false, "", "", true)
+	key := strings.Trim(vault.Folder, "/") + "/" + file
+	url, err := b.s3Client.CreatePresignedPutUrl(ctx, key, request.Expiration)
+	if err != nil {
+		return entity.BackupUploadURLResponse{}, fmt.Errorf("failed to create presigned url err: %w", err)
+	}
+	return entity.BackupUploadURLResponse{URL: url, Key: file}, nil
+}
+
+// RegisterUploadedBackup adds a successful backup job for files uploaded with CreateBackupUploadURL
+// and creates its local vault, restores download the files from S3 like for any other backup.
+func (b *BackupDaemon) RegisterUploadedBackup(ctx context.Context, backupID string) (entity.BackupResponse, error) {
+	if !b.s3Enable {
+		return entity.BackupResponse{}, ErrS3Disabled
+	}
+	if err := b.checkBackupID(ctx, entity.BackupRequest{BackupID: backupID}); err != nil {
+		return entity.BackupResponse{}, err
+	}
+	vault := b.storageRepo.GetVault(backupID, false, "", "", true)
+	prefix := strings.Trim(vault.Folder, "/") + "/"
+	keys, err := b.s3Client.ListFiles(ctx, prefix)
+	if err != nil {
+		return entity.BackupResponse{}, fmt.Errorf("failed to list files from s3 err: %w", err)
+	}
+	if len(keys) == 0 {
+		return entity.BackupResponse{}, fmt.Errorf("%w: nothing uploaded for %s", ErrBackupNotFound, backupID)
+	}
+
+	if err := os.MkdirAll(vault.Folder, 0o755); err != nil {
+		return entity.BackupResponse{}, fmt.Errorf("failed to create vault %s err: %w", backupID, err)
+	}
+	metrics, _ := json.Marshal(map[string]any{repo.MetricsComplete: true, "uploaded": true})
+	if err := os.WriteFile(vault.MetricsFilePath, metrics, 0o644); err != nil {
+		return entity.BackupResponse{}, fmt.Errorf("failed to write metrics of vault %s err: %w", backupID, err)
+	}
+	job := entity.Job{TaskID: backupID, Type: COMMONBACKUP, Status: "Successful", Vault: backupID, OverwriteDatabases: true}
+	if err := b.dbRepo.UpdateJob(ctx, job); err != nil {
+		return entity.BackupResponse{}, fmt.Errorf("failed to update job err: %w", err)
+	}
+	b.logger.Infof("Registered uploaded backup %s with %d files", backupID, len(keys))
+	return entity.BackupResponse{BackupID: backupID}, nil
+}
+
+func (b *BackupDaemon) GetStorageUsage(ctx context.Context) (entity.StorageUsageResponse, error) {
+	var response entity.StorageUsageResponse
+
+	full, err := b.typeUsage(repo.FULL)
+	if err != nil {
+		return entity.StorageUsageResponse{}, err
+	}
+	granular, err := b.typeUsage(repo.GRANULAR)
+	if err != nil {
+		return entity.StorageUsageResponse{}, err
+	}
+	freeSpace, err := b.storageRepo.GetFreeSpace()
+	if err != nil {
+		return entity.StorageUsageResponse{}, err
+	}
+
+	response.Full = full
+	response.Granular = granular
+	response.TotalSize = full.Size + granular.Size
+	response.TotalCount = full.Count + granular.Count
+	response.FreeSpace = freeSpace
+	return response, nil
+}
+
+// StorageQuotas returns the usage of every storage with a quota as last measured by a quota check or
+// RunStorageQuotaRefresh, it walks no vaults. Storages not measured yet are left out.
+func (b *BackupDaemon) StorageQuotas(_ context.Context) (map[string]entity.StorageQuota, error) {
+	b.usageMu.Lock()
+	defer b.usageMu.Unlock()
+	quotas := make(map[string]entity.StorageQuota, len(b.backupCaps.StorageQuotas))
+	for storageName, quota := range b.backupCaps.StorageQuotas {
+		if usage, ok := b.quotaUsage[storageName]; ok {
+			quotas[storageName] = entity.StorageQuota{Usage: usage, Quota: quota}
+		}
+	}
+	return quotas, nil
+}
+
+// StorageInfo returns the size and inodes of the storage filesystem.
+func (b *BackupDaemon) StorageInfo() (entity.StorageInfo, error) {
+	stats, err := b.storageRepo.GetFSStats()
+	if err != nil {
+		return entity.StorageInfo{}, err
+	}
+	return entity.StorageInfo{
+		TotalSpace:  int(stats.TotalSpace),
+		FreeSpace:   int(stats.FreeSpace),
+		TotalInodes: int(stats.TotalInodes),
+		FreeInodes:  int(stats.FreeInodes),
+		UsedInodes:  int(stats.TotalInodes - stats.FreeInodes),
+	}, nil
 }
 
-func (b *BackupDaemon) GetJobStatus(ctx context.Context, request entity.JobStatusRequest) (entity.JobStatusResponse, error) {
-	job, err := b.dbRepo.SelectEverything(ctx, request.TaskID)
+// BackupAges returns how long ago the latest successful full and granular backups were taken.
+func (b *BackupDaemon) BackupAges() (entity.BackupAges, error) {
+	full, err := b.latestSuccessfulAge(repo.FULL)
 	if err != nil {
-		if errors.Is(err, repo.ErrNotFound) {
-			return entity.JobStatusResponse{
-				StatusCode: http.StatusNotFound,
-			}, nil
-		}
-		return entity.JobStatusResponse{}, fmt.Errorf("failed to select job err: %w", err)
+		return entity.BackupAges{}, err
 	}
-	var dbs []string
-	if strings.TrimSpace(job.Databases) != "" {
-		_ = json.Unmarshal([]byte(job.Databases), &dbs)
+	granular, err := b.latestSuccessfulAge(repo.GRANULAR)
+	if err != nil {
+		return entity.BackupAges{}, err
 	}
-	response := entity.JobStatusResponse{
-		TaskID:      job.TaskID,
-		Status:      job.Status,
-		Vault:       job.Vault,
-		Error:       job.Err,
-		Type:        job.Type,
-		StorageName: job.StorageName,
-		BlobPath:    job.BlobPath,
-		Databases:   dbs,
+	return entity.BackupAges{Full: full, Granular: granular}, nil
+}
+
+func (b *BackupDaemon) latestSuccessfulAge(typeOfBackup string) (*int64, error) {
+	vaults, err := b.storageRepo.List(typeOfBackup, "")
+	if err != nil && !errors.Is(err, repo.ErrNoVaults) {
+		return nil, fmt.Errorf("failed to list %s vaults err: %w", typeOfBackup, err)
 	}
-	if job.Status == "Successful" {
-		response.StatusCode = http.StatusOK
-	} else if job.Status == "Failed" {
-		response.StatusCode = http.StatusInternalServerError
-	} else {
-		response.StatusCode = http.StatusPartialContent
+	// List sorts vaults oldest first
+	for i := len(vaults) - 1; i >= 0; i-- {
+		if b.storageRepo.IsSuccessful(vaults[i]) {
+			age := max(time.Since(time.UnixMilli(vaults[i].TimeStamp)).Milliseconds()/1000, 0)
+			return &age, nil
+		}
 	}
-	return response, nil
+	return nil, nil
 }
 
-func (b *BackupDaemon) CreateS3PresignedURL(ctx context.Context, request entity.S3PresignedURLRequest) (entity.S3PresignedURLResponse, error) {
-	vault := b.storageRepo.GetVault(request.BackupID, false, "", "", false)
-	if reflect.DeepEqual(vault, entity.Vault{}) {
-		return entity.S3PresignedURLResponse{}, fmt.Errorf("backup vault %s not found in storage", request.BackupID)
-	}
-	extensions := []string{".zip", ".tar", ".gz"}
-	files, err := b.s3Client.ListFiles(ctx, vault.Folder)
-	if err != nil {
-		return entity.S3PresignedURLResponse{}, fmt.Errorf("failed to list files from s3 err: %w", err)
+func (b *BackupDaemon) typeUsage(typeOfBackup string) (entity.BackupTypeUsage, error) {
+	vaults, err := b.storageRepo.List(typeOfBackup, "")
+	if err != nil && !errors.Is(err, repo.ErrNoVaults) {
+		return entity.BackupTypeUsage{}, fmt.Errorf("failed to list %s vaults err: %w", typeOfBackup, err)
 	}
-	var urls []string
-	for _, file := range files {
-		for _, extension := range extensions {
-			if strings.HasSuffix(file, extension) {
-				url, err := b.s3Client.CreatePresignedUrl(ctx, file, request.Expiration)
-				if err != nil {
-					return entity.S3PresignedURLResponse{}, fmt.Errorf("failed to create presigned url err: %w", err)
-				}
-				urls = append(urls, url)
-				break
-			}
+	var usage entity.BackupTypeUsage
+	for _, vault := range vaults {
+		size, err := b.storageRepo.GetVaultSize(vault)
+		if err != nil {
+			return entity.BackupTypeUsage{}, fmt.Errorf("failed to get size of %s vault err: %w", vault.Folder, err)
 		}
+		usage.Count++
+		usage.Size += size
 	}
-	return entity.S3PresignedURLResponse{Urls: urls}, nil
+	return usage, nil
 }
 
 //func (b *BackupDaemon) Find(ctx context.Context, request entity.FindRequest) (entity.FindResponse, error) {
@@ -583,6 +2839,10 @@ func getRestoreAction(procType string) string {
 	return COMMONRESTORE
 }
 
+// evict returns the vaults the rules do not retain. Vault timestamps are unix milliseconds. For an
+// "age/interval" rule the vaults older than age are bucketed by (timestamp - evictionAlignment) / interval and only the
+// newest vault of each bucket is kept, so with a 7d interval buckets are weeks starting at
+// the configured week start midnight, see EvictionAlignment.
 func (b *BackupDaemon) evict(items []entity.Vault, rules string, exclude map[int64]bool) ([]entity.Vault, error) {
 	parsedRules, err := parseRules(rules)
 	if err != nil {
@@ -597,32 +2857,37 @@ func (b *BackupDaemon) evict(items []entity.Vault, rules string, exclude map[int
 	switch rule.Type {
 	case LimitType:
 		limit := rule.First
-		unique := uniqueVaults(items)
+		var evictable []entity.Vault
+		for _, x := range items {
+			if !exclude[x.TimeStamp] {
+				evictable = append(evictable, x)
+			}
+		}
+		unique := uniqueVaults(evictable)
 		sort.Slice(unique, func(i, j int) bool {
 			return unique[i].TimeStamp > unique[j].TimeStamp
 		})
 		if limit < len(unique) {
 			eviction = append(eviction, unique[limit:]...)
 		}
-		return eviction, nil
+		return b.dropChainBases(items, eviction), nil
 	case IntervalType:
-		to := time.Now().Unix()
+		to := time.Now().UnixMilli()
 		for _, r := range parsedRules {
 			var operateVersions []entity.Vault
 			for _, x := range items {
-				if x.TimeStamp <= to-int64(r.First) && !exclude[x.TimeStamp] {
+				if x.TimeStamp <= to-int64(r.First)*1000 && !exclude[x.TimeStamp] {
 					operateVersions = append(operateVersions, x)
 				}
 			}
 			if r.Second == "delete" {
 				eviction = append(eviction, operateVersions...)
 			} else {
-				interval := r.Second.(int64)
-				thursday := int64(4 * 24 * 60 * 60)
+				interval := int64(r.Second.(int)) * 1000
 
 				groups := make(map[int64][]entity.Vault)
 				for _, x := range operateVersions {
-					key := (x.TimeStamp - thursday) / interval
+					key := (x.TimeStamp - b.evictionAlignment) / interval
 					groups[key] = append(groups[key], x)
 				}
 				for _, versions := range groups {
@@ -633,11 +2898,520 @@ func (b *BackupDaemon) evict(items []entity.Vault, rules string, exclude map[int
 				}
 			}
 		}
-		return uniqueVaults(eviction), nil
+		return b.dropChainBases(items, uniqueVaults(eviction)), nil
 	}
 	return eviction, nil
 }
 
+// dropChainBases removes from eviction every vault that a retained incremental
+// backup depends on, walking each chain up to its full backup.
+func (b *BackupDaemon) dropChainBases(items []entity.Vault, eviction []entity.Vault) []entity.Vault {
+	evicted := make(map[int64]bool, len(eviction))
+	for _, v := range eviction {
+		evicted[v.TimeStamp] = true
+	}
+
+	parents := make(map[int64]*entity.Vault, len(items))
+	for i := range items {
+		parent := b.vaultParent(items[i])
+		if parent == "" {
+			continue
+		}
+		for j := range items {
+			if strconv.FormatInt(items[j].TimeStamp, 10) == parent || filepath.Base(items[j].Folder) == parent {
+				parents[items[i].TimeStamp] = &items[j]
+				break
+			}
+		}
+	}
+
+	protected := make(map[int64]bool)
+	for _, v := range items {
+		if evicted[v.TimeStamp] {
+			continue
+		}
+		for p := parents[v.TimeStamp]; p != nil && !protected[p.TimeStamp]; p = parents[p.TimeStamp] {
+			protected[p.TimeStamp] = true
+		}
+	}
+	if len(protected) == 0 {
+		return eviction
+	}
+
+	var result []entity.Vault
+	for _, v := range eviction {
+		if protected[v.TimeStamp] {
+			b.logger.Infof("Backup %s is a base of a retained incremental backup, skipping eviction", v.Folder)
+			continue
+		}
+		result = append(result, v)
+	}
+	return result
+}
+
+// vaultParent returns the start_ts the vault was taken against, empty for full backups.
+func (b *BackupDaemon) vaultParent(vault entity.Vault) string {
+	customVarsPath := vault.CustomVarsFilePath
+	if strings.TrimSpace(customVarsPath) == "" {
+		customVarsPath = filepath.Join(vault.Folder, ".custom_vars")
+	}
+	data, err := os.ReadFile(customVarsPath)
+	if err != nil {
+		return ""
+	}
+	customVars, err := parseCustomVars(data)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(customVars[STARTTS])
+}
+
+// excludedRequestedDBs returns the databases of the request's dbs that are also in its excludeDbs.
+func excludedRequestedDBs(request entity.BackupRequest) []string {
+	var excluded []string
+	for _, d := range request.DBs {
+		names := slices.Collect(maps.Keys(d.Object))
+		if d.SimpleName != "" {
+			names = append(names, d.SimpleName)
+		}
+		for _, name := range names {
+			if slices.Contains(request.ExcludeDBs, name) {
+				excluded = append(excluded, name)
+			}
+		}
+	}
+	slices.Sort(excluded)
+	return excluded
+}
+
+// retainUntil returns the time the requested backup must be kept until, zero when the
+// request leaves retention to the eviction policy.
+func retainUntil(request entity.BackupRequest, now time.Time) (time.Time, error) {
+	retainUntil := strings.TrimSpace(request.RetainUntil)
+	ttl := strings.TrimSpace(request.TTL)
+	switch {
+	case retainUntil != "" && ttl != "":
+		return time.Time{}, fmt.Errorf("%w: set only one of retainUntil and ttl", ErrInvalidRetention)
+	case retainUntil != "":
+		until, err := time.Parse(time.RFC3339, retainUntil)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: retainUntil %q is not an RFC 3339 time", ErrInvalidRetention, retainUntil)
+		}
+		if !until.After(now) {
+			return time.Time{}, fmt.Errorf("%w: retainUntil %s is in the past", ErrInvalidRetention, retainUntil)
+		}
+		return until, nil
+	case ttl != "":
+		seconds, _, err := parseSpec(ttl)
+		if err != nil || seconds <= 0 {
+			return time.Time{}, fmt.Errorf("%w: ttl %q, expected e.g. 12h, 90d or 1y", ErrInvalidRetention, ttl)
+		}
+		return now.Add(time.Duration(seconds) * time.Second), nil
+	}
+	return time.Time{}, nil
+}
+
+// discoverDatabases lists databases of the live source and keeps those matching the
+// request include patterns, all when none, and none of the exclude patterns or excludeDbs.
+func (b *BackupDaemon) discoverDatabases(request entity.BackupRequest) ([]entity.DBEntry, error) {
+	names, err := b.executor.DiscoverDBs(request.CustomVars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover databases err: %w", err)
+	}
+	names, err = filterDatabases(names, request.Include, request.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	names = slices.DeleteFunc(names, func(name string) bool {
+		return slices.Contains(request.ExcludeDBs, name)
+	})
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%w: include %v exclude %v", ErrNoDatabasesDiscovered, request.Include, request.Exclude)
+	}
+	b.logger.Infof("Discovered databases for backup: %v", names)
+	dbs := make([]entity.DBEntry, 0, len(names))
+	for _, name := range names {
+		dbs = append(dbs, entity.DBEntry{SimpleName: name})
+	}
+	return dbs, nil
+}
+
+func filterDatabases(names []string, include []string, exclude []string) ([]string, error) {
+	matchAny := func(name string, patterns []string) (bool, error) {
+		for _, pattern := range patterns {
+			ok, err := path.Match(pattern, name)
+			if err != nil {
+				return false, fmt.Errorf("invalid database pattern %q err: %w", pattern, err)
+			}
+			if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	var result []string
+	for _, name := range names {
+		if len(include) > 0 {
+			included, err := matchAny(name, include)
+			if err != nil {
+				return nil, err
+			}
+			if !included {
+				continue
+			}
+		}
+		excluded, err := matchAny(name, exclude)
+		if err != nil {
+			return nil, err
+		}
+		if !excluded {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+// uploadVault uploads the vault folder to s3, under blobPath/<backupID> when blobPath is set.
+func uploadVault(ctx context.Context, s3Client S3ClientRepository, folder string, blobPath string) error {
+	if blobPath != "" {
+		return s3Client.UploadFolderWithPrefix(ctx, folder, path.Join(blobPath, filepath.Base(folder)))
+	}
+	return s3Client.UploadFolder(ctx, folder)
+}
+
+// isFullBackup reports whether the vault holds a full, non granular, backup. When the
+// vault is not in local storage (restore from S3) the backup job's databases decide.
+func (b *BackupDaemon) isFullBackup(ctx context.Context, vault entity.Vault, vaultName string) bool {
+	if vault.Folder != "" {
+		return !vault.IsGranular
+	}
+	job, err := b.dbRepo.SelectEverything(ctx, filepath.Base(vaultName))
+	if err != nil {
+		return false
+	}
+	var dbs []string
+	if strings.TrimSpace(job.Databases) != "" {
+		_ = json.Unmarshal([]byte(job.Databases), &dbs)
+	}
+	return len(dbs) == 0
+}
+
+// s3ClientFor returns the client of bucket, the default client for an empty one.
+func (b *BackupDaemon) s3ClientFor(bucket string) (S3ClientRepository, error) {
+	if bucket == "" {
+		return b.s3Client, nil
+	}
+	if !b.s3Enable {
+		return nil, fmt.Errorf("%w: bucket %s requested", ErrS3Disabled, bucket)
+	}
+	client, ok := b.bucketS3Clients[bucket]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBucketNotAllowed, bucket)
+	}
+	return client, nil
+}
+
+// vaultBucket returns the bucket the backup of vault was uploaded to, empty for the default one.
+func (b *BackupDaemon) vaultBucket(ctx context.Context, vault string) string {
+	job, err := b.dbRepo.SelectEverything(ctx, filepath.Base(vault))
+	if err != nil {
+		return ""
+	}
+	return job.Bucket
+}
+
+// checkTenantVault hides a vault whose backup job belongs to another tenant, requests
+// without a tenant see every vault.
+func (b *BackupDaemon) checkTenantVault(ctx context.Context, vault string) error {
+	if repo.Tenant(ctx) == "" {
+		return nil
+	}
+	if _, err := b.dbRepo.SelectEverything(ctx, filepath.Base(vault)); err != nil {
+		return fmt.Errorf("%w: vault %s", ErrBackupNotFound, filepath.Base(vault))
+	}
+	return nil
+}
+
+// removeRestoreTemp removes a restore copy from the temp dir unless it is kept for debugging.
+func (b *BackupDaemon) removeRestoreTemp(folder string) {
+	if folder == "" {
+		return
+	}
+	if b.keepRestoreTemp {
+		b.logger.Infof("Keeping restore temp dir %s", folder)
+		return
+	}
+	if err := os.RemoveAll(folder); err != nil {
+		b.logger.Warnf("failed to remove restore temp dir %s: %v", folder, err)
+	}
+}
+
+// localArchive returns the archive to restore from when the vault folder is gone
+// but an archive of it exists in the local archive dir.
+func (b *BackupDaemon) localArchive(vaultFolder string) string {
+	if b.localArchiveDir == "" {
+		return ""
+	}
+	if _, err := os.Stat(vaultFolder); err == nil {
+		return ""
+	}
+	archivePath := filepath.Join(b.localArchiveDir, filepath.Base(vaultFolder)+".tar.gz")
+	if _, err := os.Stat(archivePath); err != nil {
+		return ""
+	}
+	return archivePath
+}
+
+// restoreCustomVars maps the typed Clean flag onto the executor clean variable,
+// the flag takes precedence over a clean value passed in custom vars.
+func restoreCustomVars(request entity.RestoreRequest) map[string]string {
+	if !request.Clean {
+		return request.CustomVars
+	}
+	customVars := make(map[string]string, len(request.CustomVars)+1)
+	for k, v := range request.CustomVars {
+		customVars[k] = v
+	}
+	customVars[CLEAN] = "true"
+	return customVars
+}
+
+// renameDbMap expands TargetNamespace, RenamePrefix and RenameSuffix into a dbmap for every database
+// of the backup, refusing targets that collide with a backed up or live database or with each other.
+func (b *BackupDaemon) renameDbMap(vaultFolder string, request entity.RestoreRequest) (map[string]string, error) {
+	var sourceNamespace string
+	if request.TargetNamespace != "" {
+		var ok bool
+		if _, sourceNamespace, ok = repo.ParseVaultName(vaultFolder); !ok {
+			return nil, fmt.Errorf("%w: %s", ErrVaultNamespaceUnknown, filepath.Base(vaultFolder))
+		}
+	}
+	backedDBs, err := b.executor.GetBackupDBs(vaultFolder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backup dbs err: %w", err)
+	}
+	existing := make(map[string]bool, len(backedDBs))
+	for _, db := range backedDBs {
+		existing[db] = true
+	}
+	liveDBs, err := b.executor.DiscoverDBs(request.CustomVars)
+	if err != nil && !errors.Is(err, ErrCommandEmpty) {
+		return nil, fmt.Errorf("failed to discover dbs err: %w", err)
+	}
+	for _, db := range liveDBs {
+		existing[db] = true
+	}
+
+	dbmap := make(map[string]string, len(backedDBs))
+	for _, db := range backedDBs {
+		if newName := request.RenamePrefix + replaceNamespace(db, sourceNamespace, request.TargetNamespace) + request.RenameSuffix; newName != db {
+			dbmap[db] = newName
+		}
+	}
+	for old, newName := range request.ChangeDbNames {
+		dbmap[old] = newName
+	}
+	olds := make([]string, 0, len(dbmap))
+	for old := range dbmap {
+		olds = append(olds, old)
+	}
+	sort.Strings(olds)
+	targets := make(map[string]string, len(dbmap))
+	for _, old := range olds {
+		newName := restoredName(old, dbmap)
+		if newName == old {
+			continue
+		}
+		if existing[newName] {
+			return nil, fmt.Errorf("%w: %s renamed to %s", ErrRenameCollision, old, newName)
+		}
+		if other, ok := targets[newName]; ok {
+			return nil, fmt.Errorf("%w: %s and %s both renamed to %s", ErrRenameCollision, other, old, newName)
+		}
+		targets[newName] = old
+	}
+	return dbmap, nil
+}
+
+// checkDbMapTargets refuses a dbmap restoring two databases under the same name, or a database under the name
+// of one restored unrenamed or of a live database of the target. A restore without dbs restores every database of the backup.
+// The live databases are only checked for a restore into the source, a failure to discover them skips the check.
+func (b *BackupDaemon) checkDbMapTargets(vaultFolder string, request entity.RestoreRequest) error {
+	var sources []string
+	for _, d := range request.DBs {
+		if d.SimpleName != "" {
+			sources = append(sources, d.SimpleName)
+		}
+		for name := range d.Object {
+			sources = append(sources, name)
+		}
+	}
+	if len(sources) == 0 {
+		backedDBs, err := b.executor.GetBackupDBs(vaultFolder)
+		if err != nil {
+			return fmt.Errorf("failed to get backup dbs err: %w", err)
+		}
+		sources = backedDBs
+	}
+	sort.Strings(sources)
+	kept := make(map[string]bool, len(sources))
+	for _, db := range sources {
+		if restoredName(db, request.ChangeDbNames) == db {
+			kept[db] = true
+		}
+	}
+	// the discover command lists the source, a test restore goes to another instance
+	var liveDBs []string
+	if !request.Test {
+		var err error
+		liveDBs, err = b.executor.DiscoverDBs(request.CustomVars)
+		if err != nil && !errors.Is(err, ErrCommandEmpty) {
+			b.logger.Warnf("skip checking dbmap targets against the live databases, failed to discover dbs err: %v", err)
+			liveDBs = nil
+		}
+	}
+	live := make(map[string]bool, len(liveDBs))
+	for _, db := range liveDBs {
+		live[db] = true
+	}
+
+	targets := make(map[string]string, len(sources))
+	for _, old := range sources {
+		newName := restoredName(old, request.ChangeDbNames)
+		if newName == old {
+			continue
+		}
+		if other, ok := targets[newName]; ok {
+			return fmt.Errorf("%w: %s and %s both renamed to %s", ErrRenameCollision, other, old, newName)
+		}
+		targets[newName] = old
+		if kept[newName] {
+			return fmt.Errorf("%w: %s renamed to %s, which is restored under its own name", ErrRenameCollision, old, newName)
+		}
+		if live[newName] {
+			return fmt.Errorf("%w: %s renamed to %s, which already exists on the target", ErrRenameCollision, old, newName)
+		}
+	}
+	return nil
+}
+
+// checkRestoreNamespace refuses restores into a namespace missing from allowedRestoreNamespaces,
+// an empty targetNamespace restores into the daemon's namespace.
+func (b *BackupDaemon) checkRestoreNamespace(targetNamespace string) error {
+	if len(b.allowedRestoreNamespaces) == 0 {
+		return nil
+	}
+	target := targetNamespace
+	if target == "" {
+		target = b.namespace
+	}
+	if !slices.Contains(b.allowedRestoreNamespaces, target) {
+		return fmt.Errorf("%w: %s", ErrRestoreNamespaceNotAllowed, target)
+	}
+	return nil
+}
+
+// replaceNamespace replaces every underscore separated part of db equal to source with target.
+func replaceNamespace(db string, source string, target string) string {
+	if source == "" || target == "" || source == target {
+		return db
+	}
+	parts := strings.Split(db, "_")
+	for i, part := range parts {
+		if part == source {
+			parts[i] = target
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+// restoreDownloadProgress records the download phase of a restore on its job, once per percent.
+func (b *BackupDaemon) restoreDownloadProgress(ctx context.Context, job entity.Job) DownloadProgressFunc {
+	lastPercent := -1
+	return func(p DownloadProgress) {
+		percent := 100
+		if p.TotalBytes > 0 {
+			percent = int(p.Bytes * 100 / p.TotalBytes)
+		}
+		if percent == lastPercent {
+			return
+		}
+		lastPercent = percent
+		job.Progress = fmt.Sprintf("downloading %d%% (%d/%d objects)", percent, p.Objects, p.TotalObjects)
+		if err := b.dbRepo.UpdateJob(ctx, job); err != nil {
+			b.logger.Errorf("failed to update download progress of job %s err: %v", job.TaskID, err)
+		}
+	}
+}
+
+// missingRestoredDBs returns the databases a restore should have created that the live target does not list,
+// a restore without dbs is expected to create every database of the backup.
+func (b *BackupDaemon) missingRestoredDBs(vaultFolder string, request entity.RestoreRequest) ([]string, error) {
+	var expected []string
+	for _, d := range request.DBs {
+		if d.SimpleName != "" {
+			expected = append(expected, d.SimpleName)
+		}
+		for name := range d.Object {
+			expected = append(expected, name)
+		}
+	}
+	if len(expected) == 0 {
+		backedDBs, err := b.executor.GetBackupDBs(vaultFolder)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get backup dbs err: %w", err)
+		}
+		expected = backedDBs
+	}
+	liveDBs, err := b.executor.DiscoverDBs(request.CustomVars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases of the restore target err: %w", err)
+	}
+	var missing []string
+	for _, name := range expected {
+		name = restoredName(name, request.ChangeDbNames)
+		if !slices.Contains(liveDBs, name) && !slices.Contains(missing, name) {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// newRestoreID returns the task id of a restore. A timestamp id ends with a random part,
+// so restores started within the same microsecond still get distinct ids.
+func (b *BackupDaemon) newRestoreID() string {
+	id := uuid.New().String()
+	if b.restoreIDScheme != RestoreIDTimestamp {
+		return id
+	}
+	return time.Now().UTC().Format(restoreIDFormat) + "-" + id[:8]
+}
+
+// restoredName returns the name a database gets after restore according to dbmap.
+func restoredName(name string, dbmap map[string]string) string {
+	if newName, ok := dbmap[name]; ok && newName != "" {
+		return newName
+	}
+	return name
+}
+
+// databaseStatuses builds the JSON stored in jobs.database_statuses, overrides win over the common status.
+func databaseStatuses(names []string, status string, overrides map[string]string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	statuses := make(map[string]string, len(names))
+	for _, name := range names {
+		statuses[name] = status
+		if override, ok := overrides[name]; ok {
+			statuses[name] = override
+		}
+	}
+	data, _ := json.Marshal(statuses)
+	return string(data)
+}
+
 func uniqueVaults(arr []entity.Vault) []entity.Vault {
 	seen := make(map[int64]struct{})
 	var res []entity.Vault
@@ -650,6 +3424,69 @@ func uniqueVaults(arr []entity.Vault) []entity.Vault {
 	return res
 }
 
+// StreamBackupConsole follows the .console of a running backup, the channel gets its lines from the start
+// and is closed once the backup ends and its output is drained, or ctx is done.
+func (b *BackupDaemon) StreamBackupConsole(ctx context.Context, backupID string) (<-chan string, error) {
+	vault := b.storageRepo.GetVault(backupID, false, "", "", false)
+	if reflect.DeepEqual(vault, entity.Vault{}) {
+		return nil, fmt.Errorf("%w: vault %s", ErrBackupNotFound, backupID)
+	}
+	if err := b.checkTenantVault(ctx, backupID); err != nil {
+		return nil, err
+	}
+	if !b.backupRunning(ctx, backupID) {
+		return nil, fmt.Errorf("%w: %s", ErrBackupNotRunning, backupID)
+	}
+	file, err := os.Open(filepath.Join(vault.Folder, ".console"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open console of backup %s err: %w", backupID, err)
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		defer file.Close()
+		ticker := time.NewTicker(consolePollInterval)
+		defer ticker.Stop()
+		reader := bufio.NewReader(file)
+		var partial string
+		finished := false
+		for {
+			line, err := reader.ReadString('\n')
+			partial += line
+			if err != nil && !errors.Is(err, io.EOF) {
+				b.logger.Errorf("failed to read console of backup %s err: %v", backupID, err)
+				return
+			}
+			if err == nil || (finished && partial != "") {
+				select {
+				case lines <- strings.TrimRight(partial, "\r\n"):
+				case <-ctx.Done():
+					return
+				}
+				partial = ""
+				continue
+			}
+			if finished {
+				return
+			}
+			// the backup may still append, read once more after it ends to drain the file
+			finished = !b.backupRunning(ctx, backupID)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return lines, nil
+}
+
+func (b *BackupDaemon) backupRunning(ctx context.Context, backupID string) bool {
+	job, err := b.dbRepo.SelectEverything(ctx, backupID)
+	return err == nil && (job.Status == "Queued" || job.Status == "Processing")
+}
+
 func contains(list []string, item string) bool {
 	for _, v := range list {
 		if v == item {
@@ -0,0 +1,3051 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/repo"
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/util"
+	"github.com/golang/mock/gomock"
+	"go.uber.org/zap"
+)
+
+func newTestVault(t *testing.T, root string, name string, ts int64, parent string) entity.Vault {
+	t.Helper()
+
+	folder := filepath.Join(root, name)
+	if err := os.MkdirAll(folder, 0o755); err != nil {
+		t.Fatalf("failed to create vault dir: %v", err)
+	}
+	vault := entity.Vault{
+		Folder:             folder,
+		TimeStamp:          ts,
+		CustomVarsFilePath: filepath.Join(folder, ".custom_vars"),
+	}
+	if parent != "" {
+		data, _ := json.Marshal(map[string]string{STARTTS: parent})
+		if err := os.WriteFile(vault.CustomVarsFilePath, data, 0o644); err != nil {
+			t.Fatalf("failed to write custom vars: %v", err)
+		}
+	}
+	return vault
+}
+
+func TestEvictKeepsChainBase(t *testing.T) {
+	now := time.Now().UnixMilli()
+	day := (24 * time.Hour).Milliseconds()
+
+	baseTS := now - 5*day
+	firstTS := now - 3*day
+	secondTS := now - day/2
+
+	testCases := []struct {
+		name            string
+		rules           string
+		secondIncTS     int64
+		expectedEvicted []string
+	}{
+		{
+			name:            "retained increment protects whole chain",
+			rules:           "1d/delete",
+			secondIncTS:     secondTS,
+			expectedEvicted: nil,
+		},
+		{
+			name:            "chain without retained increments is evicted",
+			rules:           "1d/delete",
+			secondIncTS:     now - 2*day,
+			expectedEvicted: []string{"base", "inc1", "inc2"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			items := []entity.Vault{
+				newTestVault(t, root, "base", baseTS, ""),
+				newTestVault(t, root, "inc1", firstTS, strconv.FormatInt(baseTS, 10)),
+				newTestVault(t, root, "inc2", tc.secondIncTS, "inc1"),
+			}
+			b := &BackupDaemon{logger: zap.NewNop().Sugar()}
+
+			eviction, err := b.evict(items, tc.rules, map[int64]bool{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var evicted []string
+			for _, v := range eviction {
+				evicted = append(evicted, filepath.Base(v.Folder))
+			}
+			sort.Strings(evicted)
+			if len(evicted) != len(tc.expectedEvicted) {
+				t.Fatalf("expected evicted %v, got %v", tc.expectedEvicted, evicted)
+			}
+			for i := range evicted {
+				if evicted[i] != tc.expectedEvicted[i] {
+					t.Fatalf("expected evicted %v, got %v", tc.expectedEvicted, evicted)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreCustomVars(t *testing.T) {
+	testCases := []struct {
+		name     string
+		request  entity.RestoreRequest
+		expected map[string]string
+	}{
+		{
+			name:     "clean not requested",
+			request:  entity.RestoreRequest{CustomVars: map[string]string{"storageName": "s1"}},
+			expected: map[string]string{"storageName": "s1"},
+		},
+		{
+			name:     "clean requested",
+			request:  entity.RestoreRequest{Clean: true, CustomVars: map[string]string{"storageName": "s1"}},
+			expected: map[string]string{"storageName": "s1", CLEAN: "true"},
+		},
+		{
+			name:     "clean flag overrides custom var",
+			request:  entity.RestoreRequest{Clean: true, CustomVars: map[string]string{CLEAN: "false"}},
+			expected: map[string]string{CLEAN: "true"},
+		},
+		{
+			name:     "clean without custom vars",
+			request:  entity.RestoreRequest{Clean: true},
+			expected: map[string]string{CLEAN: "true"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := restoreCustomVars(tc.request)
+			if len(got) != len(tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, got)
+			}
+			for k, v := range tc.expected {
+				if got[k] != v {
+					t.Fatalf("expected %v, got %v", tc.expected, got)
+				}
+			}
+		})
+	}
+}
+
+type fakeJobRepo struct {
+	repo.DBRepository
+	jobs map[string]entity.Job
+}
+
+func (f *fakeJobRepo) SelectEverything(_ context.Context, taskID string) (entity.Job, error) {
+	job, ok := f.jobs[taskID]
+	if !ok {
+		return entity.Job{}, repo.ErrNotFound
+	}
+	return job, nil
+}
+
+func (f *fakeJobRepo) UpdateJob(ctx context.Context, job entity.Job) error {
+	if job.Tenant == "" {
+		job.Tenant = repo.Tenant(ctx)
+	}
+	f.jobs[job.TaskID] = job
+	return nil
+}
+
+func (f *fakeJobRepo) ListJobs(ctx context.Context, filter entity.JobsFilter) ([]entity.Job, error) {
+	jobs := make([]entity.Job, 0, len(f.jobs))
+	for _, job := range f.jobs {
+		if (filter.ParentID == "" || job.ParentID == filter.ParentID) && (filter.StorageName == "" || job.StorageName == filter.StorageName) &&
+			(filter.Status == "" || job.Status == filter.Status) && (repo.Tenant(ctx) == "" || job.Tenant == repo.Tenant(ctx)) {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (f *fakeJobRepo) RemoveVault(_ context.Context, vault string) error {
+	for taskID, job := range f.jobs {
+		if job.Vault == vault {
+			delete(f.jobs, taskID)
+		}
+	}
+	return nil
+}
+
+// syncJobRepo is a fakeJobRepo concurrent backups can share.
+type syncJobRepo struct {
+	fakeJobRepo
+	mu sync.Mutex
+}
+
+func (s *syncJobRepo) SelectEverything(ctx context.Context, taskID string) (entity.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fakeJobRepo.SelectEverything(ctx, taskID)
+}
+
+func (s *syncJobRepo) UpdateJob(ctx context.Context, job entity.Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fakeJobRepo.UpdateJob(ctx, job)
+}
+
+func (s *syncJobRepo) ListJobs(ctx context.Context, filter entity.JobsFilter) ([]entity.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fakeJobRepo.ListJobs(ctx, filter)
+}
+
+// recordingJobRepo keeps the progress of every job update.
+type recordingJobRepo struct {
+	fakeJobRepo
+	progress []string
+}
+
+func (r *recordingJobRepo) UpdateJob(ctx context.Context, job entity.Job) error {
+	r.progress = append(r.progress, job.Progress)
+	return r.fakeJobRepo.UpdateJob(ctx, job)
+}
+
+type fakeExecutor struct {
+	CommandExecutor
+	restoredFiles     []string
+	testRestoreFolder string
+	dbmap             map[string]string
+	backupDBs         []string
+	liveDBs           []string
+	failBackupDBs     []string
+	// restoredLiveDBs replace liveDBs once a restore ran, when set
+	restoredLiveDBs []string
+	restored        bool
+	restoreErr      error
+	discoverErr     error
+	// backedUpDBs are the databases PerformBackup was called with
+	backedUpDBs []string
+	// backups counts PerformBackup calls, when set they signal backupStarted and wait for releaseBackup
+	backups       atomic.Int32
+	backupStarted chan struct{}
+	releaseBackup chan struct{}
+}
+
+func (f *fakeExecutor) PerformRestore(vaultFolder string, _ []entity.DBEntry, dbmap map[string]string, _ map[string]string, _ bool, _ string) error {
+	f.dbmap = dbmap
+	f.restored = true
+	entries, err := os.ReadDir(vaultFolder)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		f.restoredFiles = append(f.restoredFiles, e.Name())
+	}
+	return f.restoreErr
+}
+
+func (f *fakeExecutor) PerformTestRestore(vaultFolder string, _ []entity.DBEntry, dbmap map[string]string, _ map[string]string, _ string) error {
+	f.testRestoreFolder = vaultFolder
+	return f.PerformRestore(vaultFolder, nil, dbmap, nil, false, "")
+}
+
+func (f *fakeExecutor) GetBackupDBs(string) ([]string, error) {
+	return f.backupDBs, nil
+}
+
+func (f *fakeExecutor) DiscoverDBs(map[string]string) ([]string, error) {
+	if f.discoverErr != nil {
+		return nil, f.discoverErr
+	}
+	if f.restored && f.restoredLiveDBs != nil {
+		return f.restoredLiveDBs, nil
+	}
+	return f.liveDBs, nil
+}
+
+func (f *fakeExecutor) PerformBackup(_ entity.Vault, dbs []entity.DBEntry, _ []string, _ map[string]string, _ string) error {
+	f.backups.Add(1)
+	for _, db := range dbs {
+		f.backedUpDBs = append(f.backedUpDBs, db.SimpleName)
+	}
+	if f.backupStarted != nil {
+		f.backupStarted <- struct{}{}
+	}
+	if f.releaseBackup != nil {
+		<-f.releaseBackup
+	}
+	for _, db := range dbs {
+		if slices.Contains(f.failBackupDBs, db.SimpleName) {
+			return fmt.Errorf("backup of %s failed", db.SimpleName)
+		}
+	}
+	return nil
+}
+
+func (f *fakeExecutor) ExecuteEvictCmd(string) error {
+	return nil
+}
+
+func (f *fakeExecutor) CancelWatch(string) bool {
+	return false
+}
+
+func TestIsFullBackup(t *testing.T) {
+	testCases := []struct {
+		name      string
+		vault     entity.Vault
+		vaultName string
+		expected  bool
+	}{
+		{
+			name:     "local full vault",
+			vault:    entity.Vault{Folder: "/backup-storage/20250101T000000"},
+			expected: true,
+		},
+		{
+			name:     "local granular vault",
+			vault:    entity.Vault{Folder: "/backup-storage/granular/20250101T000000", IsGranular: true},
+			expected: false,
+		},
+		{
+			name:      "remote vault backed up without dbs",
+			vaultName: "full",
+			expected:  true,
+		},
+		{
+			name:      "remote vault backed up with dbs",
+			vaultName: "granular",
+			expected:  false,
+		},
+		{
+			name:      "unknown remote vault",
+			vaultName: "missing",
+			expected:  false,
+		},
+	}
+	b := &BackupDaemon{
+		logger: zap.NewNop().Sugar(),
+		dbRepo: &fakeJobRepo{jobs: map[string]entity.Job{
+			"full":     {TaskID: "full", Databases: "[]"},
+			"granular": {TaskID: "granular", Databases: `["db1"]`},
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := b.isFullBackup(context.Background(), tc.vault, tc.vaultName); got != tc.expected {
+				t.Fatalf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestEnqueueBackupSecondaryS3(t *testing.T) {
+	testCases := []struct {
+		name              string
+		secondaryErr      error
+		secondaryRequired bool
+		expectErr         bool
+		expectedStatus    string
+	}{
+		{
+			name:           "replicated",
+			expectedStatus: "Successful",
+		},
+		{
+			name:           "optional secondary failure is ignored",
+			secondaryErr:   errors.New("secondary is down"),
+			expectedStatus: "Successful",
+		},
+		{
+			name:              "required secondary failure fails the backup",
+			secondaryErr:      errors.New("secondary is down"),
+			secondaryRequired: true,
+			expectErr:         true,
+			expectedStatus:    "Failed",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			primary := NewMockS3ClientRepository(ctrl)
+			primary.EXPECT().UploadFolder(gomock.Any(), gomock.Any()).Return(nil)
+			secondary := NewMockS3ClientRepository(ctrl)
+			secondary.EXPECT().UploadFolder(gomock.Any(), gomock.Any()).Return(tc.secondaryErr)
+
+			dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{}}
+			b := NewBackupDaemon(repo.NewStorageRepo(t.TempDir(), t.TempDir(), "default", false, false, nil, nil), dbRepo, nil, primary, &fakeExecutor{},
+				zap.NewNop().Sugar(), BackupDaemonOptions{S3Enable: true, SecondaryS3Client: secondary, SecondaryS3Required: tc.secondaryRequired, RestoreIDScheme: RestoreIDUUID})
+
+			response, err := b.EnqueueBackup(context.Background(), entity.BackupRequest{ProcType: FULL})
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+			}
+			var backupID string
+			for id := range dbRepo.jobs {
+				backupID = id
+			}
+			if response.BackupID != "" && response.BackupID != backupID {
+				t.Fatalf("expected backup id %s, got %s", backupID, response.BackupID)
+			}
+			if status := dbRepo.jobs[backupID].Status; status != tc.expectedStatus {
+				t.Fatalf("expected job status %s, got %s", tc.expectedStatus, status)
+			}
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"20250101T000000", "20250102T000000"} {
+		if err := os.MkdirAll(filepath.Join(root, name), 0o755); err != nil {
+			t.Fatalf("failed to create vault dir: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "20250101T000000", ".metrics"), []byte(`{"exit_code":0}`), 0o644); err != nil {
+		t.Fatalf("failed to write metrics: %v", err)
+	}
+
+	dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{
+		"20250102T000000": {TaskID: "20250102T000000", Type: COMMONBACKUP, Vault: "20250102T000000", Status: "Successful", Tenant: "team-b"},
+		"20241231T000000": {TaskID: "20241231T000000", Type: COMMONBACKUP, Vault: "20241231T000000", Status: "Successful"},
+		"20241230T000000": {TaskID: "20241230T000000", Type: COMMONBACKUP, Vault: "20241230T000000", Status: "Processing"},
+		"20241229T000000": {TaskID: "20241229T000000", Type: COMMONBACKUP, Vault: "20241229T000000", Status: "Successful", BlobPath: "replica"},
+		"20241228T000000": {TaskID: "20241228T000000", Type: COMMONBACKUP, Vault: "20241228T000000", Status: "Successful",
+			Request: `{"externalBackupPath":"/external/20241228T000000"}`},
+		"restore-1": {TaskID: "restore-1", Type: COMMONRESTORE, Vault: "20241227T000000", Status: "Successful"},
+	}}
+	b := &BackupDaemon{
+		storageRepo: repo.NewStorageRepo(root, t.TempDir(), "default", false, false, nil, nil),
+		dbRepo:      dbRepo,
+		logger:      zap.NewNop().Sugar(),
+	}
+
+	// a tenant request reconciles the jobs of every tenant, the vault of team-b is tracked
+	response, err := b.Reconcile(repo.WithTenant(context.Background(), "team-a"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(response.Removed) != 1 || response.Removed[0] != "20241231T000000" {
+		t.Fatalf("expected removed [20241231T000000], got %v", response.Removed)
+	}
+	if len(response.Added) != 1 || response.Added[0] != "20250101T000000" {
+		t.Fatalf("expected added [20250101T000000], got %v", response.Added)
+	}
+	added, ok := dbRepo.jobs["20250101T000000"]
+	if !ok || added.Status != "Successful" || added.Type != COMMONBACKUP {
+		t.Fatalf("expected successful placeholder backup job, got %+v", added)
+	}
+	if added.Tenant != "" {
+		t.Fatalf("expected placeholder job without tenant, got %q", added.Tenant)
+	}
+	if job := dbRepo.jobs["20250102T000000"]; job.Tenant != "team-b" {
+		t.Fatalf("expected job of team-b to be kept, got %+v", job)
+	}
+	for _, kept := range []string{"20250102T000000", "20241230T000000", "20241229T000000", "20241228T000000", "restore-1"} {
+		if _, ok := dbRepo.jobs[kept]; !ok {
+			t.Fatalf("expected job %s to be kept", kept)
+		}
+	}
+}
+
+func TestPartialListStopsCleanup(t *testing.T) {
+	root := t.TempDir()
+	// the vault can not be read, its job and s3 objects must not be taken for orphans
+	loop := filepath.Join(root, "20250101T000000")
+	if err := os.Symlink(loop, loop); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{
+		"20250101T000000": {TaskID: "20250101T000000", Type: COMMONBACKUP, Vault: "20250101T000000", Status: "Successful"},
+	}}
+	b := &BackupDaemon{
+		storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+		dbRepo:      dbRepo,
+		s3Client:    NewMockS3ClientRepository(ctrl),
+		s3Enable:    true,
+		logger:      zap.NewNop().Sugar(),
+	}
+
+	if _, err := b.Reconcile(context.Background()); !errors.Is(err, repo.ErrPartialList) {
+		t.Fatalf("expected error %v from Reconcile, got %v", repo.ErrPartialList, err)
+	}
+	if _, ok := dbRepo.jobs["20250101T000000"]; !ok {
+		t.Fatalf("expected the job of the unreadable vault to be kept")
+	}
+	if _, err := b.CleanS3Orphans(context.Background(), false); !errors.Is(err, repo.ErrPartialList) {
+		t.Fatalf("expected error %v from CleanS3Orphans, got %v", repo.ErrPartialList, err)
+	}
+}
+
+func TestCleanS3Orphans(t *testing.T) {
+	testCases := []struct {
+		name            string
+		dryRun          bool
+		expectedDeleted []string
+	}{
+		{name: "dry run", dryRun: true},
+		{name: "clean", expectedDeleted: []string{"backup-storage/20250103T000000", "replica/20250104T000000"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(root, "20250101T000000"), 0o755); err != nil {
+				t.Fatalf("failed to create vault dir: %v", err)
+			}
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			s3Client := NewMockS3ClientRepository(ctrl)
+			s3Client.EXPECT().ListFiles(gomock.Any(), "").Return([]string{
+				"backup-storage/20250101T000000/.metrics",
+				"backup-storage/20250102T000000/db1/dump.gz",
+				"backup-storage/20250103T000000/.metrics",
+				"backup-storage/20250103T000000/db1/dump.gz",
+				"replica/20250104T000000/.metrics",
+				"replica/notes.txt",
+			}, nil)
+			var deleted []string
+			s3Client.EXPECT().DeletePrefix(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, prefix string) error {
+				deleted = append(deleted, prefix)
+				return nil
+			}).AnyTimes()
+			b := &BackupDaemon{
+				storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+				dbRepo: &fakeJobRepo{jobs: map[string]entity.Job{
+					"20250102T000000": {TaskID: "20250102T000000", Vault: "20250102T000000", Status: "Successful"},
+				}},
+				s3Client: s3Client,
+				s3Enable: true,
+				logger:   zap.NewNop().Sugar(),
+			}
+
+			response, err := b.CleanS3Orphans(context.Background(), tc.dryRun)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expectedOrphans := []entity.S3Orphan{
+				{Prefix: "backup-storage/20250103T000000", Objects: 2},
+				{Prefix: "replica/20250104T000000", Objects: 1},
+			}
+			if !reflect.DeepEqual(response.Orphans, expectedOrphans) || response.Count != 2 || response.DryRun != tc.dryRun {
+				t.Fatalf("expected orphans %v, got %+v", expectedOrphans, response)
+			}
+			if !reflect.DeepEqual(deleted, tc.expectedDeleted) {
+				t.Fatalf("expected deleted prefixes %v, got %v", tc.expectedDeleted, deleted)
+			}
+		})
+	}
+}
+
+func TestFilterDatabases(t *testing.T) {
+	names := []string{"orders", "orders_archive", "users", "template1"}
+	testCases := []struct {
+		name      string
+		include   []string
+		exclude   []string
+		expected  []string
+		expectErr bool
+	}{
+		{
+			name:     "no filters keep everything",
+			expected: names,
+		},
+		{
+			name:     "include pattern",
+			include:  []string{"orders*"},
+			expected: []string{"orders", "orders_archive"},
+		},
+		{
+			name:     "exclude wins over include",
+			include:  []string{"orders*", "users"},
+			exclude:  []string{"*_archive"},
+			expected: []string{"orders", "users"},
+		},
+		{
+			name:     "exclude only",
+			exclude:  []string{"template?"},
+			expected: []string{"orders", "orders_archive", "users"},
+		},
+		{
+			name:      "invalid pattern",
+			include:   []string{"["},
+			expectErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := filterDatabases(names, tc.include, tc.exclude)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+			}
+			if len(got) != len(tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, got)
+			}
+			for i := range got {
+				if got[i] != tc.expected[i] {
+					t.Fatalf("expected %v, got %v", tc.expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreFromURL(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "pg_dump.sql"), []byte("dump"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	archive := filepath.Join(t.TempDir(), "20250101T000000.tar.gz")
+	if err := util.TarGz(src, archive); err != nil {
+		t.Fatalf("TarGz failed: %v", err)
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, archive)
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		name          string
+		url           string
+		allowedHosts  []string
+		maxSize       int64
+		expectedError error
+		expectedFiles []string
+		expectedJob   string
+	}{
+		{
+			name:          "restored",
+			url:           server.URL + "/20250101T000000.tar.gz",
+			allowedHosts:  []string{"127.0.0.1"},
+			expectedFiles: []string{"pg_dump.sql"},
+			expectedJob:   "Successful",
+		},
+		{
+			name:          "host not allowed",
+			url:           server.URL + "/20250101T000000.tar.gz",
+			allowedHosts:  []string{"backups.example.com"},
+			expectedError: ErrURLNotAllowed,
+		},
+		{
+			name:          "plain http is refused",
+			url:           strings.Replace(server.URL, "https://", "http://", 1),
+			allowedHosts:  []string{"127.0.0.1"},
+			expectedError: ErrURLNotAllowed,
+		},
+		{
+			name:          "archive too large",
+			url:           server.URL + "/20250101T000000.tar.gz",
+			allowedHosts:  []string{"127.0.0.1"},
+			maxSize:       10,
+			expectedError: util.ErrDownloadTooLarge,
+			expectedJob:   "Failed",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{}}
+			executor := &fakeExecutor{}
+			b := &BackupDaemon{
+				dbRepo:                 dbRepo,
+				executor:               executor,
+				logger:                 zap.NewNop().Sugar(),
+				restoreURLAllowedHosts: tc.allowedHosts,
+				restoreURLMaxSize:      tc.maxSize,
+				httpClient:             server.Client(),
+			}
+
+			_, err := b.RestoreFromURL(context.Background(), entity.RestoreFromURLRequest{URL: tc.url})
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected err %v, got %v", tc.expectedError, err)
+			}
+			if strings.Join(executor.restoredFiles, ",") != strings.Join(tc.expectedFiles, ",") {
+				t.Fatalf("expected restore of %v, got %v", tc.expectedFiles, executor.restoredFiles)
+			}
+			if tc.expectedJob == "" {
+				if len(dbRepo.jobs) != 0 {
+					t.Fatalf("expected no job for a rejected url, got %v", dbRepo.jobs)
+				}
+				return
+			}
+			for _, job := range dbRepo.jobs {
+				if job.Status != tc.expectedJob {
+					t.Fatalf("expected job status %s, got %s", tc.expectedJob, job.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestEvictWeeklyBucketsAlignment(t *testing.T) {
+	at := func(day int, hour int) string {
+		return time.Date(2024, time.January, day, hour, 0, 0, 0, time.UTC).Format(repo.VaultNameFormat)
+	}
+	names := map[string]string{at(0, 12): "sun", at(1, 12): "mon", at(2, 12): "tue"}
+	testCases := []struct {
+		name            string
+		weekStart       time.Weekday
+		loc             *time.Location
+		expectedEvicted []string
+	}{
+		{
+			// 2023-12-31 is a sunday, 2024-01-01 a monday
+			name:            "weeks start on monday",
+			weekStart:       time.Monday,
+			loc:             time.UTC,
+			expectedEvicted: []string{"mon"},
+		},
+		{
+			name:            "weeks start on sunday",
+			weekStart:       time.Sunday,
+			loc:             time.UTC,
+			expectedEvicted: []string{"mon", "sun"},
+		},
+		{
+			// monday midnight in UTC+14 is sunday 10:00 UTC, the sunday noon backup moves to the monday week
+			name:            "weeks start on monday in a timezone ahead of utc",
+			weekStart:       time.Monday,
+			loc:             time.FixedZone("UTC+14", 14*60*60),
+			expectedEvicted: []string{"mon", "sun"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			for name := range names {
+				if err := os.MkdirAll(filepath.Join(root, name), 0o755); err != nil {
+					t.Fatalf("failed to create vault: %v", err)
+				}
+			}
+			storageRepo := repo.NewStorageRepo(root, "", "", false, false, nil, nil)
+			items, err := storageRepo.List(repo.FULL, "")
+			if err != nil {
+				t.Fatalf("failed to list vaults: %v", err)
+			}
+			b := &BackupDaemon{storageRepo: storageRepo, logger: zap.NewNop().Sugar(), evictionAlignment: EvictionAlignment(tc.weekStart, tc.loc)}
+
+			eviction, err := b.evict(items, "1h/7d", map[int64]bool{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var evicted []string
+			for _, v := range eviction {
+				evicted = append(evicted, names[filepath.Base(v.Folder)])
+			}
+			sort.Strings(evicted)
+			if strings.Join(evicted, ",") != strings.Join(tc.expectedEvicted, ",") {
+				t.Fatalf("expected evicted %v, got %v", tc.expectedEvicted, evicted)
+			}
+		})
+	}
+}
+
+func TestEvictionAlignment(t *testing.T) {
+	day := (24 * time.Hour).Milliseconds()
+	testCases := []struct {
+		name      string
+		weekStart time.Weekday
+		loc       *time.Location
+		expected  int64
+	}{
+		{name: "monday utc keeps the historical offset", weekStart: time.Monday, loc: time.UTC, expected: 4 * day},
+		{name: "thursday utc is the epoch", weekStart: time.Thursday, loc: time.UTC, expected: 0},
+		{name: "sunday utc", weekStart: time.Sunday, loc: time.UTC, expected: 3 * day},
+		{name: "monday utc+3", weekStart: time.Monday, loc: time.FixedZone("UTC+3", 3*60*60), expected: 4*day - (3 * time.Hour).Milliseconds()},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := EvictionAlignment(tc.weekStart, tc.loc); got != tc.expected {
+				t.Fatalf("expected %d, got %d", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestFailJob(t *testing.T) {
+	testCases := []struct {
+		name          string
+		taskID        string
+		expectedError error
+	}{
+		{name: "processing job is failed", taskID: "processing"},
+		{name: "queued job is failed", taskID: "queued"},
+		{name: "finished job is left alone", taskID: "successful", expectedError: ErrJobFinished},
+		{name: "unknown job", taskID: "missing", expectedError: ErrJobNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{
+				"processing": {TaskID: "processing", Status: "Processing", Vault: "20250101T000000"},
+				"queued":     {TaskID: "queued", Status: "Queued"},
+				"successful": {TaskID: "successful", Status: "Successful"},
+			}}
+			b := &BackupDaemon{dbRepo: dbRepo, logger: zap.NewNop().Sugar()}
+
+			err := b.FailJob(context.Background(), tc.taskID)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected err %v, got %v", tc.expectedError, err)
+			}
+			if tc.expectedError != nil {
+				return
+			}
+			job := dbRepo.jobs[tc.taskID]
+			if job.Status != "Failed" || job.Err == "" {
+				t.Fatalf("expected job to be failed with a reason, got %+v", job)
+			}
+		})
+	}
+}
+
+func TestEvictKeepsRetainedBackup(t *testing.T) {
+	root := t.TempDir()
+	storageRepo := repo.NewStorageRepo(root, "", "", false, false, nil, nil)
+	for _, name := range []string{"20240101T000000", "20240102T000000"} {
+		if err := os.MkdirAll(filepath.Join(root, name), 0o755); err != nil {
+			t.Fatalf("failed to create vault: %v", err)
+		}
+	}
+	retained := storageRepo.GetVault("20240101T000000", false, "", "", false)
+	until, err := retainUntil(entity.BackupRequest{TTL: "100y"}, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := storageRepo.LockUntil(retained, until); err != nil {
+		t.Fatalf("LockUntil failed: %v", err)
+	}
+	items, err := storageRepo.List(repo.ALL, "")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	exclude, err := storageRepo.GetNonEvictableVaults(repo.ALL)
+	if err != nil {
+		t.Fatalf("GetNonEvictableVaults failed: %v", err)
+	}
+	b := &BackupDaemon{logger: zap.NewNop().Sugar()}
+
+	// 0/delete keeps no backups, only the retention stops it
+	eviction, err := b.evict(items, "0/delete", exclude)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(eviction) != 1 || eviction[0].TimeStamp == retained.TimeStamp {
+		t.Fatalf("expected only the unretained vault to be evicted, got %v", eviction)
+	}
+}
+
+func TestRetainUntil(t *testing.T) {
+	now := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name          string
+		request       entity.BackupRequest
+		expected      time.Time
+		expectedError error
+	}{
+		{name: "no retention", request: entity.BackupRequest{}},
+		{name: "ttl", request: entity.BackupRequest{TTL: "90d"}, expected: now.Add(90 * 24 * time.Hour)},
+		{name: "retain until", request: entity.BackupRequest{RetainUntil: "2026-01-01T00:00:00Z"}, expected: now.AddDate(1, 0, 0)},
+		{name: "both set", request: entity.BackupRequest{TTL: "1d", RetainUntil: "2026-01-01T00:00:00Z"}, expectedError: ErrInvalidRetention},
+		{name: "retain until in the past", request: entity.BackupRequest{RetainUntil: "2024-01-01T00:00:00Z"}, expectedError: ErrInvalidRetention},
+		{name: "invalid ttl", request: entity.BackupRequest{TTL: "soon"}, expectedError: ErrInvalidRetention},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			until, err := retainUntil(tc.request, now)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+			if !until.Equal(tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, until)
+			}
+		})
+	}
+}
+
+func TestRestoreMulti(t *testing.T) {
+	root := t.TempDir()
+	// granular vaults hold one database each, the full one is refused as full restore is disabled
+	for folder, file := range map[string]string{
+		filepath.Join(repo.GRANULAR, "20240101T000000"): "db1",
+		"20240102T000000": "full",
+		filepath.Join(repo.GRANULAR, "20240103T000000"): "db3",
+	} {
+		if err := os.MkdirAll(filepath.Join(root, folder), 0o755); err != nil {
+			t.Fatalf("failed to create vault: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(root, folder, file), nil, 0o644); err != nil {
+			t.Fatalf("failed to write vault file: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(root, folder, ".console"), []byte("restore failed\n"), 0o644); err != nil {
+			t.Fatalf("failed to write console: %v", err)
+		}
+	}
+	first := strconv.FormatInt(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC).UnixMilli(), 10)
+	last := strconv.FormatInt(time.Date(2024, time.January, 3, 0, 0, 0, 0, time.UTC).UnixMilli(), 10)
+
+	testCases := []struct {
+		name             string
+		request          entity.MultiRestoreRequest
+		expectedRestored []string
+		expectedFailed   []string
+		expectedSkipped  []string
+		expectedFiles    []string
+		expectedError    error
+	}{
+		{
+			name:             "listed vaults",
+			request:          entity.MultiRestoreRequest{Vaults: []string{"20240103T000000", "20240101T000000"}},
+			expectedRestored: []string{"20240103T000000", "20240101T000000"},
+			expectedFiles:    []string{".console", "db3", ".console", "db1"},
+		},
+		{
+			name:             "time range stops on error",
+			request:          entity.MultiRestoreRequest{From: first, To: last},
+			expectedRestored: []string{"20240101T000000"},
+			expectedFailed:   []string{"20240102T000000"},
+			expectedSkipped:  []string{"20240103T000000"},
+			expectedFiles:    []string{".console", "db1"},
+			expectedError:    ErrFullRestoreDisabled,
+		},
+		{
+			name:             "time range continues on error",
+			request:          entity.MultiRestoreRequest{From: first, ContinueOnError: true},
+			expectedRestored: []string{"20240101T000000", "20240103T000000"},
+			expectedFailed:   []string{"20240102T000000"},
+			expectedFiles:    []string{".console", "db1", ".console", "db3"},
+			expectedError:    ErrFullRestoreDisabled,
+		},
+		{
+			name:          "missing vault restores nothing",
+			request:       entity.MultiRestoreRequest{Vaults: []string{"20240101T000000", "20240104T000000"}},
+			expectedError: ErrBackupNotFound,
+		},
+		{
+			name:          "vaults and range",
+			request:       entity.MultiRestoreRequest{Vaults: []string{"20240101T000000"}, From: first},
+			expectedError: ErrInvalidMultiRestore,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			executor := &fakeExecutor{}
+			b := &BackupDaemon{
+				storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+				dbRepo:      &fakeJobRepo{jobs: map[string]entity.Job{}},
+				executor:    executor,
+				logger:      zap.NewNop().Sugar(),
+			}
+
+			response, err := b.RestoreMulti(context.Background(), tc.request)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+			var restored, failed []string
+			for _, r := range response.Restored {
+				restored = append(restored, r.Vault)
+			}
+			for _, f := range response.Failed {
+				failed = append(failed, f.Vault)
+			}
+			if !reflect.DeepEqual(restored, tc.expectedRestored) {
+				t.Fatalf("expected restored %v, got %v", tc.expectedRestored, restored)
+			}
+			if !reflect.DeepEqual(failed, tc.expectedFailed) {
+				t.Fatalf("expected failed %v, got %v", tc.expectedFailed, failed)
+			}
+			if !reflect.DeepEqual(response.Skipped, tc.expectedSkipped) {
+				t.Fatalf("expected skipped %v, got %v", tc.expectedSkipped, response.Skipped)
+			}
+			if !reflect.DeepEqual(executor.restoredFiles, tc.expectedFiles) {
+				t.Fatalf("expected restored files %v, got %v", tc.expectedFiles, executor.restoredFiles)
+			}
+		})
+	}
+}
+
+func TestRestoreBackupRemovesTemp(t *testing.T) {
+	const vaultName = "20240101T000000"
+	testCases := []struct {
+		name            string
+		keepRestoreTemp bool
+		fullBackup      bool
+		expectErr       bool
+		expectedKept    bool
+	}{
+		{name: "removed after success"},
+		{name: "removed after failure", fullBackup: true, expectErr: true},
+		{name: "kept for debugging", keepRestoreTemp: true, expectedKept: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("TMPDIR", t.TempDir())
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			s3Client := NewMockS3ClientRepository(ctrl)
+			s3Client.EXPECT().DownloadFolder(gomock.Any(), "blob/"+vaultName, gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, _ string, localDir string, _ DownloadProgressFunc) error {
+					return os.WriteFile(filepath.Join(localDir, "db1.dump"), []byte("dump"), 0o644)
+				})
+			jobs := map[string]entity.Job{}
+			if tc.fullBackup {
+				// a vault job without databases marks a full backup, refused as full restore is disabled
+				jobs[vaultName] = entity.Job{TaskID: vaultName, Status: "Successful"}
+			}
+			executor := &fakeExecutor{}
+			b := &BackupDaemon{
+				storageRepo:     repo.NewStorageRepo(t.TempDir(), "", "", false, false, nil, nil),
+				dbRepo:          &fakeJobRepo{jobs: jobs},
+				s3Client:        s3Client,
+				executor:        executor,
+				s3Enable:        true,
+				logger:          zap.NewNop().Sugar(),
+				keepRestoreTemp: tc.keepRestoreTemp,
+			}
+
+			_, err := b.RestoreBackup(context.Background(), entity.RestoreRequest{
+				Vault:      vaultName,
+				CustomVars: map[string]string{"blob_path": "blob"},
+			})
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+			}
+			if !tc.expectErr && strings.Join(executor.restoredFiles, ",") != "db1.dump" {
+				t.Fatalf("expected db1.dump to be restored, got %v", executor.restoredFiles)
+			}
+			_, statErr := os.Stat(filepath.Join(os.TempDir(), "backup-daemon", "restore", vaultName))
+			if kept := statErr == nil; kept != tc.expectedKept {
+				t.Fatalf("expected restore temp dir kept %v, stat err: %v", tc.expectedKept, statErr)
+			}
+		})
+	}
+}
+
+func TestVaultBucketS3Client(t *testing.T) {
+	const vaultName = "20240101T000000"
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	root := t.TempDir()
+	folder := filepath.Join(root, vaultName)
+	if err := os.MkdirAll(folder, 0o755); err != nil {
+		t.Fatalf("failed to create vault dir: %v", err)
+	}
+	// the default client must not be used for a backup uploaded to another bucket
+	primary := NewMockS3ClientRepository(ctrl)
+	bucketClient := NewMockS3ClientRepository(ctrl)
+	bucketClient.EXPECT().DownloadFolder(gomock.Any(), folder, folder, gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ string, localDir string, _ DownloadProgressFunc) error {
+			return os.WriteFile(filepath.Join(localDir, "db1.dump"), []byte("dump"), 0o644)
+		})
+	bucketClient.EXPECT().ListFiles(gomock.Any(), folder).Return([]string{folder + "/db1.tar", folder + "/.metrics"}, nil)
+	bucketClient.EXPECT().CreatePresignedUrl(gomock.Any(), folder+"/db1.tar", 60).Return("https://backups-b/db1.tar", nil)
+	executor := &fakeExecutor{}
+	b := &BackupDaemon{
+		storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+		dbRepo: &fakeJobRepo{jobs: map[string]entity.Job{
+			vaultName: {TaskID: vaultName, Type: COMMONBACKUP, Status: "Successful", Vault: vaultName, Bucket: "backups-b"},
+		}},
+		s3Client:          primary,
+		bucketS3Clients:   map[string]S3ClientRepository{"backups-b": bucketClient},
+		executor:          executor,
+		s3Enable:          true,
+		enableFullRestore: true,
+		logger:            zap.NewNop().Sugar(),
+	}
+
+	if _, err := b.RestoreBackup(context.Background(), entity.RestoreRequest{Vault: vaultName}); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if strings.Join(executor.restoredFiles, ",") != "db1.dump" {
+		t.Fatalf("expected db1.dump to be restored, got %v", executor.restoredFiles)
+	}
+	response, err := b.CreateS3PresignedURL(context.Background(), entity.S3PresignedURLRequest{BackupID: vaultName, Expiration: 60})
+	if err != nil {
+		t.Fatalf("presign failed: %v", err)
+	}
+	if !reflect.DeepEqual(response.Urls, []string{"https://backups-b/db1.tar"}) {
+		t.Fatalf("expected the url of the bucket client, got %v", response.Urls)
+	}
+}
+
+func TestEnqueueBackupBucket(t *testing.T) {
+	testCases := []struct {
+		name           string
+		bucket         string
+		expectedClient string
+		expectedError  error
+	}{
+		{name: "default bucket", expectedClient: "default"},
+		{name: "allowed bucket", bucket: "backups-b", expectedClient: "backups-b"},
+		{name: "bucket not allowed", bucket: "backups-c", expectedError: ErrBucketNotAllowed},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var uploadedTo string
+			newClient := func(name string) S3ClientRepository {
+				client := NewMockS3ClientRepository(ctrl)
+				client.EXPECT().UploadFolder(gomock.Any(), gomock.Any()).DoAndReturn(func(context.Context, string) error {
+					uploadedTo = name
+					return nil
+				}).AnyTimes()
+				return client
+			}
+
+			dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{}}
+			b := NewBackupDaemon(repo.NewStorageRepo(t.TempDir(), t.TempDir(), "default", false, false, nil, nil), dbRepo, nil, newClient("default"), &fakeExecutor{},
+				zap.NewNop().Sugar(), BackupDaemonOptions{S3Enable: true, BucketS3Clients: map[string]S3ClientRepository{"backups-b": newClient("backups-b")},
+					RestoreIDScheme: RestoreIDUUID})
+
+			response, err := b.EnqueueBackup(context.Background(), entity.BackupRequest{ProcType: FULL, Bucket: tc.bucket})
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+			if uploadedTo != tc.expectedClient {
+				t.Fatalf("expected upload to %q, got %q", tc.expectedClient, uploadedTo)
+			}
+			if tc.expectedError != nil {
+				if len(dbRepo.jobs) != 0 {
+					t.Fatalf("expected no job, got %v", dbRepo.jobs)
+				}
+				return
+			}
+			if bucket := dbRepo.jobs[response.BackupID].Bucket; bucket != tc.bucket {
+				t.Fatalf("expected job bucket %q, got %q", tc.bucket, bucket)
+			}
+		})
+	}
+}
+
+func TestRestoreBackupTestMode(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	root := t.TempDir()
+	const vaultName = "20240101T000000"
+	if err := os.MkdirAll(filepath.Join(root, vaultName), 0o755); err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, vaultName, "full.dump"), []byte("dump"), 0o644); err != nil {
+		t.Fatalf("failed to write vault file: %v", err)
+	}
+	executor := &fakeExecutor{}
+	dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{}}
+	// full restore is disabled, a test restore leaves the live system alone and is allowed
+	b := &BackupDaemon{
+		storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+		dbRepo:      dbRepo,
+		executor:    executor,
+		logger:      zap.NewNop().Sugar(),
+	}
+
+	response, err := b.RestoreBackup(context.Background(), entity.RestoreRequest{Vault: vaultName, Test: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if executor.testRestoreFolder == "" || executor.testRestoreFolder == filepath.Join(root, vaultName) {
+		t.Fatalf("expected the test restore to run on a copy, got %q", executor.testRestoreFolder)
+	}
+	if strings.Join(executor.restoredFiles, ",") != "full.dump" {
+		t.Fatalf("expected the copy to hold full.dump, got %v", executor.restoredFiles)
+	}
+	if _, err := os.Stat(executor.testRestoreFolder); !os.IsNotExist(err) {
+		t.Fatalf("expected the copy to be removed, stat err: %v", err)
+	}
+	job := dbRepo.jobs[response.TaskID]
+	if job.Type != TESTRESTORE || job.Status != "Successful" {
+		t.Fatalf("expected Successful %s job, got %s %s", TESTRESTORE, job.Status, job.Type)
+	}
+}
+
+func TestEnforceBackupCap(t *testing.T) {
+	testCases := []struct {
+		name           string
+		caps           BackupCaps
+		expectedError  error
+		expectedVaults []string
+	}{
+		{name: "no cap", expectedVaults: []string{"20240101T000000", "20240102T000000", "20240103T000000"}},
+		{name: "under cap", caps: BackupCaps{Full: 4}, expectedVaults: []string{"20240101T000000", "20240102T000000", "20240103T000000"}},
+		{name: "reject", caps: BackupCaps{Full: 3}, expectedError: ErrBackupCapReached,
+			expectedVaults: []string{"20240101T000000", "20240102T000000", "20240103T000000"}},
+		{name: "evict oldest evictable", caps: BackupCaps{Full: 3, Evict: true}, expectedVaults: []string{"20240101T000000", "20240103T000000"}},
+		{name: "not enough evictable", caps: BackupCaps{Full: 1, Evict: true}, expectedError: ErrBackupCapReached,
+			expectedVaults: []string{"20240101T000000", "20240102T000000", "20240103T000000"}},
+		{name: "granular cap only", caps: BackupCaps{Granular: 1}, expectedVaults: []string{"20240101T000000", "20240102T000000", "20240103T000000"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			storageRepo := repo.NewStorageRepo(root, "", "", false, false, nil, nil)
+			for _, name := range []string{"20240101T000000", "20240102T000000", "20240103T000000"} {
+				if err := os.MkdirAll(filepath.Join(root, name), 0o755); err != nil {
+					t.Fatalf("failed to create vault: %v", err)
+				}
+			}
+			// the oldest vault is locked and never evicted
+			if err := os.WriteFile(filepath.Join(root, "20240101T000000", repo.EvictLock), nil, 0o644); err != nil {
+				t.Fatalf("failed to lock vault: %v", err)
+			}
+			b := &BackupDaemon{
+				storageRepo: storageRepo,
+				dbRepo:      &fakeJobRepo{jobs: map[string]entity.Job{}},
+				executor:    &fakeExecutor{},
+				logger:      zap.NewNop().Sugar(),
+				backupCaps:  tc.caps,
+			}
+
+			err := b.enforceBackupCap(context.Background(), false)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+			names, err := storageRepo.ListVaultNames(false, repo.FULL, "")
+			if err != nil {
+				t.Fatalf("ListVaultNames failed: %v", err)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tc.expectedVaults) {
+				t.Fatalf("expected vaults %v, got %v", tc.expectedVaults, names)
+			}
+		})
+	}
+}
+
+func TestBackupCapConcurrentBurst(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"20240101T000000", "20240102T000000"} {
+		if err := os.MkdirAll(filepath.Join(root, name), 0o755); err != nil {
+			t.Fatalf("failed to create vault: %v", err)
+		}
+	}
+	// a backup still writes the second vault, it counts against the cap as well
+	if err := os.WriteFile(filepath.Join(root, "20240102T000000", ".lock"), nil, 0o644); err != nil {
+		t.Fatalf("failed to lock vault: %v", err)
+	}
+	const burst = 5
+	executor := &fakeExecutor{backupStarted: make(chan struct{}, burst), releaseBackup: make(chan struct{})}
+	b := &BackupDaemon{
+		storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+		dbRepo:      &syncJobRepo{fakeJobRepo: fakeJobRepo{jobs: map[string]entity.Job{}}},
+		executor:    executor,
+		logger:      zap.NewNop().Sugar(),
+		backupCaps:  BackupCaps{Full: 4},
+	}
+
+	results := make(chan error, burst)
+	for i := 0; i < burst; i++ {
+		request := entity.BackupRequest{BackupID: fmt.Sprintf("20240201T00000%d", i), CustomVars: map[string]string{}}
+		go func() {
+			_, err := b.backup(context.Background(), request, time.Time{}, "", nil)
+			results <- err
+		}()
+	}
+	// two vaults exist, the cap leaves room for two of the burst
+	for i := 0; i < burst-2; i++ {
+		select {
+		case err := <-results:
+			if !errors.Is(err, ErrBackupCapReached) {
+				t.Fatalf("expected error %v, got %v", ErrBackupCapReached, err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("expected %d backups to be rejected, got %d", burst-2, i)
+		}
+	}
+	if started := executor.backups.Load(); started != 2 {
+		t.Fatalf("expected 2 backups to start, got %d", started)
+	}
+	close(executor.releaseBackup)
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(b.openVaults) != 0 {
+		t.Fatalf("expected no open vaults once the backups ended, got %v", b.openVaults)
+	}
+}
+
+func TestEnforceStorageQuota(t *testing.T) {
+	all := []string{"20240101T000000", "20240102T000000", "20240103T000000", "20240104T000000"}
+	testCases := []struct {
+		name           string
+		caps           BackupCaps
+		expectedError  error
+		expectedVaults []string
+	}{
+		{name: "no quota", expectedVaults: all},
+		{name: "under quota", caps: BackupCaps{StorageQuotas: map[string]int64{"s1": 400}}, expectedVaults: all},
+		{name: "reject", caps: BackupCaps{StorageQuotas: map[string]int64{"s1": 350}}, expectedError: ErrBackupCapReached, expectedVaults: all},
+		{name: "evict oldest evictable", caps: BackupCaps{StorageQuotas: map[string]int64{"s1": 350}, Evict: true},
+			expectedVaults: []string{"20240101T000000", "20240103T000000", "20240104T000000"}},
+		{name: "not enough evictable", caps: BackupCaps{StorageQuotas: map[string]int64{"s1": 150}, Evict: true}, expectedError: ErrBackupCapReached,
+			expectedVaults: all},
+		{name: "quota of another storage", caps: BackupCaps{StorageQuotas: map[string]int64{"s2": 100}}, expectedVaults: all},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			storageRepo := repo.NewStorageRepo(root, "", "", false, false, nil, nil)
+			jobs := map[string]entity.Job{}
+			for i, name := range all {
+				if err := os.MkdirAll(filepath.Join(root, name), 0o755); err != nil {
+					t.Fatalf("failed to create vault: %v", err)
+				}
+				size, storageName := 100, "s1"
+				if i == len(all)-1 {
+					size, storageName = 1000, "s2"
+				}
+				if err := os.WriteFile(filepath.Join(root, name, ".metrics"), []byte(fmt.Sprintf(`{"size":%d}`, size)), 0o644); err != nil {
+					t.Fatalf("failed to write metrics: %v", err)
+				}
+				jobs[name] = entity.Job{TaskID: name, Vault: name, Type: COMMONBACKUP, StorageName: storageName}
+			}
+			// the oldest vault is locked and never evicted
+			if err := os.WriteFile(filepath.Join(root, "20240101T000000", repo.EvictLock), nil, 0o644); err != nil {
+				t.Fatalf("failed to lock vault: %v", err)
+			}
+			b := &BackupDaemon{
+				storageRepo: storageRepo,
+				dbRepo:      &fakeJobRepo{jobs: jobs},
+				executor:    &fakeExecutor{},
+				logger:      zap.NewNop().Sugar(),
+				backupCaps:  tc.caps,
+			}
+
+			err := b.enforceStorageQuota(context.Background(), "s1")
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+			// the usage measured by the check is reported without walking the vaults again
+			quotas, err := b.StorageQuotas(context.Background())
+			if err != nil {
+				t.Fatalf("StorageQuotas failed: %v", err)
+			}
+			if quota, ok := tc.caps.StorageQuotas["s1"]; ok {
+				expected := entity.StorageQuota{Usage: int64(100 * (len(tc.expectedVaults) - 1)), Quota: quota}
+				if quotas["s1"] != expected {
+					t.Fatalf("expected quota %+v, got %+v", expected, quotas["s1"])
+				}
+			} else if len(quotas) != 0 {
+				t.Fatalf("expected no measured quotas, got %v", quotas)
+			}
+			names, err := storageRepo.ListVaultNames(false, repo.ALL, "")
+			if err != nil {
+				t.Fatalf("ListVaultNames failed: %v", err)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tc.expectedVaults) {
+				t.Fatalf("expected vaults %v, got %v", tc.expectedVaults, names)
+			}
+		})
+	}
+}
+
+func TestRunStorageQuotaRefresh(t *testing.T) {
+	root := t.TempDir()
+	const vaultName = "20240101T000000"
+	if err := os.MkdirAll(filepath.Join(root, vaultName), 0o755); err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, vaultName, ".metrics"), []byte(`{"size":100}`), 0o644); err != nil {
+		t.Fatalf("failed to write metrics: %v", err)
+	}
+	b := &BackupDaemon{
+		storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+		dbRepo: &fakeJobRepo{jobs: map[string]entity.Job{
+			vaultName: {TaskID: vaultName, Vault: vaultName, Type: COMMONBACKUP, StorageName: "s1"},
+		}},
+		logger:     zap.NewNop().Sugar(),
+		backupCaps: BackupCaps{StorageQuotas: map[string]int64{"s1": 350, "s2": 100}},
+	}
+	quotas, err := b.StorageQuotas(context.Background())
+	if err != nil || len(quotas) != 0 {
+		t.Fatalf("expected no quotas before the usage is measured, got %v, %v", quotas, err)
+	}
+
+	// the usage is measured once before ctx is checked
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.RunStorageQuotaRefresh(ctx, time.Hour)
+	quotas, err = b.StorageQuotas(context.Background())
+	if err != nil {
+		t.Fatalf("StorageQuotas failed: %v", err)
+	}
+	expected := map[string]entity.StorageQuota{"s1": {Usage: 100, Quota: 350}, "s2": {Usage: 0, Quota: 100}}
+	if !reflect.DeepEqual(quotas, expected) {
+		t.Fatalf("expected quotas %v, got %v", expected, quotas)
+	}
+}
+
+func TestStorageQuotaConcurrentBurst(t *testing.T) {
+	root := t.TempDir()
+	const vaultName = "20240101T000000"
+	if err := os.MkdirAll(filepath.Join(root, vaultName), 0o755); err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, vaultName, ".metrics"), []byte(`{"size":100}`), 0o644); err != nil {
+		t.Fatalf("failed to write metrics: %v", err)
+	}
+	const burst = 5
+	executor := &fakeExecutor{backupStarted: make(chan struct{}, burst), releaseBackup: make(chan struct{})}
+	b := &BackupDaemon{
+		storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+		dbRepo: &syncJobRepo{fakeJobRepo: fakeJobRepo{jobs: map[string]entity.Job{
+			vaultName: {TaskID: vaultName, Vault: vaultName, Type: COMMONBACKUP, StorageName: "s1"},
+		}}},
+		executor:   executor,
+		logger:     zap.NewNop().Sugar(),
+		backupCaps: BackupCaps{StorageQuotas: map[string]int64{"s1": 350}},
+	}
+
+	results := make(chan error, burst)
+	for i := 0; i < burst; i++ {
+		request := entity.BackupRequest{BackupID: fmt.Sprintf("20240201T00000%d", i), CustomVars: map[string]string{"storageName": "s1"}}
+		go func() {
+			_, err := b.backup(context.Background(), request, time.Time{}, "", nil)
+			results <- err
+		}()
+	}
+	// running backups are assumed as large as the latest one, the quota has room for two of them
+	for i := 0; i < burst-2; i++ {
+		select {
+		case err := <-results:
+			if !errors.Is(err, ErrBackupCapReached) {
+				t.Fatalf("expected error %v, got %v", ErrBackupCapReached, err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("expected %d backups to be rejected, got %d", burst-2, i)
+		}
+	}
+	if started := executor.backups.Load(); started != 2 {
+		t.Fatalf("expected 2 backups to start, got %d", started)
+	}
+	close(executor.releaseBackup)
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestCheckFreeInodes(t *testing.T) {
+	root := t.TempDir()
+	stats, err := util.Stats(root)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.TotalInodes == 0 || stats.FreeInodes == 0 {
+		t.Skip("the filesystem of the temp dir does not report free inodes")
+	}
+	testCases := []struct {
+		name          string
+		minPercent    float64
+		expectedError error
+	}{
+		{name: "check disabled"},
+		{name: "enough inodes", minPercent: 0.000001},
+		{name: "low on inodes", minPercent: 100.5, expectedError: ErrLowInodes},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &BackupDaemon{
+				storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+				backupCaps:  BackupCaps{MinFreeInodesPercent: tc.minPercent},
+			}
+			if err := b.checkFreeInodes(); !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestEnqueueEviction(t *testing.T) {
+	testCases := []struct {
+		name             string
+		policy           string
+		dryRun           bool
+		expectedResponse entity.EvictResponse
+		expectedVaults   []string
+	}{
+		{name: "nothing to evict", policy: "100y/delete", expectedResponse: entity.EvictResponse{NothingToEvict: true},
+			expectedVaults: []string{"20240101T000000", "20240102T000000"}},
+		{name: "evicts unlocked vaults", policy: "0/delete",
+			expectedResponse: entity.EvictResponse{Count: 1, Evicted: []string{"20240102T000000"}}, expectedVaults: []string{"20240101T000000"}},
+		{name: "dry run", policy: "0/delete", dryRun: true,
+			expectedResponse: entity.EvictResponse{Count: 1, Evicted: []string{"20240102T000000"}, DryRun: true},
+			expectedVaults:   []string{"20240101T000000", "20240102T000000"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			for _, name := range []string{"20240101T000000", "20240102T000000"} {
+				if err := os.MkdirAll(filepath.Join(root, name), 0o755); err != nil {
+					t.Fatalf("failed to create vault: %v", err)
+				}
+			}
+			if err := os.WriteFile(filepath.Join(root, "20240101T000000", repo.EvictLock), nil, 0o644); err != nil {
+				t.Fatalf("failed to lock vault: %v", err)
+			}
+			storageRepo := repo.NewStorageRepo(root, "", "", false, false, nil, nil)
+			b := &BackupDaemon{
+				storageRepo:            storageRepo,
+				dbRepo:                 &fakeJobRepo{jobs: map[string]entity.Job{}},
+				executor:               &fakeExecutor{},
+				logger:                 zap.NewNop().Sugar(),
+				evictionPolicy:         tc.policy,
+				granularEvictionPolicy: tc.policy,
+			}
+
+			response, err := b.EnqueueEviction(context.Background(), entity.EvictRequest{DryRun: tc.dryRun})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(response, tc.expectedResponse) {
+				t.Fatalf("expected response %+v, got %+v", tc.expectedResponse, response)
+			}
+			names, err := storageRepo.ListVaultNames(false, repo.FULL, "")
+			if err != nil {
+				t.Fatalf("ListVaultNames failed: %v", err)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tc.expectedVaults) {
+				t.Fatalf("expected vaults %v, got %v", tc.expectedVaults, names)
+			}
+		})
+	}
+}
+
+func TestEvictionDuringBackup(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "20240101T000000"), 0o755); err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+	storageRepo := repo.NewStorageRepo(root, "", "", false, false, nil, nil)
+	executor := &fakeExecutor{backupStarted: make(chan struct{}), releaseBackup: make(chan struct{})}
+	dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{}}
+	b := &BackupDaemon{
+		storageRepo:            storageRepo,
+		dbRepo:                 dbRepo,
+		executor:               executor,
+		logger:                 zap.NewNop().Sugar(),
+		evictionPolicy:         "0/delete",
+		granularEvictionPolicy: "0/delete",
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := b.EnqueueBackup(context.Background(), entity.BackupRequest{ProcType: FULL, CustomVars: map[string]string{}})
+		done <- err
+	}()
+	<-executor.backupStarted
+
+	var backupID string
+	for _, job := range dbRepo.jobs {
+		backupID = job.Vault
+	}
+	// the backup command has created the brand-new vault and is still writing it
+	if err := os.MkdirAll(filepath.Join(root, backupID), 0o755); err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+	response, err := b.EnqueueEviction(context.Background(), entity.EvictRequest{})
+	if err != nil {
+		t.Fatalf("eviction failed: %v", err)
+	}
+	if !reflect.DeepEqual(response.Evicted, []string{"20240101T000000"}) {
+		t.Fatalf("expected only the old vault evicted, got %+v", response)
+	}
+	if err := b.RemoveBackupV2(context.Background(), entity.EvictByVaultV2Request{Vault: backupID}); !errors.Is(err, ErrVaultBusy) {
+		t.Fatalf("expected error %v removing the running backup, got %v", ErrVaultBusy, err)
+	}
+	// the lock keeps the vault even when its job does not look running
+	job := dbRepo.jobs[backupID]
+	job.Status = "Failed"
+	dbRepo.jobs[backupID] = job
+	if response, err := b.EnqueueEviction(context.Background(), entity.EvictRequest{}); err != nil || !response.NothingToEvict {
+		t.Fatalf("expected nothing to evict while the backup runs, got %+v, %v", response, err)
+	}
+	close(executor.releaseBackup)
+	if err := <-done; err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+
+	b.evictionGracePeriod = time.Hour
+	if response, err := b.EnqueueEviction(context.Background(), entity.EvictRequest{}); err != nil || !response.NothingToEvict {
+		t.Fatalf("expected nothing to evict in the grace period, got %+v, %v", response, err)
+	}
+	b.evictionGracePeriod = 0
+	response, err = b.EnqueueEviction(context.Background(), entity.EvictRequest{})
+	if err != nil {
+		t.Fatalf("eviction failed: %v", err)
+	}
+	if !reflect.DeepEqual(response.Evicted, []string{backupID}) {
+		t.Fatalf("expected %s evicted once finished, got %+v", backupID, response)
+	}
+}
+
+func TestRemoveMissingBackup(t *testing.T) {
+	b := &BackupDaemon{
+		storageRepo: repo.NewStorageRepo(t.TempDir(), "", "", false, false, nil, nil),
+		dbRepo:      &fakeJobRepo{jobs: map[string]entity.Job{}},
+		executor:    &fakeExecutor{},
+		logger:      zap.NewNop().Sugar(),
+	}
+	if err := b.RemoveBackup(context.Background(), entity.EvictByVaultRequest{Vault: "20240101T000000"}); !errors.Is(err, ErrVaultNotFound) {
+		t.Fatalf("expected error %v, got %v", ErrVaultNotFound, err)
+	}
+	if err := b.RemoveBackupV2(context.Background(), entity.EvictByVaultV2Request{Vault: "20240101T000000"}); !errors.Is(err, ErrVaultNotFound) {
+		t.Fatalf("expected error %v, got %v", ErrVaultNotFound, err)
+	}
+	if !errors.Is(ErrVaultNotFound, ErrBackupNotFound) {
+		t.Fatalf("expected %v to be a %v", ErrVaultNotFound, ErrBackupNotFound)
+	}
+}
+
+func TestCopyBackup(t *testing.T) {
+	const vaultName = "20240101T000000"
+	testCases := []struct {
+		name           string
+		backupID       string
+		blobPath       string
+		metrics        string
+		expectedPrefix string
+		expectedError  error
+	}{
+		{name: "copy to vault path", backupID: vaultName},
+		{name: "copy under blob path", backupID: vaultName, blobPath: "/blob/", expectedPrefix: "blob/" + vaultName},
+		{name: "missing backup", backupID: "20240102T000000", expectedError: ErrBackupNotFound},
+		{name: "incomplete backup", backupID: vaultName, blobPath: "blob", metrics: `{"complete":false}`, expectedError: ErrBackupIncomplete},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			root := t.TempDir()
+			folder := filepath.Join(root, vaultName)
+			if err := os.MkdirAll(folder, 0o755); err != nil {
+				t.Fatalf("failed to create vault dir: %v", err)
+			}
+			if tc.metrics != "" {
+				if err := os.WriteFile(filepath.Join(folder, ".metrics"), []byte(tc.metrics), 0o644); err != nil {
+					t.Fatalf("failed to write metrics: %v", err)
+				}
+			}
+			s3Client := NewMockS3ClientRepository(ctrl)
+			if tc.expectedError == nil && tc.expectedPrefix == "" {
+				s3Client.EXPECT().UploadFolder(gomock.Any(), folder).Return(nil)
+			} else if tc.expectedError == nil {
+				s3Client.EXPECT().UploadFolderWithPrefix(gomock.Any(), folder, tc.expectedPrefix).Return(nil)
+			}
+			dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{}}
+			b := &BackupDaemon{
+				storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+				dbRepo:      dbRepo,
+				s3Client:    s3Client,
+				s3Enable:    true,
+				logger:      zap.NewNop().Sugar(),
+			}
+
+			response, err := b.CopyBackup(context.Background(), entity.CopyBackupRequest{BackupID: tc.backupID, BlobPath: tc.blobPath})
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+			if tc.expectedError != nil {
+				return
+			}
+			if job := dbRepo.jobs[response.TaskID]; job.Status != "Successful" || job.Vault != vaultName {
+				t.Fatalf("expected successful copy job of %s, got %+v", vaultName, job)
+			}
+		})
+	}
+}
+
+type fakeSettingsRepo struct {
+	repo.DBRepository
+	settings map[string]string
+}
+
+func (f *fakeSettingsRepo) GetSetting(_ context.Context, key string) (string, error) {
+	value, ok := f.settings[key]
+	if !ok {
+		return "", repo.ErrNotFound
+	}
+	return value, nil
+}
+
+func (f *fakeSettingsRepo) SetSetting(_ context.Context, key string, value string) error {
+	f.settings[key] = value
+	return nil
+}
+
+func (f *fakeSettingsRepo) DeleteSetting(_ context.Context, key string) error {
+	delete(f.settings, key)
+	return nil
+}
+
+func TestSetEvictionPolicy(t *testing.T) {
+	configured := entity.EvictionPolicyResponse{FullEvictionPolicy: "0/1d", GranularEvictionPolicy: "0/7d"}
+	testCases := []struct {
+		name          string
+		request       entity.EvictionPolicyRequest
+		expected      entity.EvictionPolicyResponse
+		expectedError error
+	}{
+		{
+			name:     "full only",
+			request:  entity.EvictionPolicyRequest{FullEvictionPolicy: "0/2d"},
+			expected: entity.EvictionPolicyResponse{FullEvictionPolicy: "0/2d", GranularEvictionPolicy: "0/7d"},
+		},
+		{
+			name:     "both",
+			request:  entity.EvictionPolicyRequest{FullEvictionPolicy: "0/1h,1d/7d", GranularEvictionPolicy: "0/delete"},
+			expected: entity.EvictionPolicyResponse{FullEvictionPolicy: "0/1h,1d/7d", GranularEvictionPolicy: "0/delete"},
+		},
+		{
+			name:          "empty",
+			expectedError: ErrInvalidEvictionPolicy,
+		},
+		{
+			name:          "unparsable",
+			request:       entity.EvictionPolicyRequest{GranularEvictionPolicy: "weekly"},
+			expectedError: ErrInvalidEvictionPolicy,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dbRepo := &fakeSettingsRepo{settings: map[string]string{}}
+			b := &BackupDaemon{
+				dbRepo:                 dbRepo,
+				logger:                 zap.NewNop().Sugar(),
+				evictionPolicy:         configured.FullEvictionPolicy,
+				granularEvictionPolicy: configured.GranularEvictionPolicy,
+			}
+			policy, err := b.SetEvictionPolicy(context.Background(), tc.request)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+			if err != nil {
+				if b.evictionPolicies() != configured || len(dbRepo.settings) != 0 {
+					t.Fatalf("expected policy to be kept on error, got %+v", b.evictionPolicies())
+				}
+				return
+			}
+			if policy != tc.expected || b.evictionPolicies() != tc.expected {
+				t.Fatalf("expected policy %+v, got %+v in effect %+v", tc.expected, policy, b.evictionPolicies())
+			}
+			loaded, err := LoadEvictionPolicy(context.Background(), dbRepo, configured)
+			if err != nil {
+				t.Fatalf("unexpected error loading policy: %v", err)
+			}
+			if loaded != tc.expected {
+				t.Fatalf("expected stored policy %+v, got %+v", tc.expected, loaded)
+			}
+		})
+	}
+}
+
+func TestResetEvictionPolicy(t *testing.T) {
+	configured := entity.EvictionPolicyResponse{FullEvictionPolicy: "0/1d", GranularEvictionPolicy: "0/7d"}
+	dbRepo := &fakeSettingsRepo{settings: map[string]string{}}
+	b := NewBackupDaemon(nil, dbRepo, nil, nil, nil, zap.NewNop().Sugar(), BackupDaemonOptions{
+		EvictionPolicy:           configured.FullEvictionPolicy,
+		GranularEvictionPolicy:   configured.GranularEvictionPolicy,
+		ConfiguredEvictionPolicy: configured,
+	})
+	set := entity.EvictionPolicyResponse{FullEvictionPolicy: "0/2d", GranularEvictionPolicy: "0/7d"}
+	if _, err := b.SetEvictionPolicy(context.Background(), entity.EvictionPolicyRequest{FullEvictionPolicy: "0/2d"}); err != nil {
+		t.Fatalf("unexpected error setting policy: %v", err)
+	}
+	if policy := b.GetEvictionPolicy(context.Background()); policy != set {
+		t.Fatalf("expected policy %+v in effect, got %+v", set, policy)
+	}
+
+	policy, err := b.ResetEvictionPolicy(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error resetting policy: %v", err)
+	}
+	if policy != configured || b.GetEvictionPolicy(context.Background()) != configured {
+		t.Fatalf("expected configured policy %+v, got %+v in effect %+v", configured, policy, b.GetEvictionPolicy(context.Background()))
+	}
+	// a restart after the reset uses the configured policy again
+	loaded, err := LoadEvictionPolicy(context.Background(), dbRepo, configured)
+	if err != nil {
+		t.Fatalf("unexpected error loading policy: %v", err)
+	}
+	if loaded != configured {
+		t.Fatalf("expected configured policy %+v after reset, got %+v", configured, loaded)
+	}
+}
+
+func TestLoadEvictionPolicyNotStored(t *testing.T) {
+	configured := entity.EvictionPolicyResponse{FullEvictionPolicy: "0/1d", GranularEvictionPolicy: "0/7d"}
+	loaded, err := LoadEvictionPolicy(context.Background(), &fakeSettingsRepo{settings: map[string]string{}}, configured)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loaded != configured {
+		t.Fatalf("expected configured policy %+v, got %+v", configured, loaded)
+	}
+}
+
+func TestEnqueueBackupCommandOverride(t *testing.T) {
+	testCases := []struct {
+		name          string
+		allow         bool
+		expectedError error
+	}{
+		{name: "override disabled", expectedError: ErrCommandOverrideNotAllowed},
+		{name: "override allowed", allow: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{}}
+			b := NewBackupDaemon(repo.NewStorageRepo(t.TempDir(), t.TempDir(), "default", false, false, nil, nil), dbRepo, nil, nil, &fakeExecutor{},
+				zap.NewNop().Sugar(), BackupDaemonOptions{AllowCommandOverride: tc.allow, RestoreIDScheme: RestoreIDUUID})
+
+			_, err := b.EnqueueBackup(context.Background(), entity.BackupRequest{ProcType: FULL, CommandOverride: "pg_dump --no-owner"})
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestEnqueueBackupDebounce(t *testing.T) {
+	executor := &fakeExecutor{backupStarted: make(chan struct{}), releaseBackup: make(chan struct{})}
+	dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{}}
+	b := NewBackupDaemon(repo.NewStorageRepo(t.TempDir(), t.TempDir(), "default", false, false, nil, nil), dbRepo, nil, nil, executor,
+		zap.NewNop().Sugar(), BackupDaemonOptions{DebounceDuplicateBackups: true, RestoreIDScheme: RestoreIDUUID})
+
+	request := entity.BackupRequest{ProcType: FULL, DBs: []entity.DBEntry{{SimpleName: "db1"}, {SimpleName: "db2"}}}
+	type result struct {
+		response entity.BackupResponse
+		err      error
+	}
+	first := make(chan result)
+	go func() {
+		response, err := b.EnqueueBackup(context.Background(), request)
+		first <- result{response, err}
+	}()
+	<-executor.backupStarted
+
+	// the same databases in another order are a duplicate
+	duplicate, err := b.EnqueueBackup(context.Background(), entity.BackupRequest{ProcType: FULL, DBs: []entity.DBEntry{{SimpleName: "db2"}, {SimpleName: "db1"}}})
+	if err != nil {
+		t.Fatalf("duplicate backup failed: %v", err)
+	}
+	close(executor.releaseBackup)
+	res := <-first
+	if res.err != nil {
+		t.Fatalf("backup failed: %v", res.err)
+	}
+	if duplicate.BackupID != res.response.BackupID {
+		t.Fatalf("expected the running backup %s, got %s", res.response.BackupID, duplicate.BackupID)
+	}
+	if calls := executor.backups.Load(); calls != 1 {
+		t.Fatalf("expected one backup, got %d", calls)
+	}
+
+	keyCases := []struct {
+		name    string
+		tenant  string
+		request entity.BackupRequest
+		// base is the request compared with, the first one when empty
+		base      entity.BackupRequest
+		duplicate bool
+	}{
+		{name: "same request", request: request, duplicate: true},
+		{name: "other tenant", tenant: "team-a", request: request},
+		{name: "other base backup", request: entity.BackupRequest{ProcType: INCREMENTAL, DBs: request.DBs, BaseBackupID: "20240102T000000"},
+			base: entity.BackupRequest{ProcType: INCREMENTAL, DBs: request.DBs, BaseBackupID: "20240101T000000"}},
+		{name: "same base backup", request: entity.BackupRequest{ProcType: INCREMENTAL, DBs: request.DBs, BaseBackupID: "20240101T000000"},
+			base: entity.BackupRequest{ProcType: INCREMENTAL, DBs: request.DBs, BaseBackupID: "20240101T000000"}, duplicate: true},
+		{name: "other databases", request: entity.BackupRequest{ProcType: FULL, DBs: []entity.DBEntry{{SimpleName: "db1"}}}},
+		{name: "other discovery patterns",
+			request: entity.BackupRequest{ProcType: FULL, Mode: DISCOVERDATABASES, Include: []string{"orders_*"}, Exclude: []string{"*_tmp"}},
+			base:    entity.BackupRequest{ProcType: FULL, Mode: DISCOVERDATABASES, Include: []string{"users_*"}, Exclude: []string{"*_tmp"}}},
+		{name: "same discovery patterns in another order",
+			request: entity.BackupRequest{ProcType: FULL, Mode: DISCOVERDATABASES, Include: []string{"users_*", "orders_*"}},
+			base:    entity.BackupRequest{ProcType: FULL, Mode: DISCOVERDATABASES, Include: []string{"orders_*", "users_*"}}, duplicate: true},
+		{name: "other type", request: entity.BackupRequest{ProcType: INCREMENTAL, DBs: request.DBs}},
+		{name: "other storage", request: entity.BackupRequest{ProcType: FULL, DBs: request.DBs, CustomVars: map[string]string{"storageName": "s3"}}},
+	}
+	for _, tc := range keyCases {
+		t.Run(tc.name, func(t *testing.T) {
+			base := request
+			if tc.base.ProcType != "" {
+				base = tc.base
+			}
+			ctx := repo.WithTenant(context.Background(), tc.tenant)
+			if duplicate := duplicateBackupKey(ctx, tc.request) == duplicateBackupKey(context.Background(), base); duplicate != tc.duplicate {
+				t.Fatalf("expected duplicate %v, got %v", tc.duplicate, duplicate)
+			}
+		})
+	}
+}
+
+func TestRestoreBackupIncomplete(t *testing.T) {
+	root := t.TempDir()
+	const vaultName = "20240101T000000"
+	if err := os.MkdirAll(filepath.Join(root, vaultName), 0o755); err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, vaultName, ".metrics"), []byte(`{"complete":false}`), 0o644); err != nil {
+		t.Fatalf("failed to write metrics: %v", err)
+	}
+	executor := &fakeExecutor{}
+	b := &BackupDaemon{
+		storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+		dbRepo:      &fakeJobRepo{jobs: map[string]entity.Job{}},
+		executor:    executor,
+		logger:      zap.NewNop().Sugar(),
+	}
+
+	_, err := b.RestoreBackup(context.Background(), entity.RestoreRequest{Vault: vaultName, Test: true})
+	if !errors.Is(err, ErrBackupIncomplete) {
+		t.Fatalf("expected error %v, got %v", ErrBackupIncomplete, err)
+	}
+	if executor.testRestoreFolder != "" {
+		t.Fatalf("expected no restore, got one from %s", executor.testRestoreFolder)
+	}
+}
+
+func TestRestoreBlobPathIncomplete(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	const vaultName = "20240101T000000"
+	s3Client := NewMockS3ClientRepository(ctrl)
+	s3Client.EXPECT().DownloadFolder(gomock.Any(), "blob/"+vaultName, gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ string, localDir string, _ DownloadProgressFunc) error {
+			return os.WriteFile(filepath.Join(localDir, ".metrics"), []byte(`{"complete":false}`), 0o644)
+		})
+	executor := &fakeExecutor{}
+	b := &BackupDaemon{
+		storageRepo: repo.NewStorageRepo(t.TempDir(), "", "", false, false, nil, nil),
+		dbRepo:      &fakeJobRepo{jobs: map[string]entity.Job{}},
+		s3Client:    s3Client,
+		executor:    executor,
+		s3Enable:    true,
+		logger:      zap.NewNop().Sugar(),
+	}
+
+	_, err := b.RestoreBackup(context.Background(), entity.RestoreRequest{
+		Vault:      vaultName,
+		Test:       true,
+		CustomVars: map[string]string{"blob_path": "blob"},
+	})
+	if !errors.Is(err, ErrBackupIncomplete) {
+		t.Fatalf("expected error %v, got %v", ErrBackupIncomplete, err)
+	}
+	if executor.testRestoreFolder != "" {
+		t.Fatalf("expected no restore, got one from %s", executor.testRestoreFolder)
+	}
+}
+
+func TestRestoreBackupRename(t *testing.T) {
+	testCases := []struct {
+		name          string
+		vault         string
+		request       entity.RestoreRequest
+		backupDBs     []string
+		liveDBs       []string
+		expectedDbmap map[string]string
+		expectedError error
+	}{
+		{
+			name:          "prefix",
+			request:       entity.RestoreRequest{RenamePrefix: "restore_"},
+			liveDBs:       []string{"db1", "db2"},
+			expectedDbmap: map[string]string{"db1": "restore_db1", "db2": "restore_db2"},
+		},
+		{
+			name:          "prefix and suffix, explicit name wins",
+			request:       entity.RestoreRequest{RenamePrefix: "r_", RenameSuffix: "_copy", ChangeDbNames: map[string]string{"db2": "other"}},
+			expectedDbmap: map[string]string{"db1": "r_db1_copy", "db2": "other"},
+		},
+		{
+			name:          "collides with a live database",
+			request:       entity.RestoreRequest{RenameSuffix: "_old"},
+			liveDBs:       []string{"db1", "db2_old"},
+			expectedError: ErrRenameCollision,
+		},
+		{
+			name:          "collides with a backed up database",
+			request:       entity.RestoreRequest{RenamePrefix: "r_", ChangeDbNames: map[string]string{"db1": "db2"}},
+			expectedError: ErrRenameCollision,
+		},
+		{
+			name:          "two databases renamed to the same name",
+			request:       entity.RestoreRequest{RenamePrefix: "r_", ChangeDbNames: map[string]string{"db1": "r_db2"}},
+			expectedError: ErrRenameCollision,
+		},
+		{
+			name:          "target namespace",
+			vault:         "granular_ns-a_20240101T000000",
+			request:       entity.RestoreRequest{TargetNamespace: "ns-b"},
+			backupDBs:     []string{"orders_ns-a", "ns-a_users", "ns-a-archive", "shared"},
+			expectedDbmap: map[string]string{"orders_ns-a": "orders_ns-b", "ns-a_users": "ns-b_users"},
+		},
+		{
+			name:          "target namespace with prefix",
+			vault:         "ns-a_20240101T000000",
+			request:       entity.RestoreRequest{TargetNamespace: "ns-b", RenamePrefix: "r_"},
+			backupDBs:     []string{"db_ns-a"},
+			expectedDbmap: map[string]string{"db_ns-a": "r_db_ns-b"},
+		},
+		{
+			name:          "target namespace collides with a backed up database",
+			vault:         "ns-a_20240101T000000",
+			request:       entity.RestoreRequest{TargetNamespace: "ns-b"},
+			backupDBs:     []string{"db_ns-a", "db_ns-b"},
+			expectedError: ErrRenameCollision,
+		},
+		{
+			name:          "vault without namespace",
+			request:       entity.RestoreRequest{TargetNamespace: "ns-b"},
+			expectedError: ErrVaultNamespaceUnknown,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			vaultName := tc.vault
+			if vaultName == "" {
+				vaultName = "20240101T000000"
+			}
+			backupDBs := tc.backupDBs
+			if backupDBs == nil {
+				backupDBs = []string{"db1", "db2"}
+			}
+			if err := os.MkdirAll(filepath.Join(root, vaultName), 0o755); err != nil {
+				t.Fatalf("failed to create vault: %v", err)
+			}
+			executor := &fakeExecutor{backupDBs: backupDBs, liveDBs: tc.liveDBs}
+			b := &BackupDaemon{
+				storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+				dbRepo:      &fakeJobRepo{jobs: map[string]entity.Job{}},
+				executor:    executor,
+				logger:      zap.NewNop().Sugar(),
+			}
+
+			request := tc.request
+			request.Vault = vaultName
+			request.Test = true
+			_, err := b.RestoreBackup(context.Background(), request)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+			if err != nil {
+				if executor.testRestoreFolder != "" {
+					t.Fatalf("expected no restore, got one from %s", executor.testRestoreFolder)
+				}
+				return
+			}
+			if !reflect.DeepEqual(executor.dbmap, tc.expectedDbmap) {
+				t.Fatalf("expected dbmap %v, got %v", tc.expectedDbmap, executor.dbmap)
+			}
+		})
+	}
+}
+
+func TestRestoreBackupDbMapTargets(t *testing.T) {
+	testCases := []struct {
+		name          string
+		request       entity.RestoreRequest
+		liveDBs       []string
+		discoverErr   error
+		expectedError error
+	}{
+		{
+			name:    "unique new names",
+			request: entity.RestoreRequest{ChangeDbNames: map[string]string{"db1": "new1", "db2": "new2"}},
+			liveDBs: []string{"db1", "db2"},
+		},
+		{
+			name:          "two databases renamed to the same name",
+			request:       entity.RestoreRequest{ChangeDbNames: map[string]string{"db1": "new", "db2": "new"}},
+			expectedError: ErrRenameCollision,
+		},
+		{
+			name:          "renamed onto a database restored under its own name",
+			request:       entity.RestoreRequest{ChangeDbNames: map[string]string{"db1": "db2"}},
+			expectedError: ErrRenameCollision,
+		},
+		{
+			name: "renamed onto a backed up database not restored",
+			request: entity.RestoreRequest{DBs: []entity.DBEntry{{SimpleName: "db1"}},
+				ChangeDbNames: map[string]string{"db1": "db2"}},
+		},
+		{
+			name: "dbmap entry of a database not restored",
+			request: entity.RestoreRequest{DBs: []entity.DBEntry{{SimpleName: "db1"}},
+				ChangeDbNames: map[string]string{"db1": "new", "db2": "new"}},
+		},
+		{
+			name:          "renamed onto a live database",
+			request:       entity.RestoreRequest{ChangeDbNames: map[string]string{"db1": "new1"}},
+			liveDBs:       []string{"new1"},
+			expectedError: ErrRenameCollision,
+		},
+		{
+			name:    "test restore onto a name live on the source",
+			request: entity.RestoreRequest{Test: true, ChangeDbNames: map[string]string{"db1": "new1"}},
+			liveDBs: []string{"new1"},
+		},
+		{
+			name:        "live databases not discovered",
+			request:     entity.RestoreRequest{ChangeDbNames: map[string]string{"db1": "new1"}},
+			discoverErr: fmt.Errorf("%w: connection refused", ErrExecuteCmdFailed),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			const vaultName = "20240101T000000"
+			if err := os.MkdirAll(filepath.Join(root, vaultName), 0o755); err != nil {
+				t.Fatalf("failed to create vault: %v", err)
+			}
+			dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{}}
+			executor := &fakeExecutor{backupDBs: []string{"db1", "db2"}, liveDBs: tc.liveDBs, discoverErr: tc.discoverErr}
+			b := &BackupDaemon{
+				storageRepo:       repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+				dbRepo:            dbRepo,
+				executor:          executor,
+				logger:            zap.NewNop().Sugar(),
+				enableFullRestore: true,
+			}
+
+			request := tc.request
+			request.Vault = vaultName
+			_, err := b.RestoreBackup(context.Background(), request)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+			if err == nil {
+				return
+			}
+			if executor.restored {
+				t.Fatalf("expected no restore, got one with dbmap %v", executor.dbmap)
+			}
+			for _, job := range dbRepo.jobs {
+				if job.Status != "Failed" || job.Err != err.Error() {
+					t.Fatalf("expected job failed with %q, got %s %q", err, job.Status, job.Err)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreBackupPerDBFailures(t *testing.T) {
+	root := t.TempDir()
+	const vaultName = "20240101T000000"
+	if err := os.MkdirAll(filepath.Join(root, vaultName), 0o755); err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+	dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{}}
+	restoreErr := &DBRestoreError{Failed: map[string]error{"db2": ErrExecuteCmdFailed}}
+	b := &BackupDaemon{
+		storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+		dbRepo:      dbRepo,
+		executor:    &fakeExecutor{backupDBs: []string{"db1", "db2", "db3"}, restoreErr: restoreErr},
+		logger:      zap.NewNop().Sugar(),
+	}
+
+	_, err := b.RestoreBackup(context.Background(), entity.RestoreRequest{
+		Vault:         vaultName,
+		DBs:           []entity.DBEntry{{SimpleName: "db1"}, {SimpleName: "db2"}, {SimpleName: "db3"}},
+		ChangeDbNames: map[string]string{"db2": "new2"},
+	})
+	if !errors.Is(err, ErrDBRestoreFailed) {
+		t.Fatalf("expected error %v, got %v", ErrDBRestoreFailed, err)
+	}
+	const expectedStatuses = `{"db1":"Successful","db3":"Successful","new2":"Failed"}`
+	for _, job := range dbRepo.jobs {
+		if job.Status != "Failed" || job.Err != restoreErr.Error() {
+			t.Fatalf("expected job failed with %q, got %s %q", restoreErr, job.Status, job.Err)
+		}
+		if job.DatabaseStatuses != expectedStatuses {
+			t.Fatalf("expected database statuses %s, got %s", expectedStatuses, job.DatabaseStatuses)
+		}
+	}
+}
+
+func TestRestoreBackupValidationStatuses(t *testing.T) {
+	testCases := []struct {
+		name             string
+		request          entity.RestoreRequest
+		expectedStatuses string
+	}{
+		{
+			name:             "database missing from backup",
+			request:          entity.RestoreRequest{DBs: []entity.DBEntry{{SimpleName: "db1"}, {SimpleName: "db3"}, {SimpleName: "db2"}}},
+			expectedStatuses: `{"db1":"Skipped","db2":"Skipped","db3":"Failed"}`,
+		},
+		{
+			name: "dbmap source missing from backup",
+			request: entity.RestoreRequest{DBs: []entity.DBEntry{{SimpleName: "db1"}, {SimpleName: "db2"}},
+				ChangeDbNames: map[string]string{"db3": "db1"}},
+			expectedStatuses: `{"db1":"Failed","db2":"Skipped"}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			const vaultName = "20240101T000000"
+			if err := os.MkdirAll(filepath.Join(root, vaultName), 0o755); err != nil {
+				t.Fatalf("failed to create vault: %v", err)
+			}
+			dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{}}
+			b := &BackupDaemon{
+				storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+				dbRepo:      dbRepo,
+				executor:    &fakeExecutor{backupDBs: []string{"db1", "db2"}},
+				logger:      zap.NewNop().Sugar(),
+			}
+
+			request := tc.request
+			request.Vault = vaultName
+			if _, err := b.RestoreBackup(context.Background(), request); err == nil {
+				t.Fatalf("expected an error")
+			}
+			if len(dbRepo.jobs) == 0 {
+				t.Fatalf("expected a job")
+			}
+			for _, job := range dbRepo.jobs {
+				if job.Status != "Failed" || job.DatabaseStatuses != tc.expectedStatuses {
+					t.Fatalf("expected failed job with statuses %s, got %s %s", tc.expectedStatuses, job.Status, job.DatabaseStatuses)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreBackupVerify(t *testing.T) {
+	testCases := []struct {
+		name             string
+		request          entity.RestoreRequest
+		liveDBs          []string
+		restoredLiveDBs  []string
+		expectedStatuses string
+		expectedError    error
+	}{
+		{
+			name:             "requested databases restored",
+			request:          entity.RestoreRequest{DBs: []entity.DBEntry{{SimpleName: "db1"}}},
+			liveDBs:          []string{"db1", "db2"},
+			expectedStatuses: `{"db1":"Successful"}`,
+		},
+		{
+			name:             "requested database missing",
+			request:          entity.RestoreRequest{DBs: []entity.DBEntry{{SimpleName: "db1"}, {SimpleName: "db2"}}},
+			liveDBs:          []string{"db1"},
+			expectedStatuses: `{"db1":"Successful","db2":"Failed"}`,
+			expectedError:    ErrRestoreNotVerified,
+		},
+		{
+			name:            "full restore checks renamed backup databases",
+			request:         entity.RestoreRequest{ChangeDbNames: map[string]string{"db1": "new1"}},
+			restoredLiveDBs: []string{"new1", "db2"},
+		},
+		{
+			name:          "full restore missing a database",
+			request:       entity.RestoreRequest{},
+			liveDBs:       []string{"db2"},
+			expectedError: ErrRestoreNotVerified,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			const vaultName = "20240101T000000"
+			if err := os.MkdirAll(filepath.Join(root, vaultName), 0o755); err != nil {
+				t.Fatalf("failed to create vault: %v", err)
+			}
+			dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{}}
+			b := &BackupDaemon{
+				storageRepo:        repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+				dbRepo:             dbRepo,
+				executor:           &fakeExecutor{backupDBs: []string{"db1", "db2"}, liveDBs: tc.liveDBs, restoredLiveDBs: tc.restoredLiveDBs},
+				logger:             zap.NewNop().Sugar(),
+				enableFullRestore:  true,
+				verifyAfterRestore: true,
+			}
+
+			request := tc.request
+			request.Vault = vaultName
+			_, err := b.RestoreBackup(context.Background(), request)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+			expectedStatus := "Successful"
+			if tc.expectedError != nil {
+				expectedStatus = "Failed"
+			}
+			for _, job := range dbRepo.jobs {
+				if job.Status != expectedStatus {
+					t.Fatalf("expected job status %s, got %s", expectedStatus, job.Status)
+				}
+				if job.DatabaseStatuses != tc.expectedStatuses {
+					t.Fatalf("expected database statuses %s, got %s", tc.expectedStatuses, job.DatabaseStatuses)
+				}
+			}
+		})
+	}
+}
+
+func TestNewRestoreID(t *testing.T) {
+	testCases := []struct {
+		name     string
+		scheme   string
+		expected string
+	}{
+		{name: "uuid", scheme: RestoreIDUUID, expected: `^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`},
+		{name: "timestamp", scheme: RestoreIDTimestamp, expected: `^\d{8}T\d{6}\.\d{6}-[0-9a-f]{8}$`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &BackupDaemon{restoreIDScheme: tc.scheme}
+			seen := make(map[string]bool)
+			previous := ""
+			for i := 0; i < 100; i++ {
+				id := b.newRestoreID()
+				if !regexp.MustCompile(tc.expected).MatchString(id) {
+					t.Fatalf("expected id matching %s, got %s", tc.expected, id)
+				}
+				if seen[id] {
+					t.Fatalf("expected unique ids, got %s twice", id)
+				}
+				seen[id] = true
+				if tc.scheme == RestoreIDTimestamp && id[:22] < previous {
+					t.Fatalf("expected ids sorted by time, got %s after %s", id, previous)
+				}
+				previous = id[:22]
+			}
+		})
+	}
+}
+
+func TestEnqueuePerDBBackups(t *testing.T) {
+	testCases := []struct {
+		name             string
+		failBackupDBs    []string
+		expectedChildren int
+		expectedStatus   string
+		expectedStatuses string
+		expectError      bool
+	}{
+		{
+			name:             "one database fails",
+			failBackupDBs:    []string{"db2"},
+			expectedChildren: 1,
+			expectedStatus:   "Failed",
+			expectedStatuses: `{"db1":"Successful","db2":"Failed"}`,
+		},
+		{
+			name:             "all databases fail",
+			failBackupDBs:    []string{"db1", "db2"},
+			expectedStatus:   "Failed",
+			expectedStatuses: `{"db1":"Failed","db2":"Failed"}`,
+			expectError:      true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{}}
+			b := &BackupDaemon{
+				storageRepo:       repo.NewStorageRepo(t.TempDir(), "", "", false, false, nil, nil),
+				dbRepo:            dbRepo,
+				executor:          &fakeExecutor{failBackupDBs: tc.failBackupDBs},
+				logger:            zap.NewNop().Sugar(),
+				granularPerDBJobs: true,
+			}
+
+			response, err := b.EnqueueBackup(context.Background(), entity.BackupRequest{
+				DBs:        []entity.DBEntry{{SimpleName: "db1"}, {SimpleName: "db2"}},
+				CustomVars: map[string]string{},
+			})
+			if (err != nil) != tc.expectError {
+				t.Fatalf("expected error %v, got %v", tc.expectError, err)
+			}
+			if len(response.Children) != tc.expectedChildren {
+				t.Fatalf("expected %d children, got %v", tc.expectedChildren, response.Children)
+			}
+
+			var parent entity.Job
+			var children []string
+			for _, job := range dbRepo.jobs {
+				if job.Type == PERDBBACKUP {
+					parent = job
+				} else {
+					children = append(children, job.TaskID)
+				}
+			}
+			if parent.Status != tc.expectedStatus || parent.DatabaseStatuses != tc.expectedStatuses {
+				t.Fatalf("expected parent %s with %s, got %+v", tc.expectedStatus, tc.expectedStatuses, parent)
+			}
+			if len(children) != 2 || children[0] == children[1] {
+				t.Fatalf("expected a distinct job per database, got %v", children)
+			}
+			status, err := b.GetJobStatus(context.Background(), entity.JobStatusRequest{TaskID: parent.TaskID})
+			if err != nil {
+				t.Fatalf("GetJobStatus failed: %v", err)
+			}
+			sort.Strings(children)
+			if !reflect.DeepEqual(status.Children, children) {
+				t.Fatalf("expected children %v, got %v", children, status.Children)
+			}
+		})
+	}
+}
+
+func TestDiscoverExcludeDBs(t *testing.T) {
+	for _, perDB := range []bool{false, true} {
+		t.Run(fmt.Sprintf("per database jobs %v", perDB), func(t *testing.T) {
+			executor := &fakeExecutor{liveDBs: []string{"db1", "db2", "db3", "db3_tmp"}}
+			b := &BackupDaemon{
+				storageRepo:       repo.NewStorageRepo(t.TempDir(), "", "", false, false, nil, nil),
+				dbRepo:            &fakeJobRepo{jobs: map[string]entity.Job{}},
+				executor:          executor,
+				logger:            zap.NewNop().Sugar(),
+				granularPerDBJobs: perDB,
+			}
+
+			response, err := b.EnqueueBackup(context.Background(), entity.BackupRequest{
+				Mode:       DISCOVERDATABASES,
+				Exclude:    []string{"*_tmp"},
+				ExcludeDBs: []string{"db2"},
+				CustomVars: map[string]string{},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			sort.Strings(executor.backedUpDBs)
+			if expected := []string{"db1", "db3"}; !reflect.DeepEqual(executor.backedUpDBs, expected) {
+				t.Fatalf("expected databases %v to be backed up, got %v", expected, executor.backedUpDBs)
+			}
+			if perDB && len(response.Children) != 2 {
+				t.Fatalf("expected a child backup per database left, got %v", response.Children)
+			}
+		})
+	}
+}
+
+func TestCancelBackup(t *testing.T) {
+	executor := &fakeExecutor{backupStarted: make(chan struct{}), releaseBackup: make(chan struct{})}
+	dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{}}
+	b := &BackupDaemon{
+		storageRepo:       repo.NewStorageRepo(t.TempDir(), "", "", false, false, nil, nil),
+		dbRepo:            dbRepo,
+		executor:          executor,
+		logger:            zap.NewNop().Sugar(),
+		granularPerDBJobs: true,
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := b.EnqueueBackup(context.Background(), entity.BackupRequest{
+			DBs:        []entity.DBEntry{{SimpleName: "db1"}, {SimpleName: "db2"}, {SimpleName: "db3"}},
+			CustomVars: map[string]string{},
+		})
+		done <- err
+	}()
+	<-executor.backupStarted
+
+	// jobs are not updated while the backup of db1 runs
+	var parentID, childID string
+	for _, job := range dbRepo.jobs {
+		if job.Type == PERDBBACKUP {
+			parentID = job.TaskID
+		} else {
+			childID = job.TaskID
+		}
+	}
+	if err := b.CancelBackup(context.Background(), childID); !errors.Is(err, ErrBackupNotQueued) {
+		t.Fatalf("expected error %v canceling a running backup, got %v", ErrBackupNotQueued, err)
+	}
+	if err := b.CancelBackup(context.Background(), parentID); err != nil {
+		t.Fatalf("failed to cancel queued databases: %v", err)
+	}
+	close(executor.releaseBackup)
+	if err := <-done; err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+
+	if calls := executor.backups.Load(); calls != 1 {
+		t.Fatalf("expected only the running database to be backed up, got %d backups", calls)
+	}
+	parent := dbRepo.jobs[parentID]
+	const expectedStatuses = `{"db1":"Successful","db2":"Canceled","db3":"Canceled"}`
+	if parent.Status != "Canceled" || parent.DatabaseStatuses != expectedStatuses {
+		t.Fatalf("expected parent Canceled with %s, got %+v", expectedStatuses, parent)
+	}
+	if status := dbRepo.jobs[childID].Status; status != "Successful" {
+		t.Fatalf("expected the running backup to finish, got %s", status)
+	}
+	if err := b.CancelBackup(context.Background(), parentID); !errors.Is(err, ErrJobFinished) {
+		t.Fatalf("expected error %v, got %v", ErrJobFinished, err)
+	}
+	if err := b.CancelBackup(context.Background(), "unknown"); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("expected error %v, got %v", ErrJobNotFound, err)
+	}
+}
+
+func TestCancelWatchedBackup(t *testing.T) {
+	dbRepo := &syncJobRepo{fakeJobRepo: fakeJobRepo{jobs: map[string]entity.Job{}}}
+	b := &BackupDaemon{
+		storageRepo: repo.NewStorageRepo(t.TempDir(), "", "", false, false, nil, nil),
+		dbRepo:      dbRepo,
+		executor: &Executor{
+			watch:  BackupWatch{Enabled: true, Marker: ".backup_complete", Timeout: time.Hour},
+			logger: zap.NewNop().Sugar(),
+		},
+		logger: zap.NewNop().Sugar(),
+	}
+
+	const backupID = "20240101T000000"
+	done := make(chan error)
+	go func() {
+		_, err := b.EnqueueBackup(context.Background(), entity.BackupRequest{BackupID: backupID, CustomVars: map[string]string{}})
+		done <- err
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for err := b.CancelBackup(context.Background(), backupID); err != nil; err = b.CancelBackup(context.Background(), backupID) {
+		if time.Now().After(deadline) {
+			t.Fatalf("failed to cancel the watched backup: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrBackupWatchCanceled) {
+			t.Fatalf("expected error %v, got %v", ErrBackupWatchCanceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the canceled backup to end")
+	}
+	job, err := dbRepo.SelectEverything(context.Background(), backupID)
+	if err != nil {
+		t.Fatalf("failed to select job: %v", err)
+	}
+	if job.Status != "Canceled" {
+		t.Fatalf("expected the backup to end Canceled, got %+v", job)
+	}
+}
+
+func TestRetryBackup(t *testing.T) {
+	executor := &fakeExecutor{failBackupDBs: []string{"db1"}}
+	dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{
+		"restore-1":    {TaskID: "restore-1", Type: COMMONRESTORE, Status: "Failed"},
+		"registered-1": {TaskID: "registered-1", Type: COMMONBACKUP, Status: "Failed"},
+	}}
+	b := &BackupDaemon{
+		storageRepo: repo.NewStorageRepo(t.TempDir(), "", "", false, false, nil, nil),
+		dbRepo:      dbRepo,
+		executor:    executor,
+		logger:      zap.NewNop().Sugar(),
+	}
+
+	request := entity.BackupRequest{
+		DBs:        []entity.DBEntry{{SimpleName: "db1"}},
+		CustomVars: map[string]string{"storageName": "s3", STARTTS: "1704067200000"},
+		ProcType:   FULL,
+	}
+	if _, err := b.EnqueueBackup(context.Background(), request); err == nil {
+		t.Fatalf("expected the backup to fail")
+	}
+	var failedID string
+	for id, job := range dbRepo.jobs {
+		if job.Status == "Failed" && job.Request != "" {
+			failedID = id
+		}
+	}
+	if failedID == "" {
+		t.Fatalf("expected a failed backup with its request, got %+v", dbRepo.jobs)
+	}
+
+	// vault names have a one second resolution, the retry must not reuse the failed vault
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	executor.failBackupDBs = nil
+	response, err := b.RetryBackup(context.Background(), failedID)
+	if err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+	if response.BackupID == "" || response.BackupID == failedID {
+		t.Fatalf("expected a new backup id, got %q", response.BackupID)
+	}
+	retried := dbRepo.jobs[response.BackupID]
+	if retried.Status != "Successful" || retried.StorageName != "s3" || retried.Databases != `["db1"]` {
+		t.Fatalf("expected a successful backup of db1 to s3, got %+v", retried)
+	}
+
+	errorCases := []struct {
+		name          string
+		backupID      string
+		expectedError error
+	}{
+		{name: "successful backup", backupID: response.BackupID, expectedError: ErrBackupNotRetryable},
+		{name: "not a backup", backupID: "restore-1", expectedError: ErrBackupNotRetryable},
+		{name: "no stored request", backupID: "registered-1", expectedError: ErrBackupNotRetryable},
+		{name: "unknown backup", backupID: "unknown", expectedError: ErrJobNotFound},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := b.RetryBackup(context.Background(), tc.backupID); !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestRestoreDownloadProgress(t *testing.T) {
+	dbRepo := &recordingJobRepo{fakeJobRepo: fakeJobRepo{jobs: map[string]entity.Job{}}}
+	b := &BackupDaemon{dbRepo: dbRepo, logger: zap.NewNop().Sugar()}
+
+	progress := b.restoreDownloadProgress(context.Background(), entity.Job{TaskID: "task-1", Status: "Processing"})
+	for _, p := range []DownloadProgress{
+		{TotalObjects: 2, TotalBytes: 1000},
+		{Bytes: 1, TotalObjects: 2, TotalBytes: 1000},
+		{Bytes: 400, TotalObjects: 2, TotalBytes: 1000},
+		{Objects: 1, Bytes: 400, TotalObjects: 2, TotalBytes: 1000},
+		{Objects: 2, Bytes: 1000, TotalObjects: 2, TotalBytes: 1000},
+	} {
+		progress(p)
+	}
+	expected := []string{"downloading 0% (0/2 objects)", "downloading 40% (0/2 objects)", "downloading 100% (2/2 objects)"}
+	if !reflect.DeepEqual(dbRepo.progress, expected) {
+		t.Fatalf("expected progress updates %v, got %v", expected, dbRepo.progress)
+	}
+}
+
+func TestAcquireBackupSlot(t *testing.T) {
+	testCases := []struct {
+		name          string
+		limits        BackupLoadLimits
+		inFlight      int32
+		ioPressure    float64
+		ioPressureErr error
+		expectedError error
+	}{
+		{name: "no limits", inFlight: 10, ioPressure: 90},
+		{name: "under in flight limit", limits: BackupLoadLimits{MaxInFlight: 2}, inFlight: 1},
+		{name: "in flight limit reached", limits: BackupLoadLimits{MaxInFlight: 2}, inFlight: 2, expectedError: ErrOverloaded},
+		{name: "low io pressure", limits: BackupLoadLimits{MaxIOPressure: 50}, ioPressure: 10},
+		{name: "high io pressure", limits: BackupLoadLimits{MaxIOPressure: 50}, ioPressure: 60, expectedError: ErrOverloaded},
+		{name: "io pressure unavailable", limits: BackupLoadLimits{MaxIOPressure: 50}, ioPressureErr: os.ErrNotExist},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &BackupDaemon{
+				logger:     zap.NewNop().Sugar(),
+				loadLimits: tc.limits,
+				ioPressure: func() (float64, error) {
+					return tc.ioPressure, tc.ioPressureErr
+				},
+			}
+			b.inFlight.Store(tc.inFlight)
+
+			release, err := b.acquireBackupSlot()
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+			if err != nil {
+				if load := b.BackupLoad(); load.InFlight != int(tc.inFlight) {
+					t.Fatalf("expected %d backups in flight after rejection, got %d", tc.inFlight, load.InFlight)
+				}
+				return
+			}
+			if load := b.BackupLoad(); load.InFlight != int(tc.inFlight)+1 {
+				t.Fatalf("expected %d backups in flight, got %d", tc.inFlight+1, load.InFlight)
+			}
+			release()
+			if load := b.BackupLoad(); load.InFlight != int(tc.inFlight) {
+				t.Fatalf("expected %d backups in flight after release, got %d", tc.inFlight, load.InFlight)
+			}
+		})
+	}
+}
+
+func TestEnqueueBackupCustomID(t *testing.T) {
+	testCases := []struct {
+		name        string
+		backupID    string
+		expectedErr error
+	}{
+		{name: "custom id", backupID: "20240105T000000"},
+		{name: "not a vault name", backupID: "nightly", expectedErr: ErrInvalidBackupID},
+		{name: "path", backupID: "../20240105T000000", expectedErr: ErrInvalidBackupID},
+		{name: "existing vault", backupID: "20240101T000000", expectedErr: ErrBackupIDExists},
+		{name: "existing job", backupID: "20240102T000000", expectedErr: ErrBackupIDExists},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(root, "20240101T000000"), 0o755); err != nil {
+				t.Fatalf("failed to create vault: %v", err)
+			}
+			dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{"20240102T000000": {TaskID: "20240102T000000"}}}
+			b := &BackupDaemon{
+				storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+				dbRepo:      dbRepo,
+				executor:    &fakeExecutor{},
+				logger:      zap.NewNop().Sugar(),
+			}
+
+			response, err := b.EnqueueBackup(context.Background(), entity.BackupRequest{
+				DBs:        []entity.DBEntry{{SimpleName: "db1"}},
+				CustomVars: map[string]string{},
+				BackupID:   tc.backupID,
+			})
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if response.BackupID != tc.backupID {
+				t.Fatalf("expected backup %s, got %s", tc.backupID, response.BackupID)
+			}
+			if job := dbRepo.jobs[tc.backupID]; job.Vault != tc.backupID {
+				t.Fatalf("expected job of vault %s, got %+v", tc.backupID, job)
+			}
+		})
+	}
+}
+
+func TestStreamBackupConsole(t *testing.T) {
+	consolePollInterval = 10 * time.Millisecond
+	testCases := []struct {
+		name          string
+		status        string
+		expectedLines []string
+		expectedErr   error
+	}{
+		{name: "running backup", status: "Processing", expectedLines: []string{"line1", "line2", "partial", "line3"}},
+		{name: "finished backup", status: "Successful", expectedErr: ErrBackupNotRunning},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			console := filepath.Join(root, "20240101T000000", ".console")
+			if err := os.MkdirAll(filepath.Dir(console), 0o755); err != nil {
+				t.Fatalf("failed to create vault: %v", err)
+			}
+			if err := os.WriteFile(console, []byte("line1\nline2\npart"), 0o644); err != nil {
+				t.Fatalf("failed to write console: %v", err)
+			}
+			dbRepo := &fakeJobRepo{jobs: map[string]entity.Job{"20240101T000000": {TaskID: "20240101T000000", Status: tc.status}}}
+			b := &BackupDaemon{
+				storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+				dbRepo:      dbRepo,
+				logger:      zap.NewNop().Sugar(),
+			}
+
+			lines, err := b.StreamBackupConsole(context.Background(), "20240101T000000")
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// the backup appends and ends before the first line is taken
+			file, err := os.OpenFile(console, os.O_APPEND|os.O_WRONLY, 0o644)
+			if err != nil {
+				t.Fatalf("failed to open console: %v", err)
+			}
+			_, _ = file.WriteString("ial\nline3\n")
+			_ = file.Close()
+			dbRepo.jobs["20240101T000000"] = entity.Job{TaskID: "20240101T000000", Status: "Successful"}
+
+			var got []string
+			for line := range lines {
+				got = append(got, line)
+			}
+			if !slices.Equal(got, tc.expectedLines) {
+				t.Fatalf("expected lines %v, got %v", tc.expectedLines, got)
+			}
+		})
+	}
+}
+
+func TestExcludedRequestedDBs(t *testing.T) {
+	testCases := []struct {
+		name     string
+		request  entity.BackupRequest
+		expected []string
+	}{
+		{name: "full backup", request: entity.BackupRequest{ExcludeDBs: []string{"postgres"}}},
+		{
+			name:    "no overlap",
+			request: entity.BackupRequest{DBs: []entity.DBEntry{{SimpleName: "db1"}}, ExcludeDBs: []string{"postgres"}},
+		},
+		{
+			name: "overlap",
+			request: entity.BackupRequest{
+				DBs:        []entity.DBEntry{{SimpleName: "db1"}, {Object: map[string]entity.DBObject{"db2": {}}}},
+				ExcludeDBs: []string{"db2", "db1"},
+			},
+			expected: []string{"db1", "db2"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if excluded := excludedRequestedDBs(tc.request); !slices.Equal(excluded, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, excluded)
+			}
+		})
+	}
+}
+
+func TestRegisterUploadedBackup(t *testing.T) {
+	const backupID = "20250101T000000"
+	testCases := []struct {
+		name                string
+		backupID            string
+		existingVault       bool
+		uploaded            []string
+		expectedUploadError error
+		expectedError       error
+	}{
+		{name: "registered", backupID: backupID, uploaded: []string{"db1.dump"}},
+		{name: "nothing uploaded", backupID: backupID, expectedError: ErrBackupNotFound},
+		{name: "existing backup", backupID: backupID, existingVault: true, expectedUploadError: ErrBackupIDExists, expectedError: ErrBackupIDExists},
+		{name: "invalid id", backupID: "../" + backupID, expectedUploadError: ErrInvalidBackupID, expectedError: ErrInvalidBackupID},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			root := t.TempDir()
+			if tc.existingVault {
+				if err := os.MkdirAll(filepath.Join(root, backupID), 0o755); err != nil {
+					t.Fatalf("failed to create vault: %v", err)
+				}
+			}
+			prefix := strings.Trim(filepath.Join(root, backupID), "/") + "/"
+			s3Client := NewMockS3ClientRepository(ctrl)
+			keys := make([]string, 0, len(tc.uploaded))
+			for _, file := range tc.uploaded {
+				keys = append(keys, prefix+file)
+			}
+			s3Client.EXPECT().ListFiles(gomock.Any(), prefix).Return(keys, nil).AnyTimes()
+			s3Client.EXPECT().CreatePresignedPutUrl(gomock.Any(), prefix+"db1.dump", 60).Return("url", nil).AnyTimes()
+			jobRepo := &fakeJobRepo{jobs: map[string]entity.Job{}}
+			b := &BackupDaemon{
+				storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+				dbRepo:      jobRepo,
+				s3Client:    s3Client,
+				s3Enable:    true,
+				logger:      zap.NewNop().Sugar(),
+			}
+
+			upload, err := b.CreateBackupUploadURL(context.Background(), entity.BackupUploadURLRequest{BackupID: tc.backupID, Key: "/db1.dump", Expiration: 60})
+			if !errors.Is(err, tc.expectedUploadError) {
+				t.Fatalf("expected upload url error %v, got %v", tc.expectedUploadError, err)
+			}
+			if err == nil && (upload.URL != "url" || upload.Key != "db1.dump") {
+				t.Fatalf("unexpected upload url %+v", upload)
+			}
+
+			response, err := b.RegisterUploadedBackup(context.Background(), tc.backupID)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+			if err != nil {
+				if _, ok := jobRepo.jobs[backupID]; ok {
+					t.Fatalf("expected no job, got %+v", jobRepo.jobs[backupID])
+				}
+				return
+			}
+			if response.BackupID != backupID {
+				t.Fatalf("expected backup id %s, got %s", backupID, response.BackupID)
+			}
+			if job := jobRepo.jobs[backupID]; job.Status != "Successful" || job.Vault != backupID {
+				t.Fatalf("expected a successful job, got %+v", job)
+			}
+			vault := b.storageRepo.GetVault(backupID, false, "", "", false)
+			if !b.storageRepo.IsSuccessful(vault) {
+				t.Fatalf("expected a successful local vault, got %+v", vault)
+			}
+			if _, err := b.CreateBackupUploadURL(context.Background(), entity.BackupUploadURLRequest{BackupID: backupID, Key: "db2.dump"}); !errors.Is(err, ErrBackupIDExists) {
+				t.Fatalf("expected uploads into a registered backup to be refused, got %v", err)
+			}
+		})
+	}
+}
+
+func TestJobStatusResponseTimes(t *testing.T) {
+	testCases := []struct {
+		name               string
+		job                entity.Job
+		expectedStartedAt  string
+		expectedFinishedAt string
+	}{
+		{
+			name:              "running",
+			job:               entity.Job{Status: "Processing", CreatedAt: 1735689600, UpdatedAt: 1735689660},
+			expectedStartedAt: "2025-01-01T00:00:00Z",
+		},
+		{
+			name:               "finished",
+			job:                entity.Job{Status: "Successful", CreatedAt: 1735689600, UpdatedAt: 1735693200},
+			expectedStartedAt:  "2025-01-01T00:00:00Z",
+			expectedFinishedAt: "2025-01-01T01:00:00Z",
+		},
+		{
+			name:               "created before start times were recorded",
+			job:                entity.Job{Status: "Failed", UpdatedAt: 1735693200},
+			expectedFinishedAt: "2025-01-01T01:00:00Z",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response := jobStatusResponse(tc.job)
+			if response.StartedAt != tc.expectedStartedAt || response.FinishedAt != tc.expectedFinishedAt {
+				t.Fatalf("expected started %q and finished %q, got %q and %q",
+					tc.expectedStartedAt, tc.expectedFinishedAt, response.StartedAt, response.FinishedAt)
+			}
+		})
+	}
+}
+
+func TestRestoreNamespaceAllowList(t *testing.T) {
+	testCases := []struct {
+		name            string
+		allowed         []string
+		targetNamespace string
+		expectedError   error
+	}{
+		{name: "no allow list", targetNamespace: "prod"},
+		{name: "daemon namespace allowed", allowed: []string{"test", "staging"}},
+		{name: "target namespace allowed", allowed: []string{"test", "staging"}, targetNamespace: "staging"},
+		{name: "target namespace not allowed", allowed: []string{"test"}, targetNamespace: "prod", expectedError: ErrRestoreNamespaceNotAllowed},
+		{name: "daemon namespace not allowed", allowed: []string{"staging"}, expectedError: ErrRestoreNamespaceNotAllowed},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			const vaultName = "test_20240101T000000"
+			if err := os.MkdirAll(filepath.Join(root, vaultName), 0o755); err != nil {
+				t.Fatalf("failed to create vault: %v", err)
+			}
+			executor := &fakeExecutor{backupDBs: []string{"db_test"}}
+			jobRepo := &fakeJobRepo{jobs: map[string]entity.Job{}}
+			b := &BackupDaemon{
+				storageRepo:              repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+				dbRepo:                   jobRepo,
+				executor:                 executor,
+				logger:                   zap.NewNop().Sugar(),
+				namespace:                "test",
+				allowedRestoreNamespaces: tc.allowed,
+			}
+
+			_, err := b.RestoreBackup(context.Background(), entity.RestoreRequest{Vault: vaultName, Test: true, TargetNamespace: tc.targetNamespace})
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+			if err != nil && (len(jobRepo.jobs) > 0 || executor.testRestoreFolder != "") {
+				t.Fatalf("expected a refused restore to leave no job and restore nothing, got jobs %v", jobRepo.jobs)
+			}
+		})
+	}
+}
+
+func TestBaseBackupStartTS(t *testing.T) {
+	testCases := []struct {
+		name        string
+		request     entity.BackupRequest
+		expected    string
+		expectedErr error
+	}{
+		{name: "base backup", request: entity.BackupRequest{ProcType: INCREMENTAL, BaseBackupID: "20240101T000000"}, expected: "20240101T000000"},
+		{name: "external base backup", request: entity.BackupRequest{ProcType: INCREMENTAL, BaseBackupID: "20240101T000000", ExternalBackupPath: "external"}, expected: "20240101T000000"},
+		{name: "full backup", request: entity.BackupRequest{ProcType: FULL, BaseBackupID: "20240101T000000"}, expectedErr: ErrInvalidBaseBackup},
+		{name: "missing base", request: entity.BackupRequest{ProcType: INCREMENTAL, BaseBackupID: "20240103T000000"}, expectedErr: ErrBackupNotFound},
+		{name: "failed base", request: entity.BackupRequest{ProcType: INCREMENTAL, BaseBackupID: "20240102T000000"}, expectedErr: ErrBackupNotSuccessful},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			for name, metrics := range map[string]string{"20240101T000000": `{}`, "20240102T000000": `{"exit_code":1}`} {
+				if err := os.MkdirAll(filepath.Join(root, name), 0o755); err != nil {
+					t.Fatalf("failed to create vault: %v", err)
+				}
+				if err := os.WriteFile(filepath.Join(root, name, ".metrics"), []byte(metrics), 0o644); err != nil {
+					t.Fatalf("failed to write metrics: %v", err)
+				}
+			}
+			storageRepo := repo.NewStorageRepo(root, "", "", false, false, nil, nil)
+			b := &BackupDaemon{
+				storageRepo: storageRepo,
+				dbRepo:      &fakeJobRepo{jobs: map[string]entity.Job{}},
+				executor:    &fakeExecutor{},
+				logger:      zap.NewNop().Sugar(),
+			}
+
+			startTS, err := b.baseBackupStartTS(context.Background(), tc.request)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if tc.expectedErr != nil {
+				return
+			}
+			expected := tc.expected
+			if tc.request.ExternalBackupPath == "" {
+				expected = strconv.Itoa(int(storageRepo.GetVault(tc.expected, false, "", "", false).TimeStamp))
+			}
+			if startTS != expected {
+				t.Fatalf("expected start_ts %s, got %s", expected, startTS)
+			}
+		})
+	}
+}
+
+func TestBackupFilesRejectTraversal(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "storage")
+	if err := os.MkdirAll(filepath.Join(root, "20240101T000000"), 0o755); err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "..", "secret"), []byte("secret"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	b := &BackupDaemon{
+		storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+		logger:      zap.NewNop().Sugar(),
+	}
+
+	for _, backupID := range []string{"..", ".", "../storage", `..\storage`} {
+		if _, err := b.ListBackupFiles(context.Background(), backupID); !errors.Is(err, ErrInvalidBackupID) {
+			t.Fatalf("expected error %v listing %s, got %v", ErrInvalidBackupID, backupID, err)
+		}
+		_, err := b.GetBackupFile(context.Background(), entity.BackupFileRequest{BackupID: backupID, Path: "secret"})
+		if !errors.Is(err, ErrInvalidBackupID) {
+			t.Fatalf("expected error %v reading %s, got %v", ErrInvalidBackupID, backupID, err)
+		}
+	}
+}
@@ -1,21 +1,31 @@
 package controller
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/fs"
+	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
 	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/repo"
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/util"
 	"github.com/google/shlex"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 )
 
 var ErrCommandEmpty = errors.New("command is empty")
@@ -23,12 +33,94 @@ var ErrFailedToCreateLogFile = errors.New("failed to create log file")
 var ErrProcessCmdFailed = errors.New("process cmd failed")
 var ErrExecuteCmdFailed = errors.New("execute cmd failed")
 var ErrFailedToCloseLogFile = errors.New("failed to close log file")
+var ErrInvalidCustomVars = errors.New("invalid custom vars")
+
+// formats of the .custom_vars file of a vault
+const CustomVarsJSON = "json"
+const CustomVarsEnv = "env"
+
+// redactedValue is logged instead of the value of a sensitive custom var
+const redactedValue = "***"
+
+// envNamePattern matches the names a shell accepts as variables
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+var ErrUndefinedTemplateVar = errors.New("command template references undefined variable")
+var ErrBackupOutputFailed = errors.New("backup output does not meet the success criteria")
+var ErrBackupWatchTimeout = errors.New("backup completion marker did not appear in time")
+var ErrBackupWatchCanceled = errors.New("backup was canceled while waiting for its completion marker")
+var ErrDBRestoreFailed = errors.New("restore of databases failed")
+
+// DBRestoreError lists the databases a restore run per database failed on, by backed up name,
+// the other databases were restored.
+type DBRestoreError struct {
+	Failed map[string]error
+}
+
+func (e *DBRestoreError) Error() string {
+	names := make([]string, 0, len(e.Failed))
+	for name := range e.Failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s: %v", name, e.Failed[name]))
+	}
+	return fmt.Sprintf("%v %v: %s", ErrDBRestoreFailed, names, strings.Join(parts, "; "))
+}
+
+func (e *DBRestoreError) Unwrap() []error {
+	errs := []error{ErrDBRestoreFailed}
+	for _, err := range e.Failed {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// backup modes, a watched backup is written by a tool outside the daemon instead of the backup command
+const BackupModeCommand = "command"
+const BackupModeWatch = "watch"
+
+// watchPollInterval is how often a watched vault is checked for the completion marker
+var watchPollInterval = time.Second
+
+var missingKeyMatcher = regexp.MustCompile(`map has no entry for key "([^"]*)"`)
+
+// PlaceholderCmd is the default command template, it only lists the vault and backs up nothing.
+const PlaceholderCmd = "ls -la {{.data_folder}}"
 
 type CommandExecutor interface {
 	ExecuteEvictCmd(vaultFolder string) error
-	PerformBackup(vault entity.Vault, dbs []entity.DBEntry, customVars map[string]string) error
+	PerformBackup(vault entity.Vault, dbs []entity.DBEntry, excludeDbs []string, customVars map[string]string, cmdOverride string) error
 	PerformRestore(vaultFolder string, dbs []entity.DBEntry, dbmap map[string]string, customVariables map[string]string, external bool, taskID string) error
+	PerformTestRestore(vaultFolder string, dbs []entity.DBEntry, dbmap map[string]string, customVariables map[string]string, taskID string) error
 	GetBackupDBs(vaultFolder string) ([]string, error)
+	DiscoverDBs(customVars map[string]string) ([]string, error)
+	RedactCustomVars(customVars map[string]string) map[string]string
+	CancelWatch(backupID string) bool
+}
+
+// CommandRetries re-run a backup or restore command that exits non-zero up to Backup or Restore more
+// times, Delay apart. A command killed by a signal is not retried.
+type CommandRetries struct {
+	Backup  int
+	Restore int
+	Delay   time.Duration
+}
+
+// BackupOutputCriteria judge a backup by its console output besides the exit code, a backup fails when a
+// line matches Failure or, with Success set, no line matches Success. Nil disables a check.
+type BackupOutputCriteria struct {
+	Success *regexp.Regexp
+	Failure *regexp.Regexp
+}
+
+// BackupWatch makes PerformBackup wait, up to Timeout, for a tool outside the daemon such as a sidecar
+// to write the vault instead of running the backup command. The tool creates Marker in the vault once done.
+type BackupWatch struct {
+	Enabled bool
+	Marker  string
+	Timeout time.Duration
 }
 
 type Executor struct {
@@ -37,31 +129,123 @@ type Executor struct {
 	restoreCmdTemplate string
 	dbListCmdTemplate  string
 	customVars         []string
+	customVarsFormat   string
 	databasesKey       string
 	dbmapKey           string
+	excludeDbsKey      string
 	logger             *zap.SugaredLogger
+
+	// discoverDbsCmdTemplate lists the databases of the live source, one per line
+	discoverDbsCmdTemplate string
+	// sensitiveCustomVars redact in logs the custom vars whose lower case name contains one of them
+	sensitiveCustomVars []string
+	// streamCommandLogs also logs backup and restore command output line by line at debug level
+	streamCommandLogs bool
+	// testRestoreCmdTemplate restores a copy of a vault into a test instance to verify the backup
+	testRestoreCmdTemplate string
+	retries                CommandRetries
+	outputCriteria         BackupOutputCriteria
+	watch                  BackupWatch
+	// restoreDBConcurrency above 1 runs the restore command once per requested database, that many at a time
+	restoreDBConcurrency int
+
+	// watchCancels stop the watched backups waiting for their completion marker, by backup id
+	watchMu      sync.Mutex
+	watchCancels map[string]chan struct{}
 }
 
 func NewExecutor(evictCmdTemplate string, backupCmdTemplate string, restoreCmdTemplate string,
-	dbListCmdTemplate string, customVars []string, databasesKey string, dbmapKey string,
-	logger *zap.SugaredLogger) CommandExecutor {
+	dbListCmdTemplate string, discoverDbsCmdTemplate string, testRestoreCmdTemplate string, customVars []string, customVarsFormat string,
+	sensitiveCustomVars []string, databasesKey string, dbmapKey string, excludeDbsKey string, streamCommandLogs bool, retries CommandRetries, outputCriteria BackupOutputCriteria,
+	watch BackupWatch, restoreDBConcurrency int, logger *zap.SugaredLogger) CommandExecutor {
 	return &Executor{
 		evictCmdTemplate:   evictCmdTemplate,
 		backupCmdTemplate:  backupCmdTemplate,
 		restoreCmdTemplate: restoreCmdTemplate,
 		dbListCmdTemplate:  dbListCmdTemplate,
 		customVars:         customVars,
+		customVarsFormat:   customVarsFormat,
 		databasesKey:       databasesKey,
 		dbmapKey:           dbmapKey,
+		excludeDbsKey:      excludeDbsKey,
 		logger:             logger,
+
+		discoverDbsCmdTemplate: discoverDbsCmdTemplate,
+		sensitiveCustomVars:    sensitiveCustomVars,
+		streamCommandLogs:      streamCommandLogs,
+		testRestoreCmdTemplate: testRestoreCmdTemplate,
+		retries:                retries,
+		outputCriteria:         outputCriteria,
+		watch:                  watch,
+		restoreDBConcurrency:   restoreDBConcurrency,
+	}
+}
+
+// UnconfiguredCommands returns the names of the backup, restore and dblist commands
+// that are empty or still set to PlaceholderCmd.
+func UnconfiguredCommands(backupCmd string, restoreCmd string, dbListCmd string) []string {
+	var names []string
+	for _, c := range []struct {
+		name string
+		cmd  string
+	}{
+		{name: "backup", cmd: backupCmd},
+		{name: "restore", cmd: restoreCmd},
+		{name: "dblist", cmd: dbListCmd},
+	} {
+		cmd := strings.TrimSpace(c.cmd)
+		if cmd == "" || cmd == PlaceholderCmd {
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
+
+// CommandTemplate returns cmd, or the template read from file while cmd is empty or left at
+// PlaceholderCmd. Lines of the file ending with a backslash continue on the next one.
+func CommandTemplate(cmd string, file string) (string, error) {
+	if file == "" {
+		return cmd, nil
+	}
+	if trimmed := strings.TrimSpace(cmd); trimmed != "" && trimmed != PlaceholderCmd {
+		return cmd, nil
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read command template %s err: %w", file, err)
+	}
+	tmpl := strings.TrimSpace(strings.ReplaceAll(string(content), "\\\n", " "))
+	if tmpl == "" {
+		return "", fmt.Errorf("%w: command template %s is empty", ErrCommandEmpty, file)
+	}
+	return tmpl, nil
+}
+
+// CheckTool runs the tool health check command, e.g. "pg_dump --version",
+// and returns an error with the command output when it does not succeed in time.
+func CheckTool(command string, timeout time.Duration) error {
+	args, err := shlex.Split(command)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProcessCmdFailed, err)
+	}
+	if len(args) == 0 {
+		return ErrCommandEmpty
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %v, output: %s", ErrExecuteCmdFailed, err, strings.TrimSpace(string(output)))
 	}
+	return nil
 }
 
 func (e *Executor) ExecuteEvictCmd(vaultFolder string) error {
 	if len(e.evictCmdTemplate) == 0 {
 		return fmt.Errorf("evict cmd template is empty")
 	}
-	cmdProcessed, err := e.processCmd(e.evictCmdTemplate, vaultFolder, nil, nil, nil)
+	cmdProcessed, err := e.processCmd(e.evictCmdTemplate, vaultFolder, nil, nil, nil, nil)
 	if err != nil {
 		return fmt.Errorf("%w: %v", ErrProcessCmdFailed, err)
 	}
@@ -81,34 +265,48 @@ func (e *Executor) ExecuteTerminationCmd() {
 
 }
 
-func (e *Executor) PerformBackup(vault entity.Vault, dbs []entity.DBEntry, customVars map[string]string) (err error) {
+// PerformBackup runs the backup command into the vault, cmdOverride replaces the configured
+// template when it is not empty.
+func (e *Executor) PerformBackup(vault entity.Vault, dbs []entity.DBEntry, excludeDbs []string, customVars map[string]string, cmdOverride string) (err error) {
 	start := time.Now()
-	e.logger.Info("Starting backup", zap.String("vault", vault.Folder), zap.Int("db_count", len(dbs)), zap.Any("custom_vars", customVars))
+	e.logger.Info("Starting backup", zap.String("vault", vault.Folder), zap.Int("db_count", len(dbs)))
+	e.logger.Debug("Backup custom vars", zap.Any("custom_vars", e.RedactCustomVars(customVars)))
 	if err := os.MkdirAll(vault.Folder, 0o755); err != nil {
 		return fmt.Errorf("%w: vault=%s err=%v", ErrFailedToCreateLogFile, vault.Folder, err)
 	}
 
+	metricsPath := vault.MetricsFilePath
+	if strings.TrimSpace(metricsPath) == "" {
+		metricsPath = filepath.Join(vault.Folder, ".metrics")
+	}
+	// a backup killed before the deferred metrics below leaves the vault marked incomplete
+	if b, mErr := json.Marshal(map[string]any{repo.MetricsComplete: false}); mErr == nil {
+		_ = os.WriteFile(metricsPath, b, 0o644)
+	}
+
 	customVarsPath := vault.CustomVarsFilePath
 	if strings.TrimSpace(customVarsPath) == "" {
 		customVarsPath = filepath.Join(vault.Folder, ".custom_vars")
 	}
 	if len(customVars) > 0 {
-		if b, mErr := json.Marshal(customVars); mErr == nil {
+		if b, mErr := marshalCustomVars(customVars, e.customVarsFormat); mErr == nil {
 			_ = os.WriteFile(customVarsPath, b, 0o644)
+		} else {
+			e.logger.Warn("Failed to write custom vars", zap.String("vault", vault.Folder), zap.Error(mErr))
 		}
 	}
 
+	attempts := 0
 	defer func() {
-		metricsPath := vault.MetricsFilePath
-		if strings.TrimSpace(metricsPath) == "" {
-			metricsPath = filepath.Join(vault.Folder, ".metrics")
-		}
-
-		sizeBytes, _ := dirSize(vault.Folder)
+		sizeBytes, _ := util.DirSize(vault.Folder)
 
 		m := map[string]any{
-			"spent_time": int64(time.Since(start) / time.Millisecond),
-			"size":       sizeBytes,
+			"spent_time":         int64(time.Since(start) / time.Millisecond),
+			"size":               sizeBytes,
+			repo.MetricsComplete: true,
+		}
+		if attempts > 0 {
+			m["attempts"] = attempts
 		}
 		if err != nil {
 			m["exception"] = err.Error()
@@ -119,7 +317,25 @@ func (e *Executor) PerformBackup(vault entity.Vault, dbs []entity.DBEntry, custo
 		}
 	}()
 
-	cmdProcessed, err := e.processCmd(e.backupCmdTemplate, vault.Folder, dbs, nil, customVars)
+	if e.watch.Enabled {
+		if cmdOverride != "" {
+			e.logger.Warn("Backup command override is ignored in watch mode", zap.String("vault", vault.Folder))
+		}
+		if err := e.waitForBackupMarker(vault.Folder); err != nil {
+			return err
+		}
+		if err := writeManifest(vault, metricsPath, dbs, excludeDbs, e.RedactCustomVars(customVars)); err != nil {
+			return err
+		}
+		e.logger.Info("Watched backup finished successfully", zap.String("vault", vault.Folder))
+		return nil
+	}
+
+	cmdTemplate := e.backupCmdTemplate
+	if cmdOverride != "" {
+		cmdTemplate = cmdOverride
+	}
+	cmdProcessed, err := e.processCmd(cmdTemplate, vault.Folder, dbs, nil, excludeDbs, customVars)
 	if err != nil {
 		return fmt.Errorf("%w: vault=%s err=%v", ErrProcessCmdFailed, vault.Folder, err)
 	}
@@ -128,75 +344,333 @@ func (e *Executor) PerformBackup(vault entity.Vault, dbs []entity.DBEntry, custo
 	}
 	logFilePath := vault.Folder + "/.console"
 
-	logFile, err := os.Create(logFilePath)
+	e.logger.Info("Executing backup command", zap.String("log_file", logFilePath))
+	e.logger.Debug("Backup command", zap.Strings("cmd", e.redactCmd(cmdProcessed, customVars)))
+	attempts, err = e.runCommand(cmdProcessed, logFilePath, e.retries.Backup, "vault", vault.Folder)
 	if err != nil {
-		return fmt.Errorf("%w: vault=%s path=%s err=%v",
-			ErrFailedToCreateLogFile, vault.Folder, logFilePath, err)
-	}
-	defer func() {
-		errFile := logFile.Close()
-		if errFile != nil && err == nil {
-			err = fmt.Errorf("%w: vault=%s path=%s err=%v",
-				ErrFailedToCloseLogFile, vault.Folder, logFilePath, errFile)
+		if errors.Is(err, ErrFailedToCreateLogFile) || errors.Is(err, ErrFailedToCloseLogFile) {
+			return fmt.Errorf("vault=%s: %w", vault.Folder, err)
 		}
-	}()
-
-	e.logger.Info("Executing backup command", zap.Strings("cmd", cmdProcessed), zap.String("log_file", logFilePath))
-	cmd := exec.Command(cmdProcessed[0], cmdProcessed[1:]...)
-	cmd.Stdout = logFile
-	cmd.Stderr = logFile
-
-	if err = cmd.Run(); err != nil {
-		return fmt.Errorf("%w: vault=%s cmd=%q err=%v", ErrExecuteCmdFailed, vault.Folder, strings.Join(cmdProcessed, " "), err)
+		return fmt.Errorf("%w: vault=%s cmd=%q attempts=%d err=%v", ErrExecuteCmdFailed, vault.Folder,
+			strings.Join(e.redactCmd(cmdProcessed, customVars), " "), attempts, err)
+	}
+	if err := e.checkBackupOutput(logFilePath); err != nil {
+		return err
+	}
+	if err := writeManifest(vault, metricsPath, dbs, excludeDbs, e.RedactCustomVars(customVars)); err != nil {
+		return err
 	}
 	e.logger.Info("Backup finished successfully", zap.String("vault", vault.Folder))
 	return nil
 }
 
 func (e *Executor) PerformRestore(vaultFolder string, dbs []entity.DBEntry,
-	dbmap map[string]string, customVariables map[string]string, external bool, taskID string) (err error) {
-	cmdProcessed, err := e.processCmd(e.restoreCmdTemplate, vaultFolder, dbs, dbmap, customVariables)
+	dbmap map[string]string, customVariables map[string]string, external bool, taskID string) error {
+	return e.runRestore(e.restoreCmdTemplate, vaultFolder, dbs, dbmap, customVariables, external, taskID)
+}
+
+// PerformTestRestore runs the test restore command against a copy of a vault, the production
+// restore command is never used.
+func (e *Executor) PerformTestRestore(vaultFolder string, dbs []entity.DBEntry,
+	dbmap map[string]string, customVariables map[string]string, taskID string) error {
+	if strings.TrimSpace(e.testRestoreCmdTemplate) == "" {
+		return fmt.Errorf("%w: test restore command is not configured", ErrCommandEmpty)
+	}
+	return e.runRestore(e.testRestoreCmdTemplate, vaultFolder, dbs, dbmap, customVariables, false, taskID)
+}
+
+// runRestore restores dbs with one command, or with one command per database when restoreDBConcurrency
+// allows several at a time. A restore without dbs restores the whole backup and always runs one command.
+func (e *Executor) runRestore(cmdTemplate string, vaultFolder string, dbs []entity.DBEntry,
+	dbmap map[string]string, customVariables map[string]string, external bool, taskID string) error {
+	if entries := splitDBEntries(dbs); e.restoreDBConcurrency > 1 && len(entries) > 1 {
+		return e.runRestorePerDB(cmdTemplate, vaultFolder, entries, dbmap, customVariables, external, taskID)
+	}
+	return e.runRestoreCmd(cmdTemplate, vaultFolder, dbs, dbmap, customVariables, external, taskID, taskID)
+}
+
+// runRestorePerDB runs the restore command for every entry, up to restoreDBConcurrency at a time, each
+// logging into its own <logName>_<db>.log. A DBRestoreError lists the databases that failed.
+func (e *Executor) runRestorePerDB(cmdTemplate string, vaultFolder string, entries []entity.DBEntry,
+	dbmap map[string]string, customVariables map[string]string, external bool, taskID string) error {
+	e.logger.Info("restoring databases separately", zap.String("task_id", taskID), zap.Int("db_count", len(entries)),
+		zap.Int("concurrency", e.restoreDBConcurrency))
+	var mu sync.Mutex
+	failed := make(map[string]error)
+	var g errgroup.Group
+	g.SetLimit(e.restoreDBConcurrency)
+	for _, entry := range entries {
+		name := dbEntryName(entry)
+		var entryMap map[string]string
+		if newName, ok := dbmap[name]; ok {
+			entryMap = map[string]string{name: newName}
+		}
+		logName := taskID + "_" + strings.ReplaceAll(name, string(filepath.Separator), "_")
+		g.Go(func() error {
+			if err := e.runRestoreCmd(cmdTemplate, vaultFolder, []entity.DBEntry{entry}, entryMap, customVariables, external, taskID, logName); err != nil {
+				e.logger.Error("database restore failed", zap.String("task_id", taskID), zap.String("db", name), zap.Error(err))
+				mu.Lock()
+				failed[name] = err
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+	if len(failed) > 0 {
+		return &DBRestoreError{Failed: failed}
+	}
+	return nil
+}
+
+// splitDBEntries returns an entry per database of dbs, an object entry naming several databases is split.
+func splitDBEntries(dbs []entity.DBEntry) []entity.DBEntry {
+	var entries []entity.DBEntry
+	for _, d := range dbs {
+		if d.SimpleName != "" {
+			entries = append(entries, d)
+		}
+		for name, object := range d.Object {
+			entries = append(entries, entity.DBEntry{Object: map[string]entity.DBObject{name: object}})
+		}
+	}
+	return entries
+}
+
+// dbEntryName returns the database of an entry splitDBEntries returned.
+func dbEntryName(d entity.DBEntry) string {
+	for name := range d.Object {
+		return name
+	}
+	return d.SimpleName
+}
+
+func (e *Executor) runRestoreCmd(cmdTemplate string, vaultFolder string, dbs []entity.DBEntry,
+	dbmap map[string]string, customVariables map[string]string, external bool, taskID string, logName string) (err error) {
+	cmdProcessed, err := e.processCmd(cmdTemplate, vaultFolder, dbs, dbmap, nil, customVariables)
 	if err != nil {
 		return fmt.Errorf("%w: process restore command for vault=%s task=%s: %v", ErrProcessCmdFailed, vaultFolder, taskID, err)
 	}
 	if len(cmdProcessed) == 0 {
 		return fmt.Errorf("%w: restore command empty for vault=%s task=%s", ErrCommandEmpty, vaultFolder, taskID)
 	}
-	logFilePath := fmt.Sprintf("%s/restore_%s.log", vaultFolder, taskID)
+	logFilePath := fmt.Sprintf("%s/restore_%s.log", vaultFolder, logName)
 	if !external {
 		logsDir := fmt.Sprintf("%s/restore_logs", vaultFolder)
 		if err = os.MkdirAll(logsDir, os.ModePerm); err != nil {
 			return fmt.Errorf("%w: create restore logs directory=%s for task=%s: %v", ErrFailedToCreateLogFile, logsDir, taskID, err)
 		}
-		logFilePath = fmt.Sprintf("%s/%s.log", logsDir, taskID)
+		logFilePath = fmt.Sprintf("%s/%s.log", logsDir, logName)
 	}
+	e.logger.Info("starting restore command", zap.String("task_id", taskID))
+	e.logger.Debug("restore command", zap.Strings("command", e.redactCmd(cmdProcessed, customVariables)), zap.String("task_id", taskID))
+	attempts, err := e.runCommand(cmdProcessed, logFilePath, e.retries.Restore, "task_id", taskID)
+	if err != nil {
+		if errors.Is(err, ErrFailedToCreateLogFile) || errors.Is(err, ErrFailedToCloseLogFile) {
+			return fmt.Errorf("restore task=%s: %w", taskID, err)
+		}
+		return fmt.Errorf("%w: execute restore command for task=%s cmd=%v attempts=%d: %v", ErrExecuteCmdFailed, taskID,
+			e.redactCmd(cmdProcessed, customVariables), attempts, err)
+	}
+	e.logger.Info("restore command executed successfully", zap.String("task_id", taskID), zap.String("log_path", logFilePath),
+		zap.Int("attempts", attempts))
+	return nil
+}
+
+// CancelWatch stops the watched backup backupID waiting for its completion marker, false when none waits.
+func (e *Executor) CancelWatch(backupID string) bool {
+	e.watchMu.Lock()
+	defer e.watchMu.Unlock()
+	canceled, ok := e.watchCancels[backupID]
+	if ok {
+		close(canceled)
+		delete(e.watchCancels, backupID)
+	}
+	return ok
+}
+
+// trackWatch lets CancelWatch stop the wait of backupID until untrack is called.
+func (e *Executor) trackWatch(backupID string) (<-chan struct{}, func()) {
+	canceled := make(chan struct{})
+	e.watchMu.Lock()
+	defer e.watchMu.Unlock()
+	if e.watchCancels == nil {
+		e.watchCancels = map[string]chan struct{}{}
+	}
+	e.watchCancels[backupID] = canceled
+	return canceled, func() {
+		e.watchMu.Lock()
+		if e.watchCancels[backupID] == canceled {
+			delete(e.watchCancels, backupID)
+		}
+		e.watchMu.Unlock()
+	}
+}
+
+// waitForBackupMarker waits until the watched backup tool created the completion marker in folder,
+// CancelWatch ends the wait early.
+func (e *Executor) waitForBackupMarker(folder string) error {
+	marker := filepath.Join(folder, e.watch.Marker)
+	e.logger.Info("Waiting for backup completion marker", zap.String("marker", marker), zap.Duration("timeout", e.watch.Timeout))
+	canceled, untrack := e.trackWatch(filepath.Base(folder))
+	defer untrack()
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	deadline := time.Now().Add(e.watch.Timeout)
+	for {
+		_, err := os.Stat(marker)
+		if err == nil {
+			return nil
+		}
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to check backup completion marker %s err: %w", marker, err)
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("%w: %s after %s", ErrBackupWatchTimeout, marker, e.watch.Timeout)
+		}
+		select {
+		case <-canceled:
+			return fmt.Errorf("%w: %s", ErrBackupWatchCanceled, marker)
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkBackupOutput applies the output criteria to the backup console at logFilePath.
+func (e *Executor) checkBackupOutput(logFilePath string) error {
+	if e.outputCriteria.Success == nil && e.outputCriteria.Failure == nil {
+		return nil
+	}
+	file, err := os.Open(logFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to read backup console %s err: %w", logFilePath, err)
+	}
+	defer file.Close()
+	succeeded := e.outputCriteria.Success == nil
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if e.outputCriteria.Failure != nil && e.outputCriteria.Failure.MatchString(line) {
+			return fmt.Errorf("%w: %q matches the failure pattern", ErrBackupOutputFailed, line)
+		}
+		if !succeeded && e.outputCriteria.Success.MatchString(line) {
+			succeeded = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read backup console %s err: %w", logFilePath, err)
+	}
+	if !succeeded {
+		return fmt.Errorf("%w: no line matches the success pattern", ErrBackupOutputFailed)
+	}
+	return nil
+}
+
+// runCommand runs cmdArgs with its output in a new log file at logFilePath. A command exiting non-zero is
+// run up to retries more times after the retry delay, the log of a failed attempt is kept as <logFilePath>.<attempt>.
+// It returns the number of attempts made.
+func (e *Executor) runCommand(cmdArgs []string, logFilePath string, retries int, keysAndValues ...interface{}) (int, error) {
+	for attempt := 1; ; attempt++ {
+		err := e.runAttempt(cmdArgs, logFilePath, keysAndValues...)
+		var exitErr *exec.ExitError
+		// a killed command has no exit code, it was canceled or timed out rather than failed
+		if err == nil || attempt > retries || !errors.As(err, &exitErr) || exitErr.ExitCode() <= 0 {
+			return attempt, err
+		}
+		if errRename := os.Rename(logFilePath, fmt.Sprintf("%s.%d", logFilePath, attempt)); errRename != nil {
+			e.logger.Warnf("failed to keep the log of attempt %d err: %v", attempt, errRename)
+		}
+		e.logger.Warnw("Command failed, retrying",
+			append([]interface{}{"attempt", attempt, "retries", retries, "delay", e.retries.Delay.String(), "err", err}, keysAndValues...)...)
+		time.Sleep(e.retries.Delay)
+	}
+}
+
+func (e *Executor) runAttempt(cmdArgs []string, logFilePath string, keysAndValues ...interface{}) (err error) {
 	logFile, err := os.Create(logFilePath)
 	if err != nil {
-		return fmt.Errorf("%w: create restore log file=%s for task=%s: %v", ErrFailedToCreateLogFile, logFilePath, taskID, err)
+		return fmt.Errorf("%w: path=%s err=%v", ErrFailedToCreateLogFile, logFilePath, err)
 	}
 	defer func() {
 		errFile := logFile.Close()
 		if errFile != nil && err == nil {
-			err = fmt.Errorf("%w: close restore log file=%s for task=%s: %v", ErrFailedToCloseLogFile, logFilePath, taskID, errFile)
+			err = fmt.Errorf("%w: path=%s err=%v", ErrFailedToCloseLogFile, logFilePath, errFile)
 		}
 	}()
-	e.logger.Info("starting restore command", zap.Strings("command", cmdProcessed), zap.String("task_id", taskID))
-	cmd := exec.Command(cmdProcessed[0], cmdProcessed[1:]...)
-	cmd.Stdout = logFile
-	cmd.Stderr = logFile
-	if err = cmd.Run(); err != nil {
-		return fmt.Errorf("%w: execute restore command for task=%s cmd=%v: %v", ErrExecuteCmdFailed, taskID, cmdProcessed, err)
+	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
+	output, flush := e.commandOutput(logFile, keysAndValues...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	err = cmd.Run()
+	flush()
+	return err
+}
+
+// commandOutput returns the writer for command output, the log file alone or, when streaming
+// is enabled, the log file and the logger. flush logs a last line not ended by a newline.
+func (e *Executor) commandOutput(logFile *os.File, keysAndValues ...interface{}) (io.Writer, func()) {
+	if !e.streamCommandLogs {
+		return logFile, func() {}
 	}
-	e.logger.Info("restore command executed successfully", zap.String("task_id", taskID),
-		zap.Strings("command", cmdProcessed), zap.String("log_path", logFilePath))
-	return nil
+	lines := &lineLogger{logger: e.logger, keysAndValues: keysAndValues}
+	return io.MultiWriter(logFile, lines), lines.flush
+}
+
+// lineLogger logs every line written to it as a debug entry.
+type lineLogger struct {
+	logger        *zap.SugaredLogger
+	keysAndValues []interface{}
+	buf           []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.log(l.buf[:i])
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (l *lineLogger) flush() {
+	if len(l.buf) > 0 {
+		l.log(l.buf)
+		l.buf = nil
+	}
+}
+
+func (l *lineLogger) log(line []byte) {
+	l.logger.Debugw(strings.TrimRight(string(line), "\r"), l.keysAndValues...)
 }
 
 func (e *Executor) GetBackupDBs(vaultFolder string) ([]string, error) {
-	cmdProcessed, err := e.processCmd(e.dbListCmdTemplate, vaultFolder, nil, nil, nil)
+	cmdProcessed, err := e.processCmd(e.dbListCmdTemplate, vaultFolder, nil, nil, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrProcessCmdFailed, err)
 	}
+	return runListCmd(cmdProcessed, cmdProcessed)
+}
+
+// DiscoverDBs lists the databases of the live source with the discover dbs command.
+func (e *Executor) DiscoverDBs(customVars map[string]string) ([]string, error) {
+	if strings.TrimSpace(e.discoverDbsCmdTemplate) == "" {
+		return nil, fmt.Errorf("%w: discover dbs command is not configured", ErrCommandEmpty)
+	}
+	cmdProcessed, err := e.processCmd(e.discoverDbsCmdTemplate, "", nil, nil, nil, customVars)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProcessCmdFailed, err)
+	}
+	return runListCmd(cmdProcessed, e.redactCmd(cmdProcessed, customVars))
+}
+
+// runListCmd runs the command and returns the non empty lines of its output, errors show the
+// command as logged.
+func runListCmd(cmdProcessed []string, logged []string) ([]string, error) {
 	if len(cmdProcessed) == 0 {
 		return nil, ErrCommandEmpty
 	}
@@ -205,10 +679,10 @@ func (e *Executor) GetBackupDBs(vaultFolder string) ([]string, error) {
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	err = cmd.Run()
+	err := cmd.Run()
 	if err != nil {
 		return nil, fmt.Errorf("%w: cmd=%v stderr=%s err=%v",
-			ErrExecuteCmdFailed, cmdProcessed, strings.TrimSpace(stderr.String()), err)
+			ErrExecuteCmdFailed, logged, strings.TrimSpace(stderr.String()), err)
 	}
 
 	lines := strings.Split(stdout.String(), "\n")
@@ -223,14 +697,15 @@ func (e *Executor) GetBackupDBs(vaultFolder string) ([]string, error) {
 }
 
 func (e *Executor) processCmd(cmdTemplate string, vaultFolder string, dbs []entity.DBEntry,
-	dbmap map[string]string, customVariables map[string]string) ([]string, error) {
-	e.logger.Info("Processing command template", zap.String("template", cmdTemplate), zap.String("vault_folder", vaultFolder),
-		zap.Int("db_count", len(dbs)), zap.Any("custom_vars", customVariables))
+	dbmap map[string]string, excludeDbs []string, customVariables map[string]string) ([]string, error) {
+	e.logger.Debug("Processing command template", zap.String("template", cmdTemplate), zap.String("vault_folder", vaultFolder),
+		zap.Int("db_count", len(dbs)), zap.Any("custom_vars", e.RedactCustomVars(customVariables)))
 
 	cmdOptions := map[string]string{
 		"data_folder": vaultFolder,
 		"dbs":         "",
 		"dbmap":       "",
+		"exclude_dbs": "",
 	}
 	for _, customVar := range e.customVars {
 		if val, ok := customVariables[customVar]; ok && val != "" {
@@ -265,13 +740,25 @@ func (e *Executor) processCmd(cmdTemplate string, vaultFolder string, dbs []enti
 		}
 		cmdOptions["dbmap"] = fmt.Sprintf("%s '%s'", e.dbmapKey, string(dbmapJSON))
 	}
-	tmpl, err := template.New("cmd").Parse(cmdTemplate)
+
+	if len(excludeDbs) > 0 {
+		excludeJSON, err := json.Marshal(excludeDbs)
+		if err != nil {
+			return nil, fmt.Errorf("marshal exclude dbs: %w", err)
+		}
+		cmdOptions["exclude_dbs"] = fmt.Sprintf("%s '%s'", e.excludeDbsKey, string(excludeJSON))
+	}
+	tmpl, err := template.New("cmd").Option("missingkey=error").Parse(cmdTemplate)
 	if err != nil {
 		return nil, fmt.Errorf("parse template: %w", err)
 	}
 
 	var sb strings.Builder
 	if err := tmpl.Execute(&sb, cmdOptions); err != nil {
+		if m := missingKeyMatcher.FindStringSubmatch(err.Error()); m != nil {
+			return nil, fmt.Errorf("%w %q in %q: available variables are data_folder, dbs, dbmap, exclude_dbs and configured custom vars",
+				ErrUndefinedTemplateVar, m[1], cmdTemplate)
+		}
 		return nil, fmt.Errorf("execute template: %w", err)
 	}
 	cmdProcessed, err := shlex.Split(sb.String())
@@ -279,25 +766,151 @@ func (e *Executor) processCmd(cmdTemplate string, vaultFolder string, dbs []enti
 		return nil, fmt.Errorf("failed to parse command: %w", err)
 	}
 
-	e.logger.Info("Processed command", zap.Strings("cmd", cmdProcessed))
+	e.logger.Debug("Processed command", zap.Strings("cmd", e.redactCmd(cmdProcessed, customVariables)))
 	return cmdProcessed, nil
 }
 
-func dirSize(root string) (int64, error) {
-	var size int64
-	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+func (e *Executor) isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range e.sensitiveCustomVars {
+		if s != "" && strings.Contains(name, strings.ToLower(s)) {
+			return true
 		}
-		if d.IsDir() {
-			return nil
+	}
+	return false
+}
+
+// RedactCustomVars returns a copy of customVars for logs and manifests with the values of sensitive vars redacted.
+func (e *Executor) RedactCustomVars(customVars map[string]string) map[string]string {
+	if len(customVars) == 0 {
+		return customVars
+	}
+	redacted := make(map[string]string, len(customVars))
+	for k, v := range customVars {
+		if e.isSensitive(k) {
+			v = redactedValue
 		}
-		info, err := d.Info()
-		if err != nil {
-			return err
+		redacted[k] = v
+	}
+	return redacted
+}
+
+// redactCmd returns a copy of a processed command for logs and errors with the values of sensitive
+// custom vars redacted, the command itself still gets them.
+func (e *Executor) redactCmd(cmd []string, customVars map[string]string) []string {
+	var values []string
+	for k, v := range customVars {
+		if v != "" && e.isSensitive(k) {
+			values = append(values, v)
 		}
-		size += info.Size()
-		return nil
+	}
+	if len(values) == 0 {
+		return cmd
+	}
+	// a value containing another is replaced first
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
 	})
-	return size, err
+	redacted := make([]string, len(cmd))
+	for i, arg := range cmd {
+		for _, v := range values {
+			arg = strings.ReplaceAll(arg, v, redactedValue)
+		}
+		redacted[i] = arg
+	}
+	return redacted
+}
+
+// marshalCustomVars renders custom vars as JSON or, in the env format, as sorted KEY='value' lines a shell
+// can source. Single quotes keep values literal, a quote inside closes them, is written backslash escaped
+// and opens them again:
+//
+//	'\''
+func marshalCustomVars(customVars map[string]string, format string) ([]byte, error) {
+	if format != CustomVarsEnv {
+		return json.Marshal(customVars)
+	}
+	var b bytes.Buffer
+	for _, key := range slices.Sorted(maps.Keys(customVars)) {
+		if !envNamePattern.MatchString(key) {
+			return nil, fmt.Errorf("%w: %q is not a valid env name", ErrInvalidCustomVars, key)
+		}
+		fmt.Fprintf(&b, "%s='%s'\n", key, strings.ReplaceAll(customVars[key], "'", `'\''`))
+	}
+	return b.Bytes(), nil
+}
+
+// parseCustomVars reads a .custom_vars file of either format, JSON starts with a brace. Env lines may
+// quote values with single or double quotes or escape characters with a backslash, # starts a comment.
+func parseCustomVars(data []byte) (map[string]string, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var customVars map[string]string
+		if err := json.Unmarshal(trimmed, &customVars); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCustomVars, err)
+		}
+		return customVars, nil
+	}
+	customVars := map[string]string{}
+	s := string(data)
+	for len(s) > 0 {
+		line := strings.TrimLeft(s, " \t\r\n")
+		if line == "" {
+			break
+		}
+		if line[0] == '#' {
+			_, s, _ = strings.Cut(line, "\n")
+			continue
+		}
+		key, rest, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(strings.TrimPrefix(key, "export "))
+		if !ok || !envNamePattern.MatchString(key) {
+			return nil, fmt.Errorf("%w: line %q", ErrInvalidCustomVars, strings.SplitN(line, "\n", 2)[0])
+		}
+		value, rest, err := parseEnvValue(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidCustomVars, key, err)
+		}
+		customVars[key] = value
+		s = rest
+	}
+	return customVars, nil
+}
+
+// parseEnvValue reads a value up to the end of its line the way a shell would, returning what follows it.
+func parseEnvValue(s string) (string, string, error) {
+	var value strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\n':
+			return value.String(), s[i+1:], nil
+		case '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return "", "", errors.New("unterminated single quote")
+			}
+			value.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+		case '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`"\$`+"`", s[i+1]) >= 0 {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return "", "", errors.New("unterminated double quote")
+			}
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				if s[i] != '\n' {
+					value.WriteByte(s[i])
+				}
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return value.String(), "", nil
 }
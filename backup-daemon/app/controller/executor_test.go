@@ -0,0 +1,662 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestProcessCmd(t *testing.T) {
+	testCases := []struct {
+		name          string
+		template      string
+		customVars    map[string]string
+		excludeDbs    []string
+		expectedCmd   []string
+		expectedError error
+		expectedKey   string
+	}{
+		{
+			name:        "success",
+			template:    "backup.sh {{.data_folder}} {{.clean}}",
+			customVars:  map[string]string{"clean": "true"},
+			expectedCmd: []string{"backup.sh", "/backup-storage/20240101T000000", "-clean", "true"},
+		},
+		{
+			name:        "excluded databases",
+			template:    "backup.sh {{.data_folder}} {{.exclude_dbs}}",
+			excludeDbs:  []string{"postgres", "template1"},
+			expectedCmd: []string{"backup.sh", "/backup-storage/20240101T000000", "--exclude-dbs", `["postgres","template1"]`},
+		},
+		{
+			name:        "nothing excluded",
+			template:    "backup.sh {{.data_folder}} {{.exclude_dbs}}",
+			expectedCmd: []string{"backup.sh", "/backup-storage/20240101T000000"},
+		},
+		{
+			name:          "undefined variable",
+			template:      "backup.sh {{.data_folder}} {{.missing}}",
+			expectedError: ErrUndefinedTemplateVar,
+			expectedKey:   `"missing"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &Executor{
+				customVars:    []string{"clean"},
+				excludeDbsKey: "--exclude-dbs",
+				logger:        zap.NewNop().Sugar(),
+			}
+			cmd, err := e.processCmd(tc.template, "/backup-storage/20240101T000000", nil, nil, tc.excludeDbs, tc.customVars)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected err %v, got: %v", tc.expectedError, err)
+			}
+			if err != nil {
+				if !strings.Contains(err.Error(), tc.expectedKey) {
+					t.Fatalf("expected err to name key %s, got: %v", tc.expectedKey, err)
+				}
+				return
+			}
+			if strings.Join(cmd, " ") != strings.Join(tc.expectedCmd, " ") {
+				t.Fatalf("expected cmd %v, got %v", tc.expectedCmd, cmd)
+			}
+		})
+	}
+}
+
+func TestUnconfiguredCommands(t *testing.T) {
+	testCases := []struct {
+		name       string
+		backupCmd  string
+		restoreCmd string
+		dbListCmd  string
+		expected   []string
+	}{
+		{
+			name:       "all configured",
+			backupCmd:  "/opt/backup.py {{.data_folder}}",
+			restoreCmd: "/opt/restore.py {{.data_folder}}",
+			dbListCmd:  "/opt/dblist.py {{.data_folder}}",
+			expected:   nil,
+		},
+		{
+			name:       "defaults",
+			backupCmd:  PlaceholderCmd,
+			restoreCmd: PlaceholderCmd,
+			dbListCmd:  PlaceholderCmd,
+			expected:   []string{"backup", "restore", "dblist"},
+		},
+		{
+			name:       "empty restore",
+			backupCmd:  "/opt/backup.py {{.data_folder}}",
+			restoreCmd: "  ",
+			dbListCmd:  "/opt/dblist.py {{.data_folder}}",
+			expected:   []string{"restore"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := UnconfiguredCommands(tc.backupCmd, tc.restoreCmd, tc.dbListCmd)
+			if strings.Join(got, ",") != strings.Join(tc.expected, ",") {
+				t.Fatalf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestCommandTemplate(t *testing.T) {
+	testCases := []struct {
+		name          string
+		cmd           string
+		content       string
+		noFile        bool
+		expected      string
+		expectedError error
+	}{
+		{name: "no file", cmd: "/opt/backup.py", noFile: true, expected: "/opt/backup.py"},
+		{name: "command wins", cmd: "/opt/backup.py", content: "/opt/other.py", expected: "/opt/backup.py"},
+		{name: "placeholder", cmd: PlaceholderCmd, content: "/opt/backup.py {{.data_folder}}\n", expected: "/opt/backup.py {{.data_folder}}"},
+		{name: "empty command, multi-line file", content: "/opt/backup.py \\\n  --dbs {{.dbs}} \\\n  {{.data_folder}}\n",
+			expected: "/opt/backup.py    --dbs {{.dbs}}    {{.data_folder}}"},
+		{name: "empty file", content: "\n", expectedError: ErrCommandEmpty},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var file string
+			if !tc.noFile {
+				file = filepath.Join(t.TempDir(), "cmd.tmpl")
+				if err := os.WriteFile(file, []byte(tc.content), 0o644); err != nil {
+					t.Fatalf("failed to write template: %v", err)
+				}
+			}
+			got, err := CommandTemplate(tc.cmd, file)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected err %v, got: %v", tc.expectedError, err)
+			}
+			if got != tc.expected {
+				t.Fatalf("expected template %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestDiscoverDBs(t *testing.T) {
+	testCases := []struct {
+		name          string
+		template      string
+		expectedDBs   []string
+		expectedError error
+	}{
+		{
+			name:        "success",
+			template:    `printf 'db1\n\ndb2\n'`,
+			expectedDBs: []string{"db1", "db2"},
+		},
+		{
+			name:          "not configured",
+			template:      "",
+			expectedError: ErrCommandEmpty,
+		},
+		{
+			name:          "command fails",
+			template:      "false",
+			expectedError: ErrExecuteCmdFailed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &Executor{
+				discoverDbsCmdTemplate: tc.template,
+				logger:                 zap.NewNop().Sugar(),
+			}
+			dbs, err := e.DiscoverDBs(nil)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected err %v, got: %v", tc.expectedError, err)
+			}
+			if strings.Join(dbs, ",") != strings.Join(tc.expectedDBs, ",") {
+				t.Fatalf("expected dbs %v, got %v", tc.expectedDBs, dbs)
+			}
+		})
+	}
+}
+
+func TestStreamCommandLogs(t *testing.T) {
+	testCases := []struct {
+		name          string
+		stream        bool
+		expectedLines []string
+	}{
+		{name: "streaming disabled"},
+		{name: "streaming enabled", stream: true, expectedLines: []string{"one", "two"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.DebugLevel)
+			vaultFolder := t.TempDir()
+			e := &Executor{
+				restoreCmdTemplate: `printf 'one\ntwo'`,
+				logger:             zap.New(core).Sugar(),
+				streamCommandLogs:  tc.stream,
+			}
+			if err := e.PerformRestore(vaultFolder, nil, nil, nil, true, "task-1"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			console, err := os.ReadFile(filepath.Join(vaultFolder, "restore_task-1.log"))
+			if err != nil {
+				t.Fatalf("failed to read restore log: %v", err)
+			}
+			if string(console) != "one\ntwo" {
+				t.Fatalf("expected restore log %q, got %q", "one\ntwo", console)
+			}
+			var lines []string
+			for _, entry := range logs.FilterField(zap.String("task_id", "task-1")).All() {
+				lines = append(lines, entry.Message)
+			}
+			if strings.Join(lines, ",") != strings.Join(tc.expectedLines, ",") {
+				t.Fatalf("expected logged lines %v, got %v", tc.expectedLines, lines)
+			}
+		})
+	}
+}
+
+func TestPerformRestorePerDB(t *testing.T) {
+	testCases := []struct {
+		name          string
+		concurrency   int
+		dbs           []entity.DBEntry
+		expectedLogs  map[string]string
+		expectedError error
+		expectedFails []string
+	}{
+		{
+			name:         "one command",
+			concurrency:  1,
+			dbs:          []entity.DBEntry{{SimpleName: "db1"}, {SimpleName: "db2"}},
+			expectedLogs: map[string]string{"task-1.log": `--dbs ["db1","db2"] --dbmap {"db1":"new1"}` + "\n"},
+		},
+		{
+			name:        "command per database",
+			concurrency: 2,
+			dbs:         []entity.DBEntry{{SimpleName: "db1"}, {Object: map[string]entity.DBObject{"db2": {}, "db3": {}}}},
+			expectedLogs: map[string]string{
+				"task-1_db1.log": `--dbs ["db1"] --dbmap {"db1":"new1"}` + "\n",
+				"task-1_db2.log": `--dbs [{"db2":{}}]` + "\n",
+				"task-1_db3.log": `--dbs [{"db3":{}}]` + "\n",
+			},
+		},
+		{
+			name:          "some databases fail",
+			concurrency:   2,
+			dbs:           []entity.DBEntry{{SimpleName: "db1"}, {SimpleName: "fail2"}, {SimpleName: "fail3"}},
+			expectedError: ErrDBRestoreFailed,
+			expectedFails: []string{"fail2", "fail3"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			vaultFolder := t.TempDir()
+			e := &Executor{
+				restoreCmdTemplate:   `sh -c 'echo "$@"; case "$*" in *fail*) exit 1;; esac' restore {{.dbs}} {{.dbmap}}`,
+				databasesKey:         "--dbs",
+				dbmapKey:             "--dbmap",
+				restoreDBConcurrency: tc.concurrency,
+				logger:               zap.NewNop().Sugar(),
+			}
+			err := e.PerformRestore(vaultFolder, tc.dbs, map[string]string{"db1": "new1"}, nil, false, "task-1")
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected err %v, got: %v", tc.expectedError, err)
+			}
+			if tc.expectedError != nil {
+				var dbErr *DBRestoreError
+				if !errors.As(err, &dbErr) || !errors.Is(err, ErrExecuteCmdFailed) {
+					t.Fatalf("expected a DBRestoreError of failed commands, got: %v", err)
+				}
+				var failed []string
+				for name := range dbErr.Failed {
+					failed = append(failed, name)
+				}
+				sort.Strings(failed)
+				if !reflect.DeepEqual(failed, tc.expectedFails) {
+					t.Fatalf("expected failed databases %v, got %v", tc.expectedFails, failed)
+				}
+			}
+			for name, expected := range tc.expectedLogs {
+				content, err := os.ReadFile(filepath.Join(vaultFolder, "restore_logs", name))
+				if err != nil {
+					t.Fatalf("failed to read restore log %s: %v", name, err)
+				}
+				if string(content) != expected {
+					t.Fatalf("expected restore log %s %q, got %q", name, expected, content)
+				}
+			}
+		})
+	}
+}
+
+func TestPerformTestRestore(t *testing.T) {
+	testCases := []struct {
+		name          string
+		template      string
+		expectedError error
+	}{
+		{name: "runs the test restore command", template: "true"},
+		{name: "not configured", expectedError: ErrCommandEmpty},
+		{name: "test restore fails", template: "false", expectedError: ErrExecuteCmdFailed},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &Executor{
+				// the production restore command must never run for a test restore
+				restoreCmdTemplate:     "false",
+				testRestoreCmdTemplate: tc.template,
+				logger:                 zap.NewNop().Sugar(),
+			}
+			err := e.PerformTestRestore(t.TempDir(), nil, nil, nil, "task-1")
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected err %v, got: %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestPerformBackupCommandOverride(t *testing.T) {
+	testCases := []struct {
+		name            string
+		override        string
+		expectedConsole string
+	}{
+		{name: "configured command", expectedConsole: "configured"},
+		{name: "overridden command", override: "printf overridden", expectedConsole: "overridden"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			vaultFolder := t.TempDir()
+			e := &Executor{
+				backupCmdTemplate: "printf configured",
+				logger:            zap.NewNop().Sugar(),
+			}
+			if err := e.PerformBackup(entity.Vault{Folder: vaultFolder}, nil, nil, nil, tc.override); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			console, err := os.ReadFile(filepath.Join(vaultFolder, ".console"))
+			if err != nil {
+				t.Fatalf("failed to read backup console: %v", err)
+			}
+			if string(console) != tc.expectedConsole {
+				t.Fatalf("expected console %q, got %q", tc.expectedConsole, console)
+			}
+		})
+	}
+}
+
+func TestPerformBackupWatch(t *testing.T) {
+	pollInterval := watchPollInterval
+	t.Cleanup(func() { watchPollInterval = pollInterval })
+	watchPollInterval = 10 * time.Millisecond
+	testCases := []struct {
+		name          string
+		markerAfter   time.Duration
+		expectedError error
+	}{
+		{name: "marker created", markerAfter: 30 * time.Millisecond},
+		{name: "marker missing", markerAfter: -1, expectedError: ErrBackupWatchTimeout},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			vaultFolder := t.TempDir()
+			e := &Executor{
+				backupCmdTemplate: "printf configured",
+				watch:             BackupWatch{Enabled: true, Marker: ".backup_complete", Timeout: 200 * time.Millisecond},
+				logger:            zap.NewNop().Sugar(),
+			}
+			if tc.markerAfter >= 0 {
+				go func() {
+					time.Sleep(tc.markerAfter)
+					_ = os.WriteFile(filepath.Join(vaultFolder, "dump.sql"), []byte("data"), 0o644)
+					_ = os.WriteFile(filepath.Join(vaultFolder, ".backup_complete"), nil, 0o644)
+				}()
+			}
+			err := e.PerformBackup(entity.Vault{Folder: vaultFolder}, nil, nil, nil, "")
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected err %v, got: %v", tc.expectedError, err)
+			}
+			if _, err := os.Stat(filepath.Join(vaultFolder, ".console")); !os.IsNotExist(err) {
+				t.Fatalf("expected the backup command not to run, got console err: %v", err)
+			}
+			_, err = os.Stat(filepath.Join(vaultFolder, ManifestFile))
+			if (err == nil) != (tc.expectedError == nil) {
+				t.Fatalf("expected manifest only for a completed backup, got err: %v", err)
+			}
+		})
+	}
+}
+
+func TestPerformBackupWatchCancel(t *testing.T) {
+	vaultFolder := filepath.Join(t.TempDir(), "20240101T000000")
+	e := &Executor{
+		watch:  BackupWatch{Enabled: true, Marker: ".backup_complete", Timeout: time.Hour},
+		logger: zap.NewNop().Sugar(),
+	}
+	if e.CancelWatch("20240101T000000") {
+		t.Fatalf("expected nothing to cancel before the backup waits")
+	}
+	done := make(chan error)
+	go func() {
+		done <- e.PerformBackup(entity.Vault{Folder: vaultFolder}, nil, nil, nil, "")
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for !e.CancelWatch("20240101T000000") {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the backup to wait for its marker")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrBackupWatchCanceled) {
+			t.Fatalf("expected err %v, got: %v", ErrBackupWatchCanceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the canceled backup to stop waiting")
+	}
+	if e.CancelWatch("20240101T000000") {
+		t.Fatalf("expected nothing to cancel once the backup ended")
+	}
+}
+
+func TestPerformBackupRetries(t *testing.T) {
+	// fails until the console of a failed attempt was kept
+	flaky := `sh -c 'if [ -f {{.data_folder}}/.console.1 ]; then echo ok; else echo failed; exit 3; fi'`
+	testCases := []struct {
+		name             string
+		template         string
+		retries          int
+		expectedError    error
+		expectedAttempts float64
+		expectedConsole  string
+	}{
+		{name: "succeeds on retry", template: flaky, retries: 1, expectedAttempts: 2, expectedConsole: "ok\n"},
+		{name: "no retries", template: flaky, expectedError: ErrExecuteCmdFailed, expectedAttempts: 1, expectedConsole: "failed\n"},
+		{name: "killed command", template: `sh -c 'kill -9 $$'`, retries: 2, expectedError: ErrExecuteCmdFailed, expectedAttempts: 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			vaultFolder := t.TempDir()
+			e := &Executor{
+				backupCmdTemplate: tc.template,
+				retries:           CommandRetries{Backup: tc.retries},
+				logger:            zap.NewNop().Sugar(),
+			}
+			err := e.PerformBackup(entity.Vault{Folder: vaultFolder}, nil, nil, nil, "")
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected err %v, got: %v", tc.expectedError, err)
+			}
+			console, _ := os.ReadFile(filepath.Join(vaultFolder, ".console"))
+			if string(console) != tc.expectedConsole {
+				t.Fatalf("expected console %q, got %q", tc.expectedConsole, console)
+			}
+			content, err := os.ReadFile(filepath.Join(vaultFolder, ".metrics"))
+			if err != nil {
+				t.Fatalf("failed to read metrics: %v", err)
+			}
+			var metrics map[string]any
+			if err := json.Unmarshal(content, &metrics); err != nil {
+				t.Fatalf("failed to parse metrics: %v", err)
+			}
+			if metrics["attempts"] != tc.expectedAttempts {
+				t.Fatalf("expected %v attempts, got %v", tc.expectedAttempts, metrics["attempts"])
+			}
+		})
+	}
+}
+
+func TestPerformBackupOutputCriteria(t *testing.T) {
+	output := `printf 'dumping db1\nWARNING: table t1 skipped\ndone\n'`
+	testCases := []struct {
+		name          string
+		criteria      BackupOutputCriteria
+		expectedError error
+		expectedLine  string
+	}{
+		{name: "no criteria"},
+		{name: "success line", criteria: BackupOutputCriteria{Success: regexp.MustCompile(`^done$`)}},
+		{name: "no success line", criteria: BackupOutputCriteria{Success: regexp.MustCompile(`^completed$`)}, expectedError: ErrBackupOutputFailed},
+		{
+			name:          "failure line",
+			criteria:      BackupOutputCriteria{Success: regexp.MustCompile(`^done$`), Failure: regexp.MustCompile(`^WARNING: .* skipped`)},
+			expectedError: ErrBackupOutputFailed,
+			expectedLine:  "WARNING: table t1 skipped",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &Executor{
+				backupCmdTemplate: output,
+				outputCriteria:    tc.criteria,
+				logger:            zap.NewNop().Sugar(),
+			}
+			err := e.PerformBackup(entity.Vault{Folder: t.TempDir()}, nil, nil, nil, "")
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected err %v, got: %v", tc.expectedError, err)
+			}
+			if tc.expectedLine != "" && !strings.Contains(err.Error(), tc.expectedLine) {
+				t.Fatalf("expected err to name line %q, got: %v", tc.expectedLine, err)
+			}
+		})
+	}
+}
+
+func TestSensitiveCustomVars(t *testing.T) {
+	testCases := []struct {
+		name     string
+		template string
+		failing  bool
+	}{
+		{name: "successful backup", template: "echo {{.db_password}} {{.storageName}}"},
+		{name: "failed backup", template: "sh -c 'exit 3' {{.db_password}}", failing: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.DebugLevel)
+			vaultFolder := t.TempDir()
+			e := &Executor{
+				backupCmdTemplate:   tc.template,
+				customVars:          []string{"db_password", "storageName"},
+				sensitiveCustomVars: []string{"pass", "token"},
+				logger:              zap.New(core).Sugar(),
+			}
+			customVars := map[string]string{"db_password": "s3cr3t", "storageName": "main"}
+			err := e.PerformBackup(entity.Vault{Folder: vaultFolder}, nil, nil, customVars, "")
+			if (err != nil) != tc.failing {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err != nil && strings.Contains(err.Error(), "s3cr3t") {
+				t.Fatalf("expected the error to redact the password, got: %v", err)
+			}
+			for _, entry := range logs.All() {
+				if line := fmt.Sprint(entry.Message, entry.ContextMap()); strings.Contains(line, "s3cr3t") {
+					t.Fatalf("expected logs to redact the password, got: %s", line)
+				}
+			}
+			if tc.failing {
+				return
+			}
+			console, err := os.ReadFile(filepath.Join(vaultFolder, ".console"))
+			if err != nil {
+				t.Fatalf("failed to read backup console: %v", err)
+			}
+			if string(console) != "-db_password s3cr3t -storageName main\n" {
+				t.Fatalf("expected the command to get the password, got %q", console)
+			}
+			manifest, err := readManifest(vaultFolder)
+			if err != nil {
+				t.Fatalf("failed to read manifest: %v", err)
+			}
+			expected := map[string]string{"db_password": redactedValue, "storageName": "main"}
+			if !reflect.DeepEqual(manifest.CustomVars, expected) {
+				t.Fatalf("expected manifest custom vars %v, got %v", expected, manifest.CustomVars)
+			}
+		})
+	}
+}
+
+func TestCustomVarsFormat(t *testing.T) {
+	customVars := map[string]string{
+		STARTTS:     "1704067200000",
+		"blob_path": "it's a \"path\" with $HOME and `cmd`",
+		"multiline": "first\nsecond \\ third",
+	}
+	testCases := []struct {
+		name            string
+		format          string
+		expectedPrefix  string
+		expectedSourced bool
+	}{
+		{name: "json", format: CustomVarsJSON, expectedPrefix: "{"},
+		{name: "default", expectedPrefix: "{"},
+		{name: "env", format: CustomVarsEnv, expectedPrefix: "blob_path='it'\\''s a", expectedSourced: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			vaultFolder := t.TempDir()
+			e := &Executor{
+				backupCmdTemplate: "printf done",
+				customVarsFormat:  tc.format,
+				logger:            zap.NewNop().Sugar(),
+			}
+			if err := e.PerformBackup(entity.Vault{Folder: vaultFolder}, nil, nil, customVars, ""); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			path := filepath.Join(vaultFolder, ".custom_vars")
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read custom vars: %v", err)
+			}
+			if !strings.HasPrefix(string(data), tc.expectedPrefix) {
+				t.Fatalf("expected custom vars starting with %q, got %q", tc.expectedPrefix, data)
+			}
+			parsed, err := parseCustomVars(data)
+			if err != nil {
+				t.Fatalf("failed to parse custom vars: %v", err)
+			}
+			if !reflect.DeepEqual(parsed, customVars) {
+				t.Fatalf("expected custom vars %v, got %v", customVars, parsed)
+			}
+			if !tc.expectedSourced {
+				return
+			}
+			for key, value := range customVars {
+				out, err := exec.Command("sh", "-c", `. "$1"; printf '%s' "$`+key+`"`, "sh", path).Output()
+				if err != nil {
+					t.Fatalf("failed to source custom vars: %v", err)
+				}
+				if string(out) != value {
+					t.Fatalf("expected sourced %s %q, got %q", key, value, out)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCustomVars(t *testing.T) {
+	testCases := []struct {
+		name          string
+		data          string
+		expected      map[string]string
+		expectedError error
+	}{
+		{name: "json", data: `{"start_ts":"1"}`, expected: map[string]string{STARTTS: "1"}},
+		{name: "env quoting", data: "# vars\nexport A='x y'\nB=\"say \\\"hi\\\" $\"\nC=plain\\ text\n\nD=\n",
+			expected: map[string]string{"A": "x y", "B": `say "hi" $`, "C": "plain text", "D": ""}},
+		{name: "invalid name", data: "1A=x\n", expectedError: ErrInvalidCustomVars},
+		{name: "unterminated quote", data: "A='x\n", expectedError: ErrInvalidCustomVars},
+		{name: "invalid json", data: `{"start_ts":1}`, expectedError: ErrInvalidCustomVars},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			customVars, err := parseCustomVars([]byte(tc.data))
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+			if tc.expectedError == nil && !reflect.DeepEqual(customVars, tc.expected) {
+				t.Fatalf("expected custom vars %v, got %v", tc.expected, customVars)
+			}
+		})
+	}
+}
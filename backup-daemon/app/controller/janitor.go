@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/repo"
+	"go.uber.org/zap"
+)
+
+// JobsJanitor periodically removes finished job rows whose vault is gone from storage
+// and vacuums the jobs database.
+type JobsJanitor struct {
+	storageRepo repo.StorageRepository
+	dbRepo      repo.DBRepository
+	ttl         time.Duration
+	interval    time.Duration
+	logger      *zap.SugaredLogger
+}
+
+func NewJobsJanitor(storageRepo repo.StorageRepository, dbRepo repo.DBRepository,
+	ttl time.Duration, interval time.Duration, logger *zap.SugaredLogger) *JobsJanitor {
+	return &JobsJanitor{
+		storageRepo: storageRepo,
+		dbRepo:      dbRepo,
+		ttl:         ttl,
+		interval:    interval,
+		logger:      logger,
+	}
+}
+
+func (j *JobsJanitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		j.Prune(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunVacuum vacuums the jobs database every interval, pruning leaves free pages behind.
+func (j *JobsJanitor) RunVacuum(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := j.dbRepo.Vacuum(ctx); err != nil {
+				j.logger.Errorf("failed to vacuum jobs database err: %v", err)
+			}
+		}
+	}
+}
+
+// Prune removes finished jobs older than the ttl. Rows of vaults still on the storage, locked ones included,
+// and of backups kept under a blob path are never removed.
+func (j *JobsJanitor) Prune(ctx context.Context) {
+	vaults, err := j.storageRepo.ListVaultNames(false, repo.ALL, "")
+	if err != nil {
+		j.logger.Warnf("skip jobs pruning, failed to list vaults err: %v", err)
+		return
+	}
+	jobs, err := j.dbRepo.ListJobs(repo.WithTenant(ctx, ""), entity.JobsFilter{})
+	if err != nil {
+		j.logger.Warnf("skip jobs pruning, failed to list jobs err: %v", err)
+		return
+	}
+	for _, job := range jobs {
+		if job.Vault == "" || slices.Contains(vaults, job.Vault) {
+			continue
+		}
+		// ListVaultNames hides vaults locked by a running backup, blob path backups may live in s3 only
+		if job.BlobPath != "" || j.storageRepo.GetVault(job.Vault, false, "", "", false).Folder != "" {
+			vaults = append(vaults, job.Vault)
+		}
+	}
+	pruned, err := j.dbRepo.PruneJobs(ctx, time.Now().Add(-j.ttl), vaults)
+	if err != nil {
+		j.logger.Errorf("failed to prune jobs err: %v", err)
+		return
+	}
+	if pruned > 0 {
+		j.logger.Infof("Pruned %d jobs older than %s", pruned, j.ttl)
+	}
+}
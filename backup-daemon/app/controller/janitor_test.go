@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/repo"
+	"go.uber.org/zap"
+)
+
+// pruneJobRepo records the vaults whose rows PruneJobs was asked to keep.
+type pruneJobRepo struct {
+	fakeJobRepo
+	keepVaults []string
+}
+
+func (p *pruneJobRepo) PruneJobs(_ context.Context, _ time.Time, keepVaults []string) (int64, error) {
+	p.keepVaults = keepVaults
+	return 0, nil
+}
+
+func TestJobsJanitorPruneKeepsVaults(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"20240101T000000", "20240102T000000"} {
+		if err := os.MkdirAll(filepath.Join(root, name), 0o755); err != nil {
+			t.Fatalf("failed to create vault dir: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "20240102T000000", ".lock"), nil, 0o644); err != nil {
+		t.Fatalf("failed to lock vault: %v", err)
+	}
+	dbRepo := &pruneJobRepo{fakeJobRepo: fakeJobRepo{jobs: map[string]entity.Job{
+		"local":  {TaskID: "local", Status: "Successful", Vault: "20240101T000000"},
+		"locked": {TaskID: "locked", Status: "Successful", Vault: "20240102T000000"},
+		"blob":   {TaskID: "blob", Status: "Successful", Vault: "20240103T000000", BlobPath: "bucket/path", Tenant: "team-a"},
+		"gone":   {TaskID: "gone", Status: "Failed", Vault: "20240104T000000"},
+	}}}
+	janitor := NewJobsJanitor(repo.NewStorageRepo(root, "", "", false, false, nil, nil), dbRepo, time.Hour, time.Hour, zap.NewNop().Sugar())
+
+	janitor.Prune(context.Background())
+
+	for _, vault := range []string{"20240101T000000", "20240102T000000", "20240103T000000"} {
+		if !slices.Contains(dbRepo.keepVaults, vault) {
+			t.Fatalf("expected rows of vault %s to be kept, got %v", vault, dbRepo.keepVaults)
+		}
+	}
+	if slices.Contains(dbRepo.keepVaults, "20240104T000000") {
+		t.Fatalf("expected rows of the removed vault to be pruned, got %v", dbRepo.keepVaults)
+	}
+}
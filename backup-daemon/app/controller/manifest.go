@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
+)
+
+// ManifestFile is the name of the manifest in a vault.
+const ManifestFile = "manifest.json"
+
+// ManifestFormatVersion is the newest manifest format this daemon writes and restores.
+const ManifestFormatVersion = 1
+
+var ErrUnsupportedManifest = errors.New("unsupported backup manifest format")
+
+// writeManifest lists the files of a finished backup with their sizes and checksums. The metrics
+// file is left out, it is rewritten once the backup returns.
+func writeManifest(vault entity.Vault, metricsPath string, dbs []entity.DBEntry, excludeDbs []string,
+	customVars map[string]string) error {
+	manifest := entity.BackupManifest{
+		FormatVersion:     ManifestFormatVersion,
+		ToolVersion:       toolVersion(),
+		BackupID:          filepath.Base(vault.Folder),
+		CreatedAt:         time.Now().UTC().Format(time.RFC3339),
+		Databases:         make([]string, 0, len(dbs)),
+		ExcludedDatabases: excludeDbs,
+		CustomVars:        customVars,
+		Files:             []entity.ManifestFile{},
+	}
+	for _, d := range dbs {
+		// the object form sets both the name and a key of the same name
+		names := slices.Sorted(maps.Keys(d.Object))
+		if d.SimpleName != "" && !slices.Contains(names, d.SimpleName) {
+			names = append([]string{d.SimpleName}, names...)
+		}
+		manifest.Databases = append(manifest.Databases, names...)
+	}
+	manifestPath := filepath.Join(vault.Folder, ManifestFile)
+	err := filepath.WalkDir(vault.Folder, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || p == manifestPath || p == filepath.Clean(metricsPath) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hash, err := fileSHA256(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(vault.Folder, p)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, entity.ManifestFile{Path: filepath.ToSlash(rel), Size: info.Size(), SHA256: hash})
+		manifest.Size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list vault %s err: %w", vault.Folder, err)
+	}
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest err: %w", err)
+	}
+	if err := os.WriteFile(manifestPath, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write manifest %s err: %w", manifestPath, err)
+	}
+	return nil
+}
+
+// readManifest reads the manifest of a vault folder, the error wraps os.ErrNotExist for a
+// backup taken before manifests were written.
+func readManifest(folder string) (entity.BackupManifest, error) {
+	b, err := os.ReadFile(filepath.Join(folder, ManifestFile))
+	if err != nil {
+		return entity.BackupManifest{}, fmt.Errorf("failed to read manifest err: %w", err)
+	}
+	var manifest entity.BackupManifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return entity.BackupManifest{}, fmt.Errorf("failed to parse manifest err: %w", err)
+	}
+	return manifest, nil
+}
+
+// checkManifest rejects a vault whose manifest format is newer than this daemon supports, a vault
+// without a manifest passes.
+func checkManifest(folder string) error {
+	manifest, err := readManifest(folder)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if manifest.FormatVersion < 1 || manifest.FormatVersion > ManifestFormatVersion {
+		return fmt.Errorf("%w: version %d, supported up to %d", ErrUnsupportedManifest, manifest.FormatVersion, ManifestFormatVersion)
+	}
+	return nil
+}
+
+func toolVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown"
+}
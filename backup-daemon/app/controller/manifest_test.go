@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/repo"
+	"go.uber.org/zap"
+)
+
+func TestPerformBackupManifest(t *testing.T) {
+	vaultFolder := t.TempDir()
+	e := &Executor{
+		backupCmdTemplate: "sh -c 'mkdir -p {{.data_folder}}/db1 && printf dump > {{.data_folder}}/db1/db1.dump'",
+		logger:            zap.NewNop().Sugar(),
+	}
+	dbs := []entity.DBEntry{{SimpleName: "db1"}}
+	if err := e.PerformBackup(entity.Vault{Folder: vaultFolder}, dbs, []string{"db2"}, map[string]string{"mode": "full"}, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	manifest, err := readManifest(vaultFolder)
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+	if manifest.FormatVersion != ManifestFormatVersion || manifest.BackupID != filepath.Base(vaultFolder) || manifest.ToolVersion == "" {
+		t.Fatalf("unexpected manifest header: %+v", manifest)
+	}
+	if !reflect.DeepEqual(manifest.Databases, []string{"db1"}) || !reflect.DeepEqual(manifest.ExcludedDatabases, []string{"db2"}) {
+		t.Fatalf("unexpected manifest databases: %+v", manifest)
+	}
+	if manifest.CustomVars["mode"] != "full" {
+		t.Fatalf("expected custom vars in manifest, got: %+v", manifest.CustomVars)
+	}
+	files := make(map[string]entity.ManifestFile, len(manifest.Files))
+	for _, f := range manifest.Files {
+		files[f.Path] = f
+	}
+	// sha256 of "dump"
+	dump := entity.ManifestFile{Path: "db1/db1.dump", Size: 4, SHA256: "b6ca0868bca6a2926b70aa1a71592038d9030fe26d4214edcfbd6cf41f2f4654"}
+	if files[dump.Path] != dump {
+		t.Fatalf("expected %+v in manifest, got: %+v", dump, manifest.Files)
+	}
+	if _, ok := files[".console"]; !ok {
+		t.Fatalf("expected console in manifest, got: %+v", manifest.Files)
+	}
+	if _, ok := files[".metrics"]; ok {
+		t.Fatalf("expected metrics to be left out of manifest, got: %+v", manifest.Files)
+	}
+	if _, ok := files[ManifestFile]; ok {
+		t.Fatalf("expected manifest to leave itself out, got: %+v", manifest.Files)
+	}
+}
+
+func TestWriteManifestDatabases(t *testing.T) {
+	testCases := []struct {
+		name     string
+		dbs      []entity.DBEntry
+		expected []string
+	}{
+		{name: "no databases", expected: []string{}},
+		{name: "names", dbs: []entity.DBEntry{{SimpleName: "db1"}, {SimpleName: "db2"}}, expected: []string{"db1", "db2"}},
+		{
+			name: "object form",
+			dbs: []entity.DBEntry{
+				{SimpleName: "db1"},
+				{Object: map[string]entity.DBObject{"db3": {Tables: []string{"t1"}}, "db2": {Collections: []entity.CollectionItem{{Name: "c1"}}}}},
+				{SimpleName: "db4", Object: map[string]entity.DBObject{"db4": {Tables: []string{"t2"}}}},
+			},
+			expected: []string{"db1", "db2", "db3", "db4"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			folder := t.TempDir()
+			if err := writeManifest(entity.Vault{Folder: folder}, filepath.Join(folder, ".metrics"), tc.dbs, nil, nil); err != nil {
+				t.Fatalf("failed to write manifest: %v", err)
+			}
+			manifest, err := readManifest(folder)
+			if err != nil {
+				t.Fatalf("failed to read manifest: %v", err)
+			}
+			if !reflect.DeepEqual(manifest.Databases, tc.expected) {
+				t.Fatalf("expected databases %v, got %v", tc.expected, manifest.Databases)
+			}
+		})
+	}
+}
+
+func TestCheckManifest(t *testing.T) {
+	testCases := []struct {
+		name          string
+		manifest      string
+		expectedError error
+	}{
+		{name: "no manifest"},
+		{name: "supported", manifest: `{"format_version": 1}`},
+		{name: "newer format", manifest: `{"format_version": 2}`, expectedError: ErrUnsupportedManifest},
+		{name: "missing format", manifest: `{}`, expectedError: ErrUnsupportedManifest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			folder := t.TempDir()
+			if tc.manifest != "" {
+				if err := os.WriteFile(filepath.Join(folder, ManifestFile), []byte(tc.manifest), 0o644); err != nil {
+					t.Fatalf("failed to write manifest: %v", err)
+				}
+			}
+			if err := checkManifest(folder); !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected err %v, got: %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestGetBackupManifestRedacts(t *testing.T) {
+	root := t.TempDir()
+	const vaultName = "20240101T000000"
+	if err := os.MkdirAll(filepath.Join(root, vaultName), 0o755); err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+	// written before custom vars were redacted on write
+	manifest := `{"format_version":1,"backup_id":"20240101T000000","custom_vars":{"db_password":"secret","mode":"full"}}`
+	if err := os.WriteFile(filepath.Join(root, vaultName, ManifestFile), []byte(manifest), 0o644); err != nil {
+		t.Fatalf("failed to write manifest: %v", err)
+	}
+	b := &BackupDaemon{
+		storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+		executor:    &Executor{sensitiveCustomVars: []string{"pass"}, logger: zap.NewNop().Sugar()},
+		logger:      zap.NewNop().Sugar(),
+	}
+
+	got, err := b.GetBackupManifest(context.Background(), vaultName)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{"db_password": redactedValue, "mode": "full"}
+	if !reflect.DeepEqual(got.CustomVars, expected) {
+		t.Fatalf("expected custom vars %v, got %v", expected, got.CustomVars)
+	}
+}
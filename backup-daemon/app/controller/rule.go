@@ -5,6 +5,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type RuleType int
@@ -94,3 +95,32 @@ func parseRules(rules string) ([]Rule, error) {
 	}
 	return result, nil
 }
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// ParseWeekday parses a lower case english weekday name such as "monday".
+func ParseWeekday(name string) (time.Weekday, error) {
+	weekday, ok := weekdays[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return 0, fmt.Errorf("invalid weekday: %s", name)
+	}
+	return weekday, nil
+}
+
+// EvictionAlignment returns the unix time in milliseconds, the unit of vault timestamps, of the
+// first weekStart midnight in loc after the epoch. Interval rules bucket vaults relative to it, so
+// day buckets start at local midnight and week buckets at weekStart. The offset is fixed, buckets
+// move by an hour across DST.
+func EvictionAlignment(weekStart time.Weekday, loc *time.Location) int64 {
+	// the epoch was a thursday
+	days := (int(weekStart) - int(time.Thursday) + 7) % 7
+	return time.Date(1970, time.January, 1+days, 0, 0, 0, 0, loc).UnixMilli()
+}
@@ -2,18 +2,25 @@ package controller
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"mime"
+	"net"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/repo"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
@@ -30,18 +37,73 @@ import (
 
 const WorkerCount = 3
 
+// MaxDeleteBatchSize is the S3 limit of keys in a single DeleteObjects request.
+const MaxDeleteBatchSize = 1000
+
+// ContentHashMetadataKey is the object metadata entry holding the SHA-256 of the uploaded file.
+const ContentHashMetadataKey = "content-sha256"
+
+// S3Timeouts bounds how long the client waits on the S3 endpoint, zero disables a limit.
+// Operation applies to every single S3 call (one file upload or download, one list or delete).
+// ObjectWait bounds the wait for an uploaded object to exist, zero uses one minute.
+type S3Timeouts struct {
+	Dial           time.Duration
+	TLSHandshake   time.Duration
+	ResponseHeader time.Duration
+	Operation      time.Duration
+	ObjectWait     time.Duration
+}
+
+const defaultObjectWait = time.Minute
+
+// S3StorageClasses pick the storage class of uploaded objects by backup type, an empty Granular
+// uses Full. STANDARD is the S3 default and is not sent, some S3 compatible stores reject the header.
+type S3StorageClasses struct {
+	Full     string
+	Granular string
+}
+
+// S3ObjectLock sets the retention of uploaded objects in a bucket with Object Lock, an empty Mode disables it.
+type S3ObjectLock struct {
+	Mode string
+	Days int
+}
+
+// DownloadProgress counts the objects and bytes of a folder download done so far.
+type DownloadProgress struct {
+	Objects      int
+	TotalObjects int
+	Bytes        int64
+	TotalBytes   int64
+}
+
+// DownloadProgressFunc is called by DownloadFolder as parts of objects arrive, nil reports nothing.
+type DownloadProgressFunc func(DownloadProgress)
+
+// ErrObjectLocked is returned when objects are kept by the Object Lock retention of the bucket.
+var ErrObjectLocked = errors.New("s3 object is locked")
+
+// ErrObjectArchived is returned for an object of an archive storage class that has to be restored before download.
+var ErrObjectArchived = errors.New("s3 object is archived")
+
+// ErrUnsafeDownloadPath is returned when DownloadFolder has no base directory or a key would be written outside of it.
+var ErrUnsafeDownloadPath = errors.New("unsafe download path")
+
 type S3ClientRepository interface {
 	CreatePresignedUrl(ctx context.Context, objectName string, expiration int) (string, error)
+	CreatePresignedPutUrl(ctx context.Context, objectName string, expiration int) (string, error)
 	ListFiles(ctx context.Context, path string) ([]string, error)
 	UploadFolder(ctx context.Context, path string) error
 	UploadFolderWithPrefix(ctx context.Context, path, prefix string) error
-	DownloadFolder(ctx context.Context, s3Folder string, localDir string) error
+	DownloadFolder(ctx context.Context, s3Folder string, localDir string, progress DownloadProgressFunc) error
 	DeletePrefix(ctx context.Context, prefix string) error
+	CheckBucket(ctx context.Context) error
 }
 
 //go:generate mockgen -source=s3client.go -destination=s3mock.go -package=controller
 type PresignClientInterface interface {
 	PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+	PresignPutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
 }
 
 type DownloaderInterface interface {
@@ -62,22 +124,68 @@ type ClientInterface interface {
 	AbortMultipartUpload(context.Context, *s3.AbortMultipartUploadInput, ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
 	HeadObject(context.Context, *s3.HeadObjectInput, ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
 	DeleteObjects(context.Context, *s3.DeleteObjectsInput, ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+	HeadBucket(context.Context, *s3.HeadBucketInput, ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
 }
 
 type S3Client struct {
-	url             string
-	accessKeyID     string
-	accessKeySecret string
-	bucketName      string
-	region          string
-	Client          ClientInterface
-	PresignClient   PresignClientInterface
-	Uploader        UploaderInterface
-	Downloader      DownloaderInterface
-}
-
-func NewS3Client(ctx context.Context, url string, accessKeyID string, accessKeySecret string, bucketName string, region string, sslVerify bool) (S3ClientRepository, error) {
-	httpClient := awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+	url                string
+	accessKeyID        string
+	accessKeySecret    string
+	bucketName         string
+	region             string
+	multipartThreshold int64
+	deleteBatchSize    int
+	skipUnchanged      bool
+	timeouts           S3Timeouts
+	Client             ClientInterface
+	PresignClient      PresignClientInterface
+	Uploader           UploaderInterface
+	Downloader         DownloaderInterface
+
+	// contentHeaders stores Content-Encoding and Content-Type derived from the file extension
+	contentHeaders bool
+	// skipExistsCheck trusts a successful upload without waiting for the object to exist
+	skipExistsCheck bool
+	// followSymlinks uploads the content of symlinked directories, their targets may be outside the vault
+	followSymlinks bool
+	storageClasses S3StorageClasses
+	objectLock     S3ObjectLock
+}
+
+// NewS3Client creates an S3 client. Files smaller than multipartThreshold are sent
+// with a single PutObject call, larger ones go through the multipart uploader
+// using partSize chunks. DeleteObjects requests carry at most deleteBatchSize keys.
+// With skipUnchanged, files whose remote copy has the same size and content hash are not uploaded again.
+// Timeouts make an unreachable or hung endpoint fail the call instead of blocking it.
+// With contentHeaders, uploaded objects carry Content-Encoding and Content-Type, so clients
+// fetching a gzip'd file decompress it transparently.
+// forcePathStyle suits MinIO or Ceph, real AWS S3 works with virtual-hosted style and an empty url.
+func NewS3Client(ctx context.Context, url string, accessKeyID string, accessKeySecret string, bucketName string, region string, sslVerify bool, forcePathStyle bool,
+	partSize int64, multipartThreshold int64, deleteBatchSize int, skipUnchanged bool, contentHeaders bool, skipExistsCheck bool, followSymlinks bool, storageClasses S3StorageClasses, objectLock S3ObjectLock, timeouts S3Timeouts) (S3ClientRepository, error) {
+	for _, class := range []string{storageClasses.Full, storageClasses.Granular} {
+		if class != "" && !slices.Contains(types.StorageClass("").Values(), types.StorageClass(class)) {
+			return nil, fmt.Errorf("unknown s3 storage class %s", class)
+		}
+	}
+	if objectLock.Mode != "" {
+		if !slices.Contains(types.ObjectLockMode("").Values(), types.ObjectLockMode(objectLock.Mode)) {
+			return nil, fmt.Errorf("unknown s3 object lock mode %s", objectLock.Mode)
+		}
+		if objectLock.Days <= 0 {
+			return nil, fmt.Errorf("s3 object lock days must be positive with mode %s", objectLock.Mode)
+		}
+	}
+	httpClient := awshttp.NewBuildableClient().WithDialerOptions(func(d *net.Dialer) {
+		if timeouts.Dial > 0 {
+			d.Timeout = timeouts.Dial
+		}
+	}).WithTransportOptions(func(tr *http.Transport) {
+		if timeouts.TLSHandshake > 0 {
+			tr.TLSHandshakeTimeout = timeouts.TLSHandshake
+		}
+		if timeouts.ResponseHeader > 0 {
+			tr.ResponseHeaderTimeout = timeouts.ResponseHeader
+		}
 		if !sslVerify {
 			if tr.TLSClientConfig == nil {
 				tr.TLSClientConfig = &tls.Config{}
@@ -95,8 +203,11 @@ func NewS3Client(ctx context.Context, url string, accessKeyID string, accessKeyS
 		return nil, err
 	}
 	realClient := s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.BaseEndpoint = aws.String(url)
-		o.UsePathStyle = true
+		// without an endpoint the SDK resolves the regional AWS endpoint itself
+		if url != "" {
+			o.BaseEndpoint = aws.String(url)
+		}
+		o.UsePathStyle = forcePathStyle
 	})
 	presignClient := s3.NewPresignClient(realClient)
 	return &S3Client{
@@ -106,13 +217,22 @@ func NewS3Client(ctx context.Context, url string, accessKeyID string, accessKeyS
 			d.PartSize = 64 * 1024 * 1024
 		}),
 		Uploader: manager.NewUploader(realClient, func(d *manager.Uploader) {
-			d.PartSize = 64 * 1024 * 1024
+			d.PartSize = partSize
 		}),
-		url:             url,
-		accessKeyID:     accessKeyID,
-		accessKeySecret: accessKeySecret,
-		bucketName:      bucketName,
-		region:          region,
+		url:                url,
+		accessKeyID:        accessKeyID,
+		accessKeySecret:    accessKeySecret,
+		bucketName:         bucketName,
+		region:             region,
+		multipartThreshold: multipartThreshold,
+		deleteBatchSize:    deleteBatchSize,
+		skipUnchanged:      skipUnchanged,
+		timeouts:           timeouts,
+		contentHeaders:     contentHeaders,
+		skipExistsCheck:    skipExistsCheck,
+		followSymlinks:     followSymlinks,
+		storageClasses:     storageClasses,
+		objectLock:         objectLock,
 	}, nil
 }
 
@@ -120,30 +240,141 @@ func (s *S3Client) CreatePresignedUrl(ctx context.Context, objectName string, ex
 	if expiration == 0 {
 		expiration = 3600
 	}
-	resp, err := s.PresignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+	fileName := path.Base(objectName)
+	input := &s3.GetObjectInput{
+		Bucket:                     aws.String(s.bucketName),
+		Key:                        aws.String(objectName),
+		ResponseContentDisposition: aws.String(fmt.Sprintf("attachment; filename=%q", fileName)),
+	}
+	// a stored type describes the decompressed content and must match the stored encoding
+	if !s.contentHeaders {
+		input.ResponseContentType = aws.String(contentType(fileName))
+	}
+	resp, err := s.PresignClient.PresignGetObject(ctx, input, func(opts *s3.PresignOptions) {
+		opts.Expires = time.Duration(expiration * int(time.Second))
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create presigned url: %w", err)
+	}
+	return resp.URL, nil
+}
+
+// CreatePresignedPutUrl presigns an upload of objectName, a client PUTs the object body to the url.
+func (s *S3Client) CreatePresignedPutUrl(ctx context.Context, objectName string, expiration int) (string, error) {
+	if expiration == 0 {
+		expiration = 3600
+	}
+	input := &s3.PutObjectInput{
 		Bucket: aws.String(s.bucketName),
 		Key:    aws.String(objectName),
-	}, func(opts *s3.PresignOptions) {
+	}
+	resp, err := s.PresignClient.PresignPutObject(ctx, input, func(opts *s3.PresignOptions) {
 		opts.Expires = time.Duration(expiration * int(time.Second))
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to create presigned url: %w", err)
+		return "", fmt.Errorf("failed to create presigned put url: %w", err)
 	}
 	return resp.URL, nil
 }
 
+// contentType picks the type a browser should save the object as, backups are mostly archives
+// whose extensions are missing from the builtin mime table.
+func contentType(fileName string) string {
+	lower := strings.ToLower(fileName)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"), strings.HasSuffix(lower, ".gz"):
+		return "application/gzip"
+	case strings.HasSuffix(lower, ".tar"):
+		return "application/x-tar"
+	case strings.HasSuffix(lower, ".zip"):
+		return "application/zip"
+	}
+	if t := mime.TypeByExtension(filepath.Ext(lower)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// objectContentHeaders returns the Content-Encoding and Content-Type to store with an object.
+// A gzip'd file is typed by its decompressed content, e.g. a .tar.gz is an application/x-tar.
+func objectContentHeaders(fileName string) (string, string) {
+	lower := strings.ToLower(fileName)
+	switch {
+	case strings.HasSuffix(lower, ".tgz"):
+		return "gzip", "application/x-tar"
+	case strings.HasSuffix(lower, ".gz"):
+		return "gzip", contentType(strings.TrimSuffix(lower, ".gz"))
+	}
+	return "", contentType(lower)
+}
+
+// withContentHeaders sets the content headers of src on input when they are enabled.
+func (s *S3Client) withContentHeaders(input *s3.PutObjectInput, src string) *s3.PutObjectInput {
+	if !s.contentHeaders {
+		return input
+	}
+	encoding, typ := objectContentHeaders(filepath.Base(src))
+	if encoding != "" {
+		input.ContentEncoding = aws.String(encoding)
+	}
+	input.ContentType = aws.String(typ)
+	return input
+}
+
+// withObjectLock sets the configured retention on input, counted from now.
+func (s *S3Client) withObjectLock(input *s3.PutObjectInput) *s3.PutObjectInput {
+	if s.objectLock.Mode == "" {
+		return input
+	}
+	input.ObjectLockMode = types.ObjectLockMode(s.objectLock.Mode)
+	input.ObjectLockRetainUntilDate = aws.Time(time.Now().UTC().AddDate(0, 0, s.objectLock.Days))
+	return input
+}
+
+// isObjectLocked reports whether S3 refused to delete an object kept by Object Lock. AWS answers
+// AccessDenied naming the object lock, S3 compatible stores answer ObjectLocked or "WORM protected".
+func isObjectLocked(code string, message string) bool {
+	message = strings.ToLower(message)
+	return code == "ObjectLocked" || strings.Contains(message, "worm protected") ||
+		(code == "AccessDenied" && strings.Contains(message, "object lock"))
+}
+
+// operationContext limits a single S3 call by the configured operation timeout.
+func (s *S3Client) operationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeouts.Operation <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.timeouts.Operation)
+}
+
+// CheckBucket verifies the bucket exists and the credentials may access it.
+func (s *S3Client) CheckBucket(ctx context.Context) error {
+	ctx, cancel := s.operationContext(ctx)
+	defer cancel()
+	if _, err := s.Client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.bucketName)}); err != nil {
+		return fmt.Errorf("failed to reach s3 bucket %s err: %w", s.bucketName, err)
+	}
+	return nil
+}
+
 func (s *S3Client) ListFiles(ctx context.Context, path string) ([]string, error) {
 	path = strings.Trim(path, "/")
 	var files []string
-	objects, err := s.Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+	// a single call returns at most 1000 keys, every page is listed within its own timeout
+	paginator := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
 		Bucket: aws.String(s.bucketName),
 		Prefix: aws.String(path),
 	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to list objects: %w", err)
-	}
-	for _, object := range objects.Contents {
-		files = append(files, *object.Key)
+	for paginator.HasMorePages() {
+		pageCtx, cancel := s.operationContext(ctx)
+		objects, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+		for _, object := range objects.Contents {
+			files = append(files, *object.Key)
+		}
 	}
 	return files, nil
 }
@@ -157,19 +388,7 @@ func (s *S3Client) uploadFolderInternal(parent context.Context, localDir string,
 
 	g.Go(func() error {
 		defer close(jobs)
-		return filepath.WalkDir(base, func(filePath string, d fs.DirEntry, err error) error {
-			if err != nil {
-				return fmt.Errorf("failed to walk path %s: %w", base, err)
-			}
-			if !d.IsDir() {
-				select {
-				case jobs <- filePath:
-				case <-ctx.Done():
-					return ctx.Err()
-				}
-			}
-			return nil
-		})
+		return s.walkFiles(ctx, base, jobs, map[string]bool{})
 	})
 
 	for i := 0; i < WorkerCount; i++ {
@@ -180,6 +399,49 @@ func (s *S3Client) uploadFolderInternal(parent context.Context, localDir string,
 	return g.Wait()
 }
 
+// walkFiles sends every file under dir to jobs. With followSymlinks a symlinked directory is
+// walked under its link path, visited holds resolved directories so a symlink loop ends.
+func (s *S3Client) walkFiles(ctx context.Context, dir string, jobs chan<- string, visited map[string]bool) error {
+	return filepath.WalkDir(dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to walk path %s: %w", dir, err)
+		}
+		if s.followSymlinks {
+			isDir := d.IsDir()
+			if d.Type()&fs.ModeSymlink != 0 {
+				info, err := os.Stat(filePath)
+				if err != nil {
+					return fmt.Errorf("failed to resolve symlink %s: %w", filePath, err)
+				}
+				isDir = info.IsDir()
+			}
+			if isDir && !d.IsDir() {
+				// WalkDir does not descend into a symlink, a trailing separator makes it resolve the link
+				return s.walkFiles(ctx, filePath+string(filepath.Separator), jobs, visited)
+			}
+			if isDir {
+				resolved, err := filepath.EvalSymlinks(filePath)
+				if err != nil {
+					return fmt.Errorf("failed to resolve path %s: %w", filePath, err)
+				}
+				if visited[resolved] {
+					return filepath.SkipDir
+				}
+				visited[resolved] = true
+				return nil
+			}
+		}
+		if !d.IsDir() {
+			select {
+			case jobs <- filepath.Clean(filePath):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+}
+
 func (s *S3Client) UploadFolder(ctx context.Context, localDir string) error {
 	return s.uploadFolderInternal(ctx, localDir, "")
 }
@@ -188,68 +450,114 @@ func (s *S3Client) UploadFolderWithPrefix(ctx context.Context, localDir, prefix
 	return s.uploadFolderInternal(ctx, localDir, prefix)
 }
 
-func (s *S3Client) DownloadFolder(ctx context.Context, s3Folder string, localDir string) error {
+func (s *S3Client) DownloadFolder(ctx context.Context, s3Folder string, localDir string, progress DownloadProgressFunc) error {
+	if progress == nil {
+		progress = func(DownloadProgress) {}
+	}
+	if len(localDir) == 0 {
+		return fmt.Errorf("%w: local dir is required", ErrUnsafeDownloadPath)
+	}
 	s3Folder = strings.Trim(s3Folder, "/")
-	objects, err := s.Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+	prefix := s3Folder
+	if len(prefix) > 0 {
+		// keep sibling folders sharing the name prefix out of the listing
+		prefix += "/"
+	}
+	listCtx, cancel := s.operationContext(ctx)
+	objects, err := s.Client.ListObjectsV2(listCtx, &s3.ListObjectsV2Input{
 		Bucket: aws.String(s.bucketName),
-		Prefix: aws.String(s3Folder),
+		Prefix: aws.String(prefix),
 	})
+	cancel()
 	if err != nil {
 		return fmt.Errorf("failed to list objects: %w", err)
 	}
 
+	var done DownloadProgress
+	for _, object := range objects.Contents {
+		if !strings.HasPrefix(aws.ToString(object.Key), "/") {
+			done.TotalObjects++
+			done.TotalBytes += aws.ToInt64(object.Size)
+		}
+	}
+	progress(done)
+
 	for _, object := range objects.Contents {
 		key := aws.ToString(object.Key)
 		if strings.HasPrefix(key, "/") {
 			continue
 		}
 
-		var target string
-		if len(localDir) == 0 {
-			target = filepath.Join("/", key)
-		} else {
-			relPath, err := filepath.Rel(s3Folder, key)
-			if err != nil {
-				return fmt.Errorf("failed to get relative path: %w", err)
-			}
-			target = filepath.Join(localDir, relPath)
+		relPath, err := filepath.Rel(s3Folder, key)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+		if !filepath.IsLocal(relPath) {
+			return fmt.Errorf("%w: key %s escapes %s", ErrUnsafeDownloadPath, key, localDir)
 		}
+		target := filepath.Join(localDir, relPath)
 		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
 			return fmt.Errorf("failed to create dir for %s: %v", target, err)
 		}
-		if err := s.downloadFile(ctx, key, target); err != nil {
+		objectStart := done.Bytes
+		err = s.downloadFile(ctx, key, target, func(n int64) {
+			done.Bytes = objectStart + n
+			progress(done)
+		})
+		if err != nil {
 			return fmt.Errorf("failed to download file: %w", err)
 		}
+		done.Objects++
+		done.Bytes = objectStart + aws.ToInt64(object.Size)
+		progress(done)
 	}
 
 	return nil
 }
 
-func (s *S3Client) uploadFile(ctx context.Context, src string, dest string) error {
-	dest = strings.Trim(dest, "/")
-	r, w := io.Pipe()
+// progressWriterAt reports the bytes written so far, the downloader writes parts concurrently.
+type progressWriterAt struct {
+	w       io.WriterAt
+	mu      sync.Mutex
+	written int64
+	report  func(int64)
+}
 
-	go func() {
-		defer w.Close()
-		file, err := os.Open(src)
-		if err != nil {
-			_ = w.CloseWithError(fmt.Errorf("failed to open file %s: %w", src, err))
-			return
-		}
-		defer file.Close()
+func (p *progressWriterAt) WriteAt(b []byte, off int64) (int, error) {
+	n, err := p.w.WriteAt(b, off)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.written += int64(n)
+	p.report(p.written)
+	return n, err
+}
 
-		// TODO change to CopyByffer?
-		_, err = io.Copy(w, file)
-		if err != nil {
-			_ = w.CloseWithError(fmt.Errorf("failed to copy file %s: %w", src, err))
-		}
-	}()
+func (s *S3Client) uploadFile(ctx context.Context, src string, dest string, metadata map[string]string, storageClass types.StorageClass) error {
+	dest = strings.Trim(dest, "/")
+	file, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", src, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file %s: %w", src, err)
+	}
 
-	_, err := s.Uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(s.bucketName),
-		Key:    aws.String(dest),
-		Body:   r,
-	})
+	// a seekable body of known length lets the SDK send every part with a Content-Length,
+	// some S3 compatible gateways reject the chunked encoding used for a plain reader
+	input := &s3.PutObjectInput{
+		Bucket:       aws.String(s.bucketName),
+		Key:          aws.String(dest),
+		Body:         struct{ io.Reader }{file},
+		Metadata:     metadata,
+		StorageClass: storageClass,
+	}
+	if info.Mode().IsRegular() {
+		input.Body = file
+		input.ContentLength = aws.Int64(info.Size())
+	}
+	_, err = s.Uploader.Upload(ctx, s.withObjectLock(s.withContentHeaders(input, src)))
 	if err != nil {
 		var apiErr smithy.APIError
 		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "EntityTooLarge" {
@@ -258,20 +566,55 @@ func (s *S3Client) uploadFile(ctx context.Context, src string, dest string) erro
 		}
 		return fmt.Errorf("couldn't upload large object to %v:%v. Here's why: %w", s.bucketName, dest, err)
 	}
-	err = s3.NewObjectExistsWaiter(s.Client).Wait(
+	return s.waitForObject(ctx, dest)
+}
+
+func (s *S3Client) putFile(ctx context.Context, src string, dest string, size int64, metadata map[string]string, storageClass types.StorageClass) error {
+	dest = strings.Trim(dest, "/")
+	file, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", src, err)
+	}
+	defer file.Close()
+
+	_, err = s.Client.PutObject(ctx, s.withObjectLock(s.withContentHeaders(&s3.PutObjectInput{
+		Bucket:        aws.String(s.bucketName),
+		Key:           aws.String(dest),
+		Body:          file,
+		ContentLength: aws.Int64(size),
+		Metadata:      metadata,
+		StorageClass:  storageClass,
+	}, src)))
+	if err != nil {
+		return fmt.Errorf("couldn't upload object to %v:%v. Here's why: %w", s.bucketName, dest, err)
+	}
+	return s.waitForObject(ctx, dest)
+}
+
+func (s *S3Client) waitForObject(ctx context.Context, key string) error {
+	if s.skipExistsCheck {
+		return nil
+	}
+	maxWait := s.timeouts.ObjectWait
+	if maxWait <= 0 {
+		maxWait = defaultObjectWait
+	}
+	err := s3.NewObjectExistsWaiter(s.Client).Wait(
 		ctx,
 		&s3.HeadObjectInput{
 			Bucket: aws.String(s.bucketName),
-			Key:    aws.String(dest),
+			Key:    aws.String(key),
 		},
-		time.Minute)
+		maxWait)
 	if err != nil {
-		return fmt.Errorf("failed attempt to wait for object %s to existхоро err: %w", dest, err)
+		return fmt.Errorf("failed attempt to wait for object %s to exist err: %w", key, err)
 	}
 	return nil
 }
 
-func (s *S3Client) downloadFile(ctx context.Context, src string, dest string) error {
+func (s *S3Client) downloadFile(ctx context.Context, src string, dest string, report func(int64)) error {
+	ctx, cancel := s.operationContext(ctx)
+	defer cancel()
 	file, err := os.Create(dest)
 	if err != nil {
 		return fmt.Errorf("failed to create file %s: %w", dest, err)
@@ -282,10 +625,15 @@ func (s *S3Client) downloadFile(ctx context.Context, src string, dest string) er
 		}
 	}()
 
-	_, err = s.Downloader.Download(ctx, file, &s3.GetObjectInput{
+	_, err = s.Downloader.Download(ctx, &progressWriterAt{w: file, report: report}, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucketName),
 		Key:    aws.String(src),
 	})
+	var archived *types.InvalidObjectState
+	if errors.As(err, &archived) {
+		return fmt.Errorf("%w: %s is in storage class %s, restore it from the archive before downloading",
+			ErrObjectArchived, src, archived.StorageClass)
+	}
 	if err != nil {
 		return fmt.Errorf("Couldn't download large object from %v:%v. Here's why: %w\n",
 			s.bucketName, src, err)
@@ -293,7 +641,21 @@ func (s *S3Client) downloadFile(ctx context.Context, src string, dest string) er
 	return nil
 }
 
+// storageClass returns the storage class of objects uploaded from the vault folder, granular
+// vaults are kept in the granular folder of the storage root.
+func (s *S3Client) storageClass(vaultFolder string) types.StorageClass {
+	class := s.storageClasses.Full
+	if s.storageClasses.Granular != "" && filepath.Base(filepath.Dir(vaultFolder)) == repo.GRANULAR {
+		class = s.storageClasses.Granular
+	}
+	if types.StorageClass(class) == types.StorageClassStandard {
+		return ""
+	}
+	return types.StorageClass(class)
+}
+
 func (s *S3Client) workerUpload(ctx context.Context, jobs <-chan string, baseDir string, prefix string) error {
+	storageClass := s.storageClass(baseDir)
 	for file := range jobs {
 		var key string
 
@@ -307,13 +669,66 @@ func (s *S3Client) workerUpload(ctx context.Context, jobs <-chan string, baseDir
 			key = path.Join(prefix, filepath.ToSlash(rel))
 		}
 
-		if err := s.uploadFile(ctx, file, key); err != nil {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("failed to stat file %s: %w", file, err)
+		}
+		var metadata map[string]string
+		if s.skipUnchanged {
+			hash, err := fileSHA256(file)
+			if err != nil {
+				return err
+			}
+			if s.isUnchanged(ctx, key, info.Size(), hash) {
+				continue
+			}
+			metadata = map[string]string{ContentHashMetadataKey: hash}
+		}
+
+		opCtx, cancel := s.operationContext(ctx)
+		// small files skip the multipart machinery, a single request is enough
+		if info.Size() < s.multipartThreshold {
+			err = s.putFile(opCtx, file, key, info.Size(), metadata, storageClass)
+		} else {
+			err = s.uploadFile(opCtx, file, key, metadata, storageClass)
+		}
+		cancel()
+		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// isUnchanged reports whether the object at key already holds a file of the given size and hash.
+// Any HeadObject error, including a missing object, means the file has to be uploaded.
+func (s *S3Client) isUnchanged(ctx context.Context, key string, size int64, hash string) bool {
+	ctx, cancel := s.operationContext(ctx)
+	defer cancel()
+	out, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(strings.Trim(key, "/")),
+	})
+	if err != nil {
+		return false
+	}
+	return aws.ToInt64(out.ContentLength) == size && out.Metadata[ContentHashMetadataKey] == hash
+}
+
+func fileSHA256(src string) (string, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file %s: %w", src, err)
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("failed to hash file %s: %w", src, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 func withContentMD5(o *s3.Options) {
 	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
 		_, _ = stack.Initialize.Remove("AWSChecksum:SetupInputContext")
@@ -331,13 +746,17 @@ func (s *S3Client) DeletePrefix(ctx context.Context, prefix string) error {
 		return fmt.Errorf("prefix is empty")
 	}
 
+	// objects kept by Object Lock are skipped, the others are still deleted
+	var locked int
 	var cont *string
 	for {
-		out, err := s.Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		listCtx, cancel := s.operationContext(ctx)
+		out, err := s.Client.ListObjectsV2(listCtx, &s3.ListObjectsV2Input{
 			Bucket:            aws.String(s.bucketName),
 			Prefix:            aws.String(prefix),
 			ContinuationToken: cont,
 		})
+		cancel()
 		if err != nil {
 			return fmt.Errorf("list objects: %w", err)
 		}
@@ -351,12 +770,32 @@ func (s *S3Client) DeletePrefix(ctx context.Context, prefix string) error {
 				objs = append(objs, types.ObjectIdentifier{Key: o.Key})
 			}
 
-			_, err = s.Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
-				Bucket: aws.String(s.bucketName),
-				Delete: &types.Delete{Objects: objs, Quiet: aws.Bool(true)},
-			}, withContentMD5)
-			if err != nil {
-				return fmt.Errorf("delete objects: %w", err)
+			batchSize := s.deleteBatchSize
+			if batchSize <= 0 || batchSize > MaxDeleteBatchSize {
+				batchSize = MaxDeleteBatchSize
+			}
+			for start := 0; start < len(objs); start += batchSize {
+				end := min(start+batchSize, len(objs))
+				deleteCtx, cancel := s.operationContext(ctx)
+				deleted, err := s.Client.DeleteObjects(deleteCtx, &s3.DeleteObjectsInput{
+					Bucket: aws.String(s.bucketName),
+					Delete: &types.Delete{Objects: objs[start:end], Quiet: aws.Bool(true)},
+				}, withContentMD5)
+				cancel()
+				if err != nil {
+					var apiErr smithy.APIError
+					if errors.As(err, &apiErr) && isObjectLocked(apiErr.ErrorCode(), apiErr.ErrorMessage()) {
+						locked += end - start
+						continue
+					}
+					return fmt.Errorf("delete objects: %w", err)
+				}
+				for _, e := range deleted.Errors {
+					if !isObjectLocked(aws.ToString(e.Code), aws.ToString(e.Message)) {
+						return fmt.Errorf("delete object %s: %s %s", aws.ToString(e.Key), aws.ToString(e.Code), aws.ToString(e.Message))
+					}
+					locked++
+				}
 			}
 		}
 
@@ -365,5 +804,8 @@ func (s *S3Client) DeletePrefix(ctx context.Context, prefix string) error {
 		}
 		cont = out.NextContinuationToken
 	}
+	if locked > 0 {
+		return fmt.Errorf("%w: %d objects under %s are retained", ErrObjectLocked, locked, prefix)
+	}
 	return nil
 }
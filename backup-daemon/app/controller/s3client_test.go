@@ -3,10 +3,20 @@ package controller
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -38,11 +48,13 @@ func TestCreatePresignedUrl(t *testing.T) {
 		expectedResponse   *v4.PresignedHTTPRequest
 		expectedError      error
 		expectedURL        string
+		expectedType       string
 	}{
 		{
 			name:               "success",
 			objectName:         "objectName",
 			expiration:         10,
+			expectedType:       "application/octet-stream",
 			expectedExpiration: time.Duration(10) * time.Second,
 			expectedResponse: &v4.PresignedHTTPRequest{
 				URL: "url",
@@ -50,6 +62,17 @@ func TestCreatePresignedUrl(t *testing.T) {
 			expectedError: nil,
 			expectedURL:   "url",
 		},
+		{
+			name:               "archive is downloaded as attachment",
+			objectName:         "backups/20250101T000000.tar.gz",
+			expiration:         10,
+			expectedExpiration: time.Duration(10) * time.Second,
+			expectedType:       "application/gzip",
+			expectedResponse: &v4.PresignedHTTPRequest{
+				URL: "url",
+			},
+			expectedURL: "url",
+		},
 		{
 			name:               "failure",
 			objectName:         "objectName",
@@ -58,6 +81,7 @@ func TestCreatePresignedUrl(t *testing.T) {
 			expectedResponse:   &v4.PresignedHTTPRequest{},
 			expectedError:      errors.New("s3 error"),
 			expectedURL:        "",
+			expectedType:       "application/octet-stream",
 		},
 	}
 
@@ -67,6 +91,7 @@ func TestCreatePresignedUrl(t *testing.T) {
 			defer ctrl.Finish()
 
 			var capturedDuration time.Duration
+			var capturedInput *s3.GetObjectInput
 
 			s3PresignClient := NewMockPresignClientInterface(ctrl)
 			s3Client := NewMockClientInterface(ctrl)
@@ -80,6 +105,7 @@ func TestCreatePresignedUrl(t *testing.T) {
 						fn(opts)
 					}
 					capturedDuration = opts.Expires
+					capturedInput = input
 					return tc.expectedResponse, tc.expectedError
 				}).AnyTimes()
 
@@ -95,6 +121,64 @@ func TestCreatePresignedUrl(t *testing.T) {
 			if capturedDuration != tc.expectedExpiration {
 				t.Fatalf("expected duration %v, got: %v", tc.expiration, capturedDuration)
 			}
+			expectedDisposition := fmt.Sprintf("attachment; filename=%q", path.Base(tc.objectName))
+			if aws.ToString(capturedInput.ResponseContentDisposition) != expectedDisposition {
+				t.Fatalf("expected content disposition %v, got: %v", expectedDisposition, aws.ToString(capturedInput.ResponseContentDisposition))
+			}
+			if aws.ToString(capturedInput.ResponseContentType) != tc.expectedType {
+				t.Fatalf("expected content type %v, got: %v", tc.expectedType, aws.ToString(capturedInput.ResponseContentType))
+			}
+		})
+	}
+}
+
+func TestCreatePresignedPutUrl(t *testing.T) {
+	testCases := []struct {
+		name               string
+		expiration         int
+		expectedExpiration time.Duration
+		presignError       error
+		expectedURL        string
+	}{
+		{name: "success", expiration: 10, expectedExpiration: 10 * time.Second, expectedURL: "url"},
+		{name: "default expiration", expectedExpiration: 3600 * time.Second, expectedURL: "url"},
+		{name: "failure", expiration: 10, expectedExpiration: 10 * time.Second, presignError: errors.New("s3 error")},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var capturedDuration time.Duration
+			s3PresignClient := NewMockPresignClientInterface(ctrl)
+			s3PresignClient.EXPECT().PresignPutObject(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, input *s3.PutObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+					if aws.ToString(input.Key) != "backups/20250101T000000/db1.dump" {
+						t.Fatalf("unexpected key %s", aws.ToString(input.Key))
+					}
+					opts := &s3.PresignOptions{}
+					for _, fn := range optFns {
+						fn(opts)
+					}
+					capturedDuration = opts.Expires
+					if tc.presignError != nil {
+						return nil, tc.presignError
+					}
+					return &v4.PresignedHTTPRequest{URL: "url", Method: "PUT"}, nil
+				})
+			s3clientRepository := NewS3ClientWithInterfaces(NewMockClientInterface(ctrl), s3PresignClient,
+				NewMockDownloaderInterface(ctrl), NewMockUploaderInterface(ctrl))
+
+			url, err := s3clientRepository.CreatePresignedPutUrl(context.Background(), "backups/20250101T000000/db1.dump", tc.expiration)
+			if !errors.Is(err, tc.presignError) {
+				t.Fatalf("expected err %v, got: %v", tc.presignError, err)
+			}
+			if url != tc.expectedURL {
+				t.Fatalf("expected url %v, got: %v", tc.expectedURL, url)
+			}
+			if capturedDuration != tc.expectedExpiration {
+				t.Fatalf("expected duration %v, got: %v", tc.expectedExpiration, capturedDuration)
+			}
 		})
 	}
 }
@@ -155,6 +239,35 @@ func TestListFiles(t *testing.T) {
 	}
 }
 
+func TestCheckBucket(t *testing.T) {
+	testCases := []struct {
+		name          string
+		headErr       error
+		expectedError error
+	}{
+		{name: "reachable"},
+		{name: "unreachable", headErr: errors.New("access denied"), expectedError: errors.New("access denied")},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			s3Client := NewMockClientInterface(ctrl)
+			s3Client.EXPECT().HeadBucket(gomock.Any(), gomock.Any(), gomock.Any()).Return(&s3.HeadBucketOutput{}, tc.headErr)
+
+			s3clientRepository := NewS3ClientWithInterfaces(s3Client, NewMockPresignClientInterface(ctrl), NewMockDownloaderInterface(ctrl), NewMockUploaderInterface(ctrl))
+			err := s3clientRepository.CheckBucket(context.Background())
+			if (err == nil) != (tc.expectedError == nil) {
+				t.Fatalf("expected err %v, got: %v", tc.expectedError, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), tc.expectedError.Error()) {
+				t.Fatalf("expected err %v, got: %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
 func TestUploadFolder(t *testing.T) {
 	testCases := []struct {
 		name                    string
@@ -238,11 +351,260 @@ func TestUploadFolder(t *testing.T) {
 	}
 }
 
+func TestUploadFolderSmallFiles(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		multipartThreshold   int64
+		expectedPutObjects   int
+		expectedManagerCalls int
+	}{
+		{
+			name:                 "below threshold uses put object",
+			multipartThreshold:   1024,
+			expectedPutObjects:   2,
+			expectedManagerCalls: 0,
+		},
+		{
+			name:                 "threshold disabled uses uploader",
+			multipartThreshold:   0,
+			expectedPutObjects:   0,
+			expectedManagerCalls: 2,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			dir := t.TempDir()
+			for _, name := range []string{"a.dump", "b.dump"} {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte("small"), 0o644); err != nil {
+					t.Fatalf("failed to write file: %v", err)
+				}
+			}
+
+			s3PresignClient := NewMockPresignClientInterface(ctrl)
+			s3Client := NewMockClientInterface(ctrl)
+			downloadClient := NewMockDownloaderInterface(ctrl)
+			uploadClient := NewMockUploaderInterface(ctrl)
+
+			s3Client.EXPECT().HeadObject(gomock.Any(), gomock.Any(), gomock.Any()).Return(&s3.HeadObjectOutput{}, nil).AnyTimes()
+			s3Client.EXPECT().PutObject(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+					if aws.ToInt64(input.ContentLength) != int64(len("small")) {
+						t.Errorf("expected content length %d, got %d", len("small"), aws.ToInt64(input.ContentLength))
+					}
+					return &s3.PutObjectOutput{}, nil
+				}).Times(tc.expectedPutObjects)
+			uploadClient.EXPECT().Upload(gomock.Any(), gomock.Any(), gomock.Any()).Return(&manager.UploadOutput{}, nil).Times(tc.expectedManagerCalls)
+
+			s3clientRepository := NewS3ClientWithInterfaces(s3Client, s3PresignClient, downloadClient, uploadClient)
+			s3clientRepository.multipartThreshold = tc.multipartThreshold
+
+			if err := s3clientRepository.UploadFolderWithPrefix(context.Background(), dir, "blob"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestUploadFolderSkipUnchanged(t *testing.T) {
+	content := []byte("small")
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	testCases := []struct {
+		name               string
+		head               *s3.HeadObjectOutput
+		headErr            error
+		expectedPutObjects int
+	}{
+		{
+			name:               "same size and hash is skipped",
+			head:               &s3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(content))), Metadata: map[string]string{ContentHashMetadataKey: hash}},
+			expectedPutObjects: 0,
+		},
+		{
+			name:               "different hash is uploaded",
+			head:               &s3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(content))), Metadata: map[string]string{ContentHashMetadataKey: "other"}},
+			expectedPutObjects: 1,
+		},
+		{
+			name:               "missing object is uploaded",
+			headErr:            &types.NotFound{},
+			expectedPutObjects: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "a.dump"), content, 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+
+			s3PresignClient := NewMockPresignClientInterface(ctrl)
+			s3Client := NewMockClientInterface(ctrl)
+			downloadClient := NewMockDownloaderInterface(ctrl)
+			uploadClient := NewMockUploaderInterface(ctrl)
+
+			s3Client.EXPECT().HeadObject(gomock.Any(), gomock.Any(), gomock.Any()).Return(tc.head, tc.headErr).Times(1)
+			s3Client.EXPECT().PutObject(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+					if input.Metadata[ContentHashMetadataKey] != hash {
+						t.Errorf("expected content hash %s, got %s", hash, input.Metadata[ContentHashMetadataKey])
+					}
+					return &s3.PutObjectOutput{}, nil
+				}).Times(tc.expectedPutObjects)
+			if tc.expectedPutObjects > 0 {
+				s3Client.EXPECT().HeadObject(gomock.Any(), gomock.Any(), gomock.Any()).Return(&s3.HeadObjectOutput{}, nil).AnyTimes()
+			}
+
+			s3clientRepository := NewS3ClientWithInterfaces(s3Client, s3PresignClient, downloadClient, uploadClient)
+			s3clientRepository.multipartThreshold = 1024
+			s3clientRepository.skipUnchanged = true
+
+			if err := s3clientRepository.UploadFolderWithPrefix(context.Background(), dir, "blob"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeletePrefixBatches(t *testing.T) {
+	testCases := []struct {
+		name            string
+		keys            int
+		deleteBatchSize int
+		expectedBatches []int
+	}{
+		{
+			name:            "keys split into batches",
+			keys:            250,
+			deleteBatchSize: 100,
+			expectedBatches: []int{100, 100, 50},
+		},
+		{
+			name:            "default batch size",
+			keys:            250,
+			deleteBatchSize: 0,
+			expectedBatches: []int{250},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			s3PresignClient := NewMockPresignClientInterface(ctrl)
+			s3Client := NewMockClientInterface(ctrl)
+			downloadClient := NewMockDownloaderInterface(ctrl)
+			uploadClient := NewMockUploaderInterface(ctrl)
+
+			contents := make([]types.Object, 0, tc.keys)
+			for i := 0; i < tc.keys; i++ {
+				contents = append(contents, types.Object{Key: aws.String(fmt.Sprintf("blob/backup/file%d", i))})
+			}
+			s3Client.EXPECT().ListObjectsV2(gomock.Any(), gomock.Any(), gomock.Any()).
+				Return(&s3.ListObjectsV2Output{Contents: contents, IsTruncated: aws.Bool(false)}, nil).Times(1)
+
+			var batches []int
+			s3Client.EXPECT().DeleteObjects(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(ctx context.Context, input *s3.DeleteObjectsInput, opts ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+					batches = append(batches, len(input.Delete.Objects))
+					return &s3.DeleteObjectsOutput{}, nil
+				}).Times(len(tc.expectedBatches))
+
+			s3clientRepository := NewS3ClientWithInterfaces(s3Client, s3PresignClient, downloadClient, uploadClient)
+			s3clientRepository.deleteBatchSize = tc.deleteBatchSize
+
+			if err := s3clientRepository.DeletePrefix(context.Background(), "blob/backup"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for i := range tc.expectedBatches {
+				if batches[i] != tc.expectedBatches[i] {
+					t.Fatalf("expected batches %v, got %v", tc.expectedBatches, batches)
+				}
+			}
+		})
+	}
+}
+
+func TestListFilesPages(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s3Client := NewMockClientInterface(ctrl)
+	gomock.InOrder(
+		s3Client.EXPECT().ListObjectsV2(gomock.Any(), gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, input *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+				if input.ContinuationToken != nil {
+					t.Fatalf("expected the first page without a token, got %s", *input.ContinuationToken)
+				}
+				return &s3.ListObjectsV2Output{
+					Contents:              []types.Object{{Key: aws.String("vault/file1.txt")}},
+					IsTruncated:           aws.Bool(true),
+					NextContinuationToken: aws.String("page-2"),
+				}, nil
+			}),
+		s3Client.EXPECT().ListObjectsV2(gomock.Any(), gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, input *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+				if aws.ToString(input.ContinuationToken) != "page-2" {
+					t.Fatalf("expected token page-2, got %v", input.ContinuationToken)
+				}
+				return &s3.ListObjectsV2Output{
+					Contents:    []types.Object{{Key: aws.String("vault/file2.txt")}},
+					IsTruncated: aws.Bool(false),
+				}, nil
+			}),
+	)
+	s3clientRepository := NewS3ClientWithInterfaces(s3Client, NewMockPresignClientInterface(ctrl), NewMockDownloaderInterface(ctrl), NewMockUploaderInterface(ctrl))
+
+	files, err := s3clientRepository.ListFiles(context.Background(), "vault")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(files, ",") != "vault/file1.txt,vault/file2.txt" {
+		t.Fatalf("expected the keys of both pages, got %v", files)
+	}
+}
+
+func TestListFilesOperationTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s3PresignClient := NewMockPresignClientInterface(ctrl)
+	s3Client := NewMockClientInterface(ctrl)
+	downloadClient := NewMockDownloaderInterface(ctrl)
+	uploadClient := NewMockUploaderInterface(ctrl)
+
+	// a hung endpoint never answers, only the context can release the call
+	s3Client.EXPECT().ListObjectsV2(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, input *s3.ListObjectsV2Input, opts ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}).Times(1)
+
+	s3clientRepository := NewS3ClientWithInterfaces(s3Client, s3PresignClient, downloadClient, uploadClient)
+	s3clientRepository.timeouts = S3Timeouts{Operation: 100 * time.Millisecond}
+
+	start := time.Now()
+	_, err := s3clientRepository.ListFiles(context.Background(), "blob/backup")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected call to fail fast, took %v", elapsed)
+	}
+}
+
 func TestDownloadFolder(t *testing.T) {
 	testCases := []struct {
 		name                       string
 		s3Folder                   string
-		localDir                   string
+		emptyLocalDir              bool
 		expectedGetObjectError     error
 		expectedGetObjectResponse  *s3.GetObjectOutput
 		expectedListObjectResponse *s3.ListObjectsV2Output
@@ -253,7 +615,6 @@ func TestDownloadFolder(t *testing.T) {
 		{
 			name:                   "success",
 			s3Folder:               "./",
-			localDir:               "",
 			expectedGetObjectError: nil,
 			expectedGetObjectResponse: &s3.GetObjectOutput{
 				Body: io.NopCloser(bytes.NewReader([]byte("file content"))),
@@ -269,10 +630,16 @@ func TestDownloadFolder(t *testing.T) {
 			expectedListObjectError: nil,
 			expectedDownloadError:   nil,
 		},
+		{
+			name:                       "empty local dir",
+			s3Folder:                   "./",
+			emptyLocalDir:              true,
+			expectedListObjectResponse: &s3.ListObjectsV2Output{},
+			expectedError:              ErrUnsafeDownloadPath,
+		},
 		{
 			name:                       "list objects error",
 			s3Folder:                   "./",
-			localDir:                   "",
 			expectedGetObjectError:     nil,
 			expectedGetObjectResponse:  nil,
 			expectedListObjectError:    errors.New("s3 error"),
@@ -283,7 +650,6 @@ func TestDownloadFolder(t *testing.T) {
 		{
 			name:                   "success 2",
 			s3Folder:               "./",
-			localDir:               "./",
 			expectedGetObjectError: nil,
 			expectedGetObjectResponse: &s3.GetObjectOutput{
 				Body: io.NopCloser(bytes.NewReader([]byte("file content"))),
@@ -299,17 +665,29 @@ func TestDownloadFolder(t *testing.T) {
 			expectedListObjectError: nil,
 			expectedDownloadError:   nil,
 		},
+		{
+			name:                      "key escaping local dir",
+			s3Folder:                  "folder",
+			expectedGetObjectError:    nil,
+			expectedGetObjectResponse: nil,
+			expectedError:             ErrUnsafeDownloadPath,
+			expectedListObjectResponse: &s3.ListObjectsV2Output{
+				Contents: []types.Object{
+					{Key: aws.String("folder/../../file1.txt")},
+				},
+			},
+			expectedListObjectError: nil,
+			expectedDownloadError:   nil,
+		},
 		{
 			name:                      "fail to relative",
-			s3Folder:                  `C:\\folder`,
-			localDir:                  "./",
+			s3Folder:                  "../folder",
 			expectedGetObjectError:    nil,
 			expectedGetObjectResponse: nil,
-			expectedError:             errors.New(`Rel: can't make file1.txt relative to C:\\folder`),
+			expectedError:             errors.New("Rel: can't make file1.txt relative to ../folder"),
 			expectedListObjectResponse: &s3.ListObjectsV2Output{
 				Contents: []types.Object{
 					{Key: aws.String("file1.txt")},
-					{Key: aws.String("./file2.txt")},
 				},
 			},
 			expectedListObjectError: nil,
@@ -318,7 +696,6 @@ func TestDownloadFolder(t *testing.T) {
 		{
 			name:                   "downlaod error",
 			s3Folder:               "./",
-			localDir:               "./",
 			expectedGetObjectError: nil,
 			expectedGetObjectResponse: &s3.GetObjectOutput{
 				Body: io.NopCloser(bytes.NewReader([]byte("file content"))),
@@ -349,7 +726,7 @@ func TestDownloadFolder(t *testing.T) {
 			s3Client.EXPECT().
 				ListObjectsV2(gomock.Any(), gomock.Any(), gomock.Any()).
 				Return(tc.expectedListObjectResponse, tc.expectedListObjectError).
-				Times(1)
+				MaxTimes(1)
 
 			s3Client.EXPECT().
 				GetObject(gomock.Any(), gomock.Any(), gomock.Any()).
@@ -365,7 +742,11 @@ func TestDownloadFolder(t *testing.T) {
 
 			s3clientRepository := NewS3ClientWithInterfaces(s3Client, s3PresignClient, downloadClient, uploadClient)
 
-			err := s3clientRepository.DownloadFolder(context.Background(), tc.s3Folder, tc.localDir)
+			localDir := t.TempDir()
+			if tc.emptyLocalDir {
+				localDir = ""
+			}
+			err := s3clientRepository.DownloadFolder(context.Background(), tc.s3Folder, localDir, nil)
 
 			if tc.expectedError != nil {
 				if !strings.Contains(err.Error(), tc.expectedError.Error()) {
@@ -377,6 +758,486 @@ func TestDownloadFolder(t *testing.T) {
 				t.Fatalf("expected get object error %v, got: %v", tc.expectedGetObjectError, err)
 			} else if tc.expectedDownloadError != nil && !errors.Is(err, tc.expectedDownloadError) {
 				t.Fatalf("expected download error %v, got: %v", tc.expectedDownloadError, err)
+			} else if tc.expectedDownloadError == nil {
+				if _, err := os.Stat(filepath.Join(localDir, "file1.txt")); err != nil {
+					t.Fatalf("expected file1.txt in the local dir: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadFolderContentHeaders(t *testing.T) {
+	testCases := []struct {
+		name             string
+		contentHeaders   bool
+		expectedEncoding map[string]string
+		expectedType     map[string]string
+	}{
+		{
+			name:             "disabled",
+			expectedEncoding: map[string]string{"blob/a.tar.gz": "", "blob/b.tar": "", "blob/c.dump": ""},
+			expectedType:     map[string]string{"blob/a.tar.gz": "", "blob/b.tar": "", "blob/c.dump": ""},
+		},
+		{
+			name:             "enabled",
+			contentHeaders:   true,
+			expectedEncoding: map[string]string{"blob/a.tar.gz": "gzip", "blob/b.tar": "", "blob/c.dump": ""},
+			expectedType:     map[string]string{"blob/a.tar.gz": "application/x-tar", "blob/b.tar": "application/x-tar", "blob/c.dump": "application/octet-stream"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			dir := t.TempDir()
+			for _, name := range []string{"a.tar.gz", "b.tar", "c.dump"} {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte("small"), 0o644); err != nil {
+					t.Fatalf("failed to write file: %v", err)
+				}
+			}
+
+			s3PresignClient := NewMockPresignClientInterface(ctrl)
+			s3Client := NewMockClientInterface(ctrl)
+			downloadClient := NewMockDownloaderInterface(ctrl)
+			uploadClient := NewMockUploaderInterface(ctrl)
+
+			var mu sync.Mutex
+			encodings := map[string]string{}
+			contentTypes := map[string]string{}
+			s3Client.EXPECT().HeadObject(gomock.Any(), gomock.Any(), gomock.Any()).Return(&s3.HeadObjectOutput{}, nil).AnyTimes()
+			s3Client.EXPECT().PutObject(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+					mu.Lock()
+					defer mu.Unlock()
+					encodings[aws.ToString(input.Key)] = aws.ToString(input.ContentEncoding)
+					contentTypes[aws.ToString(input.Key)] = aws.ToString(input.ContentType)
+					return &s3.PutObjectOutput{}, nil
+				}).Times(3)
+
+			s3clientRepository := NewS3ClientWithInterfaces(s3Client, s3PresignClient, downloadClient, uploadClient)
+			s3clientRepository.multipartThreshold = 1024
+			s3clientRepository.contentHeaders = tc.contentHeaders
+
+			if err := s3clientRepository.UploadFolderWithPrefix(context.Background(), dir, "blob"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(encodings, tc.expectedEncoding) {
+				t.Fatalf("expected content encodings %v, got %v", tc.expectedEncoding, encodings)
+			}
+			if !reflect.DeepEqual(contentTypes, tc.expectedType) {
+				t.Fatalf("expected content types %v, got %v", tc.expectedType, contentTypes)
+			}
+		})
+	}
+}
+
+func TestUploadFolderSkipExistsCheck(t *testing.T) {
+	testCases := []struct {
+		name            string
+		skipExistsCheck bool
+		expectedHeads   int
+	}{
+		{name: "check enabled", expectedHeads: 2},
+		{name: "check skipped", skipExistsCheck: true, expectedHeads: 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			dir := t.TempDir()
+			for _, name := range []string{"a.dump", "b.dump"} {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte("small"), 0o644); err != nil {
+					t.Fatalf("failed to write file: %v", err)
+				}
+			}
+
+			s3PresignClient := NewMockPresignClientInterface(ctrl)
+			s3Client := NewMockClientInterface(ctrl)
+			downloadClient := NewMockDownloaderInterface(ctrl)
+			uploadClient := NewMockUploaderInterface(ctrl)
+
+			s3Client.EXPECT().PutObject(gomock.Any(), gomock.Any(), gomock.Any()).Return(&s3.PutObjectOutput{}, nil).Times(2)
+			s3Client.EXPECT().HeadObject(gomock.Any(), gomock.Any(), gomock.Any()).Return(&s3.HeadObjectOutput{}, nil).Times(tc.expectedHeads)
+
+			s3clientRepository := NewS3ClientWithInterfaces(s3Client, s3PresignClient, downloadClient, uploadClient)
+			s3clientRepository.multipartThreshold = 1024
+			s3clientRepository.skipExistsCheck = tc.skipExistsCheck
+			s3clientRepository.timeouts.ObjectWait = 5 * time.Second
+
+			if err := s3clientRepository.UploadFolderWithPrefix(context.Background(), dir, "blob"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestUploadFolderFollowSymlinks(t *testing.T) {
+	testCases := []struct {
+		name           string
+		followSymlinks bool
+		expectedKeys   []string
+		expectErr      bool
+	}{
+		{name: "disabled", expectErr: true},
+		{name: "enabled", followSymlinks: true, expectedKeys: []string{"blob/data/table.dump", "blob/meta.json"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			dir := t.TempDir()
+			target := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "meta.json"), []byte("{}"), 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(target, "table.dump"), []byte("dump"), 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+			if err := os.Symlink(target, filepath.Join(dir, "data")); err != nil {
+				t.Fatalf("failed to create symlink: %v", err)
+			}
+			// a link back to the vault must not be walked again
+			if err := os.Symlink(dir, filepath.Join(target, "loop")); err != nil {
+				t.Fatalf("failed to create symlink: %v", err)
+			}
+
+			s3PresignClient := NewMockPresignClientInterface(ctrl)
+			s3Client := NewMockClientInterface(ctrl)
+			downloadClient := NewMockDownloaderInterface(ctrl)
+			uploadClient := NewMockUploaderInterface(ctrl)
+
+			var mu sync.Mutex
+			var keys []string
+			s3Client.EXPECT().HeadObject(gomock.Any(), gomock.Any(), gomock.Any()).Return(&s3.HeadObjectOutput{}, nil).AnyTimes()
+			s3Client.EXPECT().PutObject(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+					if _, err := io.Copy(io.Discard, input.Body); err != nil {
+						return nil, err
+					}
+					mu.Lock()
+					defer mu.Unlock()
+					keys = append(keys, aws.ToString(input.Key))
+					return &s3.PutObjectOutput{}, nil
+				}).AnyTimes()
+
+			s3clientRepository := NewS3ClientWithInterfaces(s3Client, s3PresignClient, downloadClient, uploadClient)
+			s3clientRepository.multipartThreshold = 1 << 20
+			s3clientRepository.followSymlinks = tc.followSymlinks
+
+			err := s3clientRepository.UploadFolderWithPrefix(context.Background(), dir, "blob")
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+			}
+			if tc.expectErr {
+				return
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tc.expectedKeys) {
+				t.Fatalf("expected keys %v, got %v", tc.expectedKeys, keys)
+			}
+		})
+	}
+}
+
+func TestUploadFolderStorageClass(t *testing.T) {
+	testCases := []struct {
+		name           string
+		storageClasses S3StorageClasses
+		granular       bool
+		expectedClass  types.StorageClass
+	}{
+		{name: "standard is not sent", storageClasses: S3StorageClasses{Full: "STANDARD"}},
+		{name: "full class", storageClasses: S3StorageClasses{Full: "STANDARD_IA"}, expectedClass: types.StorageClassStandardIa},
+		{name: "granular uses full class", storageClasses: S3StorageClasses{Full: "STANDARD_IA"}, granular: true, expectedClass: types.StorageClassStandardIa},
+		{name: "granular override", storageClasses: S3StorageClasses{Full: "STANDARD", Granular: "GLACIER"}, granular: true, expectedClass: types.StorageClassGlacier},
+		{name: "granular override skips full", storageClasses: S3StorageClasses{Full: "STANDARD", Granular: "GLACIER"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			dir := filepath.Join(t.TempDir(), "20240101T000000")
+			if tc.granular {
+				dir = filepath.Join(filepath.Dir(dir), "granular", "20240101T000000")
+			}
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				t.Fatalf("failed to create vault: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "db.dump"), []byte("small"), 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+
+			s3PresignClient := NewMockPresignClientInterface(ctrl)
+			s3Client := NewMockClientInterface(ctrl)
+			downloadClient := NewMockDownloaderInterface(ctrl)
+			uploadClient := NewMockUploaderInterface(ctrl)
+
+			var storageClass types.StorageClass
+			s3Client.EXPECT().HeadObject(gomock.Any(), gomock.Any(), gomock.Any()).Return(&s3.HeadObjectOutput{}, nil).AnyTimes()
+			s3Client.EXPECT().PutObject(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+					storageClass = input.StorageClass
+					return &s3.PutObjectOutput{}, nil
+				}).Times(1)
+
+			s3clientRepository := NewS3ClientWithInterfaces(s3Client, s3PresignClient, downloadClient, uploadClient)
+			s3clientRepository.multipartThreshold = 1024
+			s3clientRepository.storageClasses = tc.storageClasses
+
+			if err := s3clientRepository.UploadFolderWithPrefix(context.Background(), dir, "blob"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if storageClass != tc.expectedClass {
+				t.Fatalf("expected storage class %q, got %q", tc.expectedClass, storageClass)
+			}
+		})
+	}
+}
+
+func TestUploadFolderObjectLock(t *testing.T) {
+	testCases := []struct {
+		name         string
+		objectLock   S3ObjectLock
+		expectedMode types.ObjectLockMode
+	}{
+		{name: "no object lock"},
+		{name: "compliance", objectLock: S3ObjectLock{Mode: "COMPLIANCE", Days: 30}, expectedMode: types.ObjectLockModeCompliance},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			dir := filepath.Join(t.TempDir(), "20240101T000000")
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				t.Fatalf("failed to create vault: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "db.dump"), []byte("small"), 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+
+			s3Client := NewMockClientInterface(ctrl)
+			var input *s3.PutObjectInput
+			s3Client.EXPECT().HeadObject(gomock.Any(), gomock.Any(), gomock.Any()).Return(&s3.HeadObjectOutput{}, nil).AnyTimes()
+			s3Client.EXPECT().PutObject(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(ctx context.Context, in *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+					input = in
+					return &s3.PutObjectOutput{}, nil
+				}).Times(1)
+
+			s3clientRepository := NewS3ClientWithInterfaces(s3Client, NewMockPresignClientInterface(ctrl), NewMockDownloaderInterface(ctrl), NewMockUploaderInterface(ctrl))
+			s3clientRepository.multipartThreshold = 1024
+			s3clientRepository.objectLock = tc.objectLock
+
+			if err := s3clientRepository.UploadFolderWithPrefix(context.Background(), dir, "blob"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if input.ObjectLockMode != tc.expectedMode {
+				t.Fatalf("expected object lock mode %q, got %q", tc.expectedMode, input.ObjectLockMode)
+			}
+			if tc.expectedMode == "" {
+				if input.ObjectLockRetainUntilDate != nil {
+					t.Fatalf("expected no retention, got %v", input.ObjectLockRetainUntilDate)
+				}
+				return
+			}
+			minRetain := time.Now().AddDate(0, 0, tc.objectLock.Days-1)
+			if input.ObjectLockRetainUntilDate == nil || input.ObjectLockRetainUntilDate.Before(minRetain) {
+				t.Fatalf("expected retention of %d days, got %v", tc.objectLock.Days, input.ObjectLockRetainUntilDate)
+			}
+		})
+	}
+}
+
+func TestUploadFolderContentLength(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir := t.TempDir()
+	content := []byte("large enough for a multipart upload")
+	if err := os.WriteFile(filepath.Join(dir, "db.dump"), content, 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	s3Client := NewMockClientInterface(ctrl)
+	s3Client.EXPECT().HeadObject(gomock.Any(), gomock.Any(), gomock.Any()).Return(&s3.HeadObjectOutput{}, nil).AnyTimes()
+	uploadClient := NewMockUploaderInterface(ctrl)
+	var input *s3.PutObjectInput
+	uploadClient.EXPECT().Upload(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, in *s3.PutObjectInput, opts ...func(*manager.Uploader)) (*manager.UploadOutput, error) {
+			input = in
+			return &manager.UploadOutput{}, nil
+		}).Times(1)
+
+	s3clientRepository := NewS3ClientWithInterfaces(s3Client, NewMockPresignClientInterface(ctrl), NewMockDownloaderInterface(ctrl), uploadClient)
+	if err := s3clientRepository.UploadFolderWithPrefix(context.Background(), dir, "blob"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if aws.ToInt64(input.ContentLength) != int64(len(content)) {
+		t.Fatalf("expected content length %d, got %v", len(content), input.ContentLength)
+	}
+	if _, ok := input.Body.(io.Seeker); !ok {
+		t.Fatalf("expected a seekable body, got %T", input.Body)
+	}
+}
+
+func TestDeletePrefixObjectLock(t *testing.T) {
+	testCases := []struct {
+		name          string
+		output        *s3.DeleteObjectsOutput
+		deleteErr     error
+		expectedError error
+	}{
+		{name: "deleted", output: &s3.DeleteObjectsOutput{}},
+		{name: "worm protected objects", output: &s3.DeleteObjectsOutput{Errors: []types.Error{
+			{Key: aws.String("blob/backup/file0"), Code: aws.String("AccessDenied"), Message: aws.String("Object is WORM protected and cannot be overwritten")},
+		}}, expectedError: ErrObjectLocked},
+		{name: "object lock denies the request", deleteErr: &smithy.GenericAPIError{Code: "AccessDenied", Message: "Access Denied because object protected by object lock."},
+			expectedError: ErrObjectLocked},
+		{name: "other object error", output: &s3.DeleteObjectsOutput{Errors: []types.Error{
+			{Key: aws.String("blob/backup/file0"), Code: aws.String("InternalError"), Message: aws.String("We encountered an internal error")},
+		}}, expectedError: errors.New("InternalError")},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			s3Client := NewMockClientInterface(ctrl)
+			s3Client.EXPECT().ListObjectsV2(gomock.Any(), gomock.Any(), gomock.Any()).
+				Return(&s3.ListObjectsV2Output{Contents: []types.Object{{Key: aws.String("blob/backup/file0")}}}, nil)
+			s3Client.EXPECT().DeleteObjects(gomock.Any(), gomock.Any(), gomock.Any()).Return(tc.output, tc.deleteErr)
+
+			s3clientRepository := NewS3ClientWithInterfaces(s3Client, NewMockPresignClientInterface(ctrl), NewMockDownloaderInterface(ctrl), NewMockUploaderInterface(ctrl))
+			err := s3clientRepository.DeletePrefix(context.Background(), "blob/backup")
+			if tc.expectedError == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !(errors.Is(err, tc.expectedError) || strings.Contains(err.Error(), tc.expectedError.Error())) {
+				t.Fatalf("expected err %v, got: %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestDownloadFolderProgress(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s3Client := NewMockClientInterface(ctrl)
+	downloadClient := NewMockDownloaderInterface(ctrl)
+	s3Client.EXPECT().ListObjectsV2(gomock.Any(), gomock.Any(), gomock.Any()).Return(&s3.ListObjectsV2Output{
+		Contents: []types.Object{
+			{Key: aws.String("vault/db1.dump"), Size: aws.Int64(6)},
+			{Key: aws.String("vault/db2.dump"), Size: aws.Int64(4)},
+		},
+	}, nil)
+	downloadClient.EXPECT().Download(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, w io.WriterAt, input *s3.GetObjectInput, _ ...func(*manager.Downloader)) (int64, error) {
+			if aws.ToString(input.Key) == "vault/db2.dump" {
+				n, err := w.WriteAt([]byte("four"), 0)
+				return int64(n), err
+			}
+			// the first object arrives in two parts
+			if _, err := w.WriteAt([]byte("abc"), 0); err != nil {
+				return 0, err
+			}
+			n, err := w.WriteAt([]byte("def"), 3)
+			return int64(3 + n), err
+		}).Times(2)
+
+	var reports []DownloadProgress
+	s3clientRepository := NewS3ClientWithInterfaces(s3Client, NewMockPresignClientInterface(ctrl), downloadClient, NewMockUploaderInterface(ctrl))
+	err := s3clientRepository.DownloadFolder(context.Background(), "vault", t.TempDir(), func(p DownloadProgress) {
+		reports = append(reports, p)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []DownloadProgress{
+		{TotalObjects: 2, TotalBytes: 10},
+		{Bytes: 3, TotalObjects: 2, TotalBytes: 10},
+		{Bytes: 6, TotalObjects: 2, TotalBytes: 10},
+		{Objects: 1, Bytes: 6, TotalObjects: 2, TotalBytes: 10},
+		{Objects: 1, Bytes: 10, TotalObjects: 2, TotalBytes: 10},
+		{Objects: 2, Bytes: 10, TotalObjects: 2, TotalBytes: 10},
+	}
+	if !reflect.DeepEqual(reports, expected) {
+		t.Fatalf("expected progress %v, got %v", expected, reports)
+	}
+}
+
+func TestDownloadFolderArchived(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s3PresignClient := NewMockPresignClientInterface(ctrl)
+	s3Client := NewMockClientInterface(ctrl)
+	downloadClient := NewMockDownloaderInterface(ctrl)
+	uploadClient := NewMockUploaderInterface(ctrl)
+
+	s3Client.EXPECT().ListObjectsV2(gomock.Any(), gomock.Any(), gomock.Any()).Return(&s3.ListObjectsV2Output{
+		Contents: []types.Object{{Key: aws.String("vault/db.dump")}},
+	}, nil)
+	downloadClient.EXPECT().Download(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(int64(0), &types.InvalidObjectState{StorageClass: types.StorageClassGlacier})
+
+	s3clientRepository := NewS3ClientWithInterfaces(s3Client, s3PresignClient, downloadClient, uploadClient)
+	err := s3clientRepository.DownloadFolder(context.Background(), "vault", t.TempDir(), nil)
+	if !errors.Is(err, ErrObjectArchived) {
+		t.Fatalf("expected error %v, got %v", ErrObjectArchived, err)
+	}
+	if !strings.Contains(err.Error(), "GLACIER") {
+		t.Fatalf("expected the storage class in the error, got %v", err)
+	}
+}
+
+// BenchmarkUploadFolderSmallFiles uploads a backup of many small files to a local S3 stub through
+// the real SDK client, with the PutObject fast path and with every file going through the uploader.
+func BenchmarkUploadFolderSmallFiles(b *testing.B) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	dir := b.TempDir()
+	content := bytes.Repeat([]byte("x"), 4*1024)
+	for i := 0; i < 200; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("table_%03d.dump", i)), content, 0o644); err != nil {
+			b.Fatalf("failed to write file: %v", err)
+		}
+	}
+	benchmarks := []struct {
+		name               string
+		multipartThreshold int64
+	}{
+		{name: "put object", multipartThreshold: 8 * 1024 * 1024},
+		{name: "uploader", multipartThreshold: 0},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			client, err := NewS3Client(context.Background(), server.URL, "key", "secret", "bucket", "us-east-1", false, true,
+				manager.DefaultUploadPartSize, bm.multipartThreshold, MaxDeleteBatchSize, false, false, true, false,
+				S3StorageClasses{}, S3ObjectLock{}, S3Timeouts{})
+			if err != nil {
+				b.Fatalf("failed to create s3 client: %v", err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := client.UploadFolderWithPrefix(context.Background(), dir, "blob"); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
 			}
 		})
 	}
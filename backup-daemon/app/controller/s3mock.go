@@ -38,6 +38,35 @@ func (m *MockS3ClientRepository) EXPECT() *MockS3ClientRepositoryMockRecorder {
 	return m.recorder
 }
 
+// CheckBucket mocks base method.
+func (m *MockS3ClientRepository) CheckBucket(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckBucket", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CheckBucket indicates an expected call of CheckBucket.
+func (mr *MockS3ClientRepositoryMockRecorder) CheckBucket(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckBucket", reflect.TypeOf((*MockS3ClientRepository)(nil).CheckBucket), ctx)
+}
+
+// CreatePresignedPutUrl mocks base method.
+func (m *MockS3ClientRepository) CreatePresignedPutUrl(ctx context.Context, objectName string, expiration int) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreatePresignedPutUrl", ctx, objectName, expiration)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreatePresignedPutUrl indicates an expected call of CreatePresignedPutUrl.
+func (mr *MockS3ClientRepositoryMockRecorder) CreatePresignedPutUrl(ctx, objectName, expiration interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreatePresignedPutUrl", reflect.TypeOf((*MockS3ClientRepository)(nil).CreatePresignedPutUrl), ctx, objectName, expiration)
+}
+
 // CreatePresignedUrl mocks base method.
 func (m *MockS3ClientRepository) CreatePresignedUrl(ctx context.Context, objectName string, expiration int) (string, error) {
 	m.ctrl.T.Helper()
@@ -68,17 +97,17 @@ func (mr *MockS3ClientRepositoryMockRecorder) DeletePrefix(ctx, prefix interface
 }
 
 // DownloadFolder mocks base method.
-func (m *MockS3ClientRepository) DownloadFolder(ctx context.Context, s3Folder, localDir string) error {
+func (m *MockS3ClientRepository) DownloadFolder(ctx context.Context, s3Folder, localDir string, progress DownloadProgressFunc) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DownloadFolder", ctx, s3Folder, localDir)
+	ret := m.ctrl.Call(m, "DownloadFolder", ctx, s3Folder, localDir, progress)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DownloadFolder indicates an expected call of DownloadFolder.
-func (mr *MockS3ClientRepositoryMockRecorder) DownloadFolder(ctx, s3Folder, localDir interface{}) *gomock.Call {
+func (mr *MockS3ClientRepositoryMockRecorder) DownloadFolder(ctx, s3Folder, localDir, progress interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadFolder", reflect.TypeOf((*MockS3ClientRepository)(nil).DownloadFolder), ctx, s3Folder, localDir)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadFolder", reflect.TypeOf((*MockS3ClientRepository)(nil).DownloadFolder), ctx, s3Folder, localDir, progress)
 }
 
 // ListFiles mocks base method.
@@ -167,6 +196,26 @@ func (mr *MockPresignClientInterfaceMockRecorder) PresignGetObject(ctx, params i
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PresignGetObject", reflect.TypeOf((*MockPresignClientInterface)(nil).PresignGetObject), varargs...)
 }
 
+// PresignPutObject mocks base method.
+func (m *MockPresignClientInterface) PresignPutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, params}
+	for _, a := range optFns {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "PresignPutObject", varargs...)
+	ret0, _ := ret[0].(*v4.PresignedHTTPRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PresignPutObject indicates an expected call of PresignPutObject.
+func (mr *MockPresignClientInterfaceMockRecorder) PresignPutObject(ctx, params interface{}, optFns ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, params}, optFns...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PresignPutObject", reflect.TypeOf((*MockPresignClientInterface)(nil).PresignPutObject), varargs...)
+}
+
 // MockDownloaderInterface is a mock of DownloaderInterface interface.
 type MockDownloaderInterface struct {
 	ctrl     *gomock.Controller
@@ -376,6 +425,26 @@ func (mr *MockClientInterfaceMockRecorder) GetObject(arg0, arg1 interface{}, arg
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetObject", reflect.TypeOf((*MockClientInterface)(nil).GetObject), varargs...)
 }
 
+// HeadBucket mocks base method.
+func (m *MockClientInterface) HeadBucket(arg0 context.Context, arg1 *s3.HeadBucketInput, arg2 ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "HeadBucket", varargs...)
+	ret0, _ := ret[0].(*s3.HeadBucketOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HeadBucket indicates an expected call of HeadBucket.
+func (mr *MockClientInterfaceMockRecorder) HeadBucket(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HeadBucket", reflect.TypeOf((*MockClientInterface)(nil).HeadBucket), varargs...)
+}
+
 // HeadObject mocks base method.
 func (m *MockClientInterface) HeadObject(arg0 context.Context, arg1 *s3.HeadObjectInput, arg2 ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
 	m.ctrl.T.Helper()
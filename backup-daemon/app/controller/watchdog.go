@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/repo"
+	"go.uber.org/zap"
+)
+
+// staleBackupEvent is the event of a backup type whose latest successful backup got older than the max age.
+const staleBackupEvent = "stale_backup"
+
+// BackupAgeWatchdog periodically checks the age of the latest successful backup of each type and reports
+// a type once it gets older than maxAge, to the webhook when one is set, zero maxAge reports none. Types
+// never backed up are skipped. The ages of the last check are kept for LastAges.
+type BackupAgeWatchdog struct {
+	backupDaemon BackupDaemonUseCase
+	maxAge       time.Duration
+	interval     time.Duration
+	webhookURL   string
+	client       *http.Client
+	logger       *zap.SugaredLogger
+
+	// stale keeps a type reported until a new backup of it succeeds
+	stale map[string]bool
+
+	// mu guards ages and checkedAt, the result of the last successful check
+	mu        sync.Mutex
+	ages      *entity.BackupAges
+	checkedAt time.Time
+}
+
+func NewBackupAgeWatchdog(backupDaemon BackupDaemonUseCase, maxAge time.Duration, interval time.Duration,
+	webhookURL string, logger *zap.SugaredLogger) *BackupAgeWatchdog {
+	return &BackupAgeWatchdog{
+		backupDaemon: backupDaemon,
+		maxAge:       maxAge,
+		interval:     interval,
+		webhookURL:   webhookURL,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		logger:       logger,
+		stale:        map[string]bool{},
+	}
+}
+
+func (w *BackupAgeWatchdog) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		w.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *BackupAgeWatchdog) Check(ctx context.Context) {
+	ages, err := w.backupDaemon.BackupAges()
+	if err != nil {
+		w.logger.Warnf("skip backup age check, failed to get backup ages err: %v", err)
+		return
+	}
+	w.mu.Lock()
+	w.ages, w.checkedAt = &ages, time.Now()
+	w.mu.Unlock()
+	if w.maxAge <= 0 {
+		return
+	}
+	maxAge := int64(w.maxAge / time.Second)
+	for typeOfBackup, age := range map[string]*int64{repo.FULL: ages.Full, repo.GRANULAR: ages.Granular} {
+		if age == nil {
+			continue
+		}
+		stale := *age > maxAge
+		if stale && !w.stale[typeOfBackup] {
+			w.logger.Errorf("Latest successful %s backup is %s old, over the max backup age %s",
+				typeOfBackup, time.Duration(*age)*time.Second, w.maxAge)
+			event := entity.StaleBackupEvent{Event: staleBackupEvent, Type: typeOfBackup, AgeSeconds: *age, MaxAgeSeconds: maxAge}
+			if err := w.notify(ctx, event); err != nil {
+				w.logger.Errorf("failed to notify stale %s backup err: %v", typeOfBackup, err)
+			}
+		}
+		w.stale[typeOfBackup] = stale
+	}
+}
+
+// LastAges returns the ages of the last check grown by the time since, false before a check succeeded.
+func (w *BackupAgeWatchdog) LastAges() (entity.BackupAges, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.ages == nil {
+		return entity.BackupAges{}, false
+	}
+	elapsed := int64(time.Since(w.checkedAt) / time.Second)
+	grow := func(age *int64) *int64 {
+		if age == nil {
+			return nil
+		}
+		grown := *age + elapsed
+		return &grown
+	}
+	return entity.BackupAges{Full: grow(w.ages.Full), Granular: grow(w.ages.Granular)}, true
+}
+
+func (w *BackupAgeWatchdog) notify(ctx context.Context, event entity.StaleBackupEvent) error {
+	if w.webhookURL == "" {
+		return nil
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event err: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request err: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post webhook err: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/repo"
+	"go.uber.org/zap"
+)
+
+func TestBackupAgeWatchdog(t *testing.T) {
+	root := t.TempDir()
+	for name, metrics := range map[string]string{
+		"20240101T000000": `{"exit_code": 0}`,
+		"20240102T000000": `{"exit_code": 1}`,
+	} {
+		if err := os.MkdirAll(filepath.Join(root, name), 0o755); err != nil {
+			t.Fatalf("failed to create vault: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name, ".metrics"), []byte(metrics), 0o644); err != nil {
+			t.Fatalf("failed to write metrics: %v", err)
+		}
+	}
+	b := &BackupDaemon{
+		storageRepo: repo.NewStorageRepo(root, "", "", false, false, nil, nil),
+		logger:      zap.NewNop().Sugar(),
+	}
+
+	ages, err := b.BackupAges()
+	if err != nil {
+		t.Fatalf("BackupAges failed: %v", err)
+	}
+	created, _ := time.Parse(repo.VaultNameFormat, "20240101T000000")
+	if ages.Full == nil || time.Duration(*ages.Full)*time.Second < time.Since(created)-time.Minute {
+		t.Fatalf("expected the age of the successful full backup, got %v", ages.Full)
+	}
+	if ages.Granular != nil {
+		t.Fatalf("expected no granular backup age, got %d", *ages.Granular)
+	}
+
+	var events []entity.StaleBackupEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event entity.StaleBackupEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("failed to decode event: %v", err)
+		}
+		events = append(events, event)
+	}))
+	defer server.Close()
+
+	watchdog := NewBackupAgeWatchdog(b, 24*time.Hour, time.Minute, server.URL, zap.NewNop().Sugar())
+	if _, ok := watchdog.LastAges(); ok {
+		t.Fatalf("expected no backup ages before the first check")
+	}
+	watchdog.Check(context.Background())
+	last, ok := watchdog.LastAges()
+	if !ok || last.Full == nil || *last.Full < *ages.Full || last.Granular != nil {
+		t.Fatalf("expected the backup ages of the last check, got %+v", last)
+	}
+	watchdog.Check(context.Background())
+	if len(events) != 1 {
+		t.Fatalf("expected one event until a backup succeeds, got %v", events)
+	}
+	if events[0].Event != "stale_backup" || events[0].Type != repo.FULL || events[0].MaxAgeSeconds != 86400 {
+		t.Fatalf("unexpected event %+v", events[0])
+	}
+
+	// without a max age the ages are only kept for /health
+	events = nil
+	watchdog = NewBackupAgeWatchdog(b, 0, time.Minute, server.URL, zap.NewNop().Sugar())
+	watchdog.Check(context.Background())
+	if len(events) != 0 {
+		t.Fatalf("expected no events without a max backup age, got %v", events)
+	}
+	if _, ok := watchdog.LastAges(); !ok {
+		t.Fatalf("expected the backup ages of the last check without a max backup age")
+	}
+}
@@ -20,13 +20,16 @@ func NewConnection(dbPath string) (*Db, error) {
 	}
 	if dir := filepath.Dir(dbPath); dir != "." {
 		if err := os.MkdirAll(dir, 0o755); err != nil {
-			return nil, fmt.Errorf("failed to create db dir %s: %w", dir, err)
+			return nil, fmt.Errorf("failed to create directory %s for database %s: %w", dir, dbPath, err)
 		}
 	}
 	db1, err := sqlx.Connect("sqlite", dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %v", err)
 	}
+	// a single writer connection serializes writes, so maintenance like VACUUM never
+	// runs next to an open write transaction
+	db1.SetMaxOpenConns(1)
 
 	db2, err := sqlx.Connect("sqlite", dbPath)
 	if err != nil {
@@ -47,11 +50,27 @@ func NewConnection(dbPath string) (*Db, error) {
 		err          TEXT,
 		storage_name TEXT,
 		blob_path    TEXT,
-		databases    TEXT
+		databases    TEXT,
+		database_statuses TEXT DEFAULT '',
+		updated_at   INTEGER DEFAULT 0,
+		archive_path TEXT DEFAULT '',
+		bucket       TEXT DEFAULT '',
+		progress     TEXT DEFAULT '',
+		tenant       TEXT DEFAULT '',
+		parent_id    TEXT DEFAULT '',
+		created_at   INTEGER DEFAULT 0,
+		request      TEXT DEFAULT ''
+	);
+	CREATE TABLE IF NOT EXISTS settings (
+		key   TEXT PRIMARY KEY,
+		value TEXT
 	);`
 	if _, err := db1.Exec(schema); err != nil {
 		return nil, fmt.Errorf("failed to create table: %v", err)
 	}
+	if err := addMissingColumns(db1); err != nil {
+		return nil, fmt.Errorf("failed to migrate table: %v", err)
+	}
 
 	if err := db1.Ping(); err != nil {
 		return nil, fmt.Errorf("failed to ping database: %v", err)
@@ -65,6 +84,64 @@ func NewConnection(dbPath string) (*Db, error) {
 	}, nil
 }
 
+// jobColumns lists columns added to jobs after its first release, they are appended to older databases on start.
+var jobColumns = []struct {
+	name       string
+	definition string
+}{
+	{name: "storage_name", definition: "TEXT DEFAULT ''"},
+	{name: "blob_path", definition: "TEXT DEFAULT ''"},
+	{name: "databases", definition: "TEXT DEFAULT ''"},
+	{name: "database_statuses", definition: "TEXT DEFAULT ''"},
+	{name: "updated_at", definition: "INTEGER DEFAULT 0"},
+	{name: "archive_path", definition: "TEXT DEFAULT ''"},
+	{name: "bucket", definition: "TEXT DEFAULT ''"},
+	{name: "progress", definition: "TEXT DEFAULT ''"},
+	{name: "tenant", definition: "TEXT DEFAULT ''"},
+	{name: "parent_id", definition: "TEXT DEFAULT ''"},
+	{name: "created_at", definition: "INTEGER DEFAULT 0"},
+	{name: "request", definition: "TEXT DEFAULT ''"},
+}
+
+func addMissingColumns(conn *sqlx.DB) error {
+	var existing []string
+	if err := conn.Select(&existing, "SELECT name FROM pragma_table_info('jobs')"); err != nil {
+		return fmt.Errorf("failed to read jobs columns: %w", err)
+	}
+	present := make(map[string]bool, len(existing))
+	for _, name := range existing {
+		present[name] = true
+	}
+	for _, column := range jobColumns {
+		if present[column.name] {
+			continue
+		}
+		if _, err := conn.Exec(fmt.Sprintf("ALTER TABLE jobs ADD COLUMN %s %s", column.name, column.definition)); err != nil {
+			return fmt.Errorf("failed to add column %s: %w", column.name, err)
+		}
+	}
+	return nil
+}
+
+// ValidatePath checks that the directory of dbPath exists or can be created and is writable,
+// so a read-only root filesystem is reported before sqlite fails with a less obvious error.
+func ValidatePath(dbPath string) error {
+	if dbPath == "" {
+		dbPath = "./database.db"
+	}
+	dir := filepath.Dir(dbPath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("database directory %s cannot be created: %w; mount a writable volume there or set --db-path (DB_PATH) to a writable location", dir, err)
+	}
+	probe, err := os.CreateTemp(dir, ".db-path-check-*")
+	if err != nil {
+		return fmt.Errorf("database directory %s is not writable: %w; mount a writable volume there or set --db-path (DB_PATH) to a writable location", dir, err)
+	}
+	_ = probe.Close()
+	_ = os.Remove(probe.Name())
+	return nil
+}
+
 func (db *Db) Close() error {
 	var errs []error
 	err := db.WriterDB.Close()
@@ -2,11 +2,31 @@ package entity
 
 import (
 	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
 	"time"
 )
 
 type EvictRequest struct {
 	ProcType string
+	// DryRun lists the vaults the policies would evict without removing them
+	DryRun bool
+}
+
+type EvictResponse struct {
+	Message string         `json:"message"`
+	Count   int            `json:"count"`
+	Evicted []string       `json:"evicted,omitempty"`
+	Failed  []EvictFailure `json:"failed,omitempty"`
+	// NothingToEvict is set when no vault matched the eviction policies
+	NothingToEvict bool `json:"nothing_to_evict,omitempty"`
+	DryRun         bool `json:"dry_run,omitempty"`
+}
+
+type EvictFailure struct {
+	Vault string `json:"vault"`
+	Error string `json:"error"`
 }
 
 type EvictByVaultRequest struct {
@@ -14,6 +34,45 @@ type EvictByVaultRequest struct {
 	ProcType string
 }
 
+type CopyBackupRequest struct {
+	BackupID    string `json:"-"`
+	StorageName string `json:"storageName"`
+	BlobPath    string `json:"blobPath"`
+}
+
+type CopyBackupResponse struct {
+	TaskID   string `json:"task_id"`
+	BackupID string `json:"backup_id"`
+}
+
+type LogLevelRequest struct {
+	Level string `json:"level"`
+}
+
+type ReconcileResponse struct {
+	Removed []string `json:"removed"`
+	Added   []string `json:"added"`
+}
+
+// S3OrphansResponse lists S3 prefixes of vaults that have neither a job nor a local vault.
+type S3OrphansResponse struct {
+	DryRun  bool       `json:"dry_run,omitempty"`
+	Count   int        `json:"count"`
+	Orphans []S3Orphan `json:"orphans"`
+}
+
+type S3Orphan struct {
+	Prefix  string `json:"prefix"`
+	Objects int    `json:"objects"`
+	// Error is set when cleaning the prefix failed
+	Error string `json:"error,omitempty"`
+}
+
+type GoldenBackupResponse struct {
+	BackupID   string `json:"backup_id"`
+	IsGranular bool   `json:"is_granular"`
+}
+
 type EvictByVaultV2Request struct {
 	Vault    string
 	BlobPath string
@@ -25,16 +84,18 @@ type HealthResponse struct {
 	Storage         StorageInfo `json:"storage"`
 }
 
+// StorageInfo leaves out the backup counts, sizes and latest backups it isn't filled with,
+// monitoring would read their zero values as real ones.
 type StorageInfo struct {
-	TotalSpace     int        `json:"total_space"`
-	DumpCount      int        `json:"dump_count"`
-	FreeSpace      int        `json:"free_space"`
-	Size           int        `json:"size"`
-	TotalInodes    int        `json:"total_inodes"`
-	FreeInodes     int        `json:"free_inodes"`
-	UsedInodes     int        `json:"used_inodes"`
-	Last           BackupInfo `json:"last"`
-	LastSuccessful BackupInfo `json:"lastSuccessful"`
+	TotalSpace     int         `json:"total_space"`
+	DumpCount      int         `json:"dump_count,omitempty"`
+	FreeSpace      int         `json:"free_space"`
+	Size           int         `json:"size,omitempty"`
+	TotalInodes    int         `json:"total_inodes"`
+	FreeInodes     int         `json:"free_inodes"`
+	UsedInodes     int         `json:"used_inodes"`
+	Last           *BackupInfo `json:"last,omitempty"`
+	LastSuccessful *BackupInfo `json:"lastSuccessful,omitempty"`
 }
 
 type BackupInfo struct {
@@ -61,7 +122,23 @@ type BackupRequest struct {
 	Prefix             string            `json:"prefix,omitempty"`
 	Mode               string            `json:"mode,omitempty"`
 	CustomVars         map[string]string `json:"custom_vars,omitempty"`
-	ProcType           string
+	// Include and Exclude are glob patterns filtering databases found in discoverDatabases mode
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// RetainUntil (RFC 3339) or TTL (e.g. 90d) keeps the backup from eviction until it expires
+	RetainUntil string `json:"retainUntil,omitempty"`
+	TTL         string `json:"ttl,omitempty"`
+	// Bucket selects one of the allowed s3 buckets instead of the default one
+	Bucket string `json:"bucket,omitempty"`
+	// CommandOverride replaces the configured backup command template, only when the daemon allows it
+	CommandOverride string `json:"commandOverride,omitempty"`
+	// ExcludeDBs are databases the backup command skips, passed to it like dbs
+	ExcludeDBs []string `json:"excludeDbs,omitempty"`
+	// BaseBackupID chains an incremental backup from this backup instead of the newest one
+	BaseBackupID string `json:"baseBackupId,omitempty"`
+	// BackupID is a client supplied vault name, set by the v2 API only
+	BackupID string `json:"-"`
+	ProcType string
 }
 
 type DBEntry struct {
@@ -69,12 +146,32 @@ type DBEntry struct {
 	Object     map[string]DBObject
 }
 
+// UnmarshalJSON accepts "db1", {"db1": {"collections": [...]}} and
+// {"name": "db1", "collections": [...], "tables": [...]}.
 func (d *DBEntry) UnmarshalJSON(data []byte) error {
 	var s string
 	if err := json.Unmarshal(data, &s); err == nil {
 		d.SimpleName = s
 		return nil
 	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err == nil {
+		var name string
+		if err := json.Unmarshal(fields["name"], &name); err == nil {
+			var named struct {
+				DBObject
+				Name string `json:"name"`
+			}
+			if err := json.Unmarshal(data, &named); err != nil {
+				return err
+			}
+			d.SimpleName = named.Name
+			if len(named.Collections) > 0 || len(named.Tables) > 0 {
+				d.Object = map[string]DBObject{named.Name: named.DBObject}
+			}
+			return nil
+		}
+	}
 	var obj map[string]DBObject
 	if err := json.Unmarshal(data, &obj); err != nil {
 		return err
@@ -86,6 +183,14 @@ func (d *DBEntry) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// MarshalJSON writes the forms UnmarshalJSON reads, "db1" or {"db1": {"collections": [...]}}.
+func (d DBEntry) MarshalJSON() ([]byte, error) {
+	if len(d.Object) == 0 {
+		return json.Marshal(d.SimpleName)
+	}
+	return json.Marshal(d.Object)
+}
+
 type DBObject struct {
 	Collections []CollectionItem `json:"collections,omitempty"`
 	Tables      []string         `json:"tables,omitempty"`
@@ -113,8 +218,43 @@ func (c *CollectionItem) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// MarshalJSON writes the forms UnmarshalJSON reads, "name" or {"name": {...}}.
+func (c CollectionItem) MarshalJSON() ([]byte, error) {
+	if c.Details == nil {
+		return json.Marshal(c.Name)
+	}
+	return json.Marshal(map[string]map[string]interface{}{c.Name: c.Details})
+}
+
 type BackupResponse struct {
 	BackupID string `json:"backup_id"` // uuid
+	// Children are the backups of a per database backup that succeeded
+	Children []string `json:"children,omitempty"`
+}
+
+// FlexBool is a bool that legacy clients may also send as a "true" or "false" string.
+type FlexBool bool
+
+func (f *FlexBool) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		if s == "" {
+			*f = false
+			return nil
+		}
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q: %w", s, err)
+		}
+		*f = FlexBool(v)
+		return nil
+	}
+	var v bool
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*f = FlexBool(v)
+	return nil
 }
 
 type RestoreRequest struct {
@@ -124,11 +264,63 @@ type RestoreRequest struct {
 	DBs                []DBEntry         `json:"dbs,omitempty"`
 	ChangeDbNames      map[string]string `json:"changeDbNames,omitempty"`
 	CustomVars         map[string]string `json:"custom_vars,omitempty"`
-	ProcType           string
+	// Clean drops existing objects of the restored databases before restore.
+	Clean FlexBool `json:"clean,omitempty"`
+	// Test restores a copy of the vault with the test restore command to verify the backup.
+	Test bool `json:"test,omitempty"`
+	// RenamePrefix and RenameSuffix rename every database of the backup, entries of ChangeDbNames win.
+	RenamePrefix string `json:"renamePrefix,omitempty"`
+	RenameSuffix string `json:"renameSuffix,omitempty"`
+	// TargetNamespace replaces the namespace embedded in the vault name wherever it is an underscore
+	// separated part of a database name.
+	TargetNamespace string `json:"targetNamespace,omitempty"`
+	// FromTs and ToTs restore the newest backup taken in the window, epoch milliseconds like ts.
+	FromTs   string `json:"fromTs,omitempty"`
+	ToTs     string `json:"toTs,omitempty"`
+	ProcType string
+}
+
+type RestoreFromURLRequest struct {
+	URL           string            `json:"url"`
+	DBs           []DBEntry         `json:"dbs,omitempty"`
+	ChangeDbNames map[string]string `json:"changeDbNames,omitempty"`
+	CustomVars    map[string]string `json:"custom_vars,omitempty"`
+	Clean         FlexBool          `json:"clean,omitempty"`
+}
+
+// MultiRestoreRequest restores the listed vaults, or those with a timestamp between From and
+// To (epoch milliseconds like ts, both inclusive), oldest first.
+type MultiRestoreRequest struct {
+	Vaults          []string          `json:"vaults,omitempty"`
+	From            string            `json:"from,omitempty"`
+	To              string            `json:"to,omitempty"`
+	ChangeDbNames   map[string]string `json:"changeDbNames,omitempty"`
+	CustomVars      map[string]string `json:"custom_vars,omitempty"`
+	Clean           FlexBool          `json:"clean,omitempty"`
+	ContinueOnError bool              `json:"continueOnError,omitempty"`
+	ProcType        string
+}
+
+type MultiRestoreResponse struct {
+	Restored []RestoreResponse `json:"restored,omitempty"`
+	Failed   []RestoreFailure  `json:"failed,omitempty"`
+	// Skipped lists vaults not attempted after a failure stopped the restore
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+type RestoreFailure struct {
+	Vault string `json:"vault"`
+	Error string `json:"error"`
 }
 
 type RestoreResponse struct {
 	TaskID string `json:"task_id"`
+	Vault  string `json:"vault,omitempty"`
+}
+
+type RestoreLatestRequest struct {
+	Restore      RestoreRequest
+	TypeOfBackup string
 }
 
 type JobStatusRequest struct {
@@ -142,10 +334,18 @@ type JobStatusResponse struct {
 	Error  string `json:"err"`
 	TaskID string `json:"task_id"`
 
-	StorageName string   `json:"storageName"`
-	BlobPath    string   `json:"blobPath"`
-	Databases   []string `json:"databases,omitempty"`
-	StatusCode  int
+	StorageName      string   `json:"storageName"`
+	BlobPath         string   `json:"blobPath"`
+	Databases        []string `json:"databases,omitempty"`
+	StatusCode       int
+	DatabaseStatuses map[string]string `json:"databaseStatuses,omitempty"`
+	ArchivePath      string            `json:"archivePath,omitempty"`
+	Progress         string            `json:"progress,omitempty"`
+	// Children are the backup ids of a per database backup
+	Children []string `json:"children,omitempty"`
+	// StartedAt and FinishedAt are RFC3339 UTC times the job was created and finished, empty when unknown
+	StartedAt  string `json:"startedAt,omitempty"`
+	FinishedAt string `json:"finishedAt,omitempty"`
 }
 
 type ListBackupsRequest struct {
@@ -174,8 +374,15 @@ type FindResponse struct {
 	CustomVars map[string]string `json:"custom_vars,omitempty"`
 }
 
+// EvictionPolicyRequest replaces the eviction policies, an empty one is kept as is.
 type EvictionPolicyRequest struct {
-	FullEvictionPolicy string `json:"fullEvictionPolicy"`
+	FullEvictionPolicy     string `json:"fullEvictionPolicy"`
+	GranularEvictionPolicy string `json:"granularEvictionPolicy"`
+}
+
+type EvictionPolicyResponse struct {
+	FullEvictionPolicy     string `json:"fullEvictionPolicy"`
+	GranularEvictionPolicy string `json:"granularEvictionPolicy"`
 }
 
 type TerminateRequest struct {
@@ -200,3 +407,72 @@ type S3PresignedURLRequest struct {
 type S3PresignedURLResponse struct {
 	Urls []string `json:"urls"`
 }
+
+// BackupUploadURLRequest asks to presign an upload of Key, a file path inside the backup.
+type BackupUploadURLRequest struct {
+	BackupID   string `json:"-"`
+	Key        string `json:"key"`
+	Expiration int    `json:"-"`
+}
+
+type BackupUploadURLResponse struct {
+	URL string `json:"url"`
+	Key string `json:"key"`
+}
+
+type BackupFilesResponse struct {
+	Files []string `json:"files"`
+}
+
+type BackupFileRequest struct {
+	BackupID   string
+	Path       string
+	Expiration int
+}
+
+// BackupFileResponse holds either the local File to stream or the presigned URL of the S3 object.
+type BackupFileResponse struct {
+	File *os.File
+	URL  string
+}
+
+// BackupLoad reports backups running now against the limit, RetryAfter is the seconds a
+// rejected client should wait.
+type BackupLoad struct {
+	InFlight   int `json:"in_flight"`
+	Limit      int `json:"limit"`
+	RetryAfter int `json:"retry_after"`
+}
+
+// BackupAges are the seconds since the latest successful backup of each type, null without one.
+type BackupAges struct {
+	Full     *int64 `json:"full"`
+	Granular *int64 `json:"granular"`
+}
+
+// StaleBackupEvent is posted to the stale backup webhook once a backup type gets older than the max age.
+type StaleBackupEvent struct {
+	Event         string `json:"event"`
+	Type          string `json:"type"`
+	AgeSeconds    int64  `json:"age_seconds"`
+	MaxAgeSeconds int64  `json:"max_age_seconds"`
+}
+
+type StorageUsageResponse struct {
+	Full       BackupTypeUsage `json:"full"`
+	Granular   BackupTypeUsage `json:"granular"`
+	TotalSize  int64           `json:"total_size"`
+	TotalCount int             `json:"total_count"`
+	FreeSpace  int64           `json:"free_space"`
+}
+
+type BackupTypeUsage struct {
+	Count int   `json:"count"`
+	Size  int64 `json:"size"`
+}
+
+// StorageQuota is the size in bytes of the backups of a storageName and the quota they may reach.
+type StorageQuota struct {
+	Usage int64 `json:"usage"`
+	Quota int64 `json:"quota"`
+}
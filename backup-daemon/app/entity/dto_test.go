@@ -0,0 +1,117 @@
+package entity
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDBEntryUnmarshalJSON(t *testing.T) {
+	testCases := []struct {
+		name          string
+		data          string
+		expected      DBEntry
+		expectedError bool
+	}{
+		{
+			name:     "bare string",
+			data:     `"db1"`,
+			expected: DBEntry{SimpleName: "db1"},
+		},
+		{
+			name: "single key object",
+			data: `{"db1": {"collections": ["c1"], "tables": ["t1"]}}`,
+			expected: DBEntry{SimpleName: "db1", Object: map[string]DBObject{
+				"db1": {Collections: []CollectionItem{{Name: "c1"}}, Tables: []string{"t1"}},
+			}},
+		},
+		{
+			name: "explicit name field",
+			data: `{"name": "db1", "collections": ["c1", {"c2": {"filter": "x"}}], "tables": ["t1"]}`,
+			expected: DBEntry{SimpleName: "db1", Object: map[string]DBObject{
+				"db1": {
+					Collections: []CollectionItem{{Name: "c1"}, {Name: "c2", Details: map[string]interface{}{"filter": "x"}}},
+					Tables:      []string{"t1"},
+				},
+			}},
+		},
+		{
+			name:     "explicit name field only",
+			data:     `{"name": "db1"}`,
+			expected: DBEntry{SimpleName: "db1"},
+		},
+		{
+			name: "database called name keeps the single key form",
+			data: `{"name": {"tables": ["t1"]}}`,
+			expected: DBEntry{SimpleName: "name", Object: map[string]DBObject{
+				"name": {Tables: []string{"t1"}},
+			}},
+		},
+		{
+			name:          "invalid",
+			data:          `[1]`,
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got DBEntry
+			err := json.Unmarshal([]byte(tc.data), &got)
+			if (err != nil) != tc.expectedError {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Fatalf("expected %+v, got %+v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestDBEntryMarshalJSON(t *testing.T) {
+	testCases := []struct {
+		name     string
+		entry    DBEntry
+		expected string
+	}{
+		{name: "simple name", entry: DBEntry{SimpleName: "db1"}, expected: `"db1"`},
+		{
+			name: "object",
+			entry: DBEntry{SimpleName: "db1", Object: map[string]DBObject{
+				"db1": {Collections: []CollectionItem{{Name: "c1"}, {Name: "c2", Details: map[string]interface{}{"filter": "x"}}}, Tables: []string{"t1"}},
+			}},
+			expected: `{"db1":{"collections":["c1",{"c2":{"filter":"x"}}],"tables":["t1"]}}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(tc.entry)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(data) != tc.expected {
+				t.Fatalf("expected %s, got %s", tc.expected, data)
+			}
+			var got DBEntry
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("failed to read back %s: %v", data, err)
+			}
+			if !reflect.DeepEqual(got, tc.entry) {
+				t.Fatalf("expected %+v, got %+v", tc.entry, got)
+			}
+		})
+	}
+}
+
+func TestStorageInfoMarshalJSON(t *testing.T) {
+	data, err := json.Marshal(StorageInfo{TotalSpace: 100, FreeSpace: 40, TotalInodes: 10, FreeInodes: 4, UsedInodes: 6})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `{"total_space":100,"free_space":40,"total_inodes":10,"free_inodes":4,"used_inodes":6}`
+	if string(data) != expected {
+		t.Fatalf("expected %s, got %s", expected, data)
+	}
+}
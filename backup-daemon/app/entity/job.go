@@ -1,12 +1,43 @@
 package entity
 
 type Job struct {
-	TaskID      string `json:"task_id" db:"task_id"`
-	Type        string `json:"type" db:"type"`
-	Status      string `json:"status" db:"status"`
-	Vault       string `json:"vault" db:"vault"`
-	Err         string `json:"err" db:"err"`
-	StorageName string `db:"storage_name"`
-	BlobPath    string `db:"blob_path"`
-	Databases   string `db:"databases"`
+	TaskID           string `json:"task_id" db:"task_id"`
+	Type             string `json:"type" db:"type"`
+	Status           string `json:"status" db:"status"`
+	Vault            string `json:"vault" db:"vault"`
+	Err              string `json:"err" db:"err"`
+	StorageName      string `db:"storage_name"`
+	BlobPath         string `db:"blob_path"`
+	Databases        string `db:"databases"`
+	DatabaseStatuses string `db:"database_statuses"`
+	ArchivePath      string `db:"archive_path"`
+	// Bucket is the s3 bucket the backup was uploaded to, empty for the default one
+	Bucket string `db:"bucket"`
+	// Progress describes a running phase, e.g. "downloading 40%", later updates without it clear it
+	Progress string `db:"progress"`
+	// Tenant owns the job, only requests of the same tenant see it, empty for jobs created without one
+	Tenant string `db:"tenant"`
+	// ParentID is the per database backup the job backs up one database of
+	ParentID string `db:"parent_id"`
+	// CreatedAt and UpdatedAt are unix seconds of the first and the last UpdateJob, CreatedAt is zero
+	// for jobs created before it was recorded
+	CreatedAt int64 `db:"created_at"`
+	UpdatedAt int64 `db:"updated_at"`
+	// Request is the JSON of the BackupRequest a backup job was started with, kept to retry it
+	Request string `db:"request"`
+	// OverwriteDatabases makes UpdateJob store Databases and DatabaseStatuses even when empty, status
+	// updates leave it unset to keep the stored lists
+	OverwriteDatabases bool `db:"-"`
+}
+
+// JobsFilter narrows ListJobs, empty fields are not applied.
+type JobsFilter struct {
+	StorageName string
+	Types       []string
+	Status      string
+	ParentID    string
+	// Limit caps the number of jobs returned, zero returns all of them
+	Limit int
+	// Offset skips that many jobs, it is only applied together with Limit
+	Offset int
 }
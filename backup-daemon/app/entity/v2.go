@@ -14,6 +14,9 @@ type BackupV2Request struct {
 	StorageName string   `json:"storageName"`
 	BlobPath    string   `json:"blobPath"`
 	Databases   []string `json:"databases"`
+	Bucket      string   `json:"bucket,omitempty"`
+	// BackupID names the backup instead of the generated timestamp name
+	BackupID string `json:"backupId,omitempty"`
 }
 
 type BackupV2Response struct {
@@ -25,10 +28,16 @@ type BackupV2Response struct {
 	Databases    []DatabaseV2Status `json:"databases"`
 }
 
+type BackupV2ListResponse struct {
+	Backups []BackupV2Response `json:"backups"`
+}
+
 type RestoreV2Request struct {
 	StorageName string         `json:"storageName"`
 	BlobPath    string         `json:"blobPath"`
 	Databases   []RestoreDBMap `json:"databases"`
+	// Clean drops existing objects of the restored databases before restore.
+	Clean bool `json:"clean,omitempty"`
 }
 
 type RestoreV2Response struct {
@@ -38,4 +47,18 @@ type RestoreV2Response struct {
 	StorageName  string             `json:"storageName"`
 	BlobPath     string             `json:"blobPath"`
 	Databases    []DatabaseV2Status `json:"databases"`
+	Progress     string             `json:"progress,omitempty"`
+}
+
+type EvictV2Response struct {
+	DryRun  bool            `json:"dryRun"`
+	Count   int             `json:"count"`
+	Backups []EvictV2Backup `json:"backups"`
+}
+
+// EvictV2Backup is a backup evicted, failed to evict or, in a dry run, that would be evicted (notStarted).
+type EvictV2Backup struct {
+	BackupID string `json:"backupId"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
 }
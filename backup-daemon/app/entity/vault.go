@@ -12,5 +12,25 @@ type Vault struct {
 	IsLocked           bool                   `json:"is_locked"`
 	Canceled           bool                   `json:"canceled"`
 	IsGranular         bool                   `json:"is_granular"`
+	IsIncomplete       bool                   `json:"is_incomplete"`
 	Metrics            map[string]interface{} `json:"metrics"`
 }
+
+// BackupManifest describes a backup independently of the daemon's DB, it is kept in the vault as manifest.json.
+type BackupManifest struct {
+	FormatVersion     int               `json:"format_version"`
+	ToolVersion       string            `json:"tool_version"`
+	BackupID          string            `json:"backup_id"`
+	CreatedAt         string            `json:"created_at"`
+	Databases         []string          `json:"databases"`
+	ExcludedDatabases []string          `json:"excluded_databases,omitempty"`
+	CustomVars        map[string]string `json:"custom_vars,omitempty"`
+	Size              int64             `json:"size"`
+	Files             []ManifestFile    `json:"files"`
+}
+
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
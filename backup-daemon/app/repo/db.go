@@ -5,20 +5,44 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/db"
 	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
+	"github.com/jmoiron/sqlx"
 )
 
 type DBRepository interface {
 	UpdateJob(ctx context.Context, job entity.Job) error
 	RemoveVault(ctx context.Context, vault string) error
 	SelectEverything(ctx context.Context, taskID string) (entity.Job, error)
+	PruneJobs(ctx context.Context, olderThan time.Time, keepVaults []string) (int64, error)
+	ListJobs(ctx context.Context, filter entity.JobsFilter) ([]entity.Job, error)
+	CountJobs(ctx context.Context, filter entity.JobsFilter) (int, error)
+	Vacuum(ctx context.Context) error
+	FailUnfinishedJobs(ctx context.Context, reason string) (int64, error)
+	GetSetting(ctx context.Context, key string) (string, error)
+	SetSetting(ctx context.Context, key string, value string) error
+	DeleteSetting(ctx context.Context, key string) error
 }
 
 var ErrNotFound = errors.New("sql: no rows in result set")
 var ErrNoVaults = errors.New("no vaults found")
 
+// TenantKey is the context key holding the tenant of a request, a string so gin contexts resolve it as well.
+const TenantKey = "tenant"
+
+// WithTenant scopes the jobs read and written with ctx to tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, TenantKey, tenant) //nolint:staticcheck
+}
+
+// Tenant returns the tenant of ctx, empty when jobs of every tenant are visible.
+func Tenant(ctx context.Context) string {
+	tenant, _ := ctx.Value(TenantKey).(string)
+	return tenant
+}
+
 type DBRepo struct {
 	db *db.Db
 }
@@ -29,25 +53,56 @@ func NewDBRepo(db *db.Db) DBRepository {
 	}
 }
 
+// UpdateJob upserts job, an empty Databases or DatabaseStatuses keeps the stored one
+// unless OverwriteDatabases is set.
 func (d *DBRepo) UpdateJob(ctx context.Context, job entity.Job) error {
 	upsertQuery := `
-		insert into jobs (task_id, type, status, vault, err, storage_name, blob_path, databases)
-		values ($1, $2, $3, $4, $5, $6, $7, $8)
+		insert into jobs (task_id, type, status, vault, err, storage_name, blob_path, databases, database_statuses, updated_at, archive_path, bucket, progress, tenant, parent_id, created_at, request)
+		values (:task_id, :type, :status, :vault, :err, :storage_name, :blob_path, :databases, :database_statuses, :updated_at,
+			:archive_path, :bucket, :progress, :tenant, :parent_id, :created_at, :request)
 		on conflict(task_id) do update set
-			type         = excluded.type,
-			status       = excluded.status,
-			vault        = excluded.vault,
-			err          = excluded.err,
-			storage_name = excluded.storage_name,
-			blob_path    = excluded.blob_path,
-			databases    = COALESCE(NULLIF(excluded.databases, ''), jobs.databases);
+			updated_at        = excluded.updated_at,
+			type              = excluded.type,
+			status            = excluded.status,
+			vault             = excluded.vault,
+			err               = excluded.err,
+			storage_name      = excluded.storage_name,
+			blob_path         = excluded.blob_path,
+			databases         = case when :overwrite_databases then excluded.databases else COALESCE(NULLIF(excluded.databases, ''), jobs.databases) end,
+			database_statuses = case when :overwrite_databases then excluded.database_statuses else COALESCE(NULLIF(excluded.database_statuses, ''), jobs.database_statuses) end,
+			archive_path      = COALESCE(NULLIF(excluded.archive_path, ''), jobs.archive_path),
+			bucket            = COALESCE(NULLIF(excluded.bucket, ''), jobs.bucket),
+			progress          = excluded.progress,
+			tenant            = COALESCE(NULLIF(excluded.tenant, ''), jobs.tenant),
+			parent_id         = COALESCE(NULLIF(excluded.parent_id, ''), jobs.parent_id),
+			request           = COALESCE(NULLIF(excluded.request, ''), jobs.request);
 	`
 
-	_, err := d.db.WriterDB.ExecContext(
-		ctx, upsertQuery,
-		job.TaskID, job.Type, job.Status, job.Vault, job.Err,
-		job.StorageName, job.BlobPath, job.Databases,
-	)
+	if job.Tenant == "" {
+		job.Tenant = Tenant(ctx)
+	}
+	// created_at is only written by the insert, the first update creates the job
+	now := time.Now().Unix()
+	_, err := d.db.WriterDB.NamedExecContext(ctx, upsertQuery, map[string]interface{}{
+		"task_id":             job.TaskID,
+		"type":                job.Type,
+		"status":              job.Status,
+		"vault":               job.Vault,
+		"err":                 job.Err,
+		"storage_name":        job.StorageName,
+		"blob_path":           job.BlobPath,
+		"databases":           job.Databases,
+		"database_statuses":   job.DatabaseStatuses,
+		"updated_at":          now,
+		"created_at":          now,
+		"archive_path":        job.ArchivePath,
+		"bucket":              job.Bucket,
+		"progress":            job.Progress,
+		"tenant":              job.Tenant,
+		"parent_id":           job.ParentID,
+		"overwrite_databases": job.OverwriteDatabases,
+		"request":             job.Request,
+	})
 	if err != nil {
 		return fmt.Errorf("error updating job status: %w", err)
 	}
@@ -55,9 +110,14 @@ func (d *DBRepo) UpdateJob(ctx context.Context, job entity.Job) error {
 }
 
 func (d *DBRepo) RemoveVault(ctx context.Context, vault string) error {
-	deleteWithVault := `delete from jobs where vault = $1`
+	deleteWithVault := `delete from jobs where vault = ?`
+	args := []interface{}{vault}
+	if tenant := Tenant(ctx); tenant != "" {
+		deleteWithVault += ` and tenant = ?`
+		args = append(args, tenant)
+	}
 
-	res, err := d.db.WriterDB.ExecContext(ctx, deleteWithVault, vault)
+	res, err := d.db.WriterDB.ExecContext(ctx, deleteWithVault, args...)
 	if err != nil {
 		return fmt.Errorf("unable to delete vault %s from jobs database: %v", vault, err)
 	}
@@ -73,9 +133,16 @@ func (d *DBRepo) RemoveVault(ctx context.Context, vault string) error {
 
 func (d *DBRepo) SelectEverything(ctx context.Context, taskID string) (entity.Job, error) {
 	var job entity.Job
-	query := `select * from jobs where task_id = $1`
+	query := `select task_id, type, status, vault, err, storage_name, blob_path, databases, database_statuses, archive_path, bucket, progress, tenant, parent_id,
+		created_at, updated_at, request
+		from jobs where task_id = ?`
+	args := []interface{}{taskID}
+	if tenant := Tenant(ctx); tenant != "" {
+		query += ` and tenant = ?`
+		args = append(args, tenant)
+	}
 
-	err := d.db.ReaderDB.QueryRowxContext(ctx, query, taskID).StructScan(&job)
+	err := d.db.ReaderDB.QueryRowxContext(ctx, query, args...).StructScan(&job)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return entity.Job{}, fmt.Errorf("no job found with task_id %s: %w", taskID, ErrNotFound)
@@ -84,3 +151,146 @@ func (d *DBRepo) SelectEverything(ctx context.Context, taskID string) (entity.Jo
 	}
 	return job, nil
 }
+
+// PruneJobs deletes finished jobs not updated since olderThan, rows of keepVaults are never removed.
+func (d *DBRepo) PruneJobs(ctx context.Context, olderThan time.Time, keepVaults []string) (int64, error) {
+	query := `delete from jobs where updated_at < ? and status in ('Successful', 'Failed', 'Canceled')`
+	args := []interface{}{olderThan.Unix()}
+	if len(keepVaults) > 0 {
+		inQuery, inArgs, err := sqlx.In(` and vault not in (?)`, keepVaults)
+		if err != nil {
+			return 0, fmt.Errorf("unable to build prune jobs query: %w", err)
+		}
+		query += inQuery
+		args = append(args, inArgs...)
+	}
+
+	res, err := d.db.WriterDB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("unable to prune jobs older than %s: %w", olderThan, err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("unable to prune jobs older than %s: %w", olderThan, err)
+	}
+	return rows, nil
+}
+
+// FailUnfinishedJobs marks every Queued or Processing job as Failed with reason.
+func (d *DBRepo) FailUnfinishedJobs(ctx context.Context, reason string) (int64, error) {
+	query := `update jobs set status = 'Failed', err = $1, updated_at = $2 where status in ('Queued', 'Processing')`
+
+	res, err := d.db.WriterDB.ExecContext(ctx, query, reason, time.Now().Unix())
+	if err != nil {
+		return 0, fmt.Errorf("unable to fail unfinished jobs: %w", err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("unable to fail unfinished jobs: %w", err)
+	}
+	return rows, nil
+}
+
+// GetSetting returns the value stored under key, ErrNotFound when it was never set.
+func (d *DBRepo) GetSetting(ctx context.Context, key string) (string, error) {
+	var value string
+	err := d.db.ReaderDB.GetContext(ctx, &value, `select value from settings where key = ?`, key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("no setting %s: %w", key, ErrNotFound)
+		}
+		return "", fmt.Errorf("error getting setting %s: %w", key, err)
+	}
+	return value, nil
+}
+
+// SetSetting stores value under key, replacing the previous one.
+func (d *DBRepo) SetSetting(ctx context.Context, key string, value string) error {
+	query := `insert into settings (key, value) values ($1, $2) on conflict(key) do update set value = $2`
+	if _, err := d.db.WriterDB.ExecContext(ctx, query, key, value); err != nil {
+		return fmt.Errorf("unable to store setting %s: %w", key, err)
+	}
+	return nil
+}
+
+// DeleteSetting removes the value stored under key, a key never set is no error.
+func (d *DBRepo) DeleteSetting(ctx context.Context, key string) error {
+	if _, err := d.db.WriterDB.ExecContext(ctx, `delete from settings where key = ?`, key); err != nil {
+		return fmt.Errorf("unable to delete setting %s: %w", key, err)
+	}
+	return nil
+}
+
+// Vacuum rebuilds the database file to drop free pages and truncates the WAL afterwards.
+func (d *DBRepo) Vacuum(ctx context.Context) error {
+	if _, err := d.db.WriterDB.ExecContext(ctx, `VACUUM`); err != nil {
+		return fmt.Errorf("unable to vacuum jobs database: %w", err)
+	}
+	if _, err := d.db.WriterDB.ExecContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return fmt.Errorf("unable to checkpoint jobs database wal: %w", err)
+	}
+	return nil
+}
+
+func (d *DBRepo) ListJobs(ctx context.Context, filter entity.JobsFilter) ([]entity.Job, error) {
+	where, args, err := jobsWhere(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	query := `select task_id, type, status, vault, err, storage_name, blob_path, databases, database_statuses, archive_path, bucket, progress, tenant, parent_id,
+		created_at, updated_at, request
+		from jobs` + where + ` order by updated_at desc, task_id desc`
+	if filter.Limit > 0 {
+		query += ` limit ? offset ?`
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	jobs := []entity.Job{}
+	if err := d.db.ReaderDB.SelectContext(ctx, &jobs, query, args...); err != nil {
+		return nil, fmt.Errorf("error listing jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// CountJobs counts the jobs ListJobs returns for filter, ignoring its Limit and Offset.
+func (d *DBRepo) CountJobs(ctx context.Context, filter entity.JobsFilter) (int, error) {
+	where, args, err := jobsWhere(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	if err := d.db.ReaderDB.GetContext(ctx, &count, `select count(*) from jobs`+where, args...); err != nil {
+		return 0, fmt.Errorf("error counting jobs: %w", err)
+	}
+	return count, nil
+}
+
+func jobsWhere(ctx context.Context, filter entity.JobsFilter) (string, []interface{}, error) {
+	query := ` where 1 = 1`
+	var args []interface{}
+	if tenant := Tenant(ctx); tenant != "" {
+		query += ` and tenant = ?`
+		args = append(args, tenant)
+	}
+	if filter.StorageName != "" {
+		query += ` and storage_name = ?`
+		args = append(args, filter.StorageName)
+	}
+	if filter.Status != "" {
+		query += ` and status = ?`
+		args = append(args, filter.Status)
+	}
+	if filter.ParentID != "" {
+		query += ` and parent_id = ?`
+		args = append(args, filter.ParentID)
+	}
+	if len(filter.Types) > 0 {
+		inQuery, inArgs, err := sqlx.In(` and type in (?)`, filter.Types)
+		if err != nil {
+			return "", nil, fmt.Errorf("unable to build list jobs query: %w", err)
+		}
+		query += inQuery
+		args = append(args, inArgs...)
+	}
+	return query, args, nil
+}
@@ -0,0 +1,96 @@
+package repo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
+)
+
+type cachedJob struct {
+	job     entity.Job
+	expires time.Time
+}
+
+// CachedDBRepo keeps SelectEverything results for a short ttl so frequent status polls
+// do not hit SQLite each time. Every write drops the cached entries it may affect.
+type CachedDBRepo struct {
+	DBRepository
+	ttl time.Duration
+	mu  sync.Mutex
+	// jobs holds the cached jobs by task id and then by the tenant they were selected for
+	jobs map[string]map[string]cachedJob
+	// generation grows on every write, a read started before a write must not be cached
+	generation uint64
+}
+
+func NewCachedDBRepo(dbRepo DBRepository, ttl time.Duration) DBRepository {
+	return &CachedDBRepo{
+		DBRepository: dbRepo,
+		ttl:          ttl,
+		jobs:         make(map[string]map[string]cachedJob),
+	}
+}
+
+func (c *CachedDBRepo) SelectEverything(ctx context.Context, taskID string) (entity.Job, error) {
+	tenant := Tenant(ctx)
+	c.mu.Lock()
+	cached, ok := c.jobs[taskID][tenant]
+	if ok && time.Now().Before(cached.expires) {
+		c.mu.Unlock()
+		return cached.job, nil
+	}
+	delete(c.jobs[taskID], tenant)
+	generation := c.generation
+	c.mu.Unlock()
+
+	job, err := c.DBRepository.SelectEverything(ctx, taskID)
+	if err != nil {
+		return job, err
+	}
+
+	c.mu.Lock()
+	if c.generation == generation {
+		if c.jobs[taskID] == nil {
+			c.jobs[taskID] = make(map[string]cachedJob)
+		}
+		c.jobs[taskID][tenant] = cachedJob{job: job, expires: time.Now().Add(c.ttl)}
+	}
+	c.mu.Unlock()
+	return job, nil
+}
+
+func (c *CachedDBRepo) UpdateJob(ctx context.Context, job entity.Job) error {
+	defer c.invalidate(job.TaskID)
+	return c.DBRepository.UpdateJob(ctx, job)
+}
+
+func (c *CachedDBRepo) RemoveVault(ctx context.Context, vault string) error {
+	defer c.invalidateAll()
+	return c.DBRepository.RemoveVault(ctx, vault)
+}
+
+func (c *CachedDBRepo) PruneJobs(ctx context.Context, olderThan time.Time, keepVaults []string) (int64, error) {
+	defer c.invalidateAll()
+	return c.DBRepository.PruneJobs(ctx, olderThan, keepVaults)
+}
+
+func (c *CachedDBRepo) FailUnfinishedJobs(ctx context.Context, reason string) (int64, error) {
+	defer c.invalidateAll()
+	return c.DBRepository.FailUnfinishedJobs(ctx, reason)
+}
+
+func (c *CachedDBRepo) invalidate(taskID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	delete(c.jobs, taskID)
+}
+
+func (c *CachedDBRepo) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.jobs = make(map[string]map[string]cachedJob)
+}
@@ -0,0 +1,84 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
+)
+
+type countingDBRepo struct {
+	DBRepository
+	selects int
+}
+
+func (c *countingDBRepo) SelectEverything(ctx context.Context, taskID string) (entity.Job, error) {
+	c.selects++
+	return c.DBRepository.SelectEverything(ctx, taskID)
+}
+
+func TestCachedDBRepo(t *testing.T) {
+	dbConn := newTestDB(t)
+	defer dbConn.Close()
+
+	counting := &countingDBRepo{DBRepository: NewDBRepo(dbConn)}
+	cached := NewCachedDBRepo(counting, time.Hour)
+	ctx := context.Background()
+
+	if err := cached.UpdateJob(ctx, entity.Job{TaskID: "task-1", Type: "backup", Status: "Processing"}); err != nil {
+		t.Fatalf("UpdateJob failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		job, err := cached.SelectEverything(ctx, "task-1")
+		if err != nil {
+			t.Fatalf("SelectEverything failed: %v", err)
+		}
+		if job.Status != "Processing" {
+			t.Fatalf("expected status Processing, got %s", job.Status)
+		}
+	}
+	if counting.selects != 1 {
+		t.Fatalf("expected repeated polls to hit the db once, got %d", counting.selects)
+	}
+
+	if err := cached.UpdateJob(ctx, entity.Job{TaskID: "task-1", Type: "backup", Status: "Successful"}); err != nil {
+		t.Fatalf("UpdateJob failed: %v", err)
+	}
+	job, err := cached.SelectEverything(ctx, "task-1")
+	if err != nil {
+		t.Fatalf("SelectEverything failed: %v", err)
+	}
+	if job.Status != "Successful" {
+		t.Fatalf("expected status Successful after update, got %s", job.Status)
+	}
+	if counting.selects != 2 {
+		t.Fatalf("expected update to invalidate the cache, got %d db reads", counting.selects)
+	}
+}
+
+func TestCachedDBRepoTenants(t *testing.T) {
+	dbConn := newTestDB(t)
+	defer dbConn.Close()
+
+	cached := NewCachedDBRepo(NewDBRepo(dbConn), time.Hour)
+	teamA := WithTenant(context.Background(), "team-a")
+	teamB := WithTenant(context.Background(), "team-b")
+
+	if err := cached.UpdateJob(teamA, entity.Job{TaskID: "task-1", Type: "backup", Status: "Processing"}); err != nil {
+		t.Fatalf("UpdateJob failed: %v", err)
+	}
+	for _, ctx := range []context.Context{teamA, context.Background()} {
+		if _, err := cached.SelectEverything(ctx, "task-1"); err != nil {
+			t.Fatalf("SelectEverything failed: %v", err)
+		}
+	}
+	// the job of team-a is cached now, team-b must still not see it
+	if _, err := cached.SelectEverything(teamB, "task-1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected error %v reading the job of another tenant, got %v", ErrNotFound, err)
+	}
+	if _, err := cached.SelectEverything(teamA, "task-1"); err != nil {
+		t.Fatalf("expected team-a to read its cached job, got %v", err)
+	}
+}
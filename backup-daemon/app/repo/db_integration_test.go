@@ -3,8 +3,15 @@ package repo
 import (
 	"context"
 	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
 
 	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/db"
 	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
@@ -68,17 +75,73 @@ func TestUpdateJob_Integration(t *testing.T) {
 	}
 }
 
+func TestUpdateJobDatabases_Integration(t *testing.T) {
+	testCases := []struct {
+		name             string
+		update           entity.Job
+		expectedDBs      string
+		expectedStatuses string
+	}{
+		{
+			name:             "status update keeps databases",
+			update:           entity.Job{TaskID: "task-1", Type: "backup", Status: "Successful"},
+			expectedDBs:      `["db1"]`,
+			expectedStatuses: `{"db1":"Queued"}`,
+		},
+		{
+			name:             "full write replaces databases",
+			update:           entity.Job{TaskID: "task-1", Type: "backup", Status: "Queued", Databases: `["db2"]`, OverwriteDatabases: true},
+			expectedDBs:      `["db2"]`,
+			expectedStatuses: "",
+		},
+		{
+			name:             "full write clears databases",
+			update:           entity.Job{TaskID: "task-1", Type: "backup", Status: "Queued", OverwriteDatabases: true},
+			expectedDBs:      "",
+			expectedStatuses: "",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dbConn := newTestDB(t)
+			defer dbConn.Close()
+
+			repo := NewDBRepo(dbConn)
+			seed := entity.Job{TaskID: "task-1", Type: "backup", Status: "Queued", Databases: `["db1"]`, DatabaseStatuses: `{"db1":"Queued"}`,
+				Request: `{"dbs":["db1"]}`}
+			if err := repo.UpdateJob(context.Background(), seed); err != nil {
+				t.Fatalf("seed UpdateJob failed: %v", err)
+			}
+			if err := repo.UpdateJob(context.Background(), tc.update); err != nil {
+				t.Fatalf("UpdateJob failed: %v", err)
+			}
+			job, err := repo.SelectEverything(context.Background(), "task-1")
+			if err != nil {
+				t.Fatalf("SelectEverything failed: %v", err)
+			}
+			if job.Databases != tc.expectedDBs || job.DatabaseStatuses != tc.expectedStatuses {
+				t.Fatalf("expected databases %q and statuses %q, got %q and %q", tc.expectedDBs, tc.expectedStatuses, job.Databases, job.DatabaseStatuses)
+			}
+			if job.Request != seed.Request {
+				t.Fatalf("expected the stored request %q to be kept, got %q", seed.Request, job.Request)
+			}
+		})
+	}
+}
+
 func TestSelectEverything_Integration(t *testing.T) {
 	dbConn := newTestDB(t)
 	defer dbConn.Close()
 
 	repo := NewDBRepo(dbConn)
 	seed := entity.Job{
-		TaskID: "task-1",
-		Type:   "backup2",
-		Status: "success",
-		Vault:  "vault2",
-		Err:    "",
+		TaskID:  "task-1",
+		Type:    "backup2",
+		Status:  "success",
+		Vault:   "vault2",
+		Err:     "",
+		Bucket:  "backups-b",
+		Request: `{"dbs":["db1"]}`,
 	}
 	if err := repo.UpdateJob(context.Background(), seed); err != nil {
 		t.Fatalf("seed UpdateJob failed: %v", err)
@@ -110,6 +173,12 @@ func TestSelectEverything_Integration(t *testing.T) {
 			if !errors.Is(err, tc.expectedErr) {
 				t.Fatalf("expected %v, got: %v", tc.expectedErr, err)
 			}
+			if err == nil {
+				if job.CreatedAt == 0 || job.UpdatedAt < job.CreatedAt {
+					t.Fatalf("expected created and updated times, got %d and %d", job.CreatedAt, job.UpdatedAt)
+				}
+				job.CreatedAt, job.UpdatedAt = 0, 0
+			}
 			if job != tc.job {
 				t.Fatalf("expected job: %v, got: %v", tc.job, job)
 			}
@@ -160,3 +229,397 @@ func TestRemoveVault_Integration(t *testing.T) {
 		})
 	}
 }
+
+func TestTenantJobs_Integration(t *testing.T) {
+	dbConn := newTestDB(t)
+	defer dbConn.Close()
+
+	repo := NewDBRepo(dbConn)
+	teamA := WithTenant(context.Background(), "team-a")
+	teamB := WithTenant(context.Background(), "team-b")
+	if err := repo.UpdateJob(teamA, entity.Job{TaskID: "task-a", Type: "backup", Status: "Successful", Vault: "vault-a"}); err != nil {
+		t.Fatalf("seed UpdateJob failed: %v", err)
+	}
+	if err := repo.UpdateJob(teamB, entity.Job{TaskID: "task-b", Type: "backup", Status: "Successful", Vault: "vault-b"}); err != nil {
+		t.Fatalf("seed UpdateJob failed: %v", err)
+	}
+	// a later update without a tenant keeps the owner
+	if err := repo.UpdateJob(context.Background(), entity.Job{TaskID: "task-a", Type: "backup", Status: "Successful", Vault: "vault-a"}); err != nil {
+		t.Fatalf("UpdateJob failed: %v", err)
+	}
+
+	testCases := []struct {
+		name          string
+		ctx           context.Context
+		expectedTasks []string
+	}{
+		{
+			name:          "global",
+			ctx:           context.Background(),
+			expectedTasks: []string{"task-a", "task-b"},
+		},
+		{
+			name:          "team-a",
+			ctx:           teamA,
+			expectedTasks: []string{"task-a"},
+		},
+		{
+			name:          "unknown tenant",
+			ctx:           WithTenant(context.Background(), "team-c"),
+			expectedTasks: []string{},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			jobs, err := repo.ListJobs(tc.ctx, entity.JobsFilter{})
+			if err != nil {
+				t.Fatalf("ListJobs failed: %v", err)
+			}
+			got := make([]string, 0, len(jobs))
+			for _, job := range jobs {
+				got = append(got, job.TaskID)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tc.expectedTasks, ",") {
+				t.Fatalf("expected %v, got %v", tc.expectedTasks, got)
+			}
+		})
+	}
+
+	job, err := repo.SelectEverything(context.Background(), "task-a")
+	if err != nil {
+		t.Fatalf("SelectEverything failed: %v", err)
+	}
+	if job.Tenant != "team-a" {
+		t.Fatalf("expected tenant team-a, got %q", job.Tenant)
+	}
+	if _, err := repo.SelectEverything(teamB, "task-a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected %v for another tenant, got: %v", ErrNotFound, err)
+	}
+	if err := repo.RemoveVault(teamB, "vault-a"); !errors.Is(err, ErrNoVaults) {
+		t.Fatalf("expected %v for another tenant, got: %v", ErrNoVaults, err)
+	}
+	if err := repo.RemoveVault(teamA, "vault-a"); err != nil {
+		t.Fatalf("RemoveVault failed: %v", err)
+	}
+}
+
+func TestNewConnectionMigratesOldSchema_Integration(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "database.db")
+
+	old, err := sqlx.Connect("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to connect to DB: %v", err)
+	}
+	_, err = old.Exec(`CREATE TABLE jobs (task_id TEXT PRIMARY KEY, type TEXT, status TEXT, vault TEXT, err TEXT);
+		INSERT INTO jobs VALUES ('task-0', 'backup', 'Successful', 'vault0', '');`)
+	if err != nil {
+		t.Fatalf("Failed to create old schema: %v", err)
+	}
+	_ = old.Close()
+
+	dbConn, err := db.NewConnection(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to migrate DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	repo := NewDBRepo(dbConn)
+	seed := entity.Job{
+		TaskID:           "task-1",
+		Type:             "restore",
+		Status:           "Failed",
+		Databases:        `["db1","db2"]`,
+		DatabaseStatuses: `{"db1":"Failed","db2":"Queued"}`,
+		Bucket:           "backups-b",
+	}
+	if err := repo.UpdateJob(context.Background(), seed); err != nil {
+		t.Fatalf("UpdateJob failed: %v", err)
+	}
+
+	for _, expected := range []entity.Job{
+		{TaskID: "task-0", Type: "backup", Status: "Successful", Vault: "vault0"},
+		seed,
+	} {
+		job, err := repo.SelectEverything(context.Background(), expected.TaskID)
+		if err != nil {
+			t.Fatalf("SelectEverything failed: %v", err)
+		}
+		// rows of the old schema have no created time
+		if expected.TaskID == seed.TaskID {
+			if job.CreatedAt == 0 {
+				t.Fatalf("expected a created time for %s", job.TaskID)
+			}
+			job.CreatedAt, job.UpdatedAt = 0, 0
+		}
+		if job != expected {
+			t.Fatalf("expected job: %v, got: %v", expected, job)
+		}
+	}
+}
+
+func TestPruneJobs_Integration(t *testing.T) {
+	dbConn := newTestDB(t)
+	defer dbConn.Close()
+
+	repo := NewDBRepo(dbConn)
+	seeds := []entity.Job{
+		{TaskID: "task-1", Type: "backup", Status: "Successful", Vault: "vault1"},
+		{TaskID: "task-2", Type: "backup", Status: "Failed", Vault: "vault2"},
+		{TaskID: "task-3", Type: "backup", Status: "Processing", Vault: "vault3"},
+		{TaskID: "task-4", Type: "restore", Status: "Successful", Vault: "vault4"},
+		{TaskID: "task-5", Type: "backup", Status: "Canceled", Vault: "vault5"},
+	}
+	for _, seed := range seeds {
+		if err := repo.UpdateJob(context.Background(), seed); err != nil {
+			t.Fatalf("seed UpdateJob failed: %v", err)
+		}
+	}
+
+	pruned, err := repo.PruneJobs(context.Background(), time.Now().Add(-time.Hour), nil)
+	if err != nil {
+		t.Fatalf("PruneJobs failed: %v", err)
+	}
+	if pruned != 0 {
+		t.Fatalf("expected fresh jobs to be kept, pruned %d", pruned)
+	}
+
+	pruned, err = repo.PruneJobs(context.Background(), time.Now().Add(time.Hour), []string{"vault4"})
+	if err != nil {
+		t.Fatalf("PruneJobs failed: %v", err)
+	}
+	if pruned != 3 {
+		t.Fatalf("expected 3 pruned jobs, got %d", pruned)
+	}
+
+	testCases := []struct {
+		taskID      string
+		expectedErr error
+	}{
+		{taskID: "task-1", expectedErr: ErrNotFound},
+		{taskID: "task-2", expectedErr: ErrNotFound},
+		{taskID: "task-3", expectedErr: nil},
+		{taskID: "task-4", expectedErr: nil},
+		{taskID: "task-5", expectedErr: ErrNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.taskID, func(t *testing.T) {
+			_, err := repo.SelectEverything(context.Background(), tc.taskID)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected %v, got: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestListJobs_Integration(t *testing.T) {
+	dbConn := newTestDB(t)
+	defer dbConn.Close()
+
+	repo := NewDBRepo(dbConn)
+	seeds := []entity.Job{
+		{TaskID: "task-1", Type: "backup", Status: "Successful", StorageName: "foo"},
+		{TaskID: "task-2", Type: "incremental backup", Status: "Failed", StorageName: "foo"},
+		{TaskID: "task-3", Type: "backup", Status: "Successful", StorageName: "bar"},
+		{TaskID: "task-4", Type: "restore", Status: "Successful", StorageName: "foo"},
+	}
+	for _, seed := range seeds {
+		if err := repo.UpdateJob(context.Background(), seed); err != nil {
+			t.Fatalf("seed UpdateJob failed: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		filter   entity.JobsFilter
+		expected []string
+		total    int
+	}{
+		{
+			name:     "no filter",
+			filter:   entity.JobsFilter{},
+			expected: []string{"task-1", "task-2", "task-3", "task-4"},
+			total:    4,
+		},
+		{
+			name:     "by storage name",
+			filter:   entity.JobsFilter{StorageName: "foo", Types: []string{"backup", "incremental backup"}},
+			expected: []string{"task-1", "task-2"},
+			total:    2,
+		},
+		{
+			name:     "by status",
+			filter:   entity.JobsFilter{StorageName: "foo", Status: "Successful"},
+			expected: []string{"task-1", "task-4"},
+			total:    2,
+		},
+		{
+			name:     "first page",
+			filter:   entity.JobsFilter{Limit: 2},
+			expected: []string{"task-3", "task-4"},
+			total:    4,
+		},
+		{
+			name:     "second page",
+			filter:   entity.JobsFilter{Limit: 2, Offset: 1},
+			expected: []string{"task-2", "task-3"},
+			total:    4,
+		},
+		{
+			name:     "page past the end",
+			filter:   entity.JobsFilter{StorageName: "foo", Limit: 2, Offset: 3},
+			expected: []string{},
+			total:    3,
+		},
+		{
+			name:     "no matches",
+			filter:   entity.JobsFilter{StorageName: "baz"},
+			expected: []string{},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			jobs, err := repo.ListJobs(context.Background(), tc.filter)
+			if err != nil {
+				t.Fatalf("ListJobs failed: %v", err)
+			}
+			got := make([]string, 0, len(jobs))
+			for _, job := range jobs {
+				got = append(got, job.TaskID)
+			}
+			sort.Strings(got)
+			total, err := repo.CountJobs(context.Background(), tc.filter)
+			if err != nil {
+				t.Fatalf("CountJobs failed: %v", err)
+			}
+			if total != tc.total {
+				t.Fatalf("expected total %d, got %d", tc.total, total)
+			}
+			if len(got) != len(tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, got)
+			}
+			for i := range got {
+				if got[i] != tc.expected[i] {
+					t.Fatalf("expected %v, got %v", tc.expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestVacuum_Integration(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "database.db")
+	dbConn, err := db.NewConnection(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to connect to DB: %v", err)
+	}
+	defer dbConn.Close()
+
+	repo := NewDBRepo(dbConn)
+	ctx := context.Background()
+	for i := 0; i < 500; i++ {
+		job := entity.Job{TaskID: fmt.Sprintf("task-%d", i), Type: "backup", Status: "Successful", Vault: fmt.Sprintf("vault%d", i),
+			Err: strings.Repeat("e", 1024)}
+		if err := repo.UpdateJob(ctx, job); err != nil {
+			t.Fatalf("seed UpdateJob failed: %v", err)
+		}
+	}
+	if err := repo.UpdateJob(ctx, entity.Job{TaskID: "kept", Type: "backup", Status: "Processing", Vault: "kept"}); err != nil {
+		t.Fatalf("UpdateJob failed: %v", err)
+	}
+	if _, err := repo.PruneJobs(ctx, time.Now().Add(time.Hour), nil); err != nil {
+		t.Fatalf("PruneJobs failed: %v", err)
+	}
+
+	if err := repo.Vacuum(ctx); err != nil {
+		t.Fatalf("Vacuum failed: %v", err)
+	}
+	wal, err := os.Stat(dbPath + "-wal")
+	if err == nil && wal.Size() != 0 {
+		t.Fatalf("expected wal to be truncated, got %d bytes", wal.Size())
+	}
+	if _, err := repo.SelectEverything(ctx, "kept"); err != nil {
+		t.Fatalf("expected job to survive vacuum, got: %v", err)
+	}
+}
+
+func TestFailUnfinishedJobs_Integration(t *testing.T) {
+	dbConn := newTestDB(t)
+	defer dbConn.Close()
+
+	repo := NewDBRepo(dbConn)
+	ctx := context.Background()
+	seeds := []entity.Job{
+		{TaskID: "task-1", Type: "backup", Status: "Queued", Vault: "vault1"},
+		{TaskID: "task-2", Type: "backup", Status: "Processing", Vault: "vault2"},
+		{TaskID: "task-3", Type: "backup", Status: "Successful", Vault: "vault3"},
+		{TaskID: "task-4", Type: "restore", Status: "Failed", Vault: "vault4", Err: "restore failed"},
+	}
+	for _, seed := range seeds {
+		if err := repo.UpdateJob(ctx, seed); err != nil {
+			t.Fatalf("seed UpdateJob failed: %v", err)
+		}
+	}
+
+	failed, err := repo.FailUnfinishedJobs(ctx, "interrupted by restart")
+	if err != nil {
+		t.Fatalf("FailUnfinishedJobs failed: %v", err)
+	}
+	if failed != 2 {
+		t.Fatalf("expected 2 failed jobs, got %d", failed)
+	}
+
+	testCases := []struct {
+		taskID         string
+		expectedStatus string
+		expectedErr    string
+	}{
+		{taskID: "task-1", expectedStatus: "Failed", expectedErr: "interrupted by restart"},
+		{taskID: "task-2", expectedStatus: "Failed", expectedErr: "interrupted by restart"},
+		{taskID: "task-3", expectedStatus: "Successful", expectedErr: ""},
+		{taskID: "task-4", expectedStatus: "Failed", expectedErr: "restore failed"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.taskID, func(t *testing.T) {
+			job, err := repo.SelectEverything(ctx, tc.taskID)
+			if err != nil {
+				t.Fatalf("SelectEverything failed: %v", err)
+			}
+			if job.Status != tc.expectedStatus || job.Err != tc.expectedErr {
+				t.Fatalf("expected %s %q, got %s %q", tc.expectedStatus, tc.expectedErr, job.Status, job.Err)
+			}
+		})
+	}
+}
+
+func TestSettings_Integration(t *testing.T) {
+	conn := newTestDB(t)
+	defer conn.Close()
+	repo := NewDBRepo(conn)
+	ctx := context.Background()
+
+	if _, err := repo.GetSetting(ctx, "eviction_policy"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a missing setting, got %v", err)
+	}
+	for _, value := range []string{"0/1d", "1d/7d"} {
+		if err := repo.SetSetting(ctx, "eviction_policy", value); err != nil {
+			t.Fatalf("SetSetting failed: %v", err)
+		}
+		got, err := repo.GetSetting(ctx, "eviction_policy")
+		if err != nil {
+			t.Fatalf("GetSetting failed: %v", err)
+		}
+		if got != value {
+			t.Fatalf("expected %q, got %q", value, got)
+		}
+	}
+	for range 2 {
+		if err := repo.DeleteSetting(ctx, "eviction_policy"); err != nil {
+			t.Fatalf("DeleteSetting failed: %v", err)
+		}
+		if _, err := repo.GetSetting(ctx, "eviction_policy"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound for a deleted setting, got %v", err)
+		}
+	}
+}
@@ -1,7 +1,12 @@
 package repo
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"math"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -11,24 +16,56 @@ import (
 	"time"
 
 	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/util"
+	"go.uber.org/zap"
 )
 
 const VaultNameFormat = "20060102T150405"
+
+// DefaultVaultNamePattern matches the part of a vault directory name after its last underscore.
+const DefaultVaultNamePattern = `(?i)\d{8}T\d{4,6}`
 const FULL = "full"
 const GRANULAR = "granular"
 const ALL = "all"
 const SHARDED = "sharded"
+const GoldenMarker = ".golden"
+
+// EvictLock protects a vault from eviction, forever when empty or until the RFC 3339 time it holds.
+const EvictLock = ".evictlock"
+
+// MetricsComplete is the .metrics key a backup sets to false when it starts and to true once it ends.
+const MetricsComplete = "complete"
+
+var ErrNoGolden = errors.New("no golden backup")
+
+// ErrPartialList is returned by ListComplete when some vaults could not be read.
+var ErrPartialList = errors.New("some vaults could not be listed")
+var ErrInvalidPath = errors.New("invalid backup file path")
 
 type StorageRepository interface {
 	GetVault(vaultName string, external bool, vaultPath string, blobPath string, skipFSCheck bool) entity.Vault
 	FindByTS(timestamp string, typeOfBackup string, storagePath string) (string, error)
+	FindLatestInRange(fromTs string, toTs string, typeOfBackup string, storagePath string) (string, error)
 	OpenVault(vaultName string, allowEviction bool, isGranular bool, isSharded bool, isExternal bool, vaultPath string, backupPrefix string, blobPath string) entity.Vault
 	Evict(vaultName string) error
 	ProtGetAsStream(backupID string, archiveFile string) (*os.File, error)
+	ListFiles(backupID string) ([]string, error)
 	List(typeOfBackup string, storagePath string) ([]entity.Vault, error)
+	ListComplete(typeOfBackup string, storagePath string) ([]entity.Vault, error)
+	ListInProgress(typeOfBackup string, storagePath string) ([]entity.Vault, error)
 	ListVaultNames(convertToTs bool, typeOfBackup string, storagePath string) ([]string, error)
 	GetNonEvictableVaults(typeOfBackup string) (map[int64]bool, error)
 	GetName(folder string) string
+	GetVaultSize(vault entity.Vault) (int64, error)
+	IsSuccessful(vault entity.Vault) bool
+	IsIncomplete(vault entity.Vault) bool
+	GetFreeSpace() (int64, error)
+	GetFSStats() (util.FSStats, error)
+	CheckRoot() error
+	SetGolden(vault entity.Vault) error
+	GetGolden() (entity.Vault, error)
+	LockUntil(vault entity.Vault, until time.Time) error
+	IsVaultName(name string) bool
 }
 
 type StorageRepo struct {
@@ -40,9 +77,23 @@ type StorageRepo struct {
 	allowPrefix         bool
 	vaultDirnameMatcher *regexp.Regexp
 	skipLockCheck       bool
+	logger              *zap.SugaredLogger
+
+	// prefixFullBackups names full backups like granular ones, with the prefix and namespace
+	prefixFullBackups bool
 }
 
-func NewStorageRepo(root string, externalRoot string, namespace string, allowPrefix bool) StorageRepository {
+// NewStorageRepo lists directories whose name after the last underscore matches vaultNameMatcher as vaults,
+// nil uses DefaultVaultNamePattern. Their creation time is still parsed from a VaultNameFormat suffix,
+// vaults named otherwise are taken as created now. A nil logger discards the warnings of List.
+func NewStorageRepo(root string, externalRoot string, namespace string, allowPrefix bool, prefixFullBackups bool,
+	vaultNameMatcher *regexp.Regexp, logger *zap.SugaredLogger) StorageRepository {
+	if vaultNameMatcher == nil {
+		vaultNameMatcher = regexp.MustCompile(DefaultVaultNamePattern)
+	}
+	if logger == nil {
+		logger = zap.NewNop().Sugar()
+	}
 	return &StorageRepo{
 		root:                root,
 		granularFolder:      filepath.Join(root, GRANULAR),
@@ -50,8 +101,11 @@ func NewStorageRepo(root string, externalRoot string, namespace string, allowPre
 		namespace:           namespace,
 		restoreLogsFolder:   filepath.Join(root, "restore_logs"),
 		allowPrefix:         allowPrefix,
-		vaultDirnameMatcher: regexp.MustCompile(`(?i)\d{8}T\d{4,6}`),
+		vaultDirnameMatcher: vaultNameMatcher,
 		skipLockCheck:       strings.ToLower(os.Getenv("SKIP_LOCK_CHECK")) == "true",
+		logger:              logger,
+
+		prefixFullBackups: prefixFullBackups,
 	}
 }
 
@@ -61,7 +115,7 @@ func (v *StorageRepo) GetVault(vaultName string, external bool, vaultPath string
 	}
 
 	makeVault := func(folder string) entity.Vault {
-		return entity.Vault{
+		vault := entity.Vault{
 			Folder:             folder,
 			TimeStamp:          v.createTime(vaultName),
 			MetricsFilePath:    fmt.Sprintf("%s/.metrics", folder),
@@ -72,12 +126,17 @@ func (v *StorageRepo) GetVault(vaultName string, external bool, vaultPath string
 			IsLocked:           v.isLocked(folder),
 			IsGranular:         v.isGranular(folder),
 		}
+		vault.IsIncomplete = v.IsIncomplete(vault)
+		return vault
 	}
 
 	if !external {
 		if strings.TrimSpace(blobPath) != "" {
 			base := filepath.Join(v.root, blobPath)
 			folder := filepath.Join(base, vaultName)
+			if !within(v.root, folder) {
+				return entity.Vault{}
+			}
 
 			if skipFSCheck || v.exists(folder) {
 				return makeVault(folder)
@@ -86,12 +145,15 @@ func (v *StorageRepo) GetVault(vaultName string, external bool, vaultPath string
 		}
 
 		folder := filepath.Join(v.root, vaultName)
+		if !within(v.root, folder) {
+			return entity.Vault{}
+		}
 		if skipFSCheck || v.exists(folder) {
 			return makeVault(folder)
 		}
 
 		granularFolderPath := filepath.Join(v.granularFolder, vaultName)
-		if v.exists(granularFolderPath) {
+		if within(v.granularFolder, granularFolderPath) && v.exists(granularFolderPath) {
 			vault := makeVault(granularFolderPath)
 			vault.IsGranular = true
 			return vault
@@ -102,6 +164,9 @@ func (v *StorageRepo) GetVault(vaultName string, external bool, vaultPath string
 
 	if len(vaultPath) > 0 {
 		externalFolder := filepath.Join(v.externalRoot, vaultPath, vaultName)
+		if !within(v.externalRoot, externalFolder) {
+			return entity.Vault{}
+		}
 		if skipFSCheck || v.exists(externalFolder) {
 			vault := makeVault(externalFolder)
 			vault.External = true
@@ -129,6 +194,44 @@ func (v *StorageRepo) FindByTS(timestamp string, typeOfBackup string, storagePat
 	return "", fmt.Errorf("%w in timestamp %s", ErrNoVaults, timestamp)
 }
 
+// FindLatestInRange returns the newest vault with a timestamp between fromTs and toTs, both
+// inclusive epoch milliseconds, an empty bound leaves that side of the window open.
+func (v *StorageRepo) FindLatestInRange(fromTs string, toTs string, typeOfBackup string, storagePath string) (string, error) {
+	from, err := parseRangeBound(fromTs, 0)
+	if err != nil {
+		return "", err
+	}
+	to, err := parseRangeBound(toTs, math.MaxInt64)
+	if err != nil {
+		return "", err
+	}
+	vaults, err := v.List(typeOfBackup, storagePath)
+	if err != nil && !errors.Is(err, ErrNoVaults) {
+		return "", fmt.Errorf("error listing vaults: %w", err)
+	}
+	latest := entity.Vault{}
+	for _, vault := range vaults {
+		if vault.TimeStamp >= from && vault.TimeStamp <= to && (latest.Folder == "" || vault.TimeStamp > latest.TimeStamp) {
+			latest = vault
+		}
+	}
+	if latest.Folder == "" {
+		return "", fmt.Errorf("%w between %s and %s", ErrNoVaults, fromTs, toTs)
+	}
+	return filepath.Base(latest.Folder), nil
+}
+
+func parseRangeBound(value string, unset int64) (int64, error) {
+	if value == "" {
+		return unset, nil
+	}
+	ts, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("timestamp %s is in incorrect format: %w", value, err)
+	}
+	return ts, nil
+}
+
 func (v *StorageRepo) OpenVault(vaultName string, allowEviction bool, isGranular bool, isSharded bool,
 	isExternal bool, vaultPath string, backupPrefix string, blobPath string) entity.Vault {
 	vault := v.GetVault(vaultName, isExternal, vaultPath, blobPath, false)
@@ -168,17 +271,64 @@ func (v *StorageRepo) Evict(vaultName string) error {
 }
 
 func (v *StorageRepo) ProtGetAsStream(backupID string, archiveFile string) (*os.File, error) {
+	if !v.isVaultID(backupID) {
+		return nil, fmt.Errorf("%w: backup %s", ErrInvalidPath, backupID)
+	}
 	backupFolder := v.GetVault(backupID, false, "", "", false).Folder
+	if backupFolder == "" {
+		return nil, fmt.Errorf("error opening backup file: backup %s: %w", backupID, os.ErrNotExist)
+	}
 	fullFilePath := filepath.Join(backupFolder, archiveFile)
+	if !within(v.root, backupFolder) || !within(backupFolder, fullFilePath) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPath, archiveFile)
+	}
 	file, err := os.Open(fullFilePath)
 	if err != nil {
-		return nil, fmt.Errorf("error opening backup file: %v", err)
+		return nil, fmt.Errorf("error opening backup file: %w", err)
+	}
+	if info, err := file.Stat(); err != nil || info.IsDir() {
+		_ = file.Close()
+		return nil, fmt.Errorf("%w: %s is not a file", ErrInvalidPath, archiveFile)
 	}
 	return file, nil
 }
 
+// ListFiles returns the paths of the files in a backup vault relative to its folder.
+func (v *StorageRepo) ListFiles(backupID string) ([]string, error) {
+	if !v.isVaultID(backupID) {
+		return nil, fmt.Errorf("%w: backup %s", ErrInvalidPath, backupID)
+	}
+	backupFolder := v.GetVault(backupID, false, "", "", false).Folder
+	if backupFolder == "" {
+		return nil, fmt.Errorf("error listing backup files: backup %s: %w", backupID, os.ErrNotExist)
+	}
+	if !within(v.root, backupFolder) {
+		return nil, fmt.Errorf("%w: backup %s", ErrInvalidPath, backupID)
+	}
+	var files []string
+	err := filepath.WalkDir(backupFolder, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(backupFolder, filePath)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing backup files: %w", err)
+	}
+	return files, nil
+}
+
 func (v *StorageRepo) createTime(folderName string) int64 {
-	parts := strings.Split(folderName, "_")
+	// List passes granular vaults as granular/<name>
+	parts := strings.Split(filepath.Base(folderName), "_")
 	if len(parts) == 0 {
 		return time.Now().UnixMilli()
 	}
@@ -193,6 +343,45 @@ func (v *StorageRepo) createTime(folderName string) int64 {
 	return t.UnixMilli()
 }
 
+// ParseVaultName splits a vault name named by getVaultName into its prefix and namespace,
+// ok is false when the name carries no namespace.
+func ParseVaultName(name string) (prefix string, namespace string, ok bool) {
+	parts := strings.Split(filepath.Base(name), "_")
+	if len(parts) < 2 {
+		return "", "", false
+	}
+	dateStr := parts[len(parts)-1]
+	if idx := strings.LastIndex(dateStr, "."); idx != -1 {
+		dateStr = dateStr[:idx]
+	}
+	if _, err := time.Parse(VaultNameFormat, dateStr); err != nil {
+		return "", "", false
+	}
+	namespace = parts[len(parts)-2]
+	if namespace == "" {
+		return "", "", false
+	}
+	return strings.Join(parts[:len(parts)-2], "_"), namespace, true
+}
+
+// IsVaultName reports whether a directory named name is listed as a vault.
+func (v *StorageRepo) IsVaultName(name string) bool {
+	parts := strings.Split(name, "_")
+	return v.vaultDirnameMatcher.MatchString(parts[len(parts)-1])
+}
+
+// isVaultID reports whether a backup id from a request names a single vault directory.
+func (v *StorageRepo) isVaultID(backupID string) bool {
+	return backupID == filepath.Base(backupID) && backupID != "." && backupID != ".." &&
+		!strings.Contains(backupID, `\`) && v.IsVaultName(backupID)
+}
+
+// within reports whether the cleaned path lies strictly under the cleaned root.
+func within(root string, path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(root), filepath.Clean(path))
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 func (v *StorageRepo) GetName(folder string) string {
 	return v.basename(folder)
 }
@@ -206,20 +395,74 @@ func (v *StorageRepo) exists(path string) bool {
 	return !os.IsNotExist(err)
 }
 
+// checkReadable fails when path can't be resolved or is a directory whose entries can't be read.
+func checkReadable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return nil
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if _, err := dir.Readdirnames(1); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// List skips and logs the vaults it cannot read, so one broken vault does not hide the others.
 func (v *StorageRepo) List(typeOfBackup string, storagePath string) ([]entity.Vault, error) {
+	vaults, _, err := v.list(typeOfBackup, storagePath, false)
+	return vaults, err
+}
+
+// ListInProgress lists the vaults List hides while a backup still writes them, those holding a .lock.
+func (v *StorageRepo) ListInProgress(typeOfBackup string, storagePath string) ([]entity.Vault, error) {
+	vaults, _, err := v.list(typeOfBackup, storagePath, true)
+	return vaults, err
+}
+
+// ListComplete lists like List but fails with ErrPartialList when a vault was skipped,
+// callers that remove what is not listed must not act on a partial list.
+func (v *StorageRepo) ListComplete(typeOfBackup string, storagePath string) ([]entity.Vault, error) {
+	vaults, skipped, err := v.list(typeOfBackup, storagePath, false)
+	if err != nil {
+		return vaults, err
+	}
+	if len(skipped) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPartialList, strings.Join(skipped, ", "))
+	}
+	return vaults, nil
+}
+
+// list returns the readable vaults and the paths it skipped, the locked ones instead when inProgress is set.
+func (v *StorageRepo) list(typeOfBackup string, storagePath string, inProgress bool) ([]entity.Vault, []string, error) {
 	storageRootPath := filepath.Join(v.externalRoot, storagePath)
 	if len(storagePath) == 0 {
 		storageRootPath = v.root
 	}
 	var dirs []string
 	if !v.exists(storageRootPath) {
-		return []entity.Vault{}, ErrNoVaults
+		if len(storagePath) > 0 && len(v.externalRoot) > 0 {
+			// the external mount is configured, no backups were placed under it yet
+			v.logger.Warnf("external storage %s does not exist, listing no external backups", storageRootPath)
+			return []entity.Vault{}, nil, nil
+		}
+		return []entity.Vault{}, nil, ErrNoVaults
 	}
+	var skipped []string
 	if typeOfBackup == GRANULAR || typeOfBackup == ALL {
 		pathToDir := filepath.Join(storageRootPath, GRANULAR)
 		files, err := os.ReadDir(pathToDir)
 		if err != nil && !os.IsNotExist(err) {
-			return nil, fmt.Errorf("failed to read dir %s: %v", pathToDir, err)
+			// only the storage root is required, granular vaults are skipped until their folder is readable again
+			v.logger.Warnf("skipping granular vaults, failed to read dir %s: %v", pathToDir, err)
+			skipped = append(skipped, pathToDir)
 		}
 		for _, file := range files {
 			dirs = append(dirs, filepath.Join(GRANULAR, file.Name()))
@@ -228,7 +471,7 @@ func (v *StorageRepo) List(typeOfBackup string, storagePath string) ([]entity.Va
 	if typeOfBackup == FULL || typeOfBackup == ALL {
 		files, err := os.ReadDir(storageRootPath)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read dir %s: %v", storageRootPath, err)
+			return nil, nil, fmt.Errorf("failed to read dir %s: %v", storageRootPath, err)
 		}
 		for _, file := range files {
 			dirs = append(dirs, file.Name())
@@ -236,11 +479,13 @@ func (v *StorageRepo) List(typeOfBackup string, storagePath string) ([]entity.Va
 	}
 	var vaults []entity.Vault
 	for _, dir := range dirs {
-		trimmed := strings.Replace(dir, GRANULAR+"/", "", 1)
-		parts := strings.Split(trimmed, "_")
-		lastPart := parts[len(parts)-1]
-		if v.vaultDirnameMatcher.MatchString(lastPart) {
-			vault := v.GetVault(dir, false, storagePath, "", true)
+		if v.IsVaultName(strings.Replace(dir, GRANULAR+"/", "", 1)) {
+			vault := v.GetVault(dir, len(storagePath) > 0, storagePath, "", true)
+			if err := checkReadable(vault.Folder); err != nil {
+				v.logger.Warnf("skipping vault %s: %v", dir, err)
+				skipped = append(skipped, dir)
+				continue
+			}
 			vaults = append(vaults, vault)
 		}
 	}
@@ -253,20 +498,20 @@ func (v *StorageRepo) List(typeOfBackup string, storagePath string) ([]entity.Va
 		}
 		vaults = shardedVaults
 	}
-	if !v.skipLockCheck {
-		var lockedVaults []entity.Vault
+	if !v.skipLockCheck || inProgress {
+		var keptVaults []entity.Vault
 		for _, vault := range vaults {
-			if !v.exists(filepath.Join(vault.Folder, ".lock")) {
-				lockedVaults = append(lockedVaults, vault)
+			if v.exists(filepath.Join(vault.Folder, ".lock")) == inProgress {
+				keptVaults = append(keptVaults, vault)
 			}
 		}
-		vaults = lockedVaults
+		vaults = keptVaults
 	}
 
 	sort.Slice(vaults, func(i, j int) bool {
 		return vaults[i].TimeStamp < vaults[j].TimeStamp
 	})
-	return vaults, nil
+	return vaults, skipped, nil
 }
 
 func (v *StorageRepo) ListVaultNames(convertToTs bool, typeOfBackup string, storagePath string) ([]string, error) {
@@ -285,8 +530,10 @@ func (v *StorageRepo) ListVaultNames(convertToTs bool, typeOfBackup string, stor
 	return vaultNames, nil
 }
 
+// getVaultName names a new vault [<prefix>_]<namespace>_<timestamp> when prefixes are allowed, createTime
+// reads the timestamp back from the last part.
 func (v *StorageRepo) getVaultName(prefix string, isGranular bool) string {
-	if !isGranular || v.namespace == "" || !v.allowPrefix {
+	if (!isGranular && !v.prefixFullBackups) || v.namespace == "" || !v.allowPrefix {
 		return time.Now().Format(VaultNameFormat)
 	}
 	vaultName := ""
@@ -312,13 +559,159 @@ func (v *StorageRepo) GetNonEvictableVaults(typeOfBackup string) (map[int64]bool
 		return nil, fmt.Errorf("error listing vaults: %v", err)
 	}
 	for _, vault := range listVaults {
-		if v.exists(filepath.Join(vault.Folder, ".evictlock")) {
+		if v.evictLocked(vault, time.Now()) {
 			vaults[vault.TimeStamp] = true
 		}
 	}
 	return vaults, nil
 }
 
+// GetVaultSize returns the size recorded in the vault's .metrics, walking the folder when it is missing.
+func (v *StorageRepo) GetVaultSize(vault entity.Vault) (int64, error) {
+	if metrics, err := v.readMetrics(vault); err == nil {
+		if size, ok := metrics["size"].(float64); ok {
+			return int64(size), nil
+		}
+	}
+	size, err := util.DirSize(vault.Folder)
+	if err != nil {
+		return 0, fmt.Errorf("failed to calculate size of %s: %w", vault.Folder, err)
+	}
+	return size, nil
+}
+
+// IsSuccessful reports whether the vault has complete .metrics without an exception, non-zero exit code or cancel mark.
+func (v *StorageRepo) IsSuccessful(vault entity.Vault) bool {
+	if vault.IsFailed || vault.Canceled || vault.IsLocked {
+		return false
+	}
+	metrics, err := v.readMetrics(vault)
+	if err != nil {
+		return false
+	}
+	if complete, ok := metrics[MetricsComplete].(bool); ok && !complete {
+		return false
+	}
+	if exception, ok := metrics["exception"]; ok && exception != nil && exception != "" {
+		return false
+	}
+	if exitCode, ok := metrics["exit_code"].(float64); ok && exitCode != 0 {
+		return false
+	}
+	if canceled, ok := metrics["canceled"].(bool); ok && canceled {
+		return false
+	}
+	return true
+}
+
+// IsIncomplete reports whether the backup into the vault was interrupted. Its .metrics still hold
+// the incomplete mark written when the backup started, vaults without the mark count as complete.
+func (v *StorageRepo) IsIncomplete(vault entity.Vault) bool {
+	metrics, err := v.readMetrics(vault)
+	if err != nil {
+		return false
+	}
+	complete, ok := metrics[MetricsComplete].(bool)
+	return ok && !complete
+}
+
+func (v *StorageRepo) readMetrics(vault entity.Vault) (map[string]interface{}, error) {
+	metricsPath := vault.MetricsFilePath
+	if strings.TrimSpace(metricsPath) == "" {
+		metricsPath = filepath.Join(vault.Folder, ".metrics")
+	}
+	data, err := os.ReadFile(metricsPath)
+	if err != nil {
+		return nil, err
+	}
+	var metrics map[string]interface{}
+	if err := json.Unmarshal(data, &metrics); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", metricsPath, err)
+	}
+	return metrics, nil
+}
+
+func (v *StorageRepo) GetFreeSpace() (int64, error) {
+	free, err := util.FreeSpace(v.root)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get free space of %s: %w", v.root, err)
+	}
+	return free, nil
+}
+
+// CheckRoot fails when the storage root can't be read.
+func (v *StorageRepo) CheckRoot() error {
+	if err := checkReadable(v.root); err != nil {
+		return fmt.Errorf("failed to read storage root %s: %w", v.root, err)
+	}
+	return nil
+}
+
+func (v *StorageRepo) GetFSStats() (util.FSStats, error) {
+	stats, err := util.Stats(v.root)
+	if err != nil {
+		return util.FSStats{}, fmt.Errorf("failed to get filesystem stats of %s: %w", v.root, err)
+	}
+	return stats, nil
+}
+
+// SetGolden marks vault as the designated restore source. Any other golden vault is
+// demoted first, so at most one marker exists even if writing the new one fails.
+func (v *StorageRepo) SetGolden(vault entity.Vault) error {
+	vaults, err := v.List(ALL, "")
+	if err != nil && !errors.Is(err, ErrNoVaults) {
+		return fmt.Errorf("error listing vaults: %w", err)
+	}
+	for _, other := range vaults {
+		if other.Folder == vault.Folder {
+			continue
+		}
+		if err := os.Remove(filepath.Join(other.Folder, GoldenMarker)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to demote golden backup %s: %w", v.GetName(other.Folder), err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(vault.Folder, GoldenMarker), nil, 0o644); err != nil {
+		return fmt.Errorf("failed to write golden marker for %s: %w", v.GetName(vault.Folder), err)
+	}
+	return nil
+}
+
+// LockUntil protects the vault from eviction until the given time.
+func (v *StorageRepo) LockUntil(vault entity.Vault, until time.Time) error {
+	if err := os.WriteFile(filepath.Join(vault.Folder, EvictLock), []byte(until.UTC().Format(time.RFC3339)), 0o644); err != nil {
+		return fmt.Errorf("failed to write evict lock for %s: %w", v.GetName(vault.Folder), err)
+	}
+	return nil
+}
+
+// evictLocked reports whether the vault evict lock is in force at now. A lock that is
+// empty or unreadable as a time never expires.
+func (v *StorageRepo) evictLocked(vault entity.Vault, now time.Time) bool {
+	data, err := os.ReadFile(filepath.Join(vault.Folder, EvictLock))
+	if err != nil {
+		return !os.IsNotExist(err)
+	}
+	until, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		return true
+	}
+	return now.Before(until)
+}
+
+// GetGolden returns the golden vault, the newest one if markers were placed by hand on several.
+func (v *StorageRepo) GetGolden() (entity.Vault, error) {
+	vaults, err := v.List(ALL, "")
+	if err != nil && !errors.Is(err, ErrNoVaults) {
+		return entity.Vault{}, fmt.Errorf("error listing vaults: %w", err)
+	}
+	for i := len(vaults) - 1; i >= 0; i-- {
+		if v.exists(filepath.Join(vaults[i].Folder, GoldenMarker)) {
+			return vaults[i], nil
+		}
+	}
+	return entity.Vault{}, ErrNoGolden
+}
+
 func (v *StorageRepo) isLocked(folder string) bool {
 	return v.exists(filepath.Join(folder, ".lock"))
 }
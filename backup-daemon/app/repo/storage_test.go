@@ -3,10 +3,13 @@ package repo
 import (
 	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
 )
@@ -97,7 +100,7 @@ func TestGetVault(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			storageRepo := NewStorageRepo("./", "./",
-				"namespace", false)
+				"namespace", false, false, nil, nil)
 			vault := storageRepo.GetVault(tc.vaultName, tc.external, tc.vaultPath, "", tc.skipFSCheck)
 			if !reflect.DeepEqual(vault, tc.expectedVault) {
 				t.Fatalf("Expected Vault %v, got %v", tc.expectedVault, vault)
@@ -152,7 +155,7 @@ func TestFindByTS(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			storageRepo := NewStorageRepo("./", "fileSystem",
-				"namespace", false)
+				"namespace", false, false, nil, nil)
 			fileName, err := storageRepo.FindByTS(tc.timeStamp, tc.typeOfBackup, tc.storagePath)
 			if !errors.Is(err, tc.expectedError) {
 				t.Fatalf("Expected error %v, got %v", tc.expectedError, err)
@@ -194,7 +197,7 @@ func TestListValueName(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			storageRepo := NewStorageRepo("./", "fileSystem",
-				"namespace", false)
+				"namespace", false, false, nil, nil)
 			vaults, err := storageRepo.ListVaultNames(tc.convertToTS, tc.typeOfBackup, tc.storagePath)
 			if !errors.Is(err, tc.expectedError) {
 				t.Fatalf("Expected error %v, got %v", tc.expectedError, err)
@@ -208,3 +211,522 @@ func TestListValueName(t *testing.T) {
 		})
 	}
 }
+
+func TestGolden(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"20250101T000000", "20250102T000000"} {
+		if err := os.MkdirAll(filepath.Join(root, name), 0o755); err != nil {
+			t.Fatalf("failed to create vault: %v", err)
+		}
+	}
+	storageRepo := NewStorageRepo(root, "", "", false, false, nil, nil)
+
+	if _, err := storageRepo.GetGolden(); !errors.Is(err, ErrNoGolden) {
+		t.Fatalf("expected %v, got %v", ErrNoGolden, err)
+	}
+
+	testCases := []struct {
+		name     string
+		promote  string
+		expected string
+		demoted  string
+	}{
+		{name: "first promotion", promote: "20250101T000000", expected: "20250101T000000"},
+		{name: "promotion demotes previous", promote: "20250102T000000", expected: "20250102T000000", demoted: "20250101T000000"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			vault := storageRepo.GetVault(tc.promote, false, "", "", false)
+			if err := storageRepo.SetGolden(vault); err != nil {
+				t.Fatalf("SetGolden failed: %v", err)
+			}
+			golden, err := storageRepo.GetGolden()
+			if err != nil {
+				t.Fatalf("GetGolden failed: %v", err)
+			}
+			if storageRepo.GetName(golden.Folder) != tc.expected {
+				t.Fatalf("expected golden %s, got %s", tc.expected, storageRepo.GetName(golden.Folder))
+			}
+			if tc.demoted != "" {
+				if _, err := os.Stat(filepath.Join(root, tc.demoted, GoldenMarker)); !os.IsNotExist(err) {
+					t.Fatalf("expected %s to be demoted, stat err: %v", tc.demoted, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLockUntil(t *testing.T) {
+	root := t.TempDir()
+	storageRepo := NewStorageRepo(root, "", "", false, false, nil, nil)
+	testCases := []struct {
+		name     string
+		vault    string
+		until    time.Time
+		expected bool
+	}{
+		{name: "retention in the future", vault: "20250101T000000", until: time.Now().Add(time.Hour), expected: true},
+		{name: "expired retention", vault: "20250102T000000", until: time.Now().Add(-time.Hour), expected: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := os.MkdirAll(filepath.Join(root, tc.vault), 0o755); err != nil {
+				t.Fatalf("failed to create vault: %v", err)
+			}
+			vault := storageRepo.GetVault(tc.vault, false, "", "", false)
+			if err := storageRepo.LockUntil(vault, tc.until); err != nil {
+				t.Fatalf("LockUntil failed: %v", err)
+			}
+			locked, err := storageRepo.GetNonEvictableVaults(ALL)
+			if err != nil {
+				t.Fatalf("GetNonEvictableVaults failed: %v", err)
+			}
+			if locked[vault.TimeStamp] != tc.expected {
+				t.Fatalf("expected locked %v, got %v", tc.expected, locked[vault.TimeStamp])
+			}
+		})
+	}
+}
+
+func TestOpenVaultPrefixFullBackups(t *testing.T) {
+	testCases := []struct {
+		name              string
+		prefixFullBackups bool
+		isGranular        bool
+		expectedName      string
+	}{
+		{name: "full backup not prefixed", expectedName: `^\d{8}T\d{6}$`},
+		{name: "full backup prefixed", prefixFullBackups: true, expectedName: `^pre_ns_\d{8}T\d{6}$`},
+		{name: "granular backup prefixed", isGranular: true, expectedName: `^pre_ns_\d{8}T\d{6}$`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			storageRepo := NewStorageRepo(root, "", "ns", true, tc.prefixFullBackups, nil, nil)
+
+			vault := storageRepo.OpenVault("", true, tc.isGranular, false, false, "", "pre", "")
+			name := storageRepo.GetName(vault.Folder)
+			if !regexp.MustCompile(tc.expectedName).MatchString(name) {
+				t.Fatalf("expected vault name matching %s, got %s", tc.expectedName, name)
+			}
+			if err := os.MkdirAll(vault.Folder, 0o755); err != nil {
+				t.Fatalf("failed to create vault: %v", err)
+			}
+
+			vaults, err := storageRepo.List(ALL, "")
+			if err != nil {
+				t.Fatalf("List failed: %v", err)
+			}
+			if len(vaults) != 1 {
+				t.Fatalf("expected 1 vault, got %d", len(vaults))
+			}
+			created, _ := time.Parse(VaultNameFormat, name[len(name)-len(VaultNameFormat):])
+			if vaults[0].TimeStamp != created.UnixMilli() {
+				t.Fatalf("expected timestamp %d, got %d", created.UnixMilli(), vaults[0].TimeStamp)
+			}
+			found, err := storageRepo.FindByTS(strconv.FormatInt(created.UnixMilli(), 10), ALL, "")
+			if err != nil {
+				t.Fatalf("FindByTS failed: %v", err)
+			}
+			if found != name {
+				t.Fatalf("expected FindByTS to find %s, got %s", name, found)
+			}
+		})
+	}
+}
+
+func TestListVaultNameMatcher(t *testing.T) {
+	testCases := []struct {
+		name     string
+		matcher  *regexp.Regexp
+		expected []string
+	}{
+		{name: "default pattern", expected: []string{"20240101T000000"}},
+		{name: "migrated names", matcher: regexp.MustCompile(`^dump-\d{4}-\d{2}-\d{2}$`), expected: []string{"dump-2024-01-02"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			for _, dir := range []string{"20240101T000000", "dump-2024-01-02", "restore_logs"} {
+				if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+					t.Fatalf("failed to create %s: %v", dir, err)
+				}
+			}
+			storageRepo := NewStorageRepo(root, "", "", false, false, tc.matcher, nil)
+
+			vaults, err := storageRepo.List(FULL, "")
+			if err != nil {
+				t.Fatalf("List failed: %v", err)
+			}
+			var names []string
+			for _, vault := range vaults {
+				names = append(names, storageRepo.GetName(vault.Folder))
+			}
+			if !reflect.DeepEqual(names, tc.expected) {
+				t.Fatalf("expected vaults %v, got %v", tc.expected, names)
+			}
+		})
+	}
+}
+
+func TestProtGetAsStream(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "20240101T000000", "schema"), 0o755); err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "20240101T000000", "schema", "db.dump"), []byte("dump"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "secret"), []byte("secret"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	storageRepo := NewStorageRepo(root, "", "", false, false, nil, nil)
+
+	testCases := []struct {
+		name        string
+		backupID    string
+		path        string
+		expectedErr error
+	}{
+		{name: "file", backupID: "20240101T000000", path: "schema/db.dump"},
+		{name: "traversal", backupID: "20240101T000000", path: "../secret", expectedErr: ErrInvalidPath},
+		{name: "directory", backupID: "20240101T000000", path: "schema", expectedErr: ErrInvalidPath},
+		{name: "vault folder", backupID: "20240101T000000", path: "/", expectedErr: ErrInvalidPath},
+		{name: "missing file", backupID: "20240101T000000", path: "missing", expectedErr: os.ErrNotExist},
+		{name: "missing vault", backupID: "20240102T000000", path: "schema/db.dump", expectedErr: os.ErrNotExist},
+		{name: "parent backup id", backupID: "..", path: "secret", expectedErr: ErrInvalidPath},
+		{name: "nested backup id", backupID: "20240101T000000/..", path: "secret", expectedErr: ErrInvalidPath},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			file, err := storageRepo.ProtGetAsStream(tc.backupID, tc.path)
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_ = file.Close()
+		})
+	}
+
+	files, err := storageRepo.ListFiles("20240101T000000")
+	if err != nil {
+		t.Fatalf("ListFiles failed: %v", err)
+	}
+	if !reflect.DeepEqual(files, []string{"schema/db.dump"}) {
+		t.Fatalf("expected files [schema/db.dump], got %v", files)
+	}
+	if _, err := storageRepo.ListFiles(".."); !errors.Is(err, ErrInvalidPath) {
+		t.Fatalf("expected error %v listing the parent of the storage root, got %v", ErrInvalidPath, err)
+	}
+	for _, name := range []string{"..", "../" + filepath.Base(root)} {
+		if vault := storageRepo.GetVault(name, false, "", "", false); vault.Folder != "" {
+			t.Fatalf("expected no vault for %s, got %s", name, vault.Folder)
+		}
+	}
+	if vault := storageRepo.GetVault("20240101T000000", false, "", "..", false); vault.Folder != "" {
+		t.Fatalf("expected no vault outside the storage root, got %s", vault.Folder)
+	}
+}
+
+func TestIncompleteVault(t *testing.T) {
+	testCases := []struct {
+		name               string
+		metrics            string
+		expectedIncomplete bool
+		expectedSuccessful bool
+	}{
+		{name: "interrupted backup", metrics: `{"complete":false}`, expectedIncomplete: true},
+		{name: "finished backup", metrics: `{"complete":true,"size":1}`, expectedSuccessful: true},
+		{name: "backup without mark", metrics: `{"size":1}`, expectedSuccessful: true},
+		{name: "no metrics"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			folder := filepath.Join(root, "20240101T000000")
+			if err := os.MkdirAll(folder, 0o755); err != nil {
+				t.Fatalf("failed to create vault: %v", err)
+			}
+			if tc.metrics != "" {
+				if err := os.WriteFile(filepath.Join(folder, ".metrics"), []byte(tc.metrics), 0o644); err != nil {
+					t.Fatalf("failed to write metrics: %v", err)
+				}
+			}
+			storageRepo := NewStorageRepo(root, "", "", false, false, nil, nil)
+
+			vaults, err := storageRepo.List(ALL, "")
+			if err != nil {
+				t.Fatalf("List failed: %v", err)
+			}
+			if len(vaults) != 1 || vaults[0].IsIncomplete != tc.expectedIncomplete {
+				t.Fatalf("expected one vault with incomplete %v, got %v", tc.expectedIncomplete, vaults)
+			}
+			if successful := storageRepo.IsSuccessful(vaults[0]); successful != tc.expectedSuccessful {
+				t.Fatalf("expected successful %v, got %v", tc.expectedSuccessful, successful)
+			}
+		})
+	}
+}
+
+func TestFindLatestInRange(t *testing.T) {
+	root := t.TempDir()
+	names := []string{"20240101T000000", "20240102T000000", "20240103T000000"}
+	for _, name := range names {
+		if err := os.MkdirAll(filepath.Join(root, name), 0o755); err != nil {
+			t.Fatalf("failed to create vault: %v", err)
+		}
+	}
+	millis := func(name string) string {
+		created, _ := time.Parse(VaultNameFormat, name)
+		return strconv.FormatInt(created.UnixMilli(), 10)
+	}
+	storageRepo := NewStorageRepo(root, "", "", true, false, nil, nil)
+
+	testCases := []struct {
+		name        string
+		fromTs      string
+		toTs        string
+		expected    string
+		expectedErr error
+	}{
+		{name: "open window", expected: "20240103T000000"},
+		{name: "inclusive bounds", fromTs: millis("20240101T000000"), toTs: millis("20240102T000000"), expected: "20240102T000000"},
+		{name: "only from", fromTs: millis("20240102T000000"), expected: "20240103T000000"},
+		{name: "only to", toTs: millis("20240101T000000"), expected: "20240101T000000"},
+		{name: "nothing in window", fromTs: millis("20240104T000000"), expectedErr: ErrNoVaults},
+		{name: "bad format", fromTs: "yesterday", expectedErr: strconv.ErrSyntax},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			found, err := storageRepo.FindLatestInRange(tc.fromTs, tc.toTs, ALL, "")
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FindLatestInRange failed: %v", err)
+			}
+			if found != tc.expected {
+				t.Fatalf("expected %s, got %s", tc.expected, found)
+			}
+		})
+	}
+}
+
+func TestListSkipsUnreadableEntries(t *testing.T) {
+	testCases := []struct {
+		name     string
+		setup    func(t *testing.T, root string)
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name: "unreadable granular folder",
+			setup: func(t *testing.T, root string) {
+				if err := os.WriteFile(filepath.Join(root, GRANULAR), []byte("not a dir"), 0o644); err != nil {
+					t.Fatalf("failed to write file: %v", err)
+				}
+			},
+			expected: []string{"20240101T000000"},
+		},
+		{
+			name: "unresolvable vault",
+			setup: func(t *testing.T, root string) {
+				loop := filepath.Join(root, "20240102T000000")
+				if err := os.Symlink(loop, loop); err != nil {
+					t.Fatalf("failed to create symlink: %v", err)
+				}
+			},
+			expected: []string{"20240101T000000"},
+		},
+		{
+			name: "vault without read permission",
+			setup: func(t *testing.T, root string) {
+				if os.Geteuid() == 0 {
+					t.Skip("permissions are not enforced for root")
+				}
+				if err := os.Mkdir(filepath.Join(root, "20240103T000000"), 0o000); err != nil {
+					t.Fatalf("failed to create vault: %v", err)
+				}
+				t.Cleanup(func() { _ = os.Chmod(filepath.Join(root, "20240103T000000"), 0o755) })
+			},
+			expected: []string{"20240101T000000"},
+		},
+		{
+			name: "unreadable root",
+			setup: func(t *testing.T, root string) {
+				if err := os.RemoveAll(root); err != nil {
+					t.Fatalf("failed to remove root: %v", err)
+				}
+				if err := os.WriteFile(root, []byte("not a dir"), 0o644); err != nil {
+					t.Fatalf("failed to write file: %v", err)
+				}
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := filepath.Join(t.TempDir(), "storage")
+			if err := os.MkdirAll(filepath.Join(root, "20240101T000000"), 0o755); err != nil {
+				t.Fatalf("failed to create vault: %v", err)
+			}
+			tc.setup(t, root)
+			storageRepo := NewStorageRepo(root, "", "", false, false, nil, nil)
+
+			vaults, err := storageRepo.List(ALL, "")
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got vaults %v", vaults)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("List failed: %v", err)
+			}
+			var names []string
+			for _, vault := range vaults {
+				names = append(names, storageRepo.GetName(vault.Folder))
+			}
+			if !reflect.DeepEqual(names, tc.expected) {
+				t.Fatalf("expected vaults %v, got %v", tc.expected, names)
+			}
+			if _, err := storageRepo.ListComplete(ALL, ""); !errors.Is(err, ErrPartialList) {
+				t.Fatalf("expected error %v from ListComplete, got %v", ErrPartialList, err)
+			}
+		})
+	}
+}
+
+func TestListExternalRoot(t *testing.T) {
+	testCases := []struct {
+		name         string
+		externalRoot func(t *testing.T) string
+		expected     int
+		expectedErr  error
+	}{
+		{
+			name:         "not configured",
+			externalRoot: func(t *testing.T) string { return "" },
+			expectedErr:  ErrNoVaults,
+		},
+		{
+			name: "configured but missing",
+			externalRoot: func(t *testing.T) string {
+				return t.TempDir()
+			},
+		},
+		{
+			name: "present but empty",
+			externalRoot: func(t *testing.T) string {
+				root := t.TempDir()
+				if err := os.MkdirAll(filepath.Join(root, "mount"), 0o755); err != nil {
+					t.Fatalf("failed to create external storage: %v", err)
+				}
+				return root
+			},
+		},
+		{
+			name: "present with a backup",
+			externalRoot: func(t *testing.T) string {
+				root := t.TempDir()
+				if err := os.MkdirAll(filepath.Join(root, "mount", "20240101T000000"), 0o755); err != nil {
+					t.Fatalf("failed to create vault: %v", err)
+				}
+				return root
+			},
+			expected: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			storageRepo := NewStorageRepo(t.TempDir(), tc.externalRoot(t), "", false, false, nil, nil)
+
+			vaults, err := storageRepo.List(FULL, "mount")
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if len(vaults) != tc.expected {
+				t.Fatalf("expected %d vaults, got %v", tc.expected, vaults)
+			}
+		})
+	}
+}
+
+func TestParseVaultName(t *testing.T) {
+	testCases := []struct {
+		name              string
+		vault             string
+		expectedPrefix    string
+		expectedNamespace string
+		expectedOK        bool
+	}{
+		{name: "namespace only", vault: "ns-a_20240101T000000", expectedNamespace: "ns-a", expectedOK: true},
+		{name: "prefix and namespace", vault: "granular_ns-a_20240101T000000", expectedPrefix: "granular", expectedNamespace: "ns-a", expectedOK: true},
+		{name: "prefix with underscores", vault: "my_prefix_ns-a_20240101T000000", expectedPrefix: "my_prefix", expectedNamespace: "ns-a", expectedOK: true},
+		{name: "granular folder path", vault: "/backup/granular/p_ns-a_20240101T000000", expectedPrefix: "p", expectedNamespace: "ns-a", expectedOK: true},
+		{name: "timestamp only", vault: "20240101T000000"},
+		{name: "no timestamp", vault: "ns-a_latest"},
+		{name: "empty namespace", vault: "p__20240101T000000"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			prefix, namespace, ok := ParseVaultName(tc.vault)
+			if ok != tc.expectedOK || prefix != tc.expectedPrefix || namespace != tc.expectedNamespace {
+				t.Fatalf("expected (%q, %q, %v), got (%q, %q, %v)", tc.expectedPrefix, tc.expectedNamespace, tc.expectedOK, prefix, namespace, ok)
+			}
+		})
+	}
+}
+
+func TestListInProgress(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"20240101T000000", "20240102T000000"} {
+		if err := os.MkdirAll(filepath.Join(root, name), 0o755); err != nil {
+			t.Fatalf("failed to create vault: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "20240102T000000", ".lock"), nil, 0o644); err != nil {
+		t.Fatalf("failed to lock vault: %v", err)
+	}
+	storageRepo := NewStorageRepo(root, "", "", false, false, nil, nil)
+
+	vaults, err := storageRepo.List(FULL, "")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(vaults) != 1 || storageRepo.GetName(vaults[0].Folder) != "20240101T000000" {
+		t.Fatalf("expected List to return 20240101T000000 only, got %v", vaults)
+	}
+	vaults, err = storageRepo.ListInProgress(FULL, "")
+	if err != nil {
+		t.Fatalf("ListInProgress failed: %v", err)
+	}
+	if len(vaults) != 1 || storageRepo.GetName(vaults[0].Folder) != "20240102T000000" {
+		t.Fatalf("expected ListInProgress to return 20240102T000000 only, got %v", vaults)
+	}
+}
+
+func TestCheckRoot(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "storage")
+	if err := os.Mkdir(root, 0o755); err != nil {
+		t.Fatalf("failed to create root: %v", err)
+	}
+	storageRepo := NewStorageRepo(root, "", "", false, false, nil, nil)
+	if err := storageRepo.CheckRoot(); err != nil {
+		t.Fatalf("expected readable root, got %v", err)
+	}
+	if err := os.Remove(root); err != nil {
+		t.Fatalf("failed to remove root: %v", err)
+	}
+	if err := storageRepo.CheckRoot(); err == nil {
+		t.Fatalf("expected an error for a removed root")
+	}
+}
@@ -1,20 +1,57 @@
 package rest
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/controller"
 	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/repo"
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/util"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
+// apiKeyHeader carries the key a request is scoped to its tenant with.
+const apiKeyHeader = "X-API-Key"
+
+// MaxPresignExpiry is the longest expiry S3 accepts for a presigned URL.
+const MaxPresignExpiry = 7 * 24 * time.Hour
+
 type EndpointHandler struct {
 	backupDaemonUseCase controller.BackupDaemonUseCase
 	logger              *zap.SugaredLogger
+	notReady            error
+	logLevel            *zap.AtomicLevel
+
+	// readyChecks are rerun by RunReadyChecks, /ready reports the failures of the last run
+	readyChecks []readyCheck
+	readyMu     sync.Mutex
+	checkFailed error
+
+	// presignDefaultExpiry applies when a request sets no expiration, a longer one than presignMaxExpiry is rejected
+	presignDefaultExpiry time.Duration
+	presignMaxExpiry     time.Duration
+
+	// tenantHeader or tenantAPIKeys scope a request to a tenant, both empty keep jobs global
+	tenantHeader  string
+	tenantAPIKeys map[string]string
+
+	// backupAges reports the backup ages in /health without listing the vaults on every probe
+	backupAges func() (entity.BackupAges, bool)
+}
+
+type readyCheck struct {
+	name  string
+	check func(ctx context.Context) error
 }
 
 func NewEndpointHandler(backupDaemonUseCase controller.BackupDaemonUseCase, logger *zap.SugaredLogger) *EndpointHandler {
@@ -38,7 +75,7 @@ func (h *EndpointHandler) Backup(ctx *gin.Context) {
 	response, err := h.backupDaemonUseCase.EnqueueBackup(ctx, request)
 	if err != nil {
 		h.logger.Errorf("failed to enqueue backup err: %v", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{
+		ctx.JSON(h.enqueueBackupStatus(ctx, err), gin.H{
 			"message": fmt.Sprintf("failed to enqueue backup err: %v", err),
 		})
 		return
@@ -46,9 +83,50 @@ func (h *EndpointHandler) Backup(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, response)
 }
 
+// RetryBackup enqueues a failed backup again with the request it was started with.
+func (h *EndpointHandler) RetryBackup(ctx *gin.Context) {
+	backupID := ctx.Param("backup_id")
+	response, err := h.backupDaemonUseCase.RetryBackup(ctx, backupID)
+	if err != nil {
+		h.logger.Errorf("failed to retry backup err: %v", err)
+		status := h.enqueueBackupStatus(ctx, err)
+		switch {
+		case errors.Is(err, controller.ErrJobNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, controller.ErrBackupNotRetryable):
+			status = http.StatusConflict
+		}
+		ctx.JSON(status, gin.H{
+			"message": fmt.Sprintf("failed to retry backup err: %v", err),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// enqueueBackupStatus is the status of a backup request EnqueueBackup rejected with err.
+func (h *EndpointHandler) enqueueBackupStatus(ctx *gin.Context, err error) int {
+	switch {
+	case errors.Is(err, controller.ErrInvalidRetention), errors.Is(err, controller.ErrBucketNotAllowed),
+		errors.Is(err, controller.ErrExcludedDBRequested), errors.Is(err, controller.ErrInvalidBaseBackup),
+		errors.Is(err, controller.ErrBackupNotSuccessful):
+		return http.StatusBadRequest
+	case errors.Is(err, controller.ErrBackupNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, controller.ErrBackupCapReached), errors.Is(err, controller.ErrLowInodes):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, controller.ErrCommandOverrideNotAllowed):
+		return http.StatusForbidden
+	case errors.Is(err, controller.ErrOverloaded):
+		h.setRetryAfter(ctx)
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
 func (h *EndpointHandler) Restore(ctx *gin.Context) {
 	var request entity.RestoreRequest
-	// TODO the unknown values it need to give to custom vars format {"vault":"20190321T080000", "dbs":["db1","db2","db3"], "changeDbNames":{"db1":"new_db1_name","db2":"new_db2_name"},  //unknown "clean":"true"}
+	// format {"vault":"20190321T080000", "dbs":["db1","db2","db3"], "changeDbNames":{"db1":"new_db1_name","db2":"new_db2_name"}, "clean":true}
 	if err := ctx.ShouldBindJSON(&request); err != nil {
 		h.logger.Errorf("failed to unmarshall body err: %v", err)
 		ctx.JSON(http.StatusBadRequest, gin.H{
@@ -56,18 +134,58 @@ func (h *EndpointHandler) Restore(ctx *gin.Context) {
 		})
 		return
 	}
-	if len(request.Vault) == 0 && len(request.TimeStamp) == 0 {
+	request.Vault = strings.TrimSpace(request.Vault)
+	request.TimeStamp = strings.TrimSpace(request.TimeStamp)
+	request.FromTs = strings.TrimSpace(request.FromTs)
+	request.ToTs = strings.TrimSpace(request.ToTs)
+	ranged := len(request.FromTs) > 0 || len(request.ToTs) > 0
+	if len(request.Vault) == 0 && len(request.TimeStamp) == 0 && !ranged {
 		h.logger.Error("Sorry, wrong JSON string. No 'vault' or 'ts' parameter")
 		ctx.JSON(http.StatusNotFound, gin.H{
 			"message": "Sorry, wrong JSON string. No 'vault' or 'ts' parameter",
 		})
 		return
 	}
+	if ranged && (len(request.Vault) > 0 || len(request.TimeStamp) > 0) {
+		h.logger.Error("Sorry, wrong JSON string. 'fromTs'/'toTs' are set together with 'vault' or 'ts'")
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Sorry, wrong JSON string. 'fromTs'/'toTs' can't be used with 'vault' or 'ts'",
+		})
+		return
+	}
+	if ranged {
+		if err := validateTimeWindow(request.FromTs, request.ToTs); err != nil {
+			h.logger.Errorf("invalid restore time window err: %v", err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": fmt.Sprintf("invalid restore time window err: %v", err),
+			})
+			return
+		}
+	}
+	// an empty vault is treated as absent, so only two non-empty values are ambiguous
+	if len(request.Vault) > 0 && len(request.TimeStamp) > 0 {
+		h.logger.Error("Sorry, wrong JSON string. Both 'vault' and 'ts' parameters are set")
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Sorry, wrong JSON string. Both 'vault' and 'ts' parameters are set, use only one of them",
+		})
+		return
+	}
 	request.ProcType = getProcType(ctx.Request.URL.Path)
 	response, err := h.backupDaemonUseCase.RestoreBackup(ctx, request)
 	if err != nil {
 		h.logger.Errorf("failed to restore backup err: %v", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, controller.ErrFullRestoreDisabled), errors.Is(err, controller.ErrRestoreNamespaceNotAllowed):
+			status = http.StatusForbidden
+		case errors.Is(err, controller.ErrBackupIncomplete), errors.Is(err, controller.ErrUnsupportedManifest):
+			status = http.StatusConflict
+		case errors.Is(err, controller.ErrRenameCollision), errors.Is(err, controller.ErrVaultNamespaceUnknown):
+			status = http.StatusBadRequest
+		case errors.Is(err, controller.ErrBackupNotFound):
+			status = http.StatusNotFound
+		}
+		ctx.JSON(status, gin.H{
 			"message": fmt.Sprintf("failed to restore backup err: %v", err),
 		})
 		return
@@ -75,36 +193,206 @@ func (h *EndpointHandler) Restore(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, response)
 }
 
+func (h *EndpointHandler) RestoreFromURL(ctx *gin.Context) {
+	var request entity.RestoreFromURLRequest
+	// format {"url":"https://bucket.s3.amazonaws.com/20190321T080000.tar.gz?X-Amz-Signature=...", "dbs":["db1"]}
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		h.logger.Errorf("failed to unmarshall body err: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": fmt.Sprintf("failed to unmarshall body err: %v", err),
+		})
+		return
+	}
+	response, err := h.backupDaemonUseCase.RestoreFromURL(ctx, request)
+	if err != nil {
+		h.logger.Errorf("failed to restore backup from url err: %v", err)
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, controller.ErrURLNotAllowed):
+			status = http.StatusBadRequest
+		case errors.Is(err, util.ErrDownloadTooLarge):
+			status = http.StatusRequestEntityTooLarge
+		case errors.Is(err, controller.ErrRestoreNamespaceNotAllowed):
+			status = http.StatusForbidden
+		}
+		ctx.JSON(status, gin.H{
+			"message": fmt.Sprintf("failed to restore backup from url err: %v", err),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (h *EndpointHandler) RestoreMulti(ctx *gin.Context) {
+	var request entity.MultiRestoreRequest
+	// format {"vaults":["20190321T080000","20190322T080000"], "continueOnError":true} or {"from":"1553155200000", "to":"1553241600000"}
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		h.logger.Errorf("failed to unmarshall body err: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": fmt.Sprintf("failed to unmarshall body err: %v", err),
+		})
+		return
+	}
+	request.ProcType = getProcType(ctx.Request.URL.Path)
+	response, err := h.backupDaemonUseCase.RestoreMulti(ctx, request)
+	if err != nil && len(response.Restored) == 0 && len(response.Failed) == 0 {
+		h.logger.Errorf("failed to restore backups err: %v", err)
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, controller.ErrInvalidMultiRestore):
+			status = http.StatusBadRequest
+		case errors.Is(err, controller.ErrBackupNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, controller.ErrRestoreNamespaceNotAllowed):
+			status = http.StatusForbidden
+		}
+		ctx.JSON(status, gin.H{
+			"message": fmt.Sprintf("failed to restore backups err: %v", err),
+		})
+		return
+	}
+	if err != nil {
+		h.logger.Errorf("restore of backups partially failed err: %v", err)
+		ctx.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (h *EndpointHandler) RestoreLatest(ctx *gin.Context) {
+	var request entity.RestoreRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil && ctx.Request.ContentLength > 0 {
+		h.logger.Errorf("failed to unmarshall body err: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": fmt.Sprintf("failed to unmarshall body err: %v", err),
+		})
+		return
+	}
+	typeOfBackup, err := getBackupType(ctx.Query("type"))
+	if err != nil {
+		h.logger.Errorf("failed to parse backup type err: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	request.ProcType = getProcType(ctx.Request.URL.Path)
+	response, err := h.backupDaemonUseCase.RestoreLatestBackup(ctx, entity.RestoreLatestRequest{
+		Restore:      request,
+		TypeOfBackup: typeOfBackup,
+	})
+	if err != nil {
+		h.logger.Errorf("failed to restore latest backup err: %v", err)
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, controller.ErrNoSuccessfulBackup):
+			status = http.StatusNotFound
+		case errors.Is(err, controller.ErrFullRestoreDisabled), errors.Is(err, controller.ErrRestoreNamespaceNotAllowed):
+			status = http.StatusForbidden
+		case errors.Is(err, controller.ErrRenameCollision), errors.Is(err, controller.ErrVaultNamespaceUnknown):
+			status = http.StatusBadRequest
+		}
+		ctx.JSON(status, gin.H{
+			"message": fmt.Sprintf("failed to restore latest backup err: %v", err),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
 func (h *EndpointHandler) Evict(ctx *gin.Context) {
 	procType := getProcType(ctx.Request.URL.Path)
 	request := entity.EvictRequest{
 		ProcType: procType,
 	}
 
-	err := h.backupDaemonUseCase.EnqueueEviction(ctx, request)
-	if err != nil {
+	response, err := h.backupDaemonUseCase.EnqueueEviction(ctx, request)
+	if err != nil && len(response.Evicted) == 0 {
 		h.logger.Errorf("failed to enqueue eviction err: %v", err)
+		response.Message = fmt.Sprintf("failed to enqueue eviction err: %v", err)
+		ctx.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	response.Message = "OK"
+	if err != nil {
+		h.logger.Errorf("eviction partially failed err: %v", err)
+		response.Message = "eviction partially failed"
+	} else if response.NothingToEvict {
+		response.Message = "nothing to evict"
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// EvictionPolicy replaces the eviction policies without a restart and answers the ones now in effect.
+func (h *EndpointHandler) EvictionPolicy(ctx *gin.Context) {
+	var request entity.EvictionPolicyRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		h.logger.Errorf("failed to unmarshall body err: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": fmt.Sprintf("failed to unmarshall body err: %v", err),
+		})
+		return
+	}
+
+	response, err := h.backupDaemonUseCase.SetEvictionPolicy(ctx, request)
+	if err != nil {
+		h.logger.Errorf("failed to set eviction policy err: %v", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, controller.ErrInvalidEvictionPolicy) {
+			status = http.StatusBadRequest
+		}
+		ctx.JSON(status, gin.H{
+			"message": fmt.Sprintf("failed to set eviction policy err: %v", err),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// GetEvictionPolicy answers the eviction policies in effect.
+func (h *EndpointHandler) GetEvictionPolicy(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.backupDaemonUseCase.GetEvictionPolicy(ctx))
+}
+
+// ResetEvictionPolicy drops the eviction policies set at runtime and answers the configured ones now in effect.
+func (h *EndpointHandler) ResetEvictionPolicy(ctx *gin.Context) {
+	response, err := h.backupDaemonUseCase.ResetEvictionPolicy(ctx)
+	if err != nil {
+		h.logger.Errorf("failed to reset eviction policy err: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"message": fmt.Sprintf("failed to enqueue eviction err: %v", err),
+			"message": fmt.Sprintf("failed to reset eviction policy err: %v", err),
 		})
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"message": "OK",
-	})
+	ctx.JSON(http.StatusOK, response)
 }
 
+// EvictByVault removes a backup by vault name, with the blobPath query it is removed like a V2
+// backup so its S3 objects under the blob path are deleted too.
 func (h *EndpointHandler) EvictByVault(ctx *gin.Context) {
 	vault := ctx.Param("vault")
-	procType := getProcType(ctx.Request.URL.Path)
-	request := entity.EvictByVaultRequest{
-		Vault:    vault,
-		ProcType: procType,
+	var err error
+	if blob := normalizeBlobPath(ctx.Query("blobPath")); blob != "" {
+		err = h.backupDaemonUseCase.RemoveBackupV2(ctx, entity.EvictByVaultV2Request{
+			Vault:    vault,
+			BlobPath: blob,
+		})
+	} else {
+		err = h.backupDaemonUseCase.RemoveBackup(ctx, entity.EvictByVaultRequest{
+			Vault:    vault,
+			ProcType: getProcType(ctx.Request.URL.Path),
+		})
 	}
-	err := h.backupDaemonUseCase.RemoveBackup(ctx, request)
 	if err != nil {
 		h.logger.Errorf("failed to remove backup err: %v", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, controller.ErrBackupNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, controller.ErrVaultBusy), errors.Is(err, controller.ErrObjectLocked):
+			status = http.StatusConflict
+		}
+		ctx.JSON(status, gin.H{
 			"message": fmt.Sprintf("failed to remove backup err: %v", err),
 		})
 		return
@@ -114,6 +402,194 @@ func (h *EndpointHandler) EvictByVault(ctx *gin.Context) {
 	})
 }
 
+func (h *EndpointHandler) CopyBackup(ctx *gin.Context) {
+	var request entity.CopyBackupRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil && ctx.Request.ContentLength > 0 {
+		h.logger.Errorf("failed to unmarshall body err: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": fmt.Sprintf("failed to unmarshall body err: %v", err),
+		})
+		return
+	}
+	request.BackupID = ctx.Param("backup_id")
+	request.BlobPath = normalizeBlobPath(request.BlobPath)
+
+	response, err := h.backupDaemonUseCase.CopyBackup(ctx, request)
+	if err != nil {
+		h.logger.Errorf("failed to copy backup err: %v", err)
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, controller.ErrBackupNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, controller.ErrS3Disabled):
+			status = http.StatusBadRequest
+		case errors.Is(err, controller.ErrBackupIncomplete):
+			status = http.StatusConflict
+		}
+		ctx.JSON(status, gin.H{
+			"message": fmt.Sprintf("failed to copy backup err: %v", err),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (h *EndpointHandler) PromoteBackup(ctx *gin.Context) {
+	backupID := ctx.Param("backup_id")
+	if err := h.backupDaemonUseCase.PromoteBackup(ctx, backupID); err != nil {
+		h.logger.Errorf("failed to promote backup err: %v", err)
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, controller.ErrBackupNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, controller.ErrBackupNotSuccessful):
+			status = http.StatusBadRequest
+		}
+		ctx.JSON(status, gin.H{
+			"message": fmt.Sprintf("failed to promote backup err: %v", err),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+	})
+}
+
+func (h *EndpointHandler) GoldenBackup(ctx *gin.Context) {
+	response, err := h.backupDaemonUseCase.GetGoldenBackup(ctx)
+	if err != nil {
+		h.logger.Errorf("failed to get golden backup err: %v", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, repo.ErrNoGolden) {
+			status = http.StatusNotFound
+		}
+		ctx.JSON(status, gin.H{
+			"message": fmt.Sprintf("failed to get golden backup err: %v", err),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (h *EndpointHandler) FailJob(ctx *gin.Context) {
+	taskID := ctx.Param("task_id")
+	if err := h.backupDaemonUseCase.FailJob(ctx, taskID); err != nil {
+		h.logger.Errorf("failed to fail job err: %v", err)
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, controller.ErrJobNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, controller.ErrJobFinished):
+			status = http.StatusConflict
+		}
+		ctx.JSON(status, gin.H{
+			"message": fmt.Sprintf("failed to fail job err: %v", err),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+	})
+}
+
+// CancelBackup cancels the databases of a per database backup that have not started yet,
+// or a watched backup still waiting for its completion marker.
+func (h *EndpointHandler) CancelBackup(ctx *gin.Context) {
+	backupID := ctx.Param("backup_id")
+	if err := h.backupDaemonUseCase.CancelBackup(ctx, backupID); err != nil {
+		h.logger.Errorf("failed to cancel backup err: %v", err)
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, controller.ErrJobNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, controller.ErrJobFinished), errors.Is(err, controller.ErrBackupNotQueued):
+			status = http.StatusConflict
+		}
+		ctx.JSON(status, gin.H{
+			"message": fmt.Sprintf("failed to cancel backup err: %v", err),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+	})
+}
+
+func (h *EndpointHandler) VacuumDB(ctx *gin.Context) {
+	if err := h.backupDaemonUseCase.VacuumDB(ctx); err != nil {
+		h.logger.Errorf("failed to vacuum database err: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": fmt.Sprintf("failed to vacuum database err: %v", err),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+	})
+}
+
+func (h *EndpointHandler) Reconcile(ctx *gin.Context) {
+	response, err := h.backupDaemonUseCase.Reconcile(ctx)
+	if err != nil {
+		h.logger.Errorf("failed to reconcile jobs with storage err: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": fmt.Sprintf("failed to reconcile jobs with storage err: %v", err),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (h *EndpointHandler) S3Orphans(ctx *gin.Context) {
+	response, err := h.backupDaemonUseCase.ListS3Orphans(ctx)
+	if err != nil {
+		h.logger.Errorf("failed to list orphaned s3 objects err: %v", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, controller.ErrS3Disabled) {
+			status = http.StatusBadRequest
+		}
+		ctx.JSON(status, gin.H{
+			"message": fmt.Sprintf("failed to list orphaned s3 objects err: %v", err),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// CleanS3Orphans only lists the orphans unless dryRun=false is passed, a job row pruned
+// by the janitor would make the prefix of an existing blob path backup look orphaned.
+func (h *EndpointHandler) CleanS3Orphans(ctx *gin.Context) {
+	dryRun := true
+	if value := ctx.Query("dryRun"); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": fmt.Sprintf("invalid dryRun '%s'", value),
+			})
+			return
+		}
+	}
+	response, err := h.backupDaemonUseCase.CleanS3Orphans(ctx, dryRun)
+	if err != nil {
+		h.logger.Errorf("failed to clean orphaned s3 objects err: %v", err)
+		if errors.Is(err, controller.ErrS3Disabled) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": fmt.Sprintf("failed to clean orphaned s3 objects err: %v", err),
+			})
+			return
+		}
+		if len(response.Orphans) == 0 {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": fmt.Sprintf("failed to clean orphaned s3 objects err: %v", err),
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
 func (h *EndpointHandler) ExternalRestore(ctx *gin.Context) {
 	var request entity.RestoreRequest
 	if err := ctx.ShouldBindJSON(&request.CustomVars); err != nil {
@@ -127,7 +603,11 @@ func (h *EndpointHandler) ExternalRestore(ctx *gin.Context) {
 	response, err := h.backupDaemonUseCase.RestoreBackup(ctx, request)
 	if err != nil {
 		h.logger.Errorf("failed to restore external backup err: %v", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{
+		status := http.StatusInternalServerError
+		if errors.Is(err, controller.ErrBackupNotFound) {
+			status = http.StatusNotFound
+		}
+		ctx.JSON(status, gin.H{
 			"message": fmt.Sprintf("failed to restore external backup err: %v", err),
 		})
 		return
@@ -177,11 +657,11 @@ func (h *EndpointHandler) JobStatus(ctx *gin.Context) {
 //}
 
 func (h *EndpointHandler) S3PresignedURL(ctx *gin.Context) {
-	expiration, err := strconv.Atoi(ctx.Query("expiration"))
+	expiration, err := h.presignExpiration(ctx.Query("expiration"))
 	if err != nil {
-		h.logger.Errorf("failed to parse value from url err: %v", err)
+		h.logger.Errorf("invalid expiration err: %v", err)
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"message": fmt.Sprintf("failed to parse value from url err: %v", err),
+			"message": err.Error(),
 		})
 		return
 	}
@@ -193,7 +673,11 @@ func (h *EndpointHandler) S3PresignedURL(ctx *gin.Context) {
 	response, err := h.backupDaemonUseCase.CreateS3PresignedURL(ctx, request)
 	if err != nil {
 		h.logger.Errorf("failed to create s3 presigned url err: %v", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{
+		status := http.StatusInternalServerError
+		if errors.Is(err, controller.ErrBackupNotFound) {
+			status = http.StatusNotFound
+		}
+		ctx.JSON(status, gin.H{
 			"message": fmt.Sprintf("failed to create s3 presigned urls err: %v", err),
 		})
 		return
@@ -201,15 +685,423 @@ func (h *EndpointHandler) S3PresignedURL(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, response)
 }
 
+func (h *EndpointHandler) BackupUploadURL(ctx *gin.Context) {
+	expiration, err := h.presignExpiration(ctx.Query("expiration"))
+	if err != nil {
+		h.logger.Errorf("invalid expiration err: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	var request entity.BackupUploadURLRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		h.logger.Errorf("failed to unmarshall body err: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": fmt.Sprintf("failed to unmarshall body err: %v", err),
+		})
+		return
+	}
+	request.BackupID = ctx.Param("backup_id")
+	request.Expiration = expiration
+
+	response, err := h.backupDaemonUseCase.CreateBackupUploadURL(ctx, request)
+	if err != nil {
+		h.logger.Errorf("failed to create upload url err: %v", err)
+		ctx.JSON(uploadedBackupStatus(err), gin.H{
+			"message": fmt.Sprintf("failed to create upload url err: %v", err),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (h *EndpointHandler) RegisterUploadedBackup(ctx *gin.Context) {
+	response, err := h.backupDaemonUseCase.RegisterUploadedBackup(ctx, ctx.Param("backup_id"))
+	if err != nil {
+		h.logger.Errorf("failed to register uploaded backup err: %v", err)
+		ctx.JSON(uploadedBackupStatus(err), gin.H{
+			"message": fmt.Sprintf("failed to register uploaded backup err: %v", err),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+func uploadedBackupStatus(err error) int {
+	switch {
+	case errors.Is(err, controller.ErrS3Disabled), errors.Is(err, controller.ErrInvalidBackupID), errors.Is(err, repo.ErrInvalidPath):
+		return http.StatusBadRequest
+	case errors.Is(err, controller.ErrBackupIDExists):
+		return http.StatusConflict
+	case errors.Is(err, controller.ErrBackupNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func (h *EndpointHandler) BackupFiles(ctx *gin.Context) {
+	response, err := h.backupDaemonUseCase.ListBackupFiles(ctx, ctx.Param("backup_id"))
+	if err != nil {
+		h.logger.Errorf("failed to list backup files err: %v", err)
+		ctx.JSON(backupFileStatus(err), gin.H{
+			"message": fmt.Sprintf("failed to list backup files err: %v", err),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (h *EndpointHandler) BackupFile(ctx *gin.Context) {
+	request := entity.BackupFileRequest{
+		BackupID: ctx.Param("backup_id"),
+		Path:     ctx.Query("path"),
+	}
+	if request.Path == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "path query parameter is required",
+		})
+		return
+	}
+	var err error
+	if request.Expiration, err = h.presignExpiration(ctx.Query("expiration")); err != nil {
+		h.logger.Errorf("invalid expiration err: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	response, err := h.backupDaemonUseCase.GetBackupFile(ctx, request)
+	if err != nil {
+		h.logger.Errorf("failed to get backup file err: %v", err)
+		ctx.JSON(backupFileStatus(err), gin.H{
+			"message": fmt.Sprintf("failed to get backup file err: %v", err),
+		})
+		return
+	}
+	if response.URL != "" {
+		ctx.Redirect(http.StatusTemporaryRedirect, response.URL)
+		return
+	}
+	defer response.File.Close()
+	info, err := response.File.Stat()
+	if err != nil {
+		h.logger.Errorf("failed to stat backup file err: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": fmt.Sprintf("failed to stat backup file err: %v", err),
+		})
+		return
+	}
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", info.Name()))
+	http.ServeContent(ctx.Writer, ctx.Request, info.Name(), info.ModTime(), response.File)
+}
+
+// BackupConsoleStream sends the .console lines of a running backup as server-sent events until it ends.
+func (h *EndpointHandler) BackupConsoleStream(ctx *gin.Context) {
+	backupID := ctx.Param("backup_id")
+	// unlike the gin context, the request one is done once the client disconnects
+	streamCtx := repo.WithTenant(ctx.Request.Context(), repo.Tenant(ctx))
+	lines, err := h.backupDaemonUseCase.StreamBackupConsole(streamCtx, backupID)
+	if err != nil {
+		h.logger.Errorf("failed to stream backup console err: %v", err)
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, controller.ErrBackupNotFound), errors.Is(err, os.ErrNotExist):
+			status = http.StatusNotFound
+		case errors.Is(err, controller.ErrBackupNotRunning):
+			status = http.StatusConflict
+		}
+		ctx.JSON(status, gin.H{
+			"message": fmt.Sprintf("failed to stream backup console err: %v", err),
+		})
+		return
+	}
+	ctx.Header("Cache-Control", "no-cache")
+	for line := range lines {
+		ctx.SSEvent("console", line)
+		ctx.Writer.Flush()
+	}
+}
+
+// presignExpiration parses the expiration query in seconds, an empty one uses the default expiry.
+func (h *EndpointHandler) presignExpiration(query string) (int, error) {
+	if query == "" {
+		return int(h.presignDefaultExpiry / time.Second), nil
+	}
+	expiration, err := strconv.Atoi(query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse value from url err: %w", err)
+	}
+	if expiration <= 0 {
+		return 0, fmt.Errorf("expiration %d must be positive", expiration)
+	}
+	// compared in seconds, a huge value overflows the duration
+	if expiration > int(MaxPresignExpiry/time.Second) {
+		return 0, fmt.Errorf("expiration %d exceeds the s3 maximum of %d seconds", expiration, int(MaxPresignExpiry/time.Second))
+	}
+	if h.presignMaxExpiry > 0 && time.Duration(expiration)*time.Second > h.presignMaxExpiry {
+		return 0, fmt.Errorf("expiration %d exceeds the maximum of %d seconds", expiration, int(h.presignMaxExpiry/time.Second))
+	}
+	return expiration, nil
+}
+
+func (h *EndpointHandler) BackupManifest(ctx *gin.Context) {
+	response, err := h.backupDaemonUseCase.GetBackupManifest(ctx, ctx.Param("backup_id"))
+	if err != nil {
+		h.logger.Errorf("failed to get backup manifest err: %v", err)
+		ctx.JSON(backupFileStatus(err), gin.H{
+			"message": fmt.Sprintf("failed to get backup manifest err: %v", err),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+func backupFileStatus(err error) int {
+	switch {
+	case errors.Is(err, controller.ErrBackupNotFound), errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, repo.ErrInvalidPath), errors.Is(err, controller.ErrBucketNotAllowed),
+		errors.Is(err, controller.ErrInvalidBackupID):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func (h *EndpointHandler) StorageUsage(ctx *gin.Context) {
+	response, err := h.backupDaemonUseCase.GetStorageUsage(ctx)
+	if err != nil {
+		h.logger.Errorf("failed to get storage usage err: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": fmt.Sprintf("failed to get storage usage err: %v", err),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
 func (h *EndpointHandler) Health(ctx *gin.Context) {
+	response := gin.H{
+		"message": "OK",
+		"backups": h.backupDaemonUseCase.BackupLoad(),
+	}
+	if h.backupAges != nil {
+		if ages, ok := h.backupAges(); ok {
+			response["backup_age_seconds"] = ages
+		}
+	}
+	if storage, err := h.backupDaemonUseCase.StorageInfo(); err != nil {
+		h.logger.Warnf("failed to get storage info err: %v", err)
+	} else {
+		response["storage"] = storage
+	}
+	if quotas, err := h.backupDaemonUseCase.StorageQuotas(ctx); err != nil {
+		h.logger.Warnf("failed to get storage quotas err: %v", err)
+	} else if len(quotas) > 0 {
+		response["storage_quotas"] = quotas
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// Metrics exposes the age of the latest successful backups in the Prometheus text format.
+func (h *EndpointHandler) Metrics(ctx *gin.Context) {
+	ages, err := h.backupDaemonUseCase.BackupAges()
+	if err != nil {
+		h.logger.Errorf("failed to get backup ages err: %v", err)
+		ctx.String(http.StatusInternalServerError, "failed to get backup ages err: %v\n", err)
+		return
+	}
+	var b strings.Builder
+	b.WriteString("# HELP backup_daemon_last_successful_backup_age_seconds Seconds since the latest successful backup of the type.\n")
+	b.WriteString("# TYPE backup_daemon_last_successful_backup_age_seconds gauge\n")
+	for _, age := range []struct {
+		typeOfBackup string
+		seconds      *int64
+	}{{repo.FULL, ages.Full}, {repo.GRANULAR, ages.Granular}} {
+		if age.seconds != nil {
+			fmt.Fprintf(&b, "backup_daemon_last_successful_backup_age_seconds{type=%q} %d\n", age.typeOfBackup, *age.seconds)
+		}
+	}
+	ctx.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
+
+// setRetryAfter tells a client whose backup was rejected under load when to retry.
+func (h *EndpointHandler) setRetryAfter(ctx *gin.Context) {
+	if retryAfter := h.backupDaemonUseCase.BackupLoad().RetryAfter; retryAfter > 0 {
+		ctx.Header("Retry-After", strconv.Itoa(retryAfter))
+	}
+}
+
+// SetBackupAges makes /health report the backup ages ages returns, they are left out while it returns false.
+func (h *EndpointHandler) SetBackupAges(ages func() (entity.BackupAges, bool)) {
+	h.backupAges = ages
+}
+
+// SetNotReady makes /ready report the daemon as unable to serve backups, it must be called before the server runs.
+// Errors of several calls are all reported.
+func (h *EndpointHandler) SetNotReady(err error) {
+	h.notReady = errors.Join(h.notReady, err)
+}
+
+// AddReadyCheck adds a dependency check /ready reports, it must be called before the server runs.
+func (h *EndpointHandler) AddReadyCheck(name string, check func(ctx context.Context) error) {
+	h.readyChecks = append(h.readyChecks, readyCheck{name: name, check: check})
+}
+
+// CheckReady runs the ready checks and keeps their failures for /ready.
+func (h *EndpointHandler) CheckReady(ctx context.Context) error {
+	var errs []error
+	for _, c := range h.readyChecks {
+		if err := c.check(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s failed: %w", c.name, err))
+		}
+	}
+	err := errors.Join(errs...)
+	h.readyMu.Lock()
+	wasReady := h.checkFailed == nil
+	h.checkFailed = err
+	h.readyMu.Unlock()
+	switch {
+	case err != nil && wasReady:
+		h.logger.Errorf("the daemon is not ready: %v", err)
+	case err == nil && !wasReady:
+		h.logger.Infof("the daemon is ready again")
+	}
+	return err
+}
+
+// RunReadyChecks reruns the ready checks every interval until ctx is done.
+func (h *EndpointHandler) RunReadyChecks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.CheckReady(ctx)
+		}
+	}
+}
+
+// SetPresignExpiry sets the expiry of presigned URLs requested without one and the longest one allowed, zero max leaves only MaxPresignExpiry.
+func (h *EndpointHandler) SetPresignExpiry(defaultExpiry time.Duration, maxExpiry time.Duration) {
+	h.presignDefaultExpiry = defaultExpiry
+	h.presignMaxExpiry = maxExpiry
+}
+
+// SetTenants scopes requests to the tenant of their X-API-Key among apiKeys, or else to the value of header.
+// Once apiKeys are set every request but the probes and metrics needs a known key, a key of an empty tenant sees all jobs.
+func (h *EndpointHandler) SetTenants(header string, apiKeys map[string]string) {
+	h.tenantHeader = header
+	h.tenantAPIKeys = apiKeys
+}
+
+// tenant stores the tenant of the request in its context for the jobs repository to filter on.
+func (h *EndpointHandler) tenant(ctx *gin.Context) {
+	if len(h.tenantAPIKeys) > 0 {
+		if path := ctx.FullPath(); path == "/health" || path == "/ready" || path == "/metrics" {
+			ctx.Next()
+			return
+		}
+		tenant, ok := h.tenantAPIKeys[ctx.GetHeader(apiKeyHeader)]
+		if !ok {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"message": "missing or unknown api key",
+			})
+			return
+		}
+		ctx.Set(repo.TenantKey, tenant)
+	} else if h.tenantHeader != "" {
+		ctx.Set(repo.TenantKey, strings.TrimSpace(ctx.GetHeader(h.tenantHeader)))
+	}
+	ctx.Next()
+}
+
+// SetLogLevel lets /admin/loglevel change the level of the logger built with it.
+func (h *EndpointHandler) SetLogLevel(level zap.AtomicLevel) {
+	h.logLevel = &level
+}
+
+func (h *EndpointHandler) LogLevel(ctx *gin.Context) {
+	if h.logLevel == nil {
+		ctx.JSON(http.StatusNotImplemented, gin.H{
+			"message": "log level can not be changed at runtime",
+		})
+		return
+	}
+	if ctx.Request.Method == http.MethodPost {
+		var request entity.LogLevelRequest
+		if err := ctx.ShouldBindJSON(&request); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": fmt.Sprintf("failed to unmarshall body err: %v", err),
+			})
+			return
+		}
+		var level zapcore.Level
+		if err := level.UnmarshalText([]byte(request.Level)); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": fmt.Sprintf("unknown log level '%s'", request.Level),
+			})
+			return
+		}
+		h.logLevel.SetLevel(level)
+		h.logger.Infof("log level changed to %s", level)
+	}
+	ctx.JSON(http.StatusOK, entity.LogLevelRequest{Level: h.logLevel.Level().String()})
+}
+
+func (h *EndpointHandler) Ready(ctx *gin.Context) {
+	h.readyMu.Lock()
+	notReady := errors.Join(h.notReady, h.checkFailed)
+	h.readyMu.Unlock()
+	if notReady != nil {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"message": fmt.Sprintf("not ready: %v", notReady),
+		})
+		return
+	}
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": "OK",
 	})
 }
 
+func getBackupType(value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "":
+		return repo.ALL, nil
+	case repo.FULL:
+		return repo.FULL, nil
+	case repo.GRANULAR:
+		return repo.GRANULAR, nil
+	default:
+		return "", fmt.Errorf("unknown backup type '%s', expected full or granular", value)
+	}
+}
+
 func getProcType(url string) string {
 	if strings.Contains(url, "incremental") {
 		return controller.INCREMENTAL
 	}
 	return controller.FULL
 }
+
+// validateTimeWindow checks fromTs and toTs are epoch milliseconds with fromTs not after toTs.
+func validateTimeWindow(fromTs string, toTs string) error {
+	var from, to int64
+	var err error
+	if fromTs != "" {
+		if from, err = strconv.ParseInt(fromTs, 10, 64); err != nil {
+			return fmt.Errorf("fromTs %s is in incorrect format", fromTs)
+		}
+	}
+	if toTs != "" {
+		if to, err = strconv.ParseInt(toTs, 10, 64); err != nil {
+			return fmt.Errorf("toTs %s is in incorrect format", toTs)
+		}
+		if fromTs != "" && from > to {
+			return fmt.Errorf("fromTs %s is after toTs %s", fromTs, toTs)
+		}
+	}
+	return nil
+}
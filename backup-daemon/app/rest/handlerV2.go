@@ -1,10 +1,16 @@
 package rest
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/controller"
 	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
 	"github.com/gin-gonic/gin"
 )
@@ -30,7 +36,19 @@ func (h *EndpointHandler) BackupV2(ctx *gin.Context) {
 
 	resp, err := h.backupDaemonUseCase.EnqueueBackup(ctx, internal)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("failed to enqueue backup err: %v", err)})
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, controller.ErrBucketNotAllowed), errors.Is(err, controller.ErrInvalidBackupID):
+			status = http.StatusBadRequest
+		case errors.Is(err, controller.ErrBackupIDExists):
+			status = http.StatusConflict
+		case errors.Is(err, controller.ErrBackupCapReached), errors.Is(err, controller.ErrLowInodes):
+			status = http.StatusInsufficientStorage
+		case errors.Is(err, controller.ErrOverloaded):
+			status = http.StatusServiceUnavailable
+			h.setRetryAfter(ctx)
+		}
+		ctx.JSON(status, gin.H{"message": fmt.Sprintf("failed to enqueue backup err: %v", err)})
 		return
 	}
 
@@ -58,7 +76,7 @@ func (h *EndpointHandler) BackupV2Status(ctx *gin.Context) {
 	resp := entity.BackupV2Response{
 		Status:       status,
 		BackupID:     backupID,
-		CreationTime: timeCreationNow(),
+		CreationTime: jobCreationTime(js),
 		StorageName:  storage,
 		BlobPath:     blob,
 		Databases:    DbStatuses(dbs, status),
@@ -67,6 +85,105 @@ func (h *EndpointHandler) BackupV2Status(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, resp)
 }
 
+func (h *EndpointHandler) BackupV2List(ctx *gin.Context) {
+	filter := entity.JobsFilter{
+		StorageName: strings.TrimSpace(ctx.Query("storageName")),
+		Types:       []string{controller.COMMONBACKUP, controller.INCREMENTALBACKUP},
+	}
+	if status := strings.TrimSpace(ctx.Query("status")); status != "" {
+		jobStatus, ok := jobStatusFromV2(status)
+		if !ok {
+			ctx.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("unknown status '%s'", status)})
+			return
+		}
+		filter.Status = jobStatus
+	}
+	limit, err := pageParam(ctx, "limit", defaultBackupsPageSize)
+	if err != nil || limit < 1 || limit > maxBackupsPageSize {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("limit must be between 1 and %d", maxBackupsPageSize)})
+		return
+	}
+	offset, err := pageParam(ctx, "offset", 0)
+	if err != nil || offset < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "offset must be a non-negative integer"})
+		return
+	}
+	filter.Limit = limit
+	filter.Offset = offset
+
+	jobs, total, err := h.backupDaemonUseCase.ListJobs(ctx, filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("failed to list backups err: %v", err)})
+		return
+	}
+	ctx.Header("X-Total-Count", strconv.Itoa(total))
+
+	etag := backupsETag(jobs)
+	ctx.Header("ETag", etag)
+	if etagMatches(ctx.GetHeader("If-None-Match"), etag) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+
+	resp := entity.BackupV2ListResponse{Backups: make([]entity.BackupV2Response, 0, len(jobs))}
+	for _, js := range jobs {
+		status := mapJobStatus(js.Status)
+		resp.Backups = append(resp.Backups, entity.BackupV2Response{
+			Status:       status,
+			BackupID:     js.TaskID,
+			CreationTime: jobCreationTime(js),
+			StorageName:  strings.TrimSpace(js.StorageName),
+			BlobPath:     normalizeBlobPath(js.BlobPath),
+			Databases:    DbStatuses(js.Databases, status),
+		})
+	}
+	ctx.JSON(http.StatusOK, resp)
+}
+
+const (
+	defaultBackupsPageSize = 100
+	maxBackupsPageSize     = 1000
+)
+
+// pageParam parses an integer query parameter, returning def when it is not set.
+func pageParam(ctx *gin.Context, name string, def int) (int, error) {
+	value := strings.TrimSpace(ctx.Query(name))
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// backupsETag fingerprints the listed backups by id and status, it changes when a backup
+// is added, removed or its status changes, and not because of the creation time we render.
+func backupsETag(jobs []entity.JobStatusResponse) string {
+	hash := sha256.New()
+	for _, js := range jobs {
+		fmt.Fprintf(hash, "%s\x00%s\x00%s\x00%s\x00%s\x00", js.TaskID, js.Status, js.StorageName, js.BlobPath, strings.Join(js.Databases, ","))
+		keys := make([]string, 0, len(js.DatabaseStatuses))
+		for db := range js.DatabaseStatuses {
+			keys = append(keys, db)
+		}
+		sort.Strings(keys)
+		for _, db := range keys {
+			fmt.Fprintf(hash, "%s=%s\x00", db, js.DatabaseStatuses[db])
+		}
+		hash.Write([]byte{'\n'})
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
 func (h *EndpointHandler) BackupV2Delete(ctx *gin.Context) {
 	backupID := strings.TrimSpace(ctx.Param("backup_id"))
 	if backupID == "" {
@@ -86,7 +203,11 @@ func (h *EndpointHandler) BackupV2Delete(ctx *gin.Context) {
 		msg := err.Error()
 
 		switch {
-		case strings.Contains(msg, "not found"):
+		case errors.Is(err, controller.ErrObjectLocked):
+			ctx.JSON(http.StatusConflict, gin.H{"message": msg, "locked": true})
+		case errors.Is(err, controller.ErrVaultBusy):
+			ctx.JSON(http.StatusConflict, gin.H{"message": msg})
+		case errors.Is(err, controller.ErrBackupNotFound):
 			ctx.JSON(http.StatusNotFound, gin.H{"message": msg})
 		case strings.Contains(msg, "locked"):
 			ctx.JSON(http.StatusConflict, gin.H{"message": msg})
@@ -103,6 +224,46 @@ func (h *EndpointHandler) BackupV2Delete(ctx *gin.Context) {
 	})
 }
 
+func (h *EndpointHandler) EvictV2(ctx *gin.Context) {
+	var dryRun bool
+	if q := strings.TrimSpace(ctx.Query("dryRun")); q != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(q); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("invalid dryRun '%s'", q)})
+			return
+		}
+	}
+	response, err := h.backupDaemonUseCase.EnqueueEviction(ctx, entity.EvictRequest{
+		ProcType: controller.FULL,
+		DryRun:   dryRun,
+	})
+	if err != nil && len(response.Evicted) == 0 {
+		h.logger.Errorf("failed to evict backups err: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("failed to evict backups err: %v", err)})
+		return
+	}
+	if err != nil {
+		h.logger.Errorf("eviction partially failed err: %v", err)
+	}
+
+	status := Finished
+	if response.DryRun {
+		status = NotStarted
+	}
+	resp := entity.EvictV2Response{
+		DryRun:  response.DryRun,
+		Count:   response.Count,
+		Backups: make([]entity.EvictV2Backup, 0, len(response.Evicted)+len(response.Failed)),
+	}
+	for _, vault := range response.Evicted {
+		resp.Backups = append(resp.Backups, entity.EvictV2Backup{BackupID: vault, Status: status})
+	}
+	for _, failed := range response.Failed {
+		resp.Backups = append(resp.Backups, entity.EvictV2Backup{BackupID: failed.Vault, Status: Failed, Error: failed.Error})
+	}
+	ctx.JSON(http.StatusOK, resp)
+}
+
 func (h *EndpointHandler) RestoreV2(ctx *gin.Context) {
 	backupID := ctx.Param("backup_id")
 
@@ -133,6 +294,18 @@ func (h *EndpointHandler) RestoreV2(ctx *gin.Context) {
 
 	resp, err := h.backupDaemonUseCase.RestoreBackup(ctx, internal)
 	if err != nil {
+		if errors.Is(err, controller.ErrRestoreNamespaceNotAllowed) {
+			ctx.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+			return
+		}
+		if errors.Is(err, controller.ErrBackupNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+			return
+		}
+		if errors.Is(err, controller.ErrRenameCollision) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("failed to restore backup err: %v", err)})
 		return
 	}
@@ -140,6 +313,57 @@ func (h *EndpointHandler) RestoreV2(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, buildRestoreV2Response(req, resp.TaskID, NotStarted))
 }
 
+func (h *EndpointHandler) RestoreV2Latest(ctx *gin.Context) {
+	var req entity.RestoreV2Request
+	if err := ctx.ShouldBindJSON(&req); err != nil && ctx.Request.ContentLength > 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("failed to unmarshall body err: %v", err)})
+		return
+	}
+
+	blob, err := validateBlobPath(req.BlobPath)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	req.BlobPath = blob
+
+	if req.Databases == nil {
+		req.Databases = []entity.RestoreDBMap{}
+	}
+	for _, m := range req.Databases {
+		if strings.TrimSpace(m.PreviousDatabaseName) == "" || strings.TrimSpace(m.DatabaseName) == "" {
+			ctx.JSON(http.StatusBadRequest, gin.H{"message": "each databases item must have previousDatabaseName and databaseName"})
+			return
+		}
+	}
+	typeOfBackup, err := getBackupType(ctx.Query("type"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	internal := mapRestoreV2ToInternal("", req, getProcType(ctx.Request.URL.Path))
+
+	resp, err := h.backupDaemonUseCase.RestoreLatestBackup(ctx, entity.RestoreLatestRequest{
+		Restore:      internal,
+		TypeOfBackup: typeOfBackup,
+	})
+	if err != nil {
+		if errors.Is(err, controller.ErrNoSuccessfulBackup) {
+			ctx.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+			return
+		}
+		if errors.Is(err, controller.ErrRestoreNamespaceNotAllowed) {
+			ctx.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("failed to restore latest backup err: %v", err)})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, buildRestoreV2Response(req, resp.TaskID, NotStarted))
+}
+
 func (h *EndpointHandler) RestoreV2Status(ctx *gin.Context) {
 	taskID := strings.TrimSpace(ctx.Param("restore_id"))
 	if taskID == "" {
@@ -169,10 +393,11 @@ func (h *EndpointHandler) RestoreV2Status(ctx *gin.Context) {
 	resp := entity.RestoreV2Response{
 		Status:       status,
 		RestoreID:    taskID,
-		CreationTime: timeCreationNow(),
+		CreationTime: jobCreationTime(js),
 		StorageName:  js.StorageName,
 		BlobPath:     js.BlobPath,
-		Databases:    DbStatuses(js.Databases, status),
+		Databases:    DbStatusesWithOverrides(js.Databases, status, js.DatabaseStatuses),
+		Progress:     js.Progress,
 	}
 
 	ctx.JSON(http.StatusOK, resp)
@@ -2,16 +2,26 @@ package rest
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/controller"
 	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/repo"
+	"github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/util"
 	"github.com/gin-gonic/gin"
 	"github.com/golang/mock/gomock"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 func TestEnqueueBackup(t *testing.T) {
@@ -22,6 +32,7 @@ func TestEnqueueBackup(t *testing.T) {
 		expectedBodyJSON   string
 		expectedStatusCode int
 		expectedError      error
+		expectedRetryAfter string
 	}{
 		{
 			name:            "success",
@@ -49,6 +60,14 @@ func TestEnqueueBackup(t *testing.T) {
 			expectedBodyJSON:   `{"message":"failed to enqueue backup err: internal error"}`,
 			expectedStatusCode: http.StatusInternalServerError,
 		},
+		{
+			name:               "overloaded",
+			requestBodyJSON:    `{}`,
+			expectedError:      fmt.Errorf("%w: 2 backups in flight, limit 2", controller.ErrOverloaded),
+			expectedBodyJSON:   `{"message":"failed to enqueue backup err: backup daemon is overloaded: 2 backups in flight, limit 2"}`,
+			expectedStatusCode: http.StatusServiceUnavailable,
+			expectedRetryAfter: "30",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -58,6 +77,7 @@ func TestEnqueueBackup(t *testing.T) {
 			defer ctrl.Finish()
 			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
 			mockStorageRepo.EXPECT().EnqueueBackup(gomock.Any(), gomock.Any()).Return(tc.expectedResponse, tc.expectedError).AnyTimes()
+			mockStorageRepo.EXPECT().BackupLoad().Return(entity.BackupLoad{InFlight: 2, Limit: 2, RetryAfter: 30}).AnyTimes()
 
 			sugar := zap.NewNop().Sugar()
 			handler := NewEndpointHandler(mockStorageRepo, sugar)
@@ -76,6 +96,9 @@ func TestEnqueueBackup(t *testing.T) {
 			if tc.expectedBodyJSON != w.Body.String() {
 				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
 			}
+			if retryAfter := w.Header().Get("Retry-After"); tc.expectedRetryAfter != retryAfter {
+				t.Fatalf("expected Retry-After %q, got %q", tc.expectedRetryAfter, retryAfter)
+			}
 		})
 	}
 }
@@ -156,20 +179,49 @@ func TestRestoreBackup(t *testing.T) {
 func TestEvict(t *testing.T) {
 	testCases := []struct {
 		name               string
+		expectedResponse   entity.EvictResponse
 		expectedError      error
 		expectedBodyJSON   string
 		expectedStatusCode int
 	}{
 		{
-			name:               "success",
+			name:               "nothing to evict",
+			expectedResponse:   entity.EvictResponse{NothingToEvict: true},
 			expectedError:      nil,
-			expectedBodyJSON:   `{"message":"OK"}`,
+			expectedBodyJSON:   `{"message":"nothing to evict","count":0,"nothing_to_evict":true}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "success with evicted vaults",
+			expectedResponse:   entity.EvictResponse{Count: 1, Evicted: []string{"20250101T000000"}},
+			expectedError:      nil,
+			expectedBodyJSON:   `{"message":"OK","count":1,"evicted":["20250101T000000"]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "partial success",
+			expectedResponse: entity.EvictResponse{
+				Count:   1,
+				Evicted: []string{"20250101T000000"},
+				Failed:  []entity.EvictFailure{{Vault: "20250102T000000", Error: "locked"}},
+			},
+			expectedError:      errors.New("locked"),
+			expectedBodyJSON:   `{"message":"eviction partially failed","count":1,"evicted":["20250101T000000"],"failed":[{"vault":"20250102T000000","error":"locked"}]}`,
 			expectedStatusCode: http.StatusOK,
 		},
+		{
+			name: "all vaults failed",
+			expectedResponse: entity.EvictResponse{
+				Failed: []entity.EvictFailure{{Vault: "20250102T000000", Error: "locked"}},
+			},
+			expectedError:      errors.New("locked"),
+			expectedBodyJSON:   `{"message":"failed to enqueue eviction err: locked","count":0,"failed":[{"vault":"20250102T000000","error":"locked"}]}`,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
 		{
 			name:               "internal error",
 			expectedError:      errors.New("internal error"),
-			expectedBodyJSON:   `{"message":"failed to enqueue eviction err: internal error"}`,
+			expectedBodyJSON:   `{"message":"failed to enqueue eviction err: internal error","count":0}`,
 			expectedStatusCode: http.StatusInternalServerError,
 		},
 	}
@@ -180,7 +232,7 @@ func TestEvict(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
 			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
-			mockStorageRepo.EXPECT().EnqueueEviction(gomock.Any(), gomock.Any()).Return(tc.expectedError).AnyTimes()
+			mockStorageRepo.EXPECT().EnqueueEviction(gomock.Any(), gomock.Any()).Return(tc.expectedResponse, tc.expectedError).AnyTimes()
 
 			sugar := zap.NewNop().Sugar()
 			handler := NewEndpointHandler(mockStorageRepo, sugar)
@@ -203,9 +255,85 @@ func TestEvict(t *testing.T) {
 	}
 }
 
+func TestEvictV2(t *testing.T) {
+	testCases := []struct {
+		name               string
+		query              string
+		response           entity.EvictResponse
+		err                error
+		expectedDryRun     bool
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "dry run",
+			query:              "?dryRun=true",
+			response:           entity.EvictResponse{Count: 1, Evicted: []string{"20250101T000000"}, DryRun: true},
+			expectedDryRun:     true,
+			expectedBodyJSON:   `{"dryRun":true,"count":1,"backups":[{"backupId":"20250101T000000","status":"notStarted"}]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "partially failed",
+			response: entity.EvictResponse{
+				Count:   1,
+				Evicted: []string{"20250101T000000"},
+				Failed:  []entity.EvictFailure{{Vault: "20250102T000000", Error: "locked"}},
+			},
+			err: errors.New("locked"),
+			expectedBodyJSON: `{"dryRun":false,"count":1,"backups":[{"backupId":"20250101T000000","status":"finished"},` +
+				`{"backupId":"20250102T000000","status":"failed","error":"locked"}]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "nothing to evict",
+			response:           entity.EvictResponse{NothingToEvict: true},
+			expectedBodyJSON:   `{"dryRun":false,"count":0,"backups":[]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "invalid dry run",
+			query:              "?dryRun=maybe",
+			expectedBodyJSON:   `{"message":"invalid dryRun 'maybe'"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().EnqueueEviction(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, request entity.EvictRequest) (entity.EvictResponse, error) {
+					if request.DryRun != tc.expectedDryRun {
+						t.Fatalf("expected dry run %v, got %v", tc.expectedDryRun, request.DryRun)
+					}
+					return tc.response, tc.err
+				}).AnyTimes()
+
+			handler := NewEndpointHandler(mockStorageRepo, zap.NewNop().Sugar())
+			r := gin.Default()
+			r.POST("/api/v1/evict", handler.EvictV2)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/evict"+tc.query, nil)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
 func TestEvictVault(t *testing.T) {
 	testCases := []struct {
 		name               string
+		query              string
+		expectedV2Request  *entity.EvictByVaultV2Request
 		expectedError      error
 		expectedBodyJSON   string
 		expectedStatusCode int
@@ -222,6 +350,27 @@ func TestEvictVault(t *testing.T) {
 			expectedBodyJSON:   `{"message":"failed to remove backup err: internal error"}`,
 			expectedStatusCode: http.StatusInternalServerError,
 		},
+		{
+			name:               "not found",
+			expectedError:      fmt.Errorf("%w: vault eeee", controller.ErrVaultNotFound),
+			expectedBodyJSON:   `{"message":"failed to remove backup err: backup not found in storage: vault eeee"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "blob path",
+			query:              "?blobPath=/backups/pg",
+			expectedV2Request:  &entity.EvictByVaultV2Request{Vault: "eeee", BlobPath: "backups/pg"},
+			expectedBodyJSON:   `{"message":"OK"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "blob path retained in s3",
+			query:              "?blobPath=backups/pg",
+			expectedV2Request:  &entity.EvictByVaultV2Request{Vault: "eeee", BlobPath: "backups/pg"},
+			expectedError:      fmt.Errorf("backup eeee is retained in s3: %w", controller.ErrObjectLocked),
+			expectedBodyJSON:   `{"message":"failed to remove backup err: backup eeee is retained in s3: s3 object is locked"}`,
+			expectedStatusCode: http.StatusConflict,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -230,7 +379,11 @@ func TestEvictVault(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
 			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
-			mockStorageRepo.EXPECT().RemoveBackup(gomock.Any(), gomock.Any()).Return(tc.expectedError).AnyTimes()
+			if tc.expectedV2Request != nil {
+				mockStorageRepo.EXPECT().RemoveBackupV2(gomock.Any(), *tc.expectedV2Request).Return(tc.expectedError).Times(1)
+			} else {
+				mockStorageRepo.EXPECT().RemoveBackup(gomock.Any(), gomock.Any()).Return(tc.expectedError).AnyTimes()
+			}
 
 			sugar := zap.NewNop().Sugar()
 			handler := NewEndpointHandler(mockStorageRepo, sugar)
@@ -238,7 +391,7 @@ func TestEvictVault(t *testing.T) {
 			r := gin.Default()
 			r.POST("/evict/:vault", handler.EvictByVault)
 
-			req := httptest.NewRequest(http.MethodPost, "/evict/eeee", nil)
+			req := httptest.NewRequest(http.MethodPost, "/evict/eeee"+tc.query, nil)
 			req.Header.Set("Content-Type", "application/json")
 			w := httptest.NewRecorder()
 
@@ -397,6 +550,7 @@ func TestS3PresignedURL(t *testing.T) {
 		expectedBodyJSON   string
 		expectedStatusCode int
 		expirationTime     string
+		expectedExpiration int
 	}{
 		{
 			name: "success",
@@ -407,6 +561,7 @@ func TestS3PresignedURL(t *testing.T) {
 			expectedStatusCode: http.StatusOK,
 			expectedError:      nil,
 			expirationTime:     "20000",
+			expectedExpiration: 20000,
 		},
 		{
 			name:               "internal error",
@@ -415,6 +570,7 @@ func TestS3PresignedURL(t *testing.T) {
 			expectedError:      errors.New("internal error"),
 			expectedStatusCode: http.StatusInternalServerError,
 			expirationTime:     "20000",
+			expectedExpiration: 20000,
 		},
 		{
 			name:               "bad request",
@@ -424,6 +580,45 @@ func TestS3PresignedURL(t *testing.T) {
 			expectedBodyJSON:   `{"message":"failed to parse value from url err: strconv.Atoi: parsing \"20000rr\": invalid syntax"}`,
 			expirationTime:     "20000rr",
 		},
+		{
+			name: "default expiry",
+			expectedResponse: entity.S3PresignedURLResponse{
+				Urls: []string{"url1"},
+			},
+			expectedBodyJSON:   `{"urls":["url1"]}`,
+			expectedStatusCode: http.StatusOK,
+			expectedExpiration: 900,
+		},
+		{
+			name:               "over max expiry",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedBodyJSON:   `{"message":"expiration 86401 exceeds the maximum of 86400 seconds"}`,
+			expirationTime:     "86401",
+		},
+		{
+			name:               "zero expiry",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedBodyJSON:   `{"message":"expiration 0 must be positive"}`,
+			expirationTime:     "0",
+		},
+		{
+			name:               "negative expiry",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedBodyJSON:   `{"message":"expiration -1 must be positive"}`,
+			expirationTime:     "-1",
+		},
+		{
+			name:               "over s3 maximum",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedBodyJSON:   `{"message":"expiration 604801 exceeds the s3 maximum of 604800 seconds"}`,
+			expirationTime:     "604801",
+		},
+		{
+			name:               "overflowing expiry",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedBodyJSON:   `{"message":"expiration 9223372036 exceeds the s3 maximum of 604800 seconds"}`,
+			expirationTime:     "9223372036",
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -431,10 +626,16 @@ func TestS3PresignedURL(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
 			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
-			mockStorageRepo.EXPECT().CreateS3PresignedURL(gomock.Any(), gomock.Any()).Return(tc.expectedResponse, tc.expectedError).AnyTimes()
+			var expiration int
+			mockStorageRepo.EXPECT().CreateS3PresignedURL(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, request entity.S3PresignedURLRequest) (entity.S3PresignedURLResponse, error) {
+					expiration = request.Expiration
+					return tc.expectedResponse, tc.expectedError
+				}).AnyTimes()
 
 			sugar := zap.NewNop().Sugar()
 			handler := NewEndpointHandler(mockStorageRepo, sugar)
+			handler.SetPresignExpiry(15*time.Minute, 24*time.Hour)
 
 			r := gin.Default()
 			r.GET("/backup/s3/:backup_id", handler.S3PresignedURL)
@@ -452,6 +653,1973 @@ func TestS3PresignedURL(t *testing.T) {
 			if tc.expectedBodyJSON != w.Body.String() {
 				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
 			}
+			if tc.expectedExpiration != expiration {
+				t.Fatalf("expected expiration %d, got %d", tc.expectedExpiration, expiration)
+			}
+		})
+	}
+}
+
+func TestStorageUsage(t *testing.T) {
+	testCases := []struct {
+		name               string
+		expectedResponse   entity.StorageUsageResponse
+		expectedError      error
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name: "success",
+			expectedResponse: entity.StorageUsageResponse{
+				Full:       entity.BackupTypeUsage{Count: 2, Size: 300},
+				Granular:   entity.BackupTypeUsage{Count: 1, Size: 50},
+				TotalSize:  350,
+				TotalCount: 3,
+				FreeSpace:  1000,
+			},
+			expectedBodyJSON:   `{"full":{"count":2,"size":300},"granular":{"count":1,"size":50},"total_size":350,"total_count":3,"free_space":1000}`,
+			expectedStatusCode: http.StatusOK,
+			expectedError:      nil,
+		},
+		{
+			name:               "internal error",
+			expectedResponse:   entity.StorageUsageResponse{},
+			expectedError:      errors.New("internal error"),
+			expectedBodyJSON:   `{"message":"failed to get storage usage err: internal error"}`,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().GetStorageUsage(gomock.Any()).Return(tc.expectedResponse, tc.expectedError).AnyTimes()
+
+			sugar := zap.NewNop().Sugar()
+			handler := NewEndpointHandler(mockStorageRepo, sugar)
+
+			r := gin.Default()
+			r.GET("/storage/usage", handler.StorageUsage)
+
+			req := httptest.NewRequest(http.MethodGet, "/storage/usage", nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRestoreLatest(t *testing.T) {
+	testCases := []struct {
+		name               string
+		query              string
+		expectedResponse   entity.RestoreResponse
+		expectedError      error
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:  "success",
+			query: "?type=full",
+			expectedResponse: entity.RestoreResponse{
+				TaskID: "coverageo",
+				Vault:  "20240101T000000",
+			},
+			expectedBodyJSON:   `{"task_id":"coverageo","vault":"20240101T000000"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "no successful backup",
+			query:              "",
+			expectedResponse:   entity.RestoreResponse{},
+			expectedError:      fmt.Errorf("%w for type all", controller.ErrNoSuccessfulBackup),
+			expectedBodyJSON:   `{"message":"failed to restore latest backup err: no successful backup found for type all"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "full restore disabled",
+			query:              "?type=full",
+			expectedResponse:   entity.RestoreResponse{},
+			expectedError:      fmt.Errorf("%w: vault 20240101T000000", controller.ErrFullRestoreDisabled),
+			expectedBodyJSON:   `{"message":"failed to restore latest backup err: full restore via REST API is disabled: vault 20240101T000000"}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "unknown type",
+			query:              "?type=sharded",
+			expectedResponse:   entity.RestoreResponse{},
+			expectedBodyJSON:   `{"message":"unknown backup type 'sharded', expected full or granular"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().RestoreLatestBackup(gomock.Any(), gomock.Any()).Return(tc.expectedResponse, tc.expectedError).AnyTimes()
+
+			sugar := zap.NewNop().Sugar()
+			handler := NewEndpointHandler(mockStorageRepo, sugar)
+
+			r := gin.Default()
+			r.POST("/restore/latest", handler.RestoreLatest)
+
+			req := httptest.NewRequest(http.MethodPost, "/restore/latest"+tc.query, nil)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestReady(t *testing.T) {
+	testCases := []struct {
+		name               string
+		notReady           error
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "ready",
+			notReady:           nil,
+			expectedBodyJSON:   `{"message":"OK"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "tool health check failed",
+			notReady:           errors.New("tool health check failed: pg_dump not found"),
+			expectedBodyJSON:   `{"message":"not ready: tool health check failed: pg_dump not found"}`,
+			expectedStatusCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:               "s3 startup check failed",
+			notReady:           errors.New("s3 startup check failed: failed to reach s3 bucket backups err: forbidden"),
+			expectedBodyJSON:   `{"message":"not ready: s3 startup check failed: failed to reach s3 bucket backups err: forbidden"}`,
+			expectedStatusCode: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+
+			sugar := zap.NewNop().Sugar()
+			handler := NewEndpointHandler(mockStorageRepo, sugar)
+			handler.SetNotReady(tc.notReady)
+
+			r := gin.Default()
+			r.GET("/ready", handler.Ready)
+
+			req := httptest.NewRequest(http.MethodGet, "/ready", nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestReadyRechecks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	handler := NewEndpointHandler(NewMockBackupDaemonUseCase(ctrl), zap.NewNop().Sugar())
+
+	var mu sync.Mutex
+	var s3Err error
+	handler.AddReadyCheck("s3 check", func(context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return s3Err
+	})
+	setS3Err := func(err error) {
+		mu.Lock()
+		s3Err = err
+		mu.Unlock()
+	}
+
+	r := gin.Default()
+	r.GET("/ready", handler.Ready)
+	ready := func() (int, string) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		return w.Code, w.Body.String()
+	}
+
+	if err := handler.CheckReady(context.Background()); err != nil {
+		t.Fatalf("expected ready, got %v", err)
+	}
+	if code, _ := ready(); code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handler.RunReadyChecks(ctx, 10*time.Millisecond)
+
+	setS3Err(errors.New("forbidden"))
+	expectedBody := `{"message":"not ready: s3 check failed: forbidden"}`
+	deadline := time.Now().Add(5 * time.Second)
+	for code, body := ready(); code != http.StatusServiceUnavailable || body != expectedBody; code, body = ready() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected status %d with %s, got %d with %s", http.StatusServiceUnavailable, expectedBody, code, body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	setS3Err(nil)
+	deadline = time.Now().Add(5 * time.Second)
+	for code, _ := ready(); code != http.StatusOK; code, _ = ready() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected status %d after the dependency recovered, got %d", http.StatusOK, code)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCopyBackup(t *testing.T) {
+	testCases := []struct {
+		name               string
+		requestBodyJSON    string
+		expectedRequest    entity.CopyBackupRequest
+		expectedResponse   entity.CopyBackupResponse
+		expectedError      error
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "success",
+			requestBodyJSON:    `{"storageName":"s3","blobPath":"/replica/"}`,
+			expectedRequest:    entity.CopyBackupRequest{BackupID: "20250101T000000", StorageName: "s3", BlobPath: "replica/"},
+			expectedResponse:   entity.CopyBackupResponse{TaskID: "task-1", BackupID: "20250101T000000"},
+			expectedBodyJSON:   `{"task_id":"task-1","backup_id":"20250101T000000"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "backup not found",
+			requestBodyJSON:    `{"blobPath":"replica"}`,
+			expectedRequest:    entity.CopyBackupRequest{BackupID: "20250101T000000", BlobPath: "replica"},
+			expectedError:      fmt.Errorf("%w: vault 20250101T000000", controller.ErrBackupNotFound),
+			expectedBodyJSON:   `{"message":"failed to copy backup err: backup not found: vault 20250101T000000"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "s3 disabled",
+			requestBodyJSON:    ``,
+			expectedRequest:    entity.CopyBackupRequest{BackupID: "20250101T000000"},
+			expectedError:      controller.ErrS3Disabled,
+			expectedBodyJSON:   `{"message":"failed to copy backup err: s3 storage is disabled"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().CopyBackup(gomock.Any(), tc.expectedRequest).Return(tc.expectedResponse, tc.expectedError).Times(1)
+
+			sugar := zap.NewNop().Sugar()
+			handler := NewEndpointHandler(mockStorageRepo, sugar)
+
+			r := gin.Default()
+			r.POST("/backup/:backup_id/copy", handler.CopyBackup)
+
+			req := httptest.NewRequest(http.MethodPost, "/backup/20250101T000000/copy", bytes.NewBufferString(tc.requestBodyJSON))
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestBackupUploadURL(t *testing.T) {
+	testCases := []struct {
+		name               string
+		requestBodyJSON    string
+		expectedRequest    *entity.BackupUploadURLRequest
+		expectedResponse   entity.BackupUploadURLResponse
+		expectedError      error
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "success",
+			requestBodyJSON:    `{"key":"db1.dump"}`,
+			expectedRequest:    &entity.BackupUploadURLRequest{BackupID: "20250101T000000", Key: "db1.dump", Expiration: 120},
+			expectedResponse:   entity.BackupUploadURLResponse{URL: "url", Key: "db1.dump"},
+			expectedBodyJSON:   `{"url":"url","key":"db1.dump"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "backup exists",
+			requestBodyJSON:    `{"key":"db1.dump"}`,
+			expectedRequest:    &entity.BackupUploadURLRequest{BackupID: "20250101T000000", Key: "db1.dump", Expiration: 120},
+			expectedError:      fmt.Errorf("%w: 20250101T000000", controller.ErrBackupIDExists),
+			expectedBodyJSON:   `{"message":"failed to create upload url err: backup id already exists: 20250101T000000"}`,
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			name:               "missing body",
+			expectedBodyJSON:   `{"message":"failed to unmarshall body err: EOF"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			if tc.expectedRequest != nil {
+				mockStorageRepo.EXPECT().CreateBackupUploadURL(gomock.Any(), *tc.expectedRequest).Return(tc.expectedResponse, tc.expectedError).Times(1)
+			}
+
+			handler := NewEndpointHandler(mockStorageRepo, zap.NewNop().Sugar())
+			r := gin.Default()
+			r.POST("/backup/:backup_id/upload-url", handler.BackupUploadURL)
+
+			req := httptest.NewRequest(http.MethodPost, "/backup/20250101T000000/upload-url?expiration=120", bytes.NewBufferString(tc.requestBodyJSON))
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRegisterUploadedBackup(t *testing.T) {
+	testCases := []struct {
+		name               string
+		expectedResponse   entity.BackupResponse
+		expectedError      error
+		expectedStatusCode int
+	}{
+		{
+			name:               "success",
+			expectedResponse:   entity.BackupResponse{BackupID: "20250101T000000"},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "nothing uploaded",
+			expectedError:      fmt.Errorf("%w: nothing uploaded for 20250101T000000", controller.ErrBackupNotFound),
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "invalid backup id",
+			expectedError:      controller.ErrInvalidBackupID,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().RegisterUploadedBackup(gomock.Any(), "20250101T000000").Return(tc.expectedResponse, tc.expectedError).Times(1)
+
+			handler := NewEndpointHandler(mockStorageRepo, zap.NewNop().Sugar())
+			r := gin.Default()
+			r.POST("/backup/:backup_id/register", handler.RegisterUploadedBackup)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/backup/20250101T000000/register", nil))
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+		})
+	}
+}
+
+func TestRestoreNamespaceNotAllowed(t *testing.T) {
+	testCases := []struct {
+		name    string
+		path    string
+		body    string
+		handler func(h *EndpointHandler) gin.HandlerFunc
+	}{
+		{name: "restore", path: "/restore", body: `{"vault":"20250101T000000","targetNamespace":"prod"}`,
+			handler: func(h *EndpointHandler) gin.HandlerFunc { return h.Restore }},
+		{name: "restore v2", path: "/api/v1/restore/20250101T000000", body: `{"blobPath":"replica"}`,
+			handler: func(h *EndpointHandler) gin.HandlerFunc { return h.RestoreV2 }},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().RestoreBackup(gomock.Any(), gomock.Any()).
+				Return(entity.RestoreResponse{}, fmt.Errorf("%w: prod", controller.ErrRestoreNamespaceNotAllowed)).Times(1)
+
+			handler := NewEndpointHandler(mockStorageRepo, zap.NewNop().Sugar())
+			r := gin.Default()
+			r.POST("/restore", tc.handler(handler))
+			r.POST("/api/v1/restore/:backup_id", tc.handler(handler))
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tc.path, bytes.NewBufferString(tc.body)))
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("expected status %d, got %d", http.StatusForbidden, w.Code)
+			}
+		})
+	}
+}
+
+func TestRestoreRenameCollision(t *testing.T) {
+	testCases := []struct {
+		name    string
+		path    string
+		body    string
+		handler func(h *EndpointHandler) gin.HandlerFunc
+	}{
+		{name: "restore", path: "/restore", body: `{"vault":"20250101T000000","changeDbNames":{"db1":"new","db2":"new"}}`,
+			handler: func(h *EndpointHandler) gin.HandlerFunc { return h.Restore }},
+		{name: "restore v2", path: "/api/v1/restore/20250101T000000",
+			body:    `{"blobPath":"replica","databases":[{"previousDatabaseName":"db1","databaseName":"new"},{"previousDatabaseName":"db2","databaseName":"new"}]}`,
+			handler: func(h *EndpointHandler) gin.HandlerFunc { return h.RestoreV2 }},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().RestoreBackup(gomock.Any(), gomock.Any()).
+				Return(entity.RestoreResponse{}, fmt.Errorf("%w: db1 and db2 both renamed to new", controller.ErrRenameCollision)).Times(1)
+
+			handler := NewEndpointHandler(mockStorageRepo, zap.NewNop().Sugar())
+			r := gin.Default()
+			r.POST("/restore", tc.handler(handler))
+			r.POST("/api/v1/restore/:backup_id", tc.handler(handler))
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tc.path, bytes.NewBufferString(tc.body)))
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusBadRequest, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRestoreVaultAndTS(t *testing.T) {
+	testCases := []struct {
+		name               string
+		requestBodyJSON    string
+		expectedRequest    entity.RestoreRequest
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "vault only",
+			requestBodyJSON:    `{"vault":"20250101T000000"}`,
+			expectedRequest:    entity.RestoreRequest{Vault: "20250101T000000", ProcType: controller.FULL},
+			expectedBodyJSON:   `{"task_id":"task-1"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "ts with empty vault",
+			requestBodyJSON:    `{"vault":"","ts":"1735689600000"}`,
+			expectedRequest:    entity.RestoreRequest{TimeStamp: "1735689600000", ProcType: controller.FULL},
+			expectedBodyJSON:   `{"task_id":"task-1"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "both vault and ts",
+			requestBodyJSON:    `{"vault":"20250101T000000","ts":"1735689600000"}`,
+			expectedBodyJSON:   `{"message":"Sorry, wrong JSON string. Both 'vault' and 'ts' parameters are set, use only one of them"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "time window",
+			requestBodyJSON:    `{"fromTs":"1735689600000","toTs":"1735776000000"}`,
+			expectedRequest:    entity.RestoreRequest{FromTs: "1735689600000", ToTs: "1735776000000", ProcType: controller.FULL},
+			expectedBodyJSON:   `{"task_id":"task-1"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "time window with vault",
+			requestBodyJSON:    `{"vault":"20250101T000000","toTs":"1735776000000"}`,
+			expectedBodyJSON:   `{"message":"Sorry, wrong JSON string. 'fromTs'/'toTs' can't be used with 'vault' or 'ts'"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "inverted time window",
+			requestBodyJSON:    `{"fromTs":"1735776000000","toTs":"1735689600000"}`,
+			expectedBodyJSON:   `{"message":"invalid restore time window err: fromTs 1735776000000 is after toTs 1735689600000"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			if tc.expectedStatusCode == http.StatusOK {
+				mockStorageRepo.EXPECT().RestoreBackup(gomock.Any(), tc.expectedRequest).Return(entity.RestoreResponse{TaskID: "task-1"}, nil).Times(1)
+			}
+
+			sugar := zap.NewNop().Sugar()
+			handler := NewEndpointHandler(mockStorageRepo, sugar)
+
+			r := gin.Default()
+			r.POST("/restore", handler.Restore)
+
+			req := httptest.NewRequest(http.MethodPost, "/restore", bytes.NewBufferString(tc.requestBodyJSON))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPromoteBackup(t *testing.T) {
+	testCases := []struct {
+		name               string
+		expectedError      error
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "success",
+			expectedBodyJSON:   `{"message":"OK"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "not found",
+			expectedError:      fmt.Errorf("%w: vault 20250101T000000", controller.ErrBackupNotFound),
+			expectedBodyJSON:   `{"message":"failed to promote backup err: backup not found: vault 20250101T000000"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "failed backup",
+			expectedError:      fmt.Errorf("%w: vault 20250101T000000", controller.ErrBackupNotSuccessful),
+			expectedBodyJSON:   `{"message":"failed to promote backup err: backup is not successful: vault 20250101T000000"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().PromoteBackup(gomock.Any(), "20250101T000000").Return(tc.expectedError).Times(1)
+
+			sugar := zap.NewNop().Sugar()
+			handler := NewEndpointHandler(mockStorageRepo, sugar)
+
+			r := gin.Default()
+			r.POST("/backup/:backup_id/promote", handler.PromoteBackup)
+
+			req := httptest.NewRequest(http.MethodPost, "/backup/20250101T000000/promote", nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestGoldenBackup(t *testing.T) {
+	testCases := []struct {
+		name               string
+		expectedResponse   entity.GoldenBackupResponse
+		expectedError      error
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "success",
+			expectedResponse:   entity.GoldenBackupResponse{BackupID: "20250101T000000"},
+			expectedBodyJSON:   `{"backup_id":"20250101T000000","is_granular":false}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "no golden backup",
+			expectedError:      fmt.Errorf("failed to get golden backup err: %w", repo.ErrNoGolden),
+			expectedBodyJSON:   `{"message":"failed to get golden backup err: failed to get golden backup err: no golden backup"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().GetGoldenBackup(gomock.Any()).Return(tc.expectedResponse, tc.expectedError).Times(1)
+
+			sugar := zap.NewNop().Sugar()
+			handler := NewEndpointHandler(mockStorageRepo, sugar)
+
+			r := gin.Default()
+			r.GET("/backup/golden", handler.GoldenBackup)
+
+			req := httptest.NewRequest(http.MethodGet, "/backup/golden", nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestFailJob(t *testing.T) {
+	testCases := []struct {
+		name               string
+		expectedError      error
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "success",
+			expectedBodyJSON:   `{"message":"OK"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "job not found",
+			expectedError:      fmt.Errorf("%w: task-1", controller.ErrJobNotFound),
+			expectedBodyJSON:   `{"message":"failed to fail job err: job not found: task-1"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "job already finished",
+			expectedError:      fmt.Errorf("%w: task-1 is Successful", controller.ErrJobFinished),
+			expectedBodyJSON:   `{"message":"failed to fail job err: job is already finished: task-1 is Successful"}`,
+			expectedStatusCode: http.StatusConflict,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().FailJob(gomock.Any(), "task-1").Return(tc.expectedError).Times(1)
+
+			sugar := zap.NewNop().Sugar()
+			handler := NewEndpointHandler(mockStorageRepo, sugar)
+
+			r := gin.Default()
+			r.POST("/admin/jobs/:task_id/fail", handler.FailJob)
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/jobs/task-1/fail", nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCancelBackup(t *testing.T) {
+	testCases := []struct {
+		name               string
+		expectedError      error
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "queued databases canceled",
+			expectedBodyJSON:   `{"message":"OK"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "backup not found",
+			expectedError:      fmt.Errorf("%w: backup-1", controller.ErrJobNotFound),
+			expectedBodyJSON:   `{"message":"failed to cancel backup err: job not found: backup-1"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "backup running",
+			expectedError:      fmt.Errorf("%w: backup-1 is running", controller.ErrBackupNotQueued),
+			expectedBodyJSON:   `{"message":"failed to cancel backup err: backup has nothing queued to cancel: backup-1 is running"}`,
+			expectedStatusCode: http.StatusConflict,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().CancelBackup(gomock.Any(), "backup-1").Return(tc.expectedError).Times(1)
+
+			handler := NewEndpointHandler(mockStorageRepo, zap.NewNop().Sugar())
+
+			r := gin.Default()
+			r.DELETE("/backup/:backup_id", handler.CancelBackup)
+
+			req := httptest.NewRequest(http.MethodDelete, "/backup/backup-1", nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRetryBackup(t *testing.T) {
+	testCases := []struct {
+		name               string
+		response           entity.BackupResponse
+		expectedError      error
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "retried",
+			response:           entity.BackupResponse{BackupID: "20250102T000000"},
+			expectedBodyJSON:   `{"backup_id":"20250102T000000"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "backup not found",
+			expectedError:      fmt.Errorf("%w: 20250101T000000", controller.ErrJobNotFound),
+			expectedBodyJSON:   `{"message":"failed to retry backup err: job not found: 20250101T000000"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "backup successful",
+			expectedError:      fmt.Errorf("%w: 20250101T000000 is Successful", controller.ErrBackupNotRetryable),
+			expectedBodyJSON:   `{"message":"failed to retry backup err: backup cannot be retried: 20250101T000000 is Successful"}`,
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			name:               "backup cap reached",
+			expectedError:      fmt.Errorf("%w: 3 full backups of 3 allowed", controller.ErrBackupCapReached),
+			expectedBodyJSON:   `{"message":"failed to retry backup err: backup cap reached: 3 full backups of 3 allowed"}`,
+			expectedStatusCode: http.StatusInsufficientStorage,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockUseCase := NewMockBackupDaemonUseCase(ctrl)
+			mockUseCase.EXPECT().RetryBackup(gomock.Any(), "20250101T000000").Return(tc.response, tc.expectedError).Times(1)
+
+			handler := NewEndpointHandler(mockUseCase, zap.NewNop().Sugar())
+
+			r := gin.Default()
+			r.POST("/backup/:backup_id/retry", handler.RetryBackup)
+
+			req := httptest.NewRequest(http.MethodPost, "/backup/20250101T000000/retry", nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestVacuumDB(t *testing.T) {
+	testCases := []struct {
+		name               string
+		expectedError      error
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "success",
+			expectedBodyJSON:   `{"message":"OK"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "internal error",
+			expectedError:      errors.New("database is locked"),
+			expectedBodyJSON:   `{"message":"failed to vacuum database err: database is locked"}`,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().VacuumDB(gomock.Any()).Return(tc.expectedError).Times(1)
+
+			sugar := zap.NewNop().Sugar()
+			handler := NewEndpointHandler(mockStorageRepo, sugar)
+
+			r := gin.Default()
+			r.POST("/admin/db/vacuum", handler.VacuumDB)
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/db/vacuum", nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	testCases := []struct {
+		name               string
+		expectedResponse   entity.ReconcileResponse
+		expectedError      error
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "success",
+			expectedResponse:   entity.ReconcileResponse{Removed: []string{"20241231T000000"}, Added: []string{}},
+			expectedBodyJSON:   `{"removed":["20241231T000000"],"added":[]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "internal error",
+			expectedError:      errors.New("internal error"),
+			expectedBodyJSON:   `{"message":"failed to reconcile jobs with storage err: internal error"}`,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().Reconcile(gomock.Any()).Return(tc.expectedResponse, tc.expectedError).Times(1)
+
+			sugar := zap.NewNop().Sugar()
+			handler := NewEndpointHandler(mockStorageRepo, sugar)
+
+			r := gin.Default()
+			r.POST("/admin/reconcile", handler.Reconcile)
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/reconcile", nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestBackupV2Delete(t *testing.T) {
+	testCases := []struct {
+		name               string
+		expectedError      error
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "success",
+			expectedBodyJSON:   `{"backupId":"20240101T000000","blobPath":"backups/pg","message":"OK"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "not found",
+			expectedError:      fmt.Errorf("%w: vault 20240101T000000", controller.ErrVaultNotFound),
+			expectedBodyJSON:   `{"message":"backup not found in storage: vault 20240101T000000"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "busy",
+			expectedError:      fmt.Errorf("%w: 20240101T000000", controller.ErrVaultBusy),
+			expectedBodyJSON:   `{"message":"backup vault is in use: 20240101T000000"}`,
+			expectedStatusCode: http.StatusConflict,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().RemoveBackupV2(gomock.Any(), entity.EvictByVaultV2Request{Vault: "20240101T000000", BlobPath: "backups/pg"}).
+				Return(tc.expectedError).Times(1)
+
+			handler := NewEndpointHandler(mockStorageRepo, zap.NewNop().Sugar())
+
+			r := gin.Default()
+			r.DELETE("/api/v1/backup/:backup_id", handler.BackupV2Delete)
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/v1/backup/20240101T000000?blobPath=backups/pg", nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestEvictionPolicy(t *testing.T) {
+	testCases := []struct {
+		name               string
+		body               string
+		request            entity.EvictionPolicyRequest
+		expectedResponse   entity.EvictionPolicyResponse
+		expectedError      error
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "success",
+			body:               `{"fullEvictionPolicy":"0/2d"}`,
+			request:            entity.EvictionPolicyRequest{FullEvictionPolicy: "0/2d"},
+			expectedResponse:   entity.EvictionPolicyResponse{FullEvictionPolicy: "0/2d", GranularEvictionPolicy: "0/7d"},
+			expectedBodyJSON:   `{"fullEvictionPolicy":"0/2d","granularEvictionPolicy":"0/7d"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "invalid policy",
+			body:               `{"fullEvictionPolicy":"weekly"}`,
+			request:            entity.EvictionPolicyRequest{FullEvictionPolicy: "weekly"},
+			expectedError:      fmt.Errorf("%w weekly: bad rule", controller.ErrInvalidEvictionPolicy),
+			expectedBodyJSON:   `{"message":"failed to set eviction policy err: invalid eviction policy weekly: bad rule"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "store failed",
+			body:               `{"fullEvictionPolicy":"0/2d"}`,
+			request:            entity.EvictionPolicyRequest{FullEvictionPolicy: "0/2d"},
+			expectedError:      errors.New("disk I/O error"),
+			expectedBodyJSON:   `{"message":"failed to set eviction policy err: disk I/O error"}`,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().SetEvictionPolicy(gomock.Any(), tc.request).
+				Return(tc.expectedResponse, tc.expectedError).Times(1)
+
+			handler := NewEndpointHandler(mockStorageRepo, zap.NewNop().Sugar())
+
+			r := gin.Default()
+			r.PUT("/eviction/policy", handler.EvictionPolicy)
+
+			req := httptest.NewRequest(http.MethodPut, "/eviction/policy", bytes.NewBufferString(tc.body))
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetEvictionPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+	mockStorageRepo.EXPECT().GetEvictionPolicy(gomock.Any()).
+		Return(entity.EvictionPolicyResponse{FullEvictionPolicy: "0/1d", GranularEvictionPolicy: "0/7d"}).Times(1)
+
+	handler := NewEndpointHandler(mockStorageRepo, zap.NewNop().Sugar())
+
+	r := gin.Default()
+	r.GET("/eviction/policy", handler.GetEvictionPolicy)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/eviction/policy", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if expected := `{"fullEvictionPolicy":"0/1d","granularEvictionPolicy":"0/7d"}`; w.Body.String() != expected {
+		t.Fatalf("expected body %s, got %s", expected, w.Body.String())
+	}
+}
+
+func TestResetEvictionPolicy(t *testing.T) {
+	testCases := []struct {
+		name               string
+		expectedResponse   entity.EvictionPolicyResponse
+		expectedError      error
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "success",
+			expectedResponse:   entity.EvictionPolicyResponse{FullEvictionPolicy: "0/1d", GranularEvictionPolicy: "0/7d"},
+			expectedBodyJSON:   `{"fullEvictionPolicy":"0/1d","granularEvictionPolicy":"0/7d"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "delete failed",
+			expectedError:      errors.New("disk I/O error"),
+			expectedBodyJSON:   `{"message":"failed to reset eviction policy err: disk I/O error"}`,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().ResetEvictionPolicy(gomock.Any()).
+				Return(tc.expectedResponse, tc.expectedError).Times(1)
+
+			handler := NewEndpointHandler(mockStorageRepo, zap.NewNop().Sugar())
+
+			r := gin.Default()
+			r.DELETE("/eviction/policy", handler.ResetEvictionPolicy)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/eviction/policy", nil))
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestBackupV2ListETag(t *testing.T) {
+	jobs := []entity.JobStatusResponse{
+		{TaskID: "20250101T000000", Status: "Successful", StorageName: "s1", BlobPath: "replica"},
+	}
+	etag := backupsETag(jobs)
+	changed := []entity.JobStatusResponse{
+		{TaskID: "20250101T000000", Status: "Failed", StorageName: "s1", BlobPath: "replica"},
+	}
+	if etag == backupsETag(changed) {
+		t.Fatalf("expected etag to change with backup status")
+	}
+	if etag == backupsETag(append(jobs, entity.JobStatusResponse{TaskID: "20250102T000000", Status: "Queued"})) {
+		t.Fatalf("expected etag to change with added backup")
+	}
+
+	testCases := []struct {
+		name               string
+		ifNoneMatch        string
+		expectedStatusCode int
+	}{
+		{
+			name:               "no validator",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "matching etag",
+			ifNoneMatch:        etag,
+			expectedStatusCode: http.StatusNotModified,
+		},
+		{
+			name:               "matching weak etag in list",
+			ifNoneMatch:        `"stale", W/` + etag,
+			expectedStatusCode: http.StatusNotModified,
+		},
+		{
+			name:               "stale etag",
+			ifNoneMatch:        `"stale"`,
+			expectedStatusCode: http.StatusOK,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().ListJobs(gomock.Any(), gomock.Any()).Return(jobs, len(jobs), nil).Times(1)
+
+			sugar := zap.NewNop().Sugar()
+			handler := NewEndpointHandler(mockStorageRepo, sugar)
+
+			r := gin.Default()
+			r.GET("/api/v1/backup", handler.BackupV2List)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/backup", nil)
+			if tc.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tc.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if w.Header().Get("ETag") != etag {
+				t.Fatalf("expected etag %s, got %s", etag, w.Header().Get("ETag"))
+			}
+			if tc.expectedStatusCode == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Fatalf("expected empty body, got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestBackupV2ListPagination(t *testing.T) {
+	testCases := []struct {
+		name               string
+		query              string
+		expectedFilter     entity.JobsFilter
+		expectedStatusCode int
+	}{
+		{
+			name:               "default page",
+			expectedFilter:     entity.JobsFilter{Limit: defaultBackupsPageSize},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "limit and offset",
+			query:              "?limit=10&offset=20",
+			expectedFilter:     entity.JobsFilter{Limit: 10, Offset: 20},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "zero limit",
+			query:              "?limit=0",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "limit above maximum",
+			query:              "?limit=1001",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "negative offset",
+			query:              "?offset=-1",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "malformed offset",
+			query:              "?offset=abc",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			if tc.expectedStatusCode == http.StatusOK {
+				mockStorageRepo.EXPECT().ListJobs(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, filter entity.JobsFilter) ([]entity.JobStatusResponse, int, error) {
+						if filter.Limit != tc.expectedFilter.Limit || filter.Offset != tc.expectedFilter.Offset {
+							t.Fatalf("expected limit %d offset %d, got limit %d offset %d",
+								tc.expectedFilter.Limit, tc.expectedFilter.Offset, filter.Limit, filter.Offset)
+						}
+						return []entity.JobStatusResponse{{TaskID: "20250101T000000", Status: "Successful"}}, 42, nil
+					}).Times(1)
+			}
+
+			handler := NewEndpointHandler(mockStorageRepo, zap.NewNop().Sugar())
+			r := gin.Default()
+			r.GET("/api/v1/backup", handler.BackupV2List)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/backup"+tc.query, nil))
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedStatusCode == http.StatusOK && w.Header().Get("X-Total-Count") != "42" {
+				t.Fatalf("expected total count 42, got %q", w.Header().Get("X-Total-Count"))
+			}
+		})
+	}
+}
+
+func TestRestoreFromURL(t *testing.T) {
+	testCases := []struct {
+		name               string
+		requestBodyJSON    string
+		expectedError      error
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "success",
+			requestBodyJSON:    `{"url":"https://backups.example.com/20250101T000000.tar.gz"}`,
+			expectedBodyJSON:   `{"task_id":"task-1"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "host not allowed",
+			requestBodyJSON:    `{"url":"https://evil.example.com/20250101T000000.tar.gz"}`,
+			expectedError:      fmt.Errorf("%w: host \"evil.example.com\" is not in the allowed hosts", controller.ErrURLNotAllowed),
+			expectedBodyJSON:   `{"message":"failed to restore backup from url err: url is not allowed: host \"evil.example.com\" is not in the allowed hosts"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "archive too large",
+			requestBodyJSON:    `{"url":"https://backups.example.com/20250101T000000.tar.gz"}`,
+			expectedError:      util.ErrDownloadTooLarge,
+			expectedBodyJSON:   `{"message":"failed to restore backup from url err: download exceeds size limit"}`,
+			expectedStatusCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			response := entity.RestoreResponse{}
+			if tc.expectedError == nil {
+				response.TaskID = "task-1"
+			}
+			mockStorageRepo.EXPECT().RestoreFromURL(gomock.Any(), gomock.Any()).Return(response, tc.expectedError).Times(1)
+
+			sugar := zap.NewNop().Sugar()
+			handler := NewEndpointHandler(mockStorageRepo, sugar)
+
+			r := gin.Default()
+			r.POST("/restore/from-url", handler.RestoreFromURL)
+
+			req := httptest.NewRequest(http.MethodPost, "/restore/from-url", bytes.NewBufferString(tc.requestBodyJSON))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	testCases := []struct {
+		name               string
+		method             string
+		requestBodyJSON    string
+		expectedLevel      zapcore.Level
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "get current level",
+			method:             http.MethodGet,
+			expectedLevel:      zapcore.InfoLevel,
+			expectedBodyJSON:   `{"level":"info"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "switch to debug",
+			method:             http.MethodPost,
+			requestBodyJSON:    `{"level":"debug"}`,
+			expectedLevel:      zapcore.DebugLevel,
+			expectedBodyJSON:   `{"level":"debug"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "unknown level",
+			method:             http.MethodPost,
+			requestBodyJSON:    `{"level":"verbose"}`,
+			expectedLevel:      zapcore.InfoLevel,
+			expectedBodyJSON:   `{"message":"unknown log level 'verbose'"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+
+			level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+			sugar := zap.NewNop().Sugar()
+			handler := NewEndpointHandler(mockStorageRepo, sugar)
+			handler.SetLogLevel(level)
+
+			r := gin.Default()
+			r.GET("/admin/loglevel", handler.LogLevel)
+			r.POST("/admin/loglevel", handler.LogLevel)
+
+			req := httptest.NewRequest(tc.method, "/admin/loglevel", bytes.NewBufferString(tc.requestBodyJSON))
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+			if level.Level() != tc.expectedLevel {
+				t.Fatalf("expected level %s, got %s", tc.expectedLevel, level.Level())
+			}
+		})
+	}
+}
+
+func TestRestoreCleanString(t *testing.T) {
+	testCases := []struct {
+		name          string
+		body          string
+		expectedClean entity.FlexBool
+		expectedCode  int
+	}{
+		{name: "string true", body: `"clean":"true"`, expectedClean: true, expectedCode: http.StatusOK},
+		{name: "string false", body: `"clean":"false"`, expectedCode: http.StatusOK},
+		{name: "bool true", body: `"clean":true`, expectedClean: true, expectedCode: http.StatusOK},
+		{name: "invalid string", body: `"clean":"yes please"`, expectedCode: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockUseCase := NewMockBackupDaemonUseCase(ctrl)
+			var cleans []entity.FlexBool
+			mockUseCase.EXPECT().RestoreBackup(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, request entity.RestoreRequest) (entity.RestoreResponse, error) {
+					cleans = append(cleans, request.Clean)
+					return entity.RestoreResponse{TaskID: "task-1"}, nil
+				}).MaxTimes(1)
+			mockUseCase.EXPECT().RestoreFromURL(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, request entity.RestoreFromURLRequest) (entity.RestoreResponse, error) {
+					cleans = append(cleans, request.Clean)
+					return entity.RestoreResponse{TaskID: "task-1"}, nil
+				}).MaxTimes(1)
+			mockUseCase.EXPECT().RestoreMulti(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, request entity.MultiRestoreRequest) (entity.MultiRestoreResponse, error) {
+					cleans = append(cleans, request.Clean)
+					return entity.MultiRestoreResponse{}, nil
+				}).MaxTimes(1)
+
+			handler := NewEndpointHandler(mockUseCase, zap.NewNop().Sugar())
+			r := gin.Default()
+			r.POST("/restore", handler.Restore)
+			r.POST("/restore/from-url", handler.RestoreFromURL)
+			r.POST("/restore/multi", handler.RestoreMulti)
+
+			requests := map[string]string{
+				"/restore":          `{"vault":"20240101T000000",` + tc.body + `}`,
+				"/restore/from-url": `{"url":"https://backups.example.com/20240101T000000.tar.gz",` + tc.body + `}`,
+				"/restore/multi":    `{"vaults":["20240101T000000"],` + tc.body + `}`,
+			}
+			for route, body := range requests {
+				req := httptest.NewRequest(http.MethodPost, route, bytes.NewBufferString(body))
+				req.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				if w.Code != tc.expectedCode {
+					t.Fatalf("expected status %d from %s, got %d: %s", tc.expectedCode, route, w.Code, w.Body.String())
+				}
+			}
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+			if len(cleans) != len(requests) {
+				t.Fatalf("expected %d restores, got %d", len(requests), len(cleans))
+			}
+			for _, clean := range cleans {
+				if clean != tc.expectedClean {
+					t.Fatalf("expected clean %v, got %v", tc.expectedClean, clean)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreMulti(t *testing.T) {
+	testCases := []struct {
+		name               string
+		body               string
+		expectedResponse   entity.MultiRestoreResponse
+		expectedError      error
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "success",
+			body:               `{"vaults":["20240101T000000","20240103T000000"]}`,
+			expectedResponse:   entity.MultiRestoreResponse{Restored: []entity.RestoreResponse{{TaskID: "t1", Vault: "20240101T000000"}, {TaskID: "t2", Vault: "20240103T000000"}}},
+			expectedBodyJSON:   `{"restored":[{"task_id":"t1","vault":"20240101T000000"},{"task_id":"t2","vault":"20240103T000000"}]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "partial failure",
+			body: `{"vaults":["20240101T000000","20240103T000000"]}`,
+			expectedResponse: entity.MultiRestoreResponse{
+				Restored: []entity.RestoreResponse{{TaskID: "t1", Vault: "20240101T000000"}},
+				Failed:   []entity.RestoreFailure{{Vault: "20240103T000000", Error: "restore failed"}},
+			},
+			expectedError:      errors.New("restore failed"),
+			expectedBodyJSON:   `{"restored":[{"task_id":"t1","vault":"20240101T000000"}],"failed":[{"vault":"20240103T000000","error":"restore failed"}]}`,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		{
+			name:               "missing vault",
+			body:               `{"vaults":["20240104T000000"]}`,
+			expectedError:      fmt.Errorf("%w: [20240104T000000]", controller.ErrBackupNotFound),
+			expectedBodyJSON:   `{"message":"failed to restore backups err: backup not found: [20240104T000000]"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "invalid request",
+			body:               `{"vaults":["20240101T000000"],"from":"1"}`,
+			expectedError:      fmt.Errorf("%w: set either vaults or a from/to range", controller.ErrInvalidMultiRestore),
+			expectedBodyJSON:   `{"message":"failed to restore backups err: invalid multi restore request: set either vaults or a from/to range"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().RestoreMulti(gomock.Any(), gomock.Any()).Return(tc.expectedResponse, tc.expectedError).Times(1)
+
+			sugar := zap.NewNop().Sugar()
+			handler := NewEndpointHandler(mockStorageRepo, sugar)
+
+			r := gin.Default()
+			r.POST("/restore/multi", handler.RestoreMulti)
+
+			req := httptest.NewRequest(http.MethodPost, "/restore/multi", bytes.NewBufferString(tc.body))
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestBackupFile(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "db.dump")
+	if err := os.WriteFile(filePath, []byte("dump"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	testCases := []struct {
+		name               string
+		query              string
+		local              bool
+		expectedResponse   entity.BackupFileResponse
+		expectedError      error
+		expectedBody       string
+		expectedLocation   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "local file",
+			query:              "?path=db.dump",
+			local:              true,
+			expectedBody:       "dump",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "s3 file",
+			query:              "?path=db.dump&expiration=60",
+			expectedResponse:   entity.BackupFileResponse{URL: "https://s3/db.dump"},
+			expectedLocation:   "https://s3/db.dump",
+			expectedStatusCode: http.StatusTemporaryRedirect,
+		},
+		{
+			name:               "traversal",
+			query:              "?path=../secret",
+			expectedError:      fmt.Errorf("%w: ../secret", repo.ErrInvalidPath),
+			expectedBody:       `{"message":"failed to get backup file err: invalid backup file path: ../secret"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "missing file",
+			query:              "?path=missing",
+			expectedError:      fmt.Errorf("backup file missing: %w", os.ErrNotExist),
+			expectedBody:       `{"message":"failed to get backup file err: backup file missing: file does not exist"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			response := tc.expectedResponse
+			if tc.local {
+				file, err := os.Open(filePath)
+				if err != nil {
+					t.Fatalf("failed to open file: %v", err)
+				}
+				response.File = file
+			}
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().GetBackupFile(gomock.Any(), gomock.Any()).Return(response, tc.expectedError).Times(1)
+
+			sugar := zap.NewNop().Sugar()
+			handler := NewEndpointHandler(mockStorageRepo, sugar)
+
+			r := gin.Default()
+			r.GET("/backup/:backup_id/file", handler.BackupFile)
+
+			req := httptest.NewRequest(http.MethodGet, "/backup/20240101T000000/file"+tc.query, nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBody != "" && tc.expectedBody != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBody, w.Body.String())
+			}
+			if location := w.Header().Get("Location"); tc.expectedLocation != location {
+				t.Fatalf("expected location %s, got %s", tc.expectedLocation, location)
+			}
+		})
+	}
+}
+
+func TestBackupManifest(t *testing.T) {
+	testCases := []struct {
+		name               string
+		expectedResponse   entity.BackupManifest
+		expectedError      error
+		expectedBody       string
+		expectedStatusCode int
+	}{
+		{
+			name:               "success",
+			expectedResponse:   entity.BackupManifest{FormatVersion: 1, BackupID: "20240101T000000", Databases: []string{"db1"}, Files: []entity.ManifestFile{}},
+			expectedBody:       `{"format_version":1,"tool_version":"","backup_id":"20240101T000000","created_at":"","databases":["db1"],"size":0,"files":[]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "backup not found",
+			expectedError:      fmt.Errorf("%w: vault 20240101T000000", controller.ErrBackupNotFound),
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "no manifest",
+			expectedError:      fmt.Errorf("failed to read manifest err: %w", os.ErrNotExist),
+			expectedBody:       `{"message":"failed to get backup manifest err: failed to read manifest err: file does not exist"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorageRepo := NewMockBackupDaemonUseCase(ctrl)
+			mockStorageRepo.EXPECT().GetBackupManifest(gomock.Any(), "20240101T000000").Return(tc.expectedResponse, tc.expectedError).Times(1)
+
+			sugar := zap.NewNop().Sugar()
+			handler := NewEndpointHandler(mockStorageRepo, sugar)
+
+			r := gin.Default()
+			r.GET("/backup/:backup_id/manifest", handler.BackupManifest)
+
+			req := httptest.NewRequest(http.MethodGet, "/backup/20240101T000000/manifest", nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBody != "" && tc.expectedBody != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCleanS3Orphans(t *testing.T) {
+	testCases := []struct {
+		name               string
+		query              string
+		expectedDryRun     bool
+		response           entity.S3OrphansResponse
+		expectedError      error
+		expectedBodyJSON   string
+		expectedStatusCode int
+	}{
+		{
+			name:               "dry run",
+			query:              "?dryRun=true",
+			expectedDryRun:     true,
+			response:           entity.S3OrphansResponse{DryRun: true, Count: 1, Orphans: []entity.S3Orphan{{Prefix: "blob/20250101T000000", Objects: 2}}},
+			expectedBodyJSON:   `{"dry_run":true,"count":1,"orphans":[{"prefix":"blob/20250101T000000","objects":2}]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "dry run by default",
+			expectedDryRun:     true,
+			response:           entity.S3OrphansResponse{DryRun: true, Count: 1, Orphans: []entity.S3Orphan{{Prefix: "blob/20250101T000000", Objects: 2}}},
+			expectedBodyJSON:   `{"dry_run":true,"count":1,"orphans":[{"prefix":"blob/20250101T000000","objects":2}]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "delete",
+			query:              "?dryRun=false",
+			response:           entity.S3OrphansResponse{Count: 1, Orphans: []entity.S3Orphan{{Prefix: "blob/20250101T000000", Objects: 2}}},
+			expectedBodyJSON:   `{"count":1,"orphans":[{"prefix":"blob/20250101T000000","objects":2}]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "s3 disabled",
+			query:              "?dryRun=false",
+			expectedError:      controller.ErrS3Disabled,
+			expectedBodyJSON:   `{"message":"failed to clean orphaned s3 objects err: s3 storage is disabled"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "invalid dry run",
+			query:              "?dryRun=maybe",
+			expectedBodyJSON:   `{"message":"invalid dryRun 'maybe'"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockUseCase := NewMockBackupDaemonUseCase(ctrl)
+			mockUseCase.EXPECT().CleanS3Orphans(gomock.Any(), tc.expectedDryRun).Return(tc.response, tc.expectedError).MaxTimes(1)
+
+			handler := NewEndpointHandler(mockUseCase, zap.NewNop().Sugar())
+			r := gin.Default()
+			r.POST("/admin/s3/orphans/clean", handler.CleanS3Orphans)
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/s3/orphans/clean"+tc.query, nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBodyJSON != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBodyJSON, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestTenant(t *testing.T) {
+	testCases := []struct {
+		name               string
+		header             string
+		apiKeys            map[string]string
+		path               string
+		requestHeaders     map[string]string
+		expectedBody       string
+		expectedStatusCode int
+	}{
+		{
+			name:               "no tenant configured",
+			path:               "/jobs",
+			requestHeaders:     map[string]string{"X-Tenant": "team-a"},
+			expectedBody:       "",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "tenant from header",
+			header:             "X-Tenant",
+			path:               "/jobs",
+			requestHeaders:     map[string]string{"X-Tenant": "team-a"},
+			expectedBody:       "team-a",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "tenant from api key",
+			header:             "X-Tenant",
+			apiKeys:            map[string]string{"key-a": "team-a", "key-b": "team-b"},
+			path:               "/jobs",
+			requestHeaders:     map[string]string{"X-API-Key": "key-b", "X-Tenant": "team-a"},
+			expectedBody:       "team-b",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "unknown api key",
+			apiKeys:            map[string]string{"key-a": "team-a"},
+			path:               "/jobs",
+			requestHeaders:     map[string]string{"X-API-Key": "key-c"},
+			expectedBody:       `{"message":"missing or unknown api key"}`,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:               "probe without api key",
+			apiKeys:            map[string]string{"key-a": "team-a"},
+			path:               "/health",
+			expectedBody:       "",
+			expectedStatusCode: http.StatusOK,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			handler := NewEndpointHandler(nil, zap.NewNop().Sugar())
+			handler.SetTenants(tc.header, tc.apiKeys)
+
+			tenant := func(ctx *gin.Context) {
+				ctx.String(http.StatusOK, repo.Tenant(ctx))
+			}
+			r := gin.Default()
+			r.Use(handler.tenant)
+			r.GET("/jobs", tenant)
+			r.GET("/health", tenant)
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			for key, value := range tc.requestHeaders {
+				req.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBody != w.Body.String() {
+				t.Fatalf("expected body %s, got %s", tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestBackupConsoleStream(t *testing.T) {
+	testCases := []struct {
+		name               string
+		lines              []string
+		expectedError      error
+		expectedBody       string
+		expectedStatusCode int
+	}{
+		{
+			name:               "running backup",
+			lines:              []string{"dumping db1", "dumping db2"},
+			expectedBody:       "event:console\ndata:dumping db1\n\nevent:console\ndata:dumping db2\n\n",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "finished backup",
+			expectedError:      fmt.Errorf("%w: 20250101T000000", controller.ErrBackupNotRunning),
+			expectedBody:       `{"message":"failed to stream backup console err: backup is not running: 20250101T000000"}`,
+			expectedStatusCode: http.StatusConflict,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			var lines chan string
+			if tc.expectedError == nil {
+				lines = make(chan string, len(tc.lines))
+				for _, line := range tc.lines {
+					lines <- line
+				}
+				close(lines)
+			}
+			mockUseCase := NewMockBackupDaemonUseCase(ctrl)
+			mockUseCase.EXPECT().StreamBackupConsole(gomock.Any(), "20250101T000000").Return(lines, tc.expectedError).Times(1)
+
+			handler := NewEndpointHandler(mockUseCase, zap.NewNop().Sugar())
+			r := gin.Default()
+			r.GET("/backup/:backup_id/console/stream", handler.BackupConsoleStream)
+
+			req := httptest.NewRequest(http.MethodGet, "/backup/20250101T000000/console/stream", nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBody != w.Body.String() {
+				t.Fatalf("expected body %q, got %q", tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	full := int64(3600)
+	testCases := []struct {
+		name               string
+		ages               entity.BackupAges
+		expectedError      error
+		expectedBody       string
+		expectedStatusCode int
+	}{
+		{
+			name: "full backup only",
+			ages: entity.BackupAges{Full: &full},
+			expectedBody: "# HELP backup_daemon_last_successful_backup_age_seconds Seconds since the latest successful backup of the type.\n" +
+				"# TYPE backup_daemon_last_successful_backup_age_seconds gauge\n" +
+				"backup_daemon_last_successful_backup_age_seconds{type=\"full\"} 3600\n",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "listing fails",
+			expectedError:      errors.New("failed to list full vaults"),
+			expectedBody:       "failed to get backup ages err: failed to list full vaults\n",
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockUseCase := NewMockBackupDaemonUseCase(ctrl)
+			mockUseCase.EXPECT().BackupAges().Return(tc.ages, tc.expectedError).Times(1)
+
+			handler := NewEndpointHandler(mockUseCase, zap.NewNop().Sugar())
+			r := gin.Default()
+			r.GET("/metrics", handler.Metrics)
+
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if tc.expectedStatusCode != w.Code {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if tc.expectedBody != w.Body.String() {
+				t.Fatalf("expected body %q, got %q", tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHealth(t *testing.T) {
+	full := int64(3600)
+	testCases := []struct {
+		name         string
+		ages         func() (entity.BackupAges, bool)
+		expectedAges string
+	}{
+		{name: "ages not set"},
+		{name: "ages not checked yet", ages: func() (entity.BackupAges, bool) { return entity.BackupAges{}, false }},
+		{name: "ages of the last check", ages: func() (entity.BackupAges, bool) { return entity.BackupAges{Full: &full}, true },
+			expectedAges: `{"full":3600,"granular":null}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			// the vaults are not listed for the backup ages on every probe
+			mockUseCase := NewMockBackupDaemonUseCase(ctrl)
+			mockUseCase.EXPECT().BackupAges().Times(0)
+			mockUseCase.EXPECT().BackupLoad().Return(entity.BackupLoad{}).Times(1)
+			mockUseCase.EXPECT().StorageInfo().Return(entity.StorageInfo{}, nil).Times(1)
+			mockUseCase.EXPECT().StorageQuotas(gomock.Any()).Return(nil, nil).Times(1)
+
+			handler := NewEndpointHandler(mockUseCase, zap.NewNop().Sugar())
+			handler.SetBackupAges(tc.ages)
+			r := gin.Default()
+			r.GET("/health", handler.Health)
+
+			req := httptest.NewRequest(http.MethodGet, "/health", nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			var body map[string]json.RawMessage
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("failed to decode body %s: %v", w.Body.String(), err)
+			}
+			if ages := string(body["backup_age_seconds"]); ages != tc.expectedAges {
+				t.Fatalf("expected backup ages %q, got %q", tc.expectedAges, ages)
+			}
 		})
 	}
 }
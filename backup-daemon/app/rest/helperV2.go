@@ -13,11 +13,21 @@ const (
 	InProgress = "inProgress"
 	Finished   = "finished"
 	Failed     = "failed"
+	Canceled   = "canceled"
+	Skipped    = "skipped"
 	Unknown    = "unknown"
 )
 
 func timeCreationNow() string {
-	return time.Now().UTC().Format(time.RFC3339Nano)
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// jobCreationTime is when the job started, now for jobs created before start times were recorded.
+func jobCreationTime(js entity.JobStatusResponse) string {
+	if js.StartedAt != "" {
+		return js.StartedAt
+	}
+	return timeCreationNow()
 }
 
 func normalizeBlobPath(p string) string {
@@ -69,6 +79,17 @@ func DbStatuses(names []string, status string) []entity.DatabaseV2Status {
 	return out
 }
 
+// DbStatusesWithOverrides is DbStatuses where databases with a recorded job status get their own mapped status.
+func DbStatusesWithOverrides(names []string, status string, jobStatuses map[string]string) []entity.DatabaseV2Status {
+	out := DbStatuses(names, status)
+	for i := range out {
+		if s, ok := jobStatuses[out[i].DatabaseName]; ok {
+			out[i].Status = mapJobStatus(s)
+		}
+	}
+	return out
+}
+
 func mapJobStatus(s string) string {
 	switch strings.ToLower(strings.TrimSpace(s)) {
 	case "queued":
@@ -79,11 +100,32 @@ func mapJobStatus(s string) string {
 		return Finished
 	case "failed":
 		return Failed
+	case "canceled":
+		return Canceled
+	case "skipped":
+		return Skipped
 	default:
 		return Unknown
 	}
 }
 
+func jobStatusFromV2(s string) (string, bool) {
+	switch s {
+	case NotStarted:
+		return "Queued", true
+	case InProgress:
+		return "Processing", true
+	case Finished:
+		return "Successful", true
+	case Failed:
+		return "Failed", true
+	case Canceled:
+		return "Canceled", true
+	default:
+		return "", false
+	}
+}
+
 func mapBackupV2ToInternal(req entity.BackupV2Request, procType string) entity.BackupRequest {
 	custom := map[string]string{
 		"storageName": req.StorageName,
@@ -95,6 +137,8 @@ func mapBackupV2ToInternal(req entity.BackupV2Request, procType string) entity.B
 		AllowEviction: true,
 		Sharded:       false,
 		CustomVars:    custom,
+		Bucket:        req.Bucket,
+		BackupID:      strings.TrimSpace(req.BackupID),
 		ProcType:      procType,
 	}
 }
@@ -123,6 +167,7 @@ func mapRestoreV2ToInternal(backupID string, req entity.RestoreV2Request, procTy
 		DBs:           dbs,
 		ChangeDbNames: dbmap,
 		CustomVars:    custom,
+		Clean:         entity.FlexBool(req.Clean),
 		ProcType:      procType,
 	}
 }
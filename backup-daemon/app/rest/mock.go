@@ -7,6 +7,7 @@ package rest
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	entity "github.com/Netcracker/qubership-backup-daemon-go/backup-daemon/app/entity"
 	gomock "github.com/golang/mock/gomock"
@@ -35,6 +36,94 @@ func (m *MockBackupDaemonUseCase) EXPECT() *MockBackupDaemonUseCaseMockRecorder
 	return m.recorder
 }
 
+// BackupAges mocks base method.
+func (m *MockBackupDaemonUseCase) BackupAges() (entity.BackupAges, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BackupAges")
+	ret0, _ := ret[0].(entity.BackupAges)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// BackupAges indicates an expected call of BackupAges.
+func (mr *MockBackupDaemonUseCaseMockRecorder) BackupAges() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BackupAges", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).BackupAges))
+}
+
+// BackupLoad mocks base method.
+func (m *MockBackupDaemonUseCase) BackupLoad() entity.BackupLoad {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BackupLoad")
+	ret0, _ := ret[0].(entity.BackupLoad)
+	return ret0
+}
+
+// BackupLoad indicates an expected call of BackupLoad.
+func (mr *MockBackupDaemonUseCaseMockRecorder) BackupLoad() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BackupLoad", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).BackupLoad))
+}
+
+// CancelBackup mocks base method.
+func (m *MockBackupDaemonUseCase) CancelBackup(ctx context.Context, backupID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelBackup", ctx, backupID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CancelBackup indicates an expected call of CancelBackup.
+func (mr *MockBackupDaemonUseCaseMockRecorder) CancelBackup(ctx, backupID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelBackup", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).CancelBackup), ctx, backupID)
+}
+
+// CleanS3Orphans mocks base method.
+func (m *MockBackupDaemonUseCase) CleanS3Orphans(ctx context.Context, dryRun bool) (entity.S3OrphansResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CleanS3Orphans", ctx, dryRun)
+	ret0, _ := ret[0].(entity.S3OrphansResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CleanS3Orphans indicates an expected call of CleanS3Orphans.
+func (mr *MockBackupDaemonUseCaseMockRecorder) CleanS3Orphans(ctx, dryRun interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CleanS3Orphans", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).CleanS3Orphans), ctx, dryRun)
+}
+
+// CopyBackup mocks base method.
+func (m *MockBackupDaemonUseCase) CopyBackup(ctx context.Context, request entity.CopyBackupRequest) (entity.CopyBackupResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CopyBackup", ctx, request)
+	ret0, _ := ret[0].(entity.CopyBackupResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CopyBackup indicates an expected call of CopyBackup.
+func (mr *MockBackupDaemonUseCaseMockRecorder) CopyBackup(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CopyBackup", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).CopyBackup), ctx, request)
+}
+
+// CreateBackupUploadURL mocks base method.
+func (m *MockBackupDaemonUseCase) CreateBackupUploadURL(ctx context.Context, request entity.BackupUploadURLRequest) (entity.BackupUploadURLResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateBackupUploadURL", ctx, request)
+	ret0, _ := ret[0].(entity.BackupUploadURLResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateBackupUploadURL indicates an expected call of CreateBackupUploadURL.
+func (mr *MockBackupDaemonUseCaseMockRecorder) CreateBackupUploadURL(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateBackupUploadURL", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).CreateBackupUploadURL), ctx, request)
+}
+
 // CreateS3PresignedURL mocks base method.
 func (m *MockBackupDaemonUseCase) CreateS3PresignedURL(ctx context.Context, request entity.S3PresignedURLRequest) (entity.S3PresignedURLResponse, error) {
 	m.ctrl.T.Helper()
@@ -66,11 +155,12 @@ func (mr *MockBackupDaemonUseCaseMockRecorder) EnqueueBackup(ctx, request interf
 }
 
 // EnqueueEviction mocks base method.
-func (m *MockBackupDaemonUseCase) EnqueueEviction(ctx context.Context, request entity.EvictRequest) error {
+func (m *MockBackupDaemonUseCase) EnqueueEviction(ctx context.Context, request entity.EvictRequest) (entity.EvictResponse, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "EnqueueEviction", ctx, request)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret0, _ := ret[0].(entity.EvictResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // EnqueueEviction indicates an expected call of EnqueueEviction.
@@ -79,6 +169,79 @@ func (mr *MockBackupDaemonUseCaseMockRecorder) EnqueueEviction(ctx, request inte
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnqueueEviction", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).EnqueueEviction), ctx, request)
 }
 
+// FailJob mocks base method.
+func (m *MockBackupDaemonUseCase) FailJob(ctx context.Context, taskID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FailJob", ctx, taskID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FailJob indicates an expected call of FailJob.
+func (mr *MockBackupDaemonUseCaseMockRecorder) FailJob(ctx, taskID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FailJob", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).FailJob), ctx, taskID)
+}
+
+// GetBackupFile mocks base method.
+func (m *MockBackupDaemonUseCase) GetBackupFile(ctx context.Context, request entity.BackupFileRequest) (entity.BackupFileResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBackupFile", ctx, request)
+	ret0, _ := ret[0].(entity.BackupFileResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBackupFile indicates an expected call of GetBackupFile.
+func (mr *MockBackupDaemonUseCaseMockRecorder) GetBackupFile(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBackupFile", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).GetBackupFile), ctx, request)
+}
+
+// GetBackupManifest mocks base method.
+func (m *MockBackupDaemonUseCase) GetBackupManifest(ctx context.Context, backupID string) (entity.BackupManifest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBackupManifest", ctx, backupID)
+	ret0, _ := ret[0].(entity.BackupManifest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBackupManifest indicates an expected call of GetBackupManifest.
+func (mr *MockBackupDaemonUseCaseMockRecorder) GetBackupManifest(ctx, backupID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBackupManifest", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).GetBackupManifest), ctx, backupID)
+}
+
+// GetEvictionPolicy mocks base method.
+func (m *MockBackupDaemonUseCase) GetEvictionPolicy(ctx context.Context) entity.EvictionPolicyResponse {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetEvictionPolicy", ctx)
+	ret0, _ := ret[0].(entity.EvictionPolicyResponse)
+	return ret0
+}
+
+// GetEvictionPolicy indicates an expected call of GetEvictionPolicy.
+func (mr *MockBackupDaemonUseCaseMockRecorder) GetEvictionPolicy(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEvictionPolicy", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).GetEvictionPolicy), ctx)
+}
+
+// GetGoldenBackup mocks base method.
+func (m *MockBackupDaemonUseCase) GetGoldenBackup(ctx context.Context) (entity.GoldenBackupResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetGoldenBackup", ctx)
+	ret0, _ := ret[0].(entity.GoldenBackupResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetGoldenBackup indicates an expected call of GetGoldenBackup.
+func (mr *MockBackupDaemonUseCaseMockRecorder) GetGoldenBackup(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGoldenBackup", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).GetGoldenBackup), ctx)
+}
+
 // GetJobStatus mocks base method.
 func (m *MockBackupDaemonUseCase) GetJobStatus(ctx context.Context, request entity.JobStatusRequest) (entity.JobStatusResponse, error) {
 	m.ctrl.T.Helper()
@@ -94,6 +257,111 @@ func (mr *MockBackupDaemonUseCaseMockRecorder) GetJobStatus(ctx, request interfa
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetJobStatus", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).GetJobStatus), ctx, request)
 }
 
+// GetStorageUsage mocks base method.
+func (m *MockBackupDaemonUseCase) GetStorageUsage(ctx context.Context) (entity.StorageUsageResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetStorageUsage", ctx)
+	ret0, _ := ret[0].(entity.StorageUsageResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetStorageUsage indicates an expected call of GetStorageUsage.
+func (mr *MockBackupDaemonUseCaseMockRecorder) GetStorageUsage(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStorageUsage", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).GetStorageUsage), ctx)
+}
+
+// ListBackupFiles mocks base method.
+func (m *MockBackupDaemonUseCase) ListBackupFiles(ctx context.Context, backupID string) (entity.BackupFilesResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListBackupFiles", ctx, backupID)
+	ret0, _ := ret[0].(entity.BackupFilesResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListBackupFiles indicates an expected call of ListBackupFiles.
+func (mr *MockBackupDaemonUseCaseMockRecorder) ListBackupFiles(ctx, backupID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListBackupFiles", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).ListBackupFiles), ctx, backupID)
+}
+
+// ListJobs mocks base method.
+func (m *MockBackupDaemonUseCase) ListJobs(ctx context.Context, filter entity.JobsFilter) ([]entity.JobStatusResponse, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListJobs", ctx, filter)
+	ret0, _ := ret[0].([]entity.JobStatusResponse)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// ListJobs indicates an expected call of ListJobs.
+func (mr *MockBackupDaemonUseCaseMockRecorder) ListJobs(ctx, filter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListJobs", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).ListJobs), ctx, filter)
+}
+
+// ListS3Orphans mocks base method.
+func (m *MockBackupDaemonUseCase) ListS3Orphans(ctx context.Context) (entity.S3OrphansResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListS3Orphans", ctx)
+	ret0, _ := ret[0].(entity.S3OrphansResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListS3Orphans indicates an expected call of ListS3Orphans.
+func (mr *MockBackupDaemonUseCaseMockRecorder) ListS3Orphans(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListS3Orphans", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).ListS3Orphans), ctx)
+}
+
+// PromoteBackup mocks base method.
+func (m *MockBackupDaemonUseCase) PromoteBackup(ctx context.Context, backupID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PromoteBackup", ctx, backupID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PromoteBackup indicates an expected call of PromoteBackup.
+func (mr *MockBackupDaemonUseCaseMockRecorder) PromoteBackup(ctx, backupID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PromoteBackup", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).PromoteBackup), ctx, backupID)
+}
+
+// Reconcile mocks base method.
+func (m *MockBackupDaemonUseCase) Reconcile(ctx context.Context) (entity.ReconcileResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reconcile", ctx)
+	ret0, _ := ret[0].(entity.ReconcileResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Reconcile indicates an expected call of Reconcile.
+func (mr *MockBackupDaemonUseCaseMockRecorder) Reconcile(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reconcile", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).Reconcile), ctx)
+}
+
+// RegisterUploadedBackup mocks base method.
+func (m *MockBackupDaemonUseCase) RegisterUploadedBackup(ctx context.Context, backupID string) (entity.BackupResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RegisterUploadedBackup", ctx, backupID)
+	ret0, _ := ret[0].(entity.BackupResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RegisterUploadedBackup indicates an expected call of RegisterUploadedBackup.
+func (mr *MockBackupDaemonUseCaseMockRecorder) RegisterUploadedBackup(ctx, backupID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterUploadedBackup", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).RegisterUploadedBackup), ctx, backupID)
+}
+
 // RemoveBackup mocks base method.
 func (m *MockBackupDaemonUseCase) RemoveBackup(ctx context.Context, request entity.EvictByVaultRequest) error {
 	m.ctrl.T.Helper()
@@ -122,6 +390,21 @@ func (mr *MockBackupDaemonUseCaseMockRecorder) RemoveBackupV2(ctx, request inter
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveBackupV2", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).RemoveBackupV2), ctx, request)
 }
 
+// ResetEvictionPolicy mocks base method.
+func (m *MockBackupDaemonUseCase) ResetEvictionPolicy(ctx context.Context) (entity.EvictionPolicyResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetEvictionPolicy", ctx)
+	ret0, _ := ret[0].(entity.EvictionPolicyResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResetEvictionPolicy indicates an expected call of ResetEvictionPolicy.
+func (mr *MockBackupDaemonUseCaseMockRecorder) ResetEvictionPolicy(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetEvictionPolicy", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).ResetEvictionPolicy), ctx)
+}
+
 // RestoreBackup mocks base method.
 func (m *MockBackupDaemonUseCase) RestoreBackup(ctx context.Context, request entity.RestoreRequest) (entity.RestoreResponse, error) {
 	m.ctrl.T.Helper()
@@ -136,3 +419,149 @@ func (mr *MockBackupDaemonUseCaseMockRecorder) RestoreBackup(ctx, request interf
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreBackup", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).RestoreBackup), ctx, request)
 }
+
+// RestoreFromURL mocks base method.
+func (m *MockBackupDaemonUseCase) RestoreFromURL(ctx context.Context, request entity.RestoreFromURLRequest) (entity.RestoreResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreFromURL", ctx, request)
+	ret0, _ := ret[0].(entity.RestoreResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RestoreFromURL indicates an expected call of RestoreFromURL.
+func (mr *MockBackupDaemonUseCaseMockRecorder) RestoreFromURL(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreFromURL", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).RestoreFromURL), ctx, request)
+}
+
+// RestoreLatestBackup mocks base method.
+func (m *MockBackupDaemonUseCase) RestoreLatestBackup(ctx context.Context, request entity.RestoreLatestRequest) (entity.RestoreResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreLatestBackup", ctx, request)
+	ret0, _ := ret[0].(entity.RestoreResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RestoreLatestBackup indicates an expected call of RestoreLatestBackup.
+func (mr *MockBackupDaemonUseCaseMockRecorder) RestoreLatestBackup(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreLatestBackup", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).RestoreLatestBackup), ctx, request)
+}
+
+// RestoreMulti mocks base method.
+func (m *MockBackupDaemonUseCase) RestoreMulti(ctx context.Context, request entity.MultiRestoreRequest) (entity.MultiRestoreResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreMulti", ctx, request)
+	ret0, _ := ret[0].(entity.MultiRestoreResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RestoreMulti indicates an expected call of RestoreMulti.
+func (mr *MockBackupDaemonUseCaseMockRecorder) RestoreMulti(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreMulti", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).RestoreMulti), ctx, request)
+}
+
+// RetryBackup mocks base method.
+func (m *MockBackupDaemonUseCase) RetryBackup(ctx context.Context, backupID string) (entity.BackupResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RetryBackup", ctx, backupID)
+	ret0, _ := ret[0].(entity.BackupResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RetryBackup indicates an expected call of RetryBackup.
+func (mr *MockBackupDaemonUseCaseMockRecorder) RetryBackup(ctx, backupID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RetryBackup", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).RetryBackup), ctx, backupID)
+}
+
+// RunStorageQuotaRefresh mocks base method.
+func (m *MockBackupDaemonUseCase) RunStorageQuotaRefresh(ctx context.Context, interval time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "RunStorageQuotaRefresh", ctx, interval)
+}
+
+// RunStorageQuotaRefresh indicates an expected call of RunStorageQuotaRefresh.
+func (mr *MockBackupDaemonUseCaseMockRecorder) RunStorageQuotaRefresh(ctx, interval interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RunStorageQuotaRefresh", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).RunStorageQuotaRefresh), ctx, interval)
+}
+
+// SetEvictionPolicy mocks base method.
+func (m *MockBackupDaemonUseCase) SetEvictionPolicy(ctx context.Context, request entity.EvictionPolicyRequest) (entity.EvictionPolicyResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetEvictionPolicy", ctx, request)
+	ret0, _ := ret[0].(entity.EvictionPolicyResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetEvictionPolicy indicates an expected call of SetEvictionPolicy.
+func (mr *MockBackupDaemonUseCaseMockRecorder) SetEvictionPolicy(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEvictionPolicy", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).SetEvictionPolicy), ctx, request)
+}
+
+// StorageInfo mocks base method.
+func (m *MockBackupDaemonUseCase) StorageInfo() (entity.StorageInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StorageInfo")
+	ret0, _ := ret[0].(entity.StorageInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StorageInfo indicates an expected call of StorageInfo.
+func (mr *MockBackupDaemonUseCaseMockRecorder) StorageInfo() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StorageInfo", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).StorageInfo))
+}
+
+// StorageQuotas mocks base method.
+func (m *MockBackupDaemonUseCase) StorageQuotas(ctx context.Context) (map[string]entity.StorageQuota, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StorageQuotas", ctx)
+	ret0, _ := ret[0].(map[string]entity.StorageQuota)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StorageQuotas indicates an expected call of StorageQuotas.
+func (mr *MockBackupDaemonUseCaseMockRecorder) StorageQuotas(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StorageQuotas", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).StorageQuotas), ctx)
+}
+
+// StreamBackupConsole mocks base method.
+func (m *MockBackupDaemonUseCase) StreamBackupConsole(ctx context.Context, backupID string) (<-chan string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StreamBackupConsole", ctx, backupID)
+	ret0, _ := ret[0].(<-chan string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StreamBackupConsole indicates an expected call of StreamBackupConsole.
+func (mr *MockBackupDaemonUseCaseMockRecorder) StreamBackupConsole(ctx, backupID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StreamBackupConsole", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).StreamBackupConsole), ctx, backupID)
+}
+
+// VacuumDB mocks base method.
+func (m *MockBackupDaemonUseCase) VacuumDB(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VacuumDB", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// VacuumDB indicates an expected call of VacuumDB.
+func (mr *MockBackupDaemonUseCaseMockRecorder) VacuumDB(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VacuumDB", reflect.TypeOf((*MockBackupDaemonUseCase)(nil).VacuumDB), ctx)
+}
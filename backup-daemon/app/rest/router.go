@@ -2,6 +2,7 @@ package rest
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -15,6 +16,7 @@ func NewRouter() *router {
 
 func (s *router) GetHandler(eh *EndpointHandler) http.Handler {
 	r := gin.Default()
+	r.Use(eh.tenant)
 
 	r.NoRoute(func(ctx *gin.Context) { // check for 404
 		ctx.JSON(http.StatusNotFound, gin.H{
@@ -24,34 +26,76 @@ func (s *router) GetHandler(eh *EndpointHandler) http.Handler {
 
 	incremental := r.Group("/incremental")
 	{
-		incremental.POST("/backup", eh.Backup)
-		incremental.POST("/restore", eh.Restore)
-		incremental.POST("/evict", eh.Evict)
-		incremental.POST("/evict/:vault", eh.EvictByVault)
+		incremental.POST("/backup", longRunning, eh.Backup)
+		incremental.POST("/restore", longRunning, eh.Restore)
+		incremental.POST("/restore/latest", longRunning, eh.RestoreLatest)
+		incremental.POST("/evict", longRunning, eh.Evict)
+		incremental.POST("/evict/:vault", longRunning, eh.EvictByVault)
 		incremental.GET("/jobstatus/:task_id", eh.JobStatus)
 	}
 
 	full := r.Group("/")
 	{
-		full.POST("/backup", eh.Backup)
-		full.POST("/restore", eh.Restore)
-		full.POST("/evict", eh.Evict)
-		full.POST("/evict/:vault", eh.EvictByVault)
-		full.POST("/external/restore", eh.ExternalRestore)
+		full.POST("/backup", longRunning, eh.Backup)
+		full.POST("/restore", longRunning, eh.Restore)
+		full.POST("/restore/latest", longRunning, eh.RestoreLatest)
+		full.POST("/restore/from-url", longRunning, eh.RestoreFromURL)
+		full.POST("/restore/multi", longRunning, eh.RestoreMulti)
+		full.POST("/evict", longRunning, eh.Evict)
+		full.POST("/evict/:vault", longRunning, eh.EvictByVault)
+		full.GET("/eviction/policy", eh.GetEvictionPolicy)
+		full.PUT("/eviction/policy", eh.EvictionPolicy)
+		full.DELETE("/eviction/policy", eh.ResetEvictionPolicy)
+		full.POST("/external/restore", longRunning, eh.ExternalRestore)
 		full.GET("/jobstatus/:task_id", eh.JobStatus)
 		full.GET("/backup/s3/:backup_id", eh.S3PresignedURL)
+		full.GET("/backup/:backup_id/files", eh.BackupFiles)
+		full.GET("/backup/:backup_id/file", longRunning, eh.BackupFile)
+		full.GET("/backup/:backup_id/manifest", eh.BackupManifest)
+		full.GET("/backup/:backup_id/console/stream", longRunning, eh.BackupConsoleStream)
+		full.POST("/backup/:backup_id/copy", longRunning, eh.CopyBackup)
+		full.POST("/backup/:backup_id/upload-url", eh.BackupUploadURL)
+		full.POST("/backup/:backup_id/register", longRunning, eh.RegisterUploadedBackup)
+		full.POST("/backup/:backup_id/promote", eh.PromoteBackup)
+		full.POST("/backup/:backup_id/retry", longRunning, eh.RetryBackup)
+		full.DELETE("/backup/:backup_id", eh.CancelBackup)
+		full.GET("/backup/golden", eh.GoldenBackup)
+		full.GET("/storage/usage", eh.StorageUsage)
 		full.GET("/health", eh.Health)
+		full.GET("/ready", eh.Ready)
+		full.GET("/metrics", eh.Metrics)
+	}
+
+	admin := r.Group("/admin")
+	{
+		admin.GET("/loglevel", eh.LogLevel)
+		admin.POST("/loglevel", eh.LogLevel)
+		admin.POST("/reconcile", longRunning, eh.Reconcile)
+		admin.POST("/db/vacuum", longRunning, eh.VacuumDB)
+		admin.POST("/jobs/:task_id/fail", eh.FailJob)
+		admin.GET("/s3/orphans", longRunning, eh.S3Orphans)
+		admin.POST("/s3/orphans/clean", longRunning, eh.CleanS3Orphans)
 	}
 
 	v1 := r.Group("/api/v1")
 	{
-		v1.POST("/backup", eh.BackupV2)
+		v1.POST("/backup", longRunning, eh.BackupV2)
+		v1.GET("/backup", eh.BackupV2List)
 		v1.GET("/backup/:backup_id", eh.BackupV2Status)
-		v1.DELETE("/backup/:backup_id", eh.BackupV2Delete)
-		v1.POST("/restore/:backup_id", eh.RestoreV2)
+		v1.DELETE("/backup/:backup_id", longRunning, eh.BackupV2Delete)
+		v1.POST("/restore/latest", longRunning, eh.RestoreV2Latest)
+		v1.POST("/restore/:backup_id", longRunning, eh.RestoreV2)
 		v1.GET("/restore/:restore_id", eh.RestoreV2Status)
+		v1.POST("/evict", longRunning, eh.EvictV2)
 
 	}
 
 	return r
 }
+
+// longRunning lifts the server write deadline for a route that runs a backup tool or
+// transfers backups, its response is written only once the work is done.
+func longRunning(ctx *gin.Context) {
+	_ = http.NewResponseController(ctx.Writer).SetWriteDeadline(time.Time{})
+	ctx.Next()
+}
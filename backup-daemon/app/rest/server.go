@@ -14,6 +14,15 @@ type routerHandler interface {
 	GetHandler(eh *EndpointHandler) http.Handler
 }
 
+// ServerTimeouts bound how long a client may take to send a request, to read a response and
+// to keep an idle connection open, zero disables a timeout. Routes that run a backup tool or
+// transfer backups are exempt from Write, see longRunning.
+type ServerTimeouts struct {
+	Read  time.Duration
+	Write time.Duration
+	Idle  time.Duration
+}
+
 type Server struct {
 	logger          *zap.SugaredLogger
 	shutdownTimeout time.Duration
@@ -23,7 +32,7 @@ type Server struct {
 	EndpointHandler *EndpointHandler
 }
 
-func NewServer(port int, shutdownTimeout time.Duration,
+func NewServer(port int, shutdownTimeout time.Duration, timeouts ServerTimeouts, maxHeaderBytes int,
 	routerHandler routerHandler, logger *zap.SugaredLogger,
 	endpointHandler *EndpointHandler) (*Server, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
@@ -32,7 +41,11 @@ func NewServer(port int, shutdownTimeout time.Duration,
 	}
 	return &Server{
 		client: &http.Server{
-			Handler: routerHandler.GetHandler(endpointHandler),
+			Handler:        routerHandler.GetHandler(endpointHandler),
+			ReadTimeout:    timeouts.Read,
+			WriteTimeout:   timeouts.Write,
+			IdleTimeout:    timeouts.Idle,
+			MaxHeaderBytes: maxHeaderBytes,
 		},
 		listener:        listener,
 		logger:          logger,
@@ -0,0 +1,53 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLongRunningWriteTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	slow := func(ctx *gin.Context) {
+		time.Sleep(300 * time.Millisecond)
+		ctx.String(http.StatusOK, "done")
+	}
+	r := gin.New()
+	r.POST("/long", longRunning, slow)
+	r.POST("/short", slow)
+
+	srv := httptest.NewUnstartedServer(r)
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{path: "/long", wantErr: false},
+		{path: "/short", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := srv.Client().Post(srv.URL+tt.path, "application/json", nil)
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("expected write timeout, got status %d", resp.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", resp.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTarGzRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{
+		"pg_dump.sql":        "dump",
+		".console":           "console output",
+		"db1/data/table.bin": "binary",
+	}
+	for name, content := range files {
+		p := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	archive := filepath.Join(t.TempDir(), "archives", "20250101T000000.tar.gz")
+	if err := TarGz(src, archive); err != nil {
+		t.Fatalf("TarGz failed: %v", err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "restore")
+	if err := ExtractTarGz(archive, dest); err != nil {
+		t.Fatalf("ExtractTarGz failed: %v", err)
+	}
+	for name, content := range files {
+		got, err := os.ReadFile(filepath.Join(dest, name))
+		if err != nil {
+			t.Fatalf("expected %s to be extracted: %v", name, err)
+		}
+		if string(got) != content {
+			t.Fatalf("expected %s content %q, got %q", name, content, got)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+var ErrDownloadTooLarge = errors.New("download exceeds size limit")
+
+// DownloadFile stores the body of a GET request to rawURL at dest. Downloads larger than
+// maxSize bytes are aborted and leave nothing behind, a non positive maxSize disables the limit.
+func DownloadFile(ctx context.Context, client *http.Client, rawURL string, dest string, maxSize int64) (err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create download request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download: unexpected status %s", resp.Status)
+	}
+	if maxSize > 0 && resp.ContentLength > maxSize {
+		return fmt.Errorf("%w: %d bytes > %d bytes", ErrDownloadTooLarge, resp.ContentLength, maxSize)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("failed to create download dir: %w", err)
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create download file: %w", err)
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close download file: %w", closeErr)
+		}
+		if err != nil {
+			_ = os.Remove(dest)
+		}
+	}()
+
+	var body io.Reader = resp.Body
+	if maxSize > 0 {
+		// read one byte over the limit to tell a body of exactly maxSize from a larger one
+		body = io.LimitReader(resp.Body, maxSize+1)
+	}
+	written, err := io.Copy(f, body)
+	if err != nil {
+		return fmt.Errorf("failed to download: %w", err)
+	}
+	if maxSize > 0 && written > maxSize {
+		return fmt.Errorf("%w: more than %d bytes", ErrDownloadTooLarge, maxSize)
+	}
+	return nil
+}
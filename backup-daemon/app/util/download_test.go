@@ -0,0 +1,79 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownloadFile(t *testing.T) {
+	testCases := []struct {
+		name          string
+		body          string
+		status        int
+		maxSize       int64
+		expectedError error
+		expectErr     bool
+	}{
+		{
+			name:    "within limit",
+			body:    "archive",
+			status:  http.StatusOK,
+			maxSize: 7,
+		},
+		{
+			name:    "no limit",
+			body:    strings.Repeat("a", 1024),
+			status:  http.StatusOK,
+			maxSize: 0,
+		},
+		{
+			name:          "over limit",
+			body:          "archive",
+			status:        http.StatusOK,
+			maxSize:       6,
+			expectedError: ErrDownloadTooLarge,
+			expectErr:     true,
+		},
+		{
+			name:      "not found",
+			status:    http.StatusNotFound,
+			expectErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// no content length, the limit must hold for streamed bodies too
+				w.Header().Set("Transfer-Encoding", "chunked")
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			dest := filepath.Join(t.TempDir(), "download", "archive.tar.gz")
+			err := DownloadFile(context.Background(), server.Client(), server.URL, dest, tc.maxSize)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+			}
+			if tc.expectedError != nil && !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected err %v, got %v", tc.expectedError, err)
+			}
+			got, readErr := os.ReadFile(dest)
+			if tc.expectErr {
+				if readErr == nil {
+					t.Fatalf("expected no file left after failed download")
+				}
+				return
+			}
+			if string(got) != tc.body {
+				t.Fatalf("expected body %q, got %q", tc.body, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// DirSize returns the total size in bytes of all regular files under root.
+func DirSize(root string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// CopyDir copies the tree under src to dest keeping file modes, symlinks are copied as links.
+func CopyDir(src string, dest string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(p, target, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+func copyFile(src string, dest string, perm os.FileMode) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	return nil
+}
+
+// FreeSpace returns the number of bytes available to unprivileged users on the filesystem holding path.
+func FreeSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+// FSStats are the size and inodes of a filesystem, inodes are zero where the filesystem does not report them.
+type FSStats struct {
+	TotalSpace  int64
+	FreeSpace   int64
+	TotalInodes int64
+	FreeInodes  int64
+}
+
+// Stats returns the size and inodes of the filesystem holding path, free space is that available to
+// unprivileged users.
+func Stats(path string) (FSStats, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return FSStats{}, err
+	}
+	return FSStats{
+		TotalSpace:  int64(stat.Blocks) * int64(stat.Bsize),
+		FreeSpace:   int64(stat.Bavail) * int64(stat.Bsize),
+		TotalInodes: int64(stat.Files),
+		FreeInodes:  int64(stat.Ffree),
+	}, nil
+}
@@ -0,0 +1,57 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyDir(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0o755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	files := map[string]string{"a.dump": "a", filepath.Join("sub", "b.dump"): "b"}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	dest := filepath.Join(t.TempDir(), "copy")
+
+	if err := CopyDir(src, dest); err != nil {
+		t.Fatalf("CopyDir failed: %v", err)
+	}
+	for name, content := range files {
+		got, err := os.ReadFile(filepath.Join(dest, name))
+		if err != nil {
+			t.Fatalf("failed to read copied file: %v", err)
+		}
+		if string(got) != content {
+			t.Fatalf("expected %s to contain %q, got %q", name, content, got)
+		}
+		info, err := os.Stat(filepath.Join(dest, name))
+		if err != nil {
+			t.Fatalf("failed to stat copied file: %v", err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Fatalf("expected %s mode 0600, got %v", name, info.Mode().Perm())
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	stats, err := Stats(t.TempDir())
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.TotalSpace <= 0 || stats.FreeSpace < 0 || stats.FreeSpace > stats.TotalSpace {
+		t.Fatalf("expected free space within total space, got %+v", stats)
+	}
+	if stats.FreeInodes < 0 || stats.FreeInodes > stats.TotalInodes {
+		t.Fatalf("expected free inodes within total inodes, got %+v", stats)
+	}
+	if _, err := Stats(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatalf("expected error for a missing path")
+	}
+}
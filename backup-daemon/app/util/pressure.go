@@ -0,0 +1,32 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// IOPressurePath is the Linux pressure stall information file of I/O.
+const IOPressurePath = "/proc/pressure/io"
+
+// IOPressure returns the "some avg10" value of a pressure stall information file, the percent
+// of the last ten seconds in which at least one task was stalled on I/O.
+func IOPressure(path string) (float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "some" {
+			continue
+		}
+		for _, field := range fields[1:] {
+			if value, ok := strings.CutPrefix(field, "avg10="); ok {
+				return strconv.ParseFloat(value, 64)
+			}
+		}
+	}
+	return 0, fmt.Errorf("no some avg10 in %s", path)
+}
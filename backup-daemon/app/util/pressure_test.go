@@ -0,0 +1,34 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIOPressure(t *testing.T) {
+	testCases := []struct {
+		name      string
+		content   string
+		expected  float64
+		expectErr bool
+	}{
+		{name: "psi file", content: "some avg10=12.50 avg60=3.00 avg300=1.00 total=100\nfull avg10=8.00 avg60=2.00 avg300=0.50 total=80\n", expected: 12.5},
+		{name: "no some line", content: "full avg10=8.00 avg60=2.00 avg300=0.50 total=80\n", expectErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "io")
+			if err := os.WriteFile(path, []byte(tc.content), 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+			pressure, err := IOPressure(path)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+			}
+			if pressure != tc.expected {
+				t.Fatalf("expected pressure %v, got %v", tc.expected, pressure)
+			}
+		})
+	}
+}
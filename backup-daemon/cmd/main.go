@@ -8,7 +8,10 @@ import (
 )
 
 func main() {
-	logger, _ := zap.NewProduction()
+	logLevel := zap.NewAtomicLevelAt(zap.InfoLevel)
+	zapConfig := zap.NewProductionConfig()
+	zapConfig.Level = logLevel
+	logger, _ := zapConfig.Build()
 
 	l := logger.Sugar()
 	l = l.With(zap.String("applicator", "backup-daemon"))
@@ -22,8 +25,11 @@ func main() {
 	if err != nil {
 		l.Fatalf("failed to load config err: %v", err)
 	}
+	if err := logLevel.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		l.Fatalf("invalid log level %q err: %v", cfg.LogLevel, err)
+	}
 
-	app := applicator.NewApp(l,&cfg)
+	app := applicator.NewApp(l, &cfg, logLevel)
 	app.Run()
 }
 